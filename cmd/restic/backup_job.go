@@ -0,0 +1,233 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/fs"
+	"github.com/restic/restic/internal/repository"
+	"github.com/restic/restic/internal/textfile"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v2"
+)
+
+// BackupJob describes several sources which are saved together in a single
+// snapshot. It is read from the file passed to "backup --job".
+type BackupJob struct {
+	Sources []BackupSource `json:"sources" yaml:"sources" toml:"sources"`
+}
+
+// BackupSource is a list of files and directories together with the options
+// which select the data below them that is saved. The options correspond to
+// the flags of the backup command with the same name.
+type BackupSource struct {
+	Paths                   []string `json:"paths" yaml:"paths" toml:"paths"`
+	FilesFrom               []string `json:"files_from" yaml:"files_from" toml:"files_from"`
+	Excludes                []string `json:"exclude" yaml:"exclude" toml:"exclude"`
+	InsensitiveExcludes     []string `json:"iexclude" yaml:"iexclude" toml:"iexclude"`
+	ExcludeFiles            []string `json:"exclude_file" yaml:"exclude_file" toml:"exclude_file"`
+	InsensitiveExcludeFiles []string `json:"iexclude_file" yaml:"iexclude_file" toml:"iexclude_file"`
+	ExcludeIfPresent        []string `json:"exclude_if_present" yaml:"exclude_if_present" toml:"exclude_if_present"`
+	ExcludeCaches           bool     `json:"exclude_caches" yaml:"exclude_caches" toml:"exclude_caches"`
+	ExcludeLargerThan       string   `json:"exclude_larger_than" yaml:"exclude_larger_than" toml:"exclude_larger_than"`
+	OneFileSystem           bool     `json:"one_file_system" yaml:"one_file_system" toml:"one_file_system"`
+}
+
+// options returns the backup options for the source, so that the functions
+// used for the command line flags can be reused.
+func (src BackupSource) options() BackupOptions {
+	return BackupOptions{
+		FilesFrom:               src.FilesFrom,
+		Excludes:                src.Excludes,
+		InsensitiveExcludes:     src.InsensitiveExcludes,
+		ExcludeFiles:            src.ExcludeFiles,
+		InsensitiveExcludeFiles: src.InsensitiveExcludeFiles,
+		ExcludeIfPresent:        src.ExcludeIfPresent,
+		ExcludeCaches:           src.ExcludeCaches,
+		ExcludeLargerThan:       src.ExcludeLargerThan,
+		ExcludeOtherFS:          src.OneFileSystem,
+	}
+}
+
+// readBackupJob parses the job file filename, the format is selected by the
+// file extension.
+func readBackupJob(filename string) (*BackupJob, error) {
+	data, err := textfile.Read(filename)
+	if err != nil {
+		return nil, errors.Fatalf("unable to read job file: %v", err)
+	}
+
+	var job BackupJob
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".json":
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.DisallowUnknownFields()
+		err = dec.Decode(&job)
+	case ".yaml", ".yml":
+		err = yaml.UnmarshalStrict(data, &job)
+	case ".toml":
+		err = decodeTOMLStrict(data, &job)
+	default:
+		return nil, errors.Fatalf("job file %v: unknown format, the file name must end in .yaml, .yml, .json or .toml", filename)
+	}
+	if err != nil {
+		return nil, errors.Fatalf("invalid job file %v: %v", filename, err)
+	}
+
+	if len(job.Sources) == 0 {
+		return nil, errors.Fatalf("invalid job file %v: no sources", filename)
+	}
+
+	for i, src := range job.Sources {
+		if len(src.Paths) == 0 && len(src.FilesFrom) == 0 {
+			return nil, errors.Fatalf("invalid job file %v: source %d has neither paths nor files_from", filename, i+1)
+		}
+		for _, file := range src.FilesFrom {
+			if file == "-" {
+				return nil, errors.Fatalf("invalid job file %v: source %d reads files_from stdin", filename, i+1)
+			}
+		}
+	}
+
+	return &job, nil
+}
+
+// decodeTOMLStrict decodes data into v and returns an error for keys which
+// are not used, like the strict decoders for YAML and JSON.
+func decodeTOMLStrict(data []byte, v interface{}) error {
+	md, err := toml.Decode(string(data), v)
+	if err != nil {
+		return err
+	}
+
+	if undecoded := md.Undecoded(); len(undecoded) > 0 {
+		return errors.Errorf("unknown keys %v", undecoded)
+	}
+	return nil
+}
+
+// sourceFilter holds the functions which reject items below the targets of
+// a single source.
+type sourceFilter struct {
+	dirs         []string
+	rejectByName []RejectByNameFunc
+	reject       []RejectFunc
+}
+
+// sourceFilters selects the filter of the source an item belongs to.
+type sourceFilters []sourceFilter
+
+// find returns the filter of the source with the longest target which
+// contains item, or nil if item does not belong to any source.
+func (filters sourceFilters) find(item string) *sourceFilter {
+	var (
+		found  *sourceFilter
+		length = -1
+	)
+
+	for i := range filters {
+		for _, dir := range filters[i].dirs {
+			if len(dir) > length && fs.HasPathPrefix(dir, item) {
+				found = &filters[i]
+				length = len(dir)
+			}
+		}
+	}
+
+	return found
+}
+
+// RejectByName rejects items by name with the functions of the source the
+// item belongs to.
+func (filters sourceFilters) RejectByName(item string) bool {
+	f := filters.find(item)
+	if f == nil {
+		return false
+	}
+
+	for _, reject := range f.rejectByName {
+		if reject(item) {
+			return true
+		}
+	}
+	return false
+}
+
+// Reject rejects items with the functions of the source the item belongs to.
+func (filters sourceFilters) Reject(item string, fi os.FileInfo) bool {
+	f := filters.find(item)
+	if f == nil {
+		return false
+	}
+
+	for _, reject := range f.reject {
+		if reject(item, fi) {
+			return true
+		}
+	}
+	return false
+}
+
+// collectJobTargets returns the targets of all sources in job and the
+// targets of each source.
+func collectJobTargets(job *BackupJob) (targets []string, sourceTargets [][]string, err error) {
+	for _, src := range job.Sources {
+		t, err := collectTargets(src.options(), src.Paths)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		targets = append(targets, t...)
+		sourceTargets = append(sourceTargets, t)
+	}
+
+	return targets, sourceTargets, nil
+}
+
+// collectJobFilters returns the filters for the sources in job, the targets
+// of source i are passed in sourceTargets[i].
+func collectJobFilters(job *BackupJob, repo *repository.Repository, sourceTargets [][]string) (sourceFilters, error) {
+	filters := make(sourceFilters, 0, len(job.Sources))
+
+	for i, src := range job.Sources {
+		opts := src.options()
+		f := sourceFilter{}
+
+		for _, target := range sourceTargets[i] {
+			dir, err := filepath.Abs(filepath.Clean(target))
+			if err != nil {
+				return nil, err
+			}
+			f.dirs = append(f.dirs, dir)
+		}
+
+		var err error
+		f.rejectByName, err = collectRejectByPatternFuncs(opts.Excludes, opts.InsensitiveExcludes,
+			opts.ExcludeFiles, opts.InsensitiveExcludeFiles)
+		if err != nil {
+			return nil, err
+		}
+
+		ifPresentFuncs, err := collectRejectIfPresentFuncs(opts.ExcludeIfPresent, opts.ExcludeCaches)
+		if err != nil {
+			return nil, err
+		}
+		f.rejectByName = append(f.rejectByName, ifPresentFuncs...)
+
+		f.reject, err = collectRejectFuncs(opts, repo, sourceTargets[i])
+		if err != nil {
+			return nil, err
+		}
+
+		debug.Log("source %d: dirs %v, %d name filters, %d filters", i, f.dirs, len(f.rejectByName), len(f.reject))
+		filters = append(filters, f)
+	}
+
+	return filters, nil
+}
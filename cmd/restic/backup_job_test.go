@@ -0,0 +1,86 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/restic/restic/internal/test"
+)
+
+func TestReadBackupJob(t *testing.T) {
+	tempDir, cleanup := test.TempDir(t)
+	defer cleanup()
+
+	var tests = []struct {
+		filename string
+		data     string
+		valid    bool
+	}{
+		{"job.yaml", "sources:\n  - paths: [/home]\n    exclude: ['*.go']\n    one_file_system: true\n", true},
+		{"job.yml", "sources:\n  - files_from: [list.txt]\n", true},
+		{"job.json", `{"sources": [{"paths": ["/home"], "exclude": ["*.go"], "one_file_system": true}]}`, true},
+		{"job.yaml", "sources:\n  - paths: [/home]\n    excludes: ['*.go']\n", false},
+		{"job.json", `{"sources": [{"paths": ["/home"], "excludes": ["*.go"]}]}`, false},
+		{"job.yaml", "sources: []\n", false},
+		{"job.yaml", "sources:\n  - exclude: ['*.go']\n", false},
+		{"job.yaml", "sources:\n  - files_from: ['-']\n", false},
+		{"job.toml", "[[sources]]\npaths = [\"/home\"]\nexclude = [\"*.go\"]\none_file_system = true\n", true},
+		{"job.toml", "[[sources]]\npaths = [\"/home\"]\nexcludes = [\"*.go\"]\n", false},
+		{"job.toml", "[[sources]]\nexclude = [\"*.go\"]\n", false},
+		{"job.toml", "sources = [\n", false},
+		{"job.txt", "sources:\n  - paths: [/home]\n", false},
+	}
+
+	for _, tc := range tests {
+		t.Run("", func(t *testing.T) {
+			filename := filepath.Join(tempDir, tc.filename)
+			test.OK(t, ioutil.WriteFile(filename, []byte(tc.data), 0600))
+
+			job, err := readBackupJob(filename)
+			if tc.valid && err != nil {
+				t.Fatalf("unexpected error for %v: %v", tc.data, err)
+			}
+			if !tc.valid && err == nil {
+				t.Fatalf("expected error for %v, got %v", tc.data, job)
+			}
+		})
+	}
+}
+
+func TestSourceFilters(t *testing.T) {
+	filters := sourceFilters{
+		{
+			dirs:         []string{"/home", "/srv"},
+			rejectByName: []RejectByNameFunc{rejectByPattern([]string{"*.go"})},
+		},
+		{
+			dirs:         []string{"/home/user"},
+			rejectByName: []RejectByNameFunc{rejectByPattern([]string{"*.c"})},
+		},
+	}
+
+	var tests = []struct {
+		filename string
+		reject   bool
+	}{
+		{filename: "/home/foo.go", reject: true},
+		{filename: "/home/foo.c", reject: false},
+		{filename: "/srv/x/foo.go", reject: true},
+		{filename: "/home/user/foo.go", reject: false},
+		{filename: "/home/user/foo.c", reject: true},
+		{filename: "/home/username/foo.go", reject: true},
+		{filename: "/var/foo.go", reject: false},
+		{filename: "/", reject: false},
+	}
+
+	for _, tc := range tests {
+		t.Run("", func(t *testing.T) {
+			res := filters.RejectByName(tc.filename)
+			if res != tc.reject {
+				t.Fatalf("wrong result for filename %v: want %v, got %v",
+					tc.filename, tc.reject, res)
+			}
+		})
+	}
+}
@@ -36,6 +36,24 @@ var cmdBackup = &cobra.Command{
 The "backup" command creates a new snapshot and saves the files and directories
 given as the arguments.
 
+With --job, the files and directories are read from a job file in YAML, JSON or
+TOML format instead. The file lists several sources, each with its own exclude
+options, which are all saved in a single snapshot:
+
+    sources:
+      - paths: [/home]
+        exclude: ["*.tmp", "/home/*/.cache"]
+        exclude_larger_than: 1G
+      - paths: [/srv, /var/lib]
+        exclude_if_present: [.nobackup]
+        one_file_system: true
+
+Each source accepts the keys paths, files_from, exclude, iexclude,
+exclude_file, iexclude_file, exclude_if_present, exclude_caches,
+exclude_larger_than and one_file_system, which correspond to the flags of the
+backup command. The exclude flags given on the command line apply to all
+sources.
+
 EXIT STATUS
 ===========
 
@@ -93,9 +111,12 @@ type BackupOptions struct {
 	Tags                    []string
 	Host                    string
 	FilesFrom               []string
+	Job                     string
 	TimeStamp               string
 	WithAtime               bool
 	IgnoreInode             bool
+	CheckpointInterval      time.Duration
+	DryRun                  bool
 }
 
 var backupOptions BackupOptions
@@ -126,9 +147,12 @@ func init() {
 	f.MarkDeprecated("hostname", "use --host")
 
 	f.StringArrayVar(&backupOptions.FilesFrom, "files-from", nil, "read the files to backup from `file` (can be combined with file args/can be specified multiple times)")
+	f.StringVar(&backupOptions.Job, "job", "", "read the sources to backup and their exclude options from a job `file` (YAML, JSON or TOML)")
 	f.StringVar(&backupOptions.TimeStamp, "time", "", "`time` of the backup (ex. '2012-11-01 22:08:41') (default: now)")
 	f.BoolVar(&backupOptions.WithAtime, "with-atime", false, "store the atime for all files and directories")
 	f.BoolVar(&backupOptions.IgnoreInode, "ignore-inode", false, "ignore inode number changes when checking for modified files")
+	f.BoolVarP(&backupOptions.DryRun, "dry-run", "n", false, "do not upload or write any data, just show what would be done")
+	f.DurationVar(&backupOptions.CheckpointInterval, "checkpoint-interval", 5*time.Minute, "save the index of already uploaded data every `interval`, so that an interrupted backup does not upload it again (0 disables checkpoints)")
 }
 
 // filterExisting returns a slice of all existing items, or an error if no
@@ -218,6 +242,16 @@ func (opts BackupOptions) Check(gopts GlobalOptions, args []string) error {
 		}
 	}
 
+	if opts.Job != "" {
+		if opts.Stdin || len(opts.FilesFrom) > 0 || len(args) > 0 {
+			return errors.Fatal("--job cannot be combined with --stdin, --files-from or files/dirs listed as arguments")
+		}
+	}
+
+	if opts.CheckpointInterval < 0 {
+		return errors.Fatal("--checkpoint-interval must not be negative")
+	}
+
 	return nil
 }
 
@@ -234,42 +268,69 @@ func collectRejectByNameFuncs(opts BackupOptions, repo *repository.Repository, t
 		fs = append(fs, f)
 	}
 
-	// add patterns from file
-	if len(opts.ExcludeFiles) > 0 {
-		excludes, err := readExcludePatternsFromFiles(opts.ExcludeFiles)
-		if err != nil {
-			return nil, err
-		}
-		opts.Excludes = append(opts.Excludes, excludes...)
+	patternFuncs, err := collectRejectByPatternFuncs(opts.Excludes, opts.InsensitiveExcludes,
+		opts.ExcludeFiles, opts.InsensitiveExcludeFiles)
+	if err != nil {
+		return nil, err
 	}
+	fs = append(fs, patternFuncs...)
 
-	if len(opts.InsensitiveExcludeFiles) > 0 {
-		excludes, err := readExcludePatternsFromFiles(opts.InsensitiveExcludeFiles)
+	ifPresentFuncs, err := collectRejectIfPresentFuncs(opts.ExcludeIfPresent, opts.ExcludeCaches)
+	if err != nil {
+		return nil, err
+	}
+	fs = append(fs, ifPresentFuncs...)
+
+	return fs, nil
+}
+
+// collectRejectIfPresentFuncs returns the functions which reject the contents
+// of directories containing one of the files in excludeIfPresent, or a
+// CACHEDIR.TAG file if excludeCaches is set.
+func collectRejectIfPresentFuncs(excludeIfPresent []string, excludeCaches bool) (fs []RejectByNameFunc, err error) {
+	if excludeCaches {
+		excludeIfPresent = append(excludeIfPresent, "CACHEDIR.TAG:Signature: 8a477f597d28d172789f06886806bc55")
+	}
+
+	for _, spec := range excludeIfPresent {
+		f, err := rejectIfPresent(spec)
 		if err != nil {
 			return nil, err
 		}
-		opts.InsensitiveExcludes = append(opts.InsensitiveExcludes, excludes...)
-	}
 
-	if len(opts.InsensitiveExcludes) > 0 {
-		fs = append(fs, rejectByInsensitivePattern(opts.InsensitiveExcludes))
+		fs = append(fs, f)
 	}
 
-	if len(opts.Excludes) > 0 {
-		fs = append(fs, rejectByPattern(opts.Excludes))
-	}
+	return fs, nil
+}
 
-	if opts.ExcludeCaches {
-		opts.ExcludeIfPresent = append(opts.ExcludeIfPresent, "CACHEDIR.TAG:Signature: 8a477f597d28d172789f06886806bc55")
+// collectRejectByPatternFuncs returns the functions which reject items by
+// matching their path against the exclude patterns, including the patterns
+// read from the exclude files.
+func collectRejectByPatternFuncs(excludes, insensitiveExcludes, excludeFiles, insensitiveExcludeFiles []string) (fs []RejectByNameFunc, err error) {
+	// add patterns from file
+	if len(excludeFiles) > 0 {
+		patterns, err := readExcludePatternsFromFiles(excludeFiles)
+		if err != nil {
+			return nil, err
+		}
+		excludes = append(excludes, patterns...)
 	}
 
-	for _, spec := range opts.ExcludeIfPresent {
-		f, err := rejectIfPresent(spec)
+	if len(insensitiveExcludeFiles) > 0 {
+		patterns, err := readExcludePatternsFromFiles(insensitiveExcludeFiles)
 		if err != nil {
 			return nil, err
 		}
+		insensitiveExcludes = append(insensitiveExcludes, patterns...)
+	}
 
-		fs = append(fs, f)
+	if len(insensitiveExcludes) > 0 {
+		fs = append(fs, rejectByInsensitivePattern(insensitiveExcludes))
+	}
+
+	if len(excludes) > 0 {
+		fs = append(fs, rejectByPattern(excludes))
 	}
 
 	return fs, nil
@@ -421,7 +482,20 @@ func runBackup(opts BackupOptions, gopts GlobalOptions, term *termstatus.Termina
 		return err
 	}
 
-	targets, err := collectTargets(opts, args)
+	var (
+		job           *BackupJob
+		targets       []string
+		sourceTargets [][]string
+	)
+	if opts.Job != "" {
+		job, err = readBackupJob(opts.Job)
+		if err != nil {
+			return err
+		}
+		targets, sourceTargets, err = collectJobTargets(job)
+	} else {
+		targets, err = collectTargets(opts, args)
+	}
 	if err != nil {
 		return err
 	}
@@ -449,9 +523,11 @@ func runBackup(opts BackupOptions, gopts GlobalOptions, term *termstatus.Termina
 		CompleteItem(item string, previous, current *restic.Node, s archiver.ItemStats, d time.Duration)
 		StartFile(filename string)
 		CompleteBlob(filename string, bytes uint64)
+		CompletePack(bytes uint64)
 		ScannerError(item string, fi os.FileInfo, err error) error
 		ReportTotal(item string, s archiver.ScanStats)
 		SetMinUpdatePause(d time.Duration)
+		SetDryRun()
 		Run(ctx context.Context) error
 		Error(item string, fi os.FileInfo, err error) error
 		Finish(snapshotID restic.ID)
@@ -492,6 +568,7 @@ func runBackup(opts BackupOptions, gopts GlobalOptions, term *termstatus.Termina
 	}
 
 	t.Go(func() error { return p.Run(t.Context(gopts.ctx)) })
+	repo.SetUploadProgress(p.CompletePack)
 
 	if !gopts.JSON {
 		p.V("lock repository")
@@ -514,17 +591,44 @@ func runBackup(opts BackupOptions, gopts GlobalOptions, term *termstatus.Termina
 		return err
 	}
 
-	if !gopts.JSON {
-		p.V("load index files")
-	}
-	err = repo.LoadIndex(gopts.ctx)
-	if err != nil {
-		return err
+	excludes := opts.Excludes
+	if job != nil {
+		// the options of each source only apply to the items below its targets
+		filters, err := collectJobFilters(job, repo, sourceTargets)
+		if err != nil {
+			return err
+		}
+		rejectByNameFuncs = append(rejectByNameFuncs, filters.RejectByName)
+		rejectFuncs = append(rejectFuncs, filters.Reject)
+
+		for _, src := range job.Sources {
+			excludes = append(excludes, src.Excludes...)
+		}
 	}
 
-	parentSnapshotID, err := findParentSnapshot(gopts.ctx, repo, opts, targets)
-	if err != nil {
-		return err
+	var parentSnapshotID *restic.ID
+	if repo.WriteOnly() {
+		// neither the index nor the snapshots can be read, so data is only
+		// deduplicated within this backup
+		if opts.Parent != "" {
+			return errors.Fatal("--parent cannot be used with a write-only key")
+		}
+		if !gopts.JSON {
+			p.V("repository opened with a write-only key, all data is saved again")
+		}
+	} else {
+		if !gopts.JSON {
+			p.V("load index files")
+		}
+		err = repo.LoadIndex(gopts.ctx)
+		if err != nil {
+			return err
+		}
+
+		parentSnapshotID, err = findParentSnapshot(gopts.ctx, repo, opts, targets)
+		if err != nil {
+			return err
+		}
 	}
 
 	if !gopts.JSON && parentSnapshotID != nil {
@@ -575,7 +679,14 @@ func runBackup(opts BackupOptions, gopts GlobalOptions, term *termstatus.Termina
 	}
 	t.Go(func() error { return sc.Scan(t.Context(gopts.ctx), targets) })
 
-	arch := archiver.New(repo, targetFS, archiver.Options{})
+	var archiveRepo restic.Repository = repo
+	if opts.DryRun {
+		// look up blobs in the index, but never upload anything
+		archiveRepo = archiver.NewDryRunRepository(repo)
+		p.SetDryRun()
+	}
+
+	arch := archiver.New(archiveRepo, targetFS, archiver.Options{CheckpointInterval: opts.CheckpointInterval})
 	arch.SelectByName = selectByNameFilter
 	arch.Select = selectFilter
 	arch.WithAtime = opts.WithAtime
@@ -594,7 +705,7 @@ func runBackup(opts BackupOptions, gopts GlobalOptions, term *termstatus.Termina
 	}
 
 	snapshotOpts := archiver.SnapshotOptions{
-		Excludes:       opts.Excludes,
+		Excludes:       excludes,
 		Tags:           opts.Tags,
 		Time:           timeStamp,
 		Hostname:       opts.Host,
@@ -618,7 +729,11 @@ func runBackup(opts BackupOptions, gopts GlobalOptions, term *termstatus.Termina
 	// Report finished execution
 	p.Finish(id)
 	if !gopts.JSON {
-		p.P("snapshot %s saved\n", id.Str())
+		if opts.DryRun {
+			p.P("dry run, no snapshot saved\n")
+		} else {
+			p.P("snapshot %s saved\n", id.Str())
+		}
 	}
 	if !success {
 		return ErrInvalidSourceData
@@ -10,6 +10,7 @@ import (
 	"github.com/restic/restic/internal/cache"
 	"github.com/restic/restic/internal/errors"
 	"github.com/restic/restic/internal/fs"
+	"github.com/restic/restic/internal/ui/json"
 	"github.com/restic/restic/internal/ui/table"
 	"github.com/spf13/cobra"
 )
@@ -76,21 +77,31 @@ func runCache(opts CacheOptions, gopts GlobalOptions, args []string) error {
 			return err
 		}
 
+		summary := &json.CacheSummary{BaseDir: cachedir}
+
 		if len(oldDirs) == 0 {
 			Verbosef("no old cache dirs found\n")
-			return nil
+		} else {
+			Verbosef("remove %d old cache directories\n", len(oldDirs))
 		}
 
-		Verbosef("remove %d old cache directories\n", len(oldDirs))
-
 		for _, item := range oldDirs {
 			dir := filepath.Join(cachedir, item.Name())
 			err = fs.RemoveAll(dir)
 			if err != nil {
-				Warnf("unable to remove %v: %v\n", dir, err)
+				if gopts.JSON {
+					printJSONError("remove cache", dir, err)
+				} else {
+					Warnf("unable to remove %v: %v\n", dir, err)
+				}
+				continue
 			}
+			summary.Removed = append(summary.Removed, item.Name())
 		}
 
+		if gopts.JSON {
+			printJSON(json.TypeSummary, summary)
+		}
 		return nil
 	}
 
@@ -116,7 +127,7 @@ func runCache(opts CacheOptions, gopts GlobalOptions, args []string) error {
 		return err
 	}
 
-	if len(dirs) == 0 {
+	if len(dirs) == 0 && !gopts.JSON {
 		Printf("no cache dirs found, basedir is %v\n", cachedir)
 		return nil
 	}
@@ -125,21 +136,38 @@ func runCache(opts CacheOptions, gopts GlobalOptions, args []string) error {
 		return dirs[i].ModTime().Before(dirs[j].ModTime())
 	})
 
+	summary := &json.CacheSummary{BaseDir: cachedir}
+
 	for _, entry := range dirs {
+		isOld := cache.IsOld(entry.ModTime(), time.Duration(opts.MaxAge)*24*time.Hour)
 		var old string
-		if cache.IsOld(entry.ModTime(), time.Duration(opts.MaxAge)*24*time.Hour) {
+		if isOld {
 			old = "yes"
 		}
 
 		var size string
+		var bytes int64
 		if !opts.NoSize {
-			bytes, err := dirSize(filepath.Join(cachedir, entry.Name()))
+			bytes, err = dirSize(filepath.Join(cachedir, entry.Name()))
 			if err != nil {
 				return err
 			}
 			size = fmt.Sprintf("%11s", formatBytes(uint64(bytes)))
 		}
 
+		if gopts.JSON {
+			dir := json.CacheDir{
+				RepositoryID: entry.Name(),
+				LastUsed:     entry.ModTime(),
+				Old:          isOld,
+			}
+			if !opts.NoSize {
+				dir.Size = &bytes
+			}
+			summary.Dirs = append(summary.Dirs, dir)
+			continue
+		}
+
 		tab.AddRow(data{
 			entry.Name()[:10],
 			fmt.Sprintf("%d days ago", uint(time.Since(entry.ModTime()).Hours()/24)),
@@ -148,6 +176,11 @@ func runCache(opts CacheOptions, gopts GlobalOptions, args []string) error {
 		})
 	}
 
+	if gopts.JSON {
+		printJSON(json.TypeSummary, summary)
+		return nil
+	}
+
 	tab.Write(gopts.stdout)
 	Printf("%d cache dirs in %s\n", len(dirs), cachedir)
 
@@ -12,7 +12,7 @@ import (
 )
 
 var cmdCat = &cobra.Command{
-	Use:   "cat [flags] [pack|blob|snapshot|index|key|masterkey|config|lock] ID",
+	Use:   "cat [flags] [pack|blob|snapshot|index|key|masterkey|config|lock|policy] ID",
 	Short: "Print internal objects to stdout",
 	Long: `
 The "cat" command is used to print internal objects to stdout.
@@ -96,6 +96,14 @@ func runCat(gopts GlobalOptions, args []string) error {
 			return err
 		}
 
+		Println(string(buf))
+		return nil
+	case "policy":
+		buf, err := repo.LoadAndDecrypt(gopts.ctx, nil, restic.PolicyFile, id)
+		if err != nil {
+			return err
+		}
+
 		Println(string(buf))
 		return nil
 	case "key":
@@ -119,6 +127,10 @@ func runCat(gopts GlobalOptions, args []string) error {
 		Println(string(buf))
 		return nil
 	case "masterkey":
+		if repo.WriteOnly() {
+			return repository.ErrWriteOnly
+		}
+
 		buf, err := json.MarshalIndent(repo.Key(), "", "  ")
 		if err != nil {
 			return err
@@ -12,6 +12,7 @@ import (
 	"github.com/restic/restic/internal/errors"
 	"github.com/restic/restic/internal/fs"
 	"github.com/restic/restic/internal/restic"
+	"github.com/restic/restic/internal/ui/json"
 )
 
 var cmdCheck = &cobra.Command{
@@ -170,26 +171,61 @@ func runCheck(opts CheckOptions, gopts GlobalOptions, args []string) error {
 	Verbosef("load indexes\n")
 	hints, errs := chkr.LoadIndex(gopts.ctx)
 
+	summary := &json.CheckSummary{}
+
 	dupFound := false
 	for _, hint := range hints {
-		Printf("%v\n", hint)
+		if gopts.JSON {
+			summary.Hints = append(summary.Hints, hint.Error())
+		} else {
+			Printf("%v\n", hint)
+		}
 		if _, ok := hint.(checker.ErrDuplicatePacks); ok {
 			dupFound = true
 		}
 	}
 
-	if dupFound {
+	if dupFound && !gopts.JSON {
 		Printf("This is non-critical, you can run `restic rebuild-index' to correct this\n")
 	}
 
 	if len(errs) > 0 {
 		for _, err := range errs {
-			Warnf("error: %v\n", err)
+			if gopts.JSON {
+				printJSONError("load index", "", err)
+			} else {
+				Warnf("error: %v\n", err)
+			}
 		}
 		return errors.Fatal("LoadIndex returned errors")
 	}
 
-	errorsFound := false
+	reportError := func(during, item string, err error) {
+		summary.NumErrors++
+		if gopts.JSON {
+			printJSONError(during, item, err)
+		}
+	}
+
+	if repo.Config().AppendOnly {
+		Verbosef("check that the backend rejects deleting files\n")
+		err = chkr.AppendOnly(gopts.ctx)
+		if err == checker.ErrDeleteAllowed {
+			// the backend may still keep the deleted file, e.g. S3 with
+			// object lock, so this is only a hint
+			if gopts.JSON {
+				summary.Hints = append(summary.Hints, err.Error())
+			} else {
+				Printf("%v\n", err)
+			}
+		} else if err != nil {
+			reportError("check append-only", "", err)
+			if !gopts.JSON {
+				Warnf("error: %v\n", err)
+			}
+		}
+	}
+
 	orphanedPacks := 0
 	errChan := make(chan error)
 
@@ -202,34 +238,44 @@ func runCheck(opts CheckOptions, gopts GlobalOptions, args []string) error {
 			Verbosef("%v\n", err)
 			continue
 		}
-		errorsFound = true
-		Warnf("%v\n", err)
+		reportError("check packs", "", err)
+		if !gopts.JSON {
+			Warnf("%v\n", err)
+		}
 	}
 
 	if orphanedPacks > 0 {
 		Verbosef("%d additional files were found in the repo, which likely contain duplicate data.\nYou can run `restic prune` to correct this.\n", orphanedPacks)
 	}
+	summary.OrphanedPacks = orphanedPacks
 
 	Verbosef("check snapshots, trees and blobs\n")
 	errChan = make(chan error)
 	go chkr.Structure(gopts.ctx, errChan)
 
 	for err := range errChan {
-		errorsFound = true
 		if e, ok := err.(checker.TreeError); ok {
-			Warnf("error for tree %v:\n", e.ID.Str())
+			if !gopts.JSON {
+				Warnf("error for tree %v:\n", e.ID.Str())
+			}
 			for _, treeErr := range e.Errors {
-				Warnf("  %v\n", treeErr)
+				reportError("check structure", e.ID.String(), treeErr)
+				if !gopts.JSON {
+					Warnf("  %v\n", treeErr)
+				}
 			}
 		} else {
-			Warnf("error: %v\n", err)
+			reportError("check structure", "", err)
+			if !gopts.JSON {
+				Warnf("error: %v\n", err)
+			}
 		}
 	}
 
 	if opts.CheckUnused {
 		for _, id := range chkr.UnusedBlobs() {
+			reportError("check unused", id.String(), errors.New("unused blob"))
 			Verbosef("unused blob %v\n", id)
-			errorsFound = true
 		}
 	}
 
@@ -249,6 +295,7 @@ func runCheck(opts CheckOptions, gopts GlobalOptions, args []string) error {
 		} else {
 			Verbosef("read all data\n")
 		}
+		summary.ReadPacks = packCount
 
 		p := newProgressMax(!gopts.Quiet, packCount, "packs")
 		errChan := make(chan error)
@@ -256,8 +303,10 @@ func runCheck(opts CheckOptions, gopts GlobalOptions, args []string) error {
 		go chkr.ReadPacks(gopts.ctx, packs, p, errChan)
 
 		for err := range errChan {
-			errorsFound = true
-			Warnf("%v\n", err)
+			reportError("read data", "", err)
+			if !gopts.JSON {
+				Warnf("%v\n", err)
+			}
 		}
 	}
 
@@ -269,7 +318,11 @@ func runCheck(opts CheckOptions, gopts GlobalOptions, args []string) error {
 		doReadData(dataSubset[0], dataSubset[1])
 	}
 
-	if errorsFound {
+	if gopts.JSON {
+		printJSON(json.TypeSummary, summary)
+	}
+
+	if summary.NumErrors > 0 {
 		return errors.Fatal("repository contains errors")
 	}
 
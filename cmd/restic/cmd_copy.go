@@ -3,10 +3,14 @@ package main
 import (
 	"context"
 	"fmt"
+	"io"
 
+	"github.com/restic/chunker"
 	"github.com/restic/restic/internal/debug"
 	"github.com/restic/restic/internal/restic"
+	"github.com/restic/restic/internal/ui/json"
 
+	"github.com/hashicorp/golang-lru/simplelru"
 	"github.com/spf13/cobra"
 )
 
@@ -20,6 +24,10 @@ entire snapshot(s) due to the different encryption keys on the source and
 destination, and that transferred files are not re-chunked, which may break
 their deduplication. This can be mitigated by the "--copy-chunker-params"
 option when initializing a new destination repository using the "init" command.
+
+If the repositories use different chunk size profiles, files are split into
+chunks again using the parameters of the destination repository. The copied
+snapshots then reference new trees.
 `,
 	RunE: func(cmd *cobra.Command, args []string) error {
 		return runCopy(copyOptions, globalOptions, args)
@@ -103,6 +111,17 @@ func runCopy(opts CopyOptions, gopts GlobalOptions, args []string) error {
 		buf:          nil,
 	}
 
+	if srcRepo.Config().ChunkerParams() != dstRepo.Config().ChunkerParams() {
+		Verbosef("chunk size profiles differ, files will be split into chunks again\n")
+		cloner.rechunk = true
+		cloner.rechunkedTrees = make(map[restic.ID]restic.ID)
+		cloner.rechunkedFiles, err = simplelru.NewLRU(rechunkedFilesCacheSize, nil)
+		if err != nil {
+			return err
+		}
+	}
+
+	summary := &json.CopySummary{}
 	for sn := range FindFilteredSnapshots(ctx, srcRepo, opts.Hosts, opts.Tags, opts.Paths, args) {
 		Verbosef("\nsnapshot %s of %v at %s)\n", sn.ID().Str(), sn.Paths, sn.Time)
 
@@ -114,19 +133,32 @@ func runCopy(opts CopyOptions, gopts GlobalOptions, args []string) error {
 		if originalSns, ok := dstSnapshotByOriginal[srcOriginal]; ok {
 			isCopy := false
 			for _, originalSn := range originalSns {
-				if similarSnapshots(originalSn, sn) {
+				if similarSnapshots(originalSn, sn, cloner.rechunk) {
 					Verbosef("skipping source snapshot %s, was already copied to snapshot %s\n", sn.ID().Str(), originalSn.ID().Str())
 					isCopy = true
 					break
 				}
 			}
 			if isCopy {
+				summary.Skipped++
+				if gopts.JSON {
+					printJSON(json.TypeVerboseStatus, &json.SnapshotAction{
+						Action:     "skipped",
+						SnapshotID: sn.ID().String(),
+					})
+				}
 				continue
 			}
 		}
 		Verbosef("  copy started, this may take a while...\n")
 
-		if err := cloner.copyTree(ctx, *sn.Tree); err != nil {
+		if cloner.rechunk {
+			treeID, err := cloner.rechunkTree(ctx, *sn.Tree)
+			if err != nil {
+				return err
+			}
+			sn.Tree = &treeID
+		} else if err := cloner.copyTree(ctx, *sn.Tree); err != nil {
 			return err
 		}
 		debug.Log("tree copied")
@@ -147,13 +179,32 @@ func runCopy(opts CopyOptions, gopts GlobalOptions, args []string) error {
 			return err
 		}
 		Verbosef("snapshot %s saved\n", newID.Str())
+
+		summary.Copied++
+		if gopts.JSON {
+			printJSON(json.TypeVerboseStatus, &json.SnapshotAction{
+				Action:     "copied",
+				SnapshotID: sn.ID().String(),
+				NewID:      newID.String(),
+			})
+		}
+	}
+
+	if gopts.JSON {
+		printJSON(json.TypeSummary, summary)
 	}
 	return nil
 }
 
-func similarSnapshots(sna *restic.Snapshot, snb *restic.Snapshot) bool {
+// similarSnapshots returns true if sna and snb are copies of the same
+// snapshot. If ignoreTree is set, the trees are not compared, they differ for
+// snapshots whose files were split into chunks again.
+func similarSnapshots(sna *restic.Snapshot, snb *restic.Snapshot, ignoreTree bool) bool {
+	if !ignoreTree && !sna.Tree.Equal(*snb.Tree) {
+		return false
+	}
 	// everything except Parent and Original must match
-	if !sna.Time.Equal(snb.Time) || !sna.Tree.Equal(*snb.Tree) || sna.Hostname != snb.Hostname ||
+	if !sna.Time.Equal(snb.Time) || sna.Hostname != snb.Hostname ||
 		sna.Username != snb.Username || sna.UID != snb.UID || sna.GID != snb.GID ||
 		len(sna.Paths) != len(snb.Paths) || len(sna.Excludes) != len(snb.Excludes) ||
 		len(sna.Tags) != len(snb.Tags) {
@@ -175,8 +226,24 @@ type treeCloner struct {
 	dstRepo      restic.Repository
 	visitedTrees restic.IDSet
 	buf          []byte
+
+	// rechunk is set if files must be split into chunks again because the
+	// repositories use different chunker parameters
+	rechunk        bool
+	chunker        *chunker.Chunker
+	chunkBuf       []byte
+	rechunkedTrees map[restic.ID]restic.ID
+	// rechunkedFiles maps the hash of the content of recently split files to
+	// the IDs of their new chunks
+	rechunkedFiles *simplelru.LRU
 }
 
+// rechunkedFilesCacheSize is the number of files whose new chunks are
+// remembered, so that files which are contained in many snapshots are only
+// split once. If a file is split again, all its chunks are already stored in
+// the destination repository.
+const rechunkedFilesCacheSize = 16 * 1024
+
 func (t *treeCloner) copyTree(ctx context.Context, treeID restic.ID) error {
 	// We have already processed this tree
 	if t.visitedTrees.Has(treeID) {
@@ -231,3 +298,120 @@ func (t *treeCloner) copyTree(ctx context.Context, treeID restic.ID) error {
 
 	return nil
 }
+
+// rechunkTree copies the tree treeID and all its subtrees to the destination
+// repository, splitting all files into chunks again. The ID of the new tree
+// is returned.
+func (t *treeCloner) rechunkTree(ctx context.Context, treeID restic.ID) (restic.ID, error) {
+	if newID, ok := t.rechunkedTrees[treeID]; ok {
+		return newID, nil
+	}
+
+	tree, err := t.srcRepo.LoadTree(ctx, treeID)
+	if err != nil {
+		return restic.ID{}, fmt.Errorf("LoadTree(%v) returned error %v", treeID.Str(), err)
+	}
+
+	for _, node := range tree.Nodes {
+		switch {
+		case node.Type == "dir" && node.Subtree != nil:
+			subtreeID, err := t.rechunkTree(ctx, *node.Subtree)
+			if err != nil {
+				return restic.ID{}, err
+			}
+			node.Subtree = &subtreeID
+		case node.Type == "file":
+			node.Content, err = t.rechunkFile(ctx, node.Content)
+			if err != nil {
+				return restic.ID{}, err
+			}
+		}
+	}
+
+	newID, err := t.dstRepo.SaveTree(ctx, tree)
+	if err != nil {
+		return restic.ID{}, fmt.Errorf("SaveTree(%v) returned error %v", treeID.Str(), err)
+	}
+	debug.Log("tree %v saved as %v", treeID.Str(), newID.Str())
+
+	t.rechunkedTrees[treeID] = newID
+	return newID, nil
+}
+
+// rechunkFile splits the file consisting of the blobs content into chunks
+// using the parameters of the destination repository, saves the chunks which
+// are not yet stored there and returns their IDs.
+func (t *treeCloner) rechunkFile(ctx context.Context, content restic.IDs) (restic.IDs, error) {
+	// files with the same content are only split once
+	buf := make([]byte, 0, len(content)*len(restic.ID{}))
+	for _, id := range content {
+		buf = append(buf, id[:]...)
+	}
+	key := restic.Hash(buf)
+	if ids, ok := t.rechunkedFiles.Get(key); ok {
+		return ids.(restic.IDs), nil
+	}
+
+	cfg := t.dstRepo.Config()
+	rd := &blobReader{ctx: ctx, repo: t.srcRepo, blobs: content}
+	if t.chunker == nil {
+		t.chunker = cfg.ChunkerParams().NewChunker(rd, cfg.ChunkerPolynomial)
+	} else {
+		cfg.ChunkerParams().ResetChunker(t.chunker, rd, cfg.ChunkerPolynomial)
+	}
+
+	ids := restic.IDs{}
+	for {
+		chunk, err := t.chunker.Next(t.chunkBuf)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		t.chunkBuf = chunk.Data
+
+		id := restic.Hash(chunk.Data)
+		if !t.dstRepo.Index().Has(id, restic.DataBlob) {
+			debug.Log("saving chunk %s", id.Str())
+			_, _, err = t.dstRepo.SaveBlob(ctx, restic.DataBlob, chunk.Data, id, false)
+			if err != nil {
+				return nil, fmt.Errorf("SaveBlob(%v) returned error %v", id, err)
+			}
+		}
+		ids = append(ids, id)
+	}
+
+	t.rechunkedFiles.Add(key, ids)
+	return ids, nil
+}
+
+// blobReader reads the content of a file from the data blobs in a repository.
+type blobReader struct {
+	ctx   context.Context
+	repo  restic.Repository
+	blobs restic.IDs
+
+	buf  []byte
+	rest []byte
+}
+
+func (r *blobReader) Read(p []byte) (int, error) {
+	for len(r.rest) == 0 {
+		if len(r.blobs) == 0 {
+			return 0, io.EOF
+		}
+
+		var err error
+		r.buf, err = r.repo.LoadBlob(r.ctx, restic.DataBlob, r.blobs[0], r.buf)
+		if err != nil {
+			return 0, fmt.Errorf("LoadBlob(%v) returned error %v", r.blobs[0], err)
+		}
+		r.rest = r.buf
+		r.blobs = r.blobs[1:]
+	}
+
+	n := copy(p, r.rest)
+	r.rest = r.rest[n:]
+	return n, nil
+}
@@ -76,10 +76,11 @@ type Pack struct {
 
 // Blob is the struct used in printPacks.
 type Blob struct {
-	Type   restic.BlobType `json:"type"`
-	Length uint            `json:"length"`
-	ID     restic.ID       `json:"id"`
-	Offset uint            `json:"offset"`
+	Type               restic.BlobType `json:"type"`
+	Length             uint            `json:"length"`
+	ID                 restic.ID       `json:"id"`
+	Offset             uint            `json:"offset"`
+	UncompressedLength uint            `json:"uncompressed_length,omitempty"`
 }
 
 func printPacks(ctx context.Context, repo *repository.Repository, wr io.Writer) error {
@@ -99,10 +100,11 @@ func printPacks(ctx context.Context, repo *repository.Repository, wr io.Writer)
 		}
 		for i, blob := range blobs {
 			p.Blobs[i] = Blob{
-				Type:   blob.Type,
-				Length: blob.Length,
-				ID:     blob.ID,
-				Offset: blob.Offset,
+				Type:               blob.Type,
+				Length:             blob.Length,
+				ID:                 blob.ID,
+				Offset:             blob.Offset,
+				UncompressedLength: blob.UncompressedLength,
 			}
 		}
 
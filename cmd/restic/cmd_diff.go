@@ -1,25 +1,32 @@
 package main
 
 import (
+	"bytes"
 	"context"
+	"math"
 	"path"
 	"reflect"
 	"sort"
 
 	"github.com/restic/restic/internal/debug"
 	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/fs"
 	"github.com/restic/restic/internal/repository"
 	"github.com/restic/restic/internal/restic"
+	"github.com/restic/restic/internal/textdiff"
+	"github.com/restic/restic/internal/ui/json"
 	"github.com/spf13/cobra"
 )
 
 var cmdDiff = &cobra.Command{
-	Use:   "diff [flags] snapshot-ID snapshot-ID",
+	Use:   "diff [flags] snapshot-ID snapshot-ID | snapshot-ID --to-local path",
 	Short: "Show differences between two snapshots",
 	Long: `
 The "diff" command shows differences from the first to the second snapshot. The
 first characters in each line display what has happened to a particular file or
-directory:
+directory. With --to-local, the snapshot is compared to the files in the
+local directory instead, which must be contained in the snapshot at the same
+path:
 
 * +  The item was added
 * -  The item was removed
@@ -27,6 +34,15 @@ directory:
 * M  The file's content was modified
 * T  The type was changed, e.g. a file was made a symlink
 
+With --patch, the changed lines of modified text files are printed as a unified
+diff. Both versions of a file are only loaded from the repository if they are
+not larger than --max-size. Binary files are only reported with their sizes.
+
+Local files are considered modified if their size, modification time, change
+time or inode differ from the snapshot, like during backup. With --content,
+local files are split into chunks and compared to the data in the snapshot
+instead.
+
 EXIT STATUS
 ===========
 
@@ -41,6 +57,11 @@ Exit status is 0 if the command was successful, and non-zero if there was any er
 // DiffOptions collects all options for the diff command.
 type DiffOptions struct {
 	ShowMetadata bool
+	Patch        bool
+	MaxSize      string
+	ToLocal      string
+	Content      bool
+	IgnoreInode  bool
 }
 
 var diffOptions DiffOptions
@@ -50,6 +71,11 @@ func init() {
 
 	f := cmdDiff.Flags()
 	f.BoolVar(&diffOptions.ShowMetadata, "metadata", false, "print changes in metadata")
+	f.BoolVar(&diffOptions.Patch, "patch", false, "print the changed lines of modified text files")
+	f.StringVar(&diffOptions.MaxSize, "max-size", "1M", "only print changed lines of files up to `size` (allowed suffixes: k/K, m/M, g/G, t/T)")
+	f.StringVar(&diffOptions.ToLocal, "to-local", "", "compare the snapshot to the local directory `path`")
+	f.BoolVar(&diffOptions.Content, "content", false, "compare the content of local files instead of their metadata (requires --to-local)")
+	f.BoolVar(&diffOptions.IgnoreInode, "ignore-inode", false, "ignore inode number and change time changes of local files (requires --to-local)")
 }
 
 func loadSnapshot(ctx context.Context, repo *repository.Repository, desc string) (*restic.Snapshot, error) {
@@ -63,8 +89,127 @@ func loadSnapshot(ctx context.Context, repo *repository.Repository, desc string)
 
 // Comparer collects all things needed to compare two snapshots.
 type Comparer struct {
-	repo restic.Repository
-	opts DiffOptions
+	repo    restic.Repository
+	local   fs.FS
+	opts    DiffOptions
+	json    bool
+	maxSize uint64
+}
+
+// printChange prints a changed item, mode is the modifier described in the
+// help text of the command.
+func (c *Comparer) printChange(mode, name string) {
+	if c.json {
+		printJSON(json.TypeChange, &json.DiffChange{Path: name, Modifier: mode})
+		return
+	}
+	Printf("%-5s%v\n", mode, name)
+}
+
+// patchContext is the number of unchanged lines printed around changes.
+const patchContext = 3
+
+// isText returns true if data looks like text, i.e. its beginning does not
+// contain any null bytes.
+func isText(data []byte) bool {
+	if len(data) > 8000 {
+		data = data[:8000]
+	}
+	return bytes.IndexByte(data, 0) < 0
+}
+
+// loadFile returns the content of the file node.
+func (c *Comparer) loadFile(ctx context.Context, node *restic.Node) ([]byte, error) {
+	data := make([]byte, 0, node.Size)
+	for _, id := range node.Content {
+		buf, err := c.repo.LoadBlob(ctx, restic.DataBlob, id, nil)
+		if err != nil {
+			return nil, err
+		}
+		data = append(data, buf...)
+	}
+	return data, nil
+}
+
+// diffContent compares the content of the modified files node1 and node2.
+func (c *Comparer) diffContent(ctx context.Context, node1, node2 *restic.Node) (*json.DiffPatch, error) {
+	if node1.Size > c.maxSize || node2.Size > c.maxSize {
+		return &json.DiffPatch{TooLarge: true, OldSize: node1.Size, NewSize: node2.Size}, nil
+	}
+
+	old, err := c.loadFile(ctx, node1)
+	if err != nil {
+		return nil, err
+	}
+	new, err := c.loadFile(ctx, node2)
+	if err != nil {
+		return nil, err
+	}
+
+	return newPatch(old, new), nil
+}
+
+// newPatch returns the changes from old to new.
+func newPatch(old, new []byte) *json.DiffPatch {
+	patch := &json.DiffPatch{
+		OldSize: uint64(len(old)),
+		NewSize: uint64(len(new)),
+	}
+
+	if !isText(old) || !isText(new) {
+		patch.Binary = true
+		return patch
+	}
+
+	for _, h := range textdiff.Unified(old, new, patchContext) {
+		patch.Hunks = append(patch.Hunks, json.DiffHunk{
+			OldStart: h.OldStart,
+			OldLines: h.OldLines,
+			NewStart: h.NewStart,
+			NewLines: h.NewLines,
+			Lines:    h.Lines,
+		})
+	}
+	return patch
+}
+
+// printPatch prints a modified file together with the changes of its content.
+func (c *Comparer) printPatch(mode, name string, patch *json.DiffPatch) {
+	if c.json {
+		printJSON(json.TypeChange, &json.DiffChange{Path: name, Modifier: mode, Patch: patch})
+		return
+	}
+
+	Printf("%-5s%v\n", mode, name)
+	switch {
+	case patch.TooLarge:
+		Printf("File a%v or b%v is too large to compare (%v, %v)\n", name, name,
+			formatBytes(patch.OldSize), formatBytes(patch.NewSize))
+	case patch.Binary:
+		Printf("Binary files a%v and b%v differ (%v, %v)\n", name, name,
+			formatBytes(patch.OldSize), formatBytes(patch.NewSize))
+	case len(patch.Hunks) > 0:
+		Printf("--- a%v\n", name)
+		Printf("+++ b%v\n", name)
+		for _, h := range patch.Hunks {
+			Printf("%v", textdiff.Hunk{
+				OldStart: h.OldStart,
+				OldLines: h.OldLines,
+				NewStart: h.NewStart,
+				NewLines: h.NewLines,
+				Lines:    h.Lines,
+			})
+		}
+	}
+}
+
+// printError prints an error which occurred while comparing the snapshots.
+func (c *Comparer) printError(err error) {
+	if c.json {
+		printJSONError("diff", "", err)
+		return
+	}
+	Warnf("error: %v\n", err)
 }
 
 // DiffStat collects stats for all types of items.
@@ -143,7 +288,11 @@ func updateBlobs(repo restic.Repository, blobs restic.BlobSet, stats *DiffStat)
 
 		size, found := repo.LookupBlobSize(h.ID, h.Type)
 		if !found {
-			Warnf("unable to find blob size for %v\n", h)
+			if globalOptions.JSON {
+				printJSONError("diff", h.ID.String(), errors.New("unable to find blob size"))
+			} else {
+				Warnf("unable to find blob size for %v\n", h)
+			}
 			continue
 		}
 
@@ -163,14 +312,14 @@ func (c *Comparer) printDir(ctx context.Context, mode string, stats *DiffStat, b
 		if node.Type == "dir" {
 			name += "/"
 		}
-		Printf("%-5s%v\n", mode, name)
+		c.printChange(mode, name)
 		stats.Add(node)
 		addBlobs(blobs, node)
 
 		if node.Type == "dir" {
 			err := c.printDir(ctx, mode, stats, blobs, name, *node.Subtree)
 			if err != nil {
-				Warnf("error: %v\n", err)
+				c.printError(err)
 			}
 		}
 	}
@@ -191,7 +340,7 @@ func (c *Comparer) collectDir(ctx context.Context, blobs restic.BlobSet, id rest
 		if node.Type == "dir" {
 			err := c.collectDir(ctx, blobs, *node.Subtree)
 			if err != nil {
-				Warnf("error: %v\n", err)
+				c.printError(err)
 			}
 		}
 	}
@@ -265,8 +414,17 @@ func (c *Comparer) diffTree(ctx context.Context, stats *DiffStats, prefix string
 				mod += "U"
 			}
 
-			if mod != "" {
-				Printf("%-5s%v\n", mod, name)
+			switch {
+			case mod == "M" && c.opts.Patch:
+				patch, err := c.diffContent(ctx, node1, node2)
+				if err != nil {
+					c.printError(err)
+					c.printChange(mod, name)
+					break
+				}
+				c.printPatch(mod, name, patch)
+			case mod != "":
+				c.printChange(mod, name)
 			}
 
 			if node1.Type == "dir" && node2.Type == "dir" {
@@ -277,7 +435,7 @@ func (c *Comparer) diffTree(ctx context.Context, stats *DiffStats, prefix string
 					err = c.diffTree(ctx, stats, name, *node1.Subtree, *node2.Subtree)
 				}
 				if err != nil {
-					Warnf("error: %v\n", err)
+					c.printError(err)
 				}
 			}
 		case t1 && !t2:
@@ -285,13 +443,13 @@ func (c *Comparer) diffTree(ctx context.Context, stats *DiffStats, prefix string
 			if node1.Type == "dir" {
 				prefix += "/"
 			}
-			Printf("%-5s%v\n", "-", prefix)
+			c.printChange("-", prefix)
 			stats.Removed.Add(node1)
 
 			if node1.Type == "dir" {
 				err := c.printDir(ctx, "-", &stats.Removed, stats.BlobsBefore, prefix, *node1.Subtree)
 				if err != nil {
-					Warnf("error: %v\n", err)
+					c.printError(err)
 				}
 			}
 		case !t1 && t2:
@@ -299,13 +457,13 @@ func (c *Comparer) diffTree(ctx context.Context, stats *DiffStats, prefix string
 			if node2.Type == "dir" {
 				prefix += "/"
 			}
-			Printf("%-5s%v\n", "+", prefix)
+			c.printChange("+", prefix)
 			stats.Added.Add(node2)
 
 			if node2.Type == "dir" {
 				err := c.printDir(ctx, "+", &stats.Added, stats.BlobsAfter, prefix, *node2.Subtree)
 				if err != nil {
-					Warnf("error: %v\n", err)
+					c.printError(err)
 				}
 			}
 		}
@@ -315,8 +473,29 @@ func (c *Comparer) diffTree(ctx context.Context, stats *DiffStats, prefix string
 }
 
 func runDiff(opts DiffOptions, gopts GlobalOptions, args []string) error {
-	if len(args) != 2 {
-		return errors.Fatalf("specify two snapshot IDs")
+	if opts.ToLocal != "" {
+		if len(args) != 1 {
+			return errors.Fatal("specify one snapshot ID to compare with --to-local")
+		}
+	} else {
+		if len(args) != 2 {
+			return errors.Fatalf("specify two snapshot IDs")
+		}
+		if opts.Content || opts.IgnoreInode {
+			return errors.Fatal("--content and --ignore-inode can only be used with --to-local")
+		}
+	}
+
+	var maxSize uint64 = math.MaxUint64
+	if opts.Patch && len(opts.MaxSize) > 0 {
+		size, err := parseSizeStr(opts.MaxSize)
+		if err != nil {
+			return errors.Fatalf("invalid size %q passed for --max-size: %v", opts.MaxSize, err)
+		}
+		if size < 0 {
+			return errors.Fatal("size for --max-size must not be negative")
+		}
+		maxSize = uint64(size)
 	}
 
 	ctx, cancel := context.WithCancel(gopts.ctx)
@@ -344,6 +523,18 @@ func runDiff(opts DiffOptions, gopts GlobalOptions, args []string) error {
 		return err
 	}
 
+	c := &Comparer{
+		repo:    repo,
+		local:   fs.Local{},
+		opts:    opts,
+		json:    gopts.JSON,
+		maxSize: maxSize,
+	}
+
+	if opts.ToLocal != "" {
+		return c.diffLocal(ctx, sn1, opts.ToLocal)
+	}
+
 	sn2, err := loadSnapshot(ctx, repo, args[1])
 	if err != nil {
 		return err
@@ -359,11 +550,6 @@ func runDiff(opts DiffOptions, gopts GlobalOptions, args []string) error {
 		return errors.Errorf("snapshot %v has nil tree", sn2.ID().Str())
 	}
 
-	c := &Comparer{
-		repo: repo,
-		opts: diffOptions,
-	}
-
 	stats := NewDiffStats()
 
 	err = c.diffTree(ctx, stats, "/", *sn1.Tree, *sn2.Tree)
@@ -375,6 +561,17 @@ func runDiff(opts DiffOptions, gopts GlobalOptions, args []string) error {
 	updateBlobs(repo, stats.BlobsBefore.Sub(both).Sub(stats.BlobsCommon), &stats.Removed)
 	updateBlobs(repo, stats.BlobsAfter.Sub(both).Sub(stats.BlobsCommon), &stats.Added)
 
+	if gopts.JSON {
+		printJSON(json.TypeSummary, &json.DiffSummary{
+			SourceSnapshot: sn1.ID().String(),
+			TargetSnapshot: sn2.ID().String(),
+			ChangedFiles:   stats.ChangedFiles,
+			Added:          json.DiffStat(stats.Added),
+			Removed:        json.DiffStat(stats.Removed),
+		})
+		return nil
+	}
+
 	Printf("\n")
 	Printf("Files:       %5d new, %5d removed, %5d changed\n", stats.Added.Files, stats.Removed.Files, stats.ChangedFiles)
 	Printf("Dirs:        %5d new, %5d removed\n", stats.Added.Dirs, stats.Removed.Dirs)
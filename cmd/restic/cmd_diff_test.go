@@ -0,0 +1,24 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"testing"
+
+	rtest "github.com/restic/restic/internal/test"
+)
+
+func TestComparerPrintChangeText(t *testing.T) {
+	buf := bytes.NewBuffer(nil)
+	globalOptions.stdout = buf
+	defer func() {
+		globalOptions.stdout = os.Stdout
+	}()
+
+	c := &Comparer{}
+	c.printChange("+", "/testdata/new")
+	c.printChange("M", "/testdata/changed")
+	c.printChange("T", "/testdata/type")
+
+	rtest.Equals(t, "+    /testdata/new\nM    /testdata/changed\nT    /testdata/type\n", buf.String())
+}
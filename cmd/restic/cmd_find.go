@@ -3,6 +3,8 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"path"
+	"path/filepath"
 	"strings"
 	"time"
 
@@ -12,22 +14,55 @@ import (
 	"github.com/restic/restic/internal/errors"
 	"github.com/restic/restic/internal/filter"
 	"github.com/restic/restic/internal/restic"
+	"github.com/restic/restic/internal/searchindex"
 	"github.com/restic/restic/internal/walker"
 )
 
 var cmdFind = &cobra.Command{
-	Use:   "find [flags] PATTERN...",
+	Use:   "find [flags] [PATTERN...]",
 	Short: "Find a file, a directory or restic IDs",
 	Long: `
 The "find" command searches for files or directories in snapshots stored in the
 repo.
-It can also be used to search for restic blobs or trees for troubleshooting.`,
+It can also be used to search for restic blobs or trees for troubleshooting.
+
+With --query, files are selected by their metadata. A query consists of
+conditions of the form FIELD OPERATOR VALUE, which can be combined with "and",
+"or", "not" and parentheses. The following fields are supported:
+
+* name, path, user, group, target (of symlinks): compared with a glob pattern
+  using = and !=, or with a regular expression using ~ and !~
+* type: one of file, dir, symlink, dev, chardev, fifo or socket
+* size (with suffixes k/K, m/M, g/G, t/T), uid, gid, links, inode: compared
+  using =, !=, <, <=, > and >=
+* mtime, ctime, atime: a date/time in the format of --oldest, compared like
+  numbers
+* mode: an octal value, & matches if all given bits are set
+* xattr: matches if the file has an extended attribute with a name matching
+  the pattern or regular expression
+
+Values which contain spaces, parentheses or operators must be quoted. When
+patterns are given as well, only files matching both are printed.
+
+Directories contained in several snapshots are only searched once if the
+patterns only match names (they contain neither "/" nor "**") and the query
+does not use the path field.
+
+With --search-index, the snapshots are searched in a local index stored in the
+cache directory instead of loading their trees from the repository. Snapshots
+which are not yet indexed are added to the index first, which only needs to
+load the directories not contained in any indexed snapshot. Removed snapshots
+are dropped from the index automatically.`,
 	Example: `restic find config.json
 restic find --json "*.yml" "*.json"
 restic find --json --blob 420f620f b46ebe8a ddd38656
 restic find --show-pack-id --blob 420f620f
 restic find --tree 577c2bc9 f81f2e22 a62827a9
 restic find --pack 025c1d06
+restic find --query 'size>1G and mtime<2024-01-01 and user=postgres'
+restic find --query 'type=file and (mode&4000 or xattr=security.*)'
+restic find --query 'path~"^/home/[^/]+/\.ssh/" and not name=known_hosts'
+restic find --search-index "*.pdf"
 
 EXIT STATUS
 ===========
@@ -54,6 +89,8 @@ type FindOptions struct {
 	Hosts              []string
 	Paths              []string
 	Tags               restic.TagLists
+	Query              string
+	SearchIndex        bool
 }
 
 var findOptions FindOptions
@@ -71,6 +108,8 @@ func init() {
 	f.BoolVar(&findOptions.ShowPackID, "show-pack-id", false, "display the pack-ID the blobs belong to (with --blob or --tree)")
 	f.BoolVarP(&findOptions.CaseInsensitive, "ignore-case", "i", false, "ignore case for pattern")
 	f.BoolVarP(&findOptions.ListLong, "long", "l", false, "use a long listing format showing size and mode")
+	f.StringVar(&findOptions.Query, "query", "", "only find files matching the `query`, see the help text for the syntax")
+	f.BoolVar(&findOptions.SearchIndex, "search-index", false, "search in the local search index, which is updated with new snapshots first")
 
 	f.StringArrayVarP(&findOptions.Hosts, "host", "H", nil, "only consider snapshots for this `host`, when no snapshot ID is given (can be specified multiple times)")
 	f.Var(&findOptions.Tags, "tag", "only consider snapshots which include this `taglist`, when no snapshot-ID is given")
@@ -81,6 +120,11 @@ type findPattern struct {
 	oldest, newest time.Time
 	pattern        []string
 	ignoreCase     bool
+	// namesOnly is set if all patterns match single names, see namePatterns
+	namesOnly bool
+
+	// query is nil if no query was given
+	query findQuery
 }
 
 var timeFormats = []string{
@@ -248,9 +292,11 @@ func (s *statefulOutput) Finish() {
 // Finder bundles information needed to find a file or directory.
 type Finder struct {
 	repo        restic.Repository
+	trees       restic.TreeLoader
 	pat         findPattern
 	out         statefulOutput
 	ignoreTrees restic.IDSet
+	queryCache  map[queryCacheKey][]queryResult
 	blobIDs     map[string]struct{}
 	treeIDs     map[string]struct{}
 	itemsFound  int
@@ -264,7 +310,7 @@ func (f *Finder) findInSnapshot(ctx context.Context, sn *restic.Snapshot) error
 	}
 
 	f.out.newsn = sn
-	return walker.Walk(ctx, f.repo, *sn.Tree, f.ignoreTrees, func(parentTreeID restic.ID, nodepath string, node *restic.Node, err error) (bool, error) {
+	return walker.Walk(ctx, f.trees, *sn.Tree, f.ignoreTrees, func(parentTreeID restic.ID, nodepath string, node *restic.Node, err error) (bool, error) {
 		if err != nil {
 			debug.Log("Error loading tree %v: %v", parentTreeID, err)
 
@@ -340,6 +386,188 @@ func (f *Finder) findInSnapshot(ctx context.Context, sn *restic.Snapshot) error
 	})
 }
 
+// namePatterns returns true if all patterns match single names, i.e. they
+// contain neither a path separator nor a recursive wildcard. Such a pattern
+// matches a path if it matches the name of the node or of one of its parent
+// directories.
+func namePatterns(patterns []string) bool {
+	for _, pat := range patterns {
+		pat = filepath.ToSlash(filepath.Clean(pat))
+		if strings.Contains(pat, "/") || strings.Contains(pat, "**") {
+			return false
+		}
+	}
+	return true
+}
+
+// queryCacheKey identifies the cached results for a tree. With name patterns,
+// the results depend on whether a parent directory already matched.
+type queryCacheKey struct {
+	id            restic.ID
+	parentMatched bool
+}
+
+// queryResult is a node in a tree which matches the query, or a directory
+// containing nodes which match.
+type queryResult struct {
+	node    *restic.Node
+	matched bool
+	// subtree identifies the results for the subtree of a directory
+	subtree queryCacheKey
+}
+
+// matchPatterns returns true if the node at nodepath matches the patterns.
+// With name patterns, parentMatched must be true if a parent directory of the
+// node matches, otherwise it is ignored.
+func (f *Finder) matchPatterns(nodepath string, node *restic.Node, parentMatched bool) (bool, error) {
+	if len(f.pat.pattern) == 0 {
+		return true, nil
+	}
+
+	str := nodepath
+	if f.pat.namesOnly {
+		if parentMatched {
+			return true, nil
+		}
+		str = node.Name
+	}
+	if f.pat.ignoreCase {
+		str = strings.ToLower(str)
+	}
+
+	for _, pat := range f.pat.pattern {
+		found, err := filter.Match(pat, str)
+		if err != nil {
+			return false, err
+		}
+		if found {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// matchNode returns true if the node at nodepath is within the time range and
+// matches the query, if any.
+func (f *Finder) matchNode(nodepath string, node *restic.Node) bool {
+	if !f.pat.oldest.IsZero() && node.ModTime.Before(f.pat.oldest) {
+		return false
+	}
+	if !f.pat.newest.IsZero() && node.ModTime.After(f.pat.newest) {
+		return false
+	}
+
+	return f.pat.query == nil || f.pat.query(nodepath, node)
+}
+
+// childMayMatchQuery returns true if nodes below the directory at nodepath can
+// match the patterns.
+func (f *Finder) childMayMatchQuery(nodepath string) (bool, error) {
+	if len(f.pat.pattern) == 0 {
+		return true, nil
+	}
+
+	if f.pat.ignoreCase {
+		nodepath = strings.ToLower(nodepath)
+	}
+	for _, pat := range f.pat.pattern {
+		mayMatch, err := filter.ChildMatch(pat, nodepath)
+		if err != nil {
+			return false, err
+		}
+		if mayMatch {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// queryTree prints all nodes in the tree id and its subtrees which match the
+// patterns and the query and returns true if there were any. If the results
+// don't depend on the path, they are cached so that trees contained in
+// several snapshots are only searched once.
+func (f *Finder) queryTree(ctx context.Context, sn *restic.Snapshot, prefix string, key queryCacheKey) (bool, error) {
+	if f.queryCache != nil {
+		if results, ok := f.queryCache[key]; ok {
+			f.printQueryResults(prefix, results)
+			return len(results) > 0, nil
+		}
+	}
+
+	tree, err := f.trees.LoadTree(ctx, key.id)
+	if err != nil {
+		debug.Log("Error loading tree %v: %v", key.id, err)
+		Printf("Unable to load tree %s\n ... which belongs to snapshot %s.\n", key.id, sn.ID())
+		return false, nil
+	}
+
+	var results []queryResult
+	for _, node := range tree.Nodes {
+		nodepath := path.Join(prefix, node.Name)
+
+		patternMatched, err := f.matchPatterns(nodepath, node, key.parentMatched)
+		if err != nil {
+			return false, err
+		}
+		matched := patternMatched && f.matchNode(nodepath, node)
+		if matched {
+			f.out.PrintPattern(nodepath, node)
+		}
+
+		found := false
+		var subtree queryCacheKey
+		if node.Type == "dir" && node.Subtree != nil {
+			subtree = queryCacheKey{id: *node.Subtree, parentMatched: f.pat.namesOnly && patternMatched}
+			mayMatch, err := f.childMayMatchQuery(nodepath)
+			if err != nil {
+				return false, err
+			}
+			if mayMatch {
+				found, err = f.queryTree(ctx, sn, nodepath, subtree)
+				if err != nil {
+					return false, err
+				}
+			}
+		}
+
+		if matched || found {
+			results = append(results, queryResult{node: node, matched: matched, subtree: subtree})
+		}
+	}
+
+	if f.queryCache != nil {
+		f.queryCache[key] = results
+	}
+	return len(results) > 0, nil
+}
+
+// printQueryResults prints the cached results for a tree at prefix.
+func (f *Finder) printQueryResults(prefix string, results []queryResult) {
+	for _, res := range results {
+		nodepath := path.Join(prefix, res.node.Name)
+		if res.matched {
+			f.out.PrintPattern(nodepath, res.node)
+		}
+		if res.node.Type == "dir" && res.node.Subtree != nil {
+			f.printQueryResults(nodepath, f.queryCache[res.subtree])
+		}
+	}
+}
+
+// findQueryInSnapshot prints all nodes in the snapshot which match the
+// patterns and the query.
+func (f *Finder) findQueryInSnapshot(ctx context.Context, sn *restic.Snapshot) error {
+	debug.Log("searching in snapshot %s for query matches", sn.ID())
+
+	if sn.Tree == nil {
+		return errors.Errorf("snapshot %v has no tree", sn.ID().Str())
+	}
+
+	f.out.newsn = sn
+	_, err := f.queryTree(ctx, sn, "/", queryCacheKey{id: *sn.Tree})
+	return err
+}
+
 func (f *Finder) findIDs(ctx context.Context, sn *restic.Snapshot) error {
 	debug.Log("searching IDs in snapshot %s", sn.ID())
 
@@ -348,7 +576,7 @@ func (f *Finder) findIDs(ctx context.Context, sn *restic.Snapshot) error {
 	}
 
 	f.out.newsn = sn
-	return walker.Walk(ctx, f.repo, *sn.Tree, f.ignoreTrees, func(parentTreeID restic.ID, nodepath string, node *restic.Node, err error) (bool, error) {
+	return walker.Walk(ctx, f.trees, *sn.Tree, f.ignoreTrees, func(parentTreeID restic.ID, nodepath string, node *restic.Node, err error) (bool, error) {
 		if err != nil {
 			debug.Log("Error loading tree %v: %v", parentTreeID, err)
 
@@ -490,12 +718,25 @@ func (f *Finder) findObjectsPacks(ctx context.Context) {
 }
 
 func runFind(opts FindOptions, gopts GlobalOptions, args []string) error {
-	if len(args) == 0 {
+	if len(args) == 0 && opts.Query == "" {
 		return errors.Fatal("wrong number of arguments")
 	}
 
 	var err error
-	pat := findPattern{pattern: args}
+	pat := findPattern{pattern: args, namesOnly: namePatterns(args)}
+	// the results for a tree can only be reused if they don't depend on the
+	// path at which the tree is found
+	cacheable := pat.namesOnly
+	if opts.Query != "" {
+		if opts.BlobID || opts.TreeID || opts.PackID {
+			return errors.Fatal("--query cannot be used with --blob, --tree or --pack")
+		}
+		var usesPath bool
+		if pat.query, usesPath, err = parseFindQuery(opts.Query); err != nil {
+			return err
+		}
+		cacheable = cacheable && !usesPath
+	}
 	if opts.CaseInsensitive {
 		for i := range pat.pattern {
 			pat.pattern[i] = strings.ToLower(pat.pattern[i])
@@ -523,6 +764,11 @@ func runFind(opts FindOptions, gopts GlobalOptions, args []string) error {
 		return errors.Fatal("cannot have several ID types")
 	}
 
+	if opts.SearchIndex && (opts.BlobID || opts.TreeID || opts.PackID) {
+		// the search index does not contain the content of files
+		return errors.Fatal("--search-index cannot be used with --blob, --tree or --pack")
+	}
+
 	repo, err := OpenRepository(gopts)
 	if err != nil {
 		return err
@@ -545,11 +791,24 @@ func runFind(opts FindOptions, gopts GlobalOptions, args []string) error {
 
 	f := &Finder{
 		repo:        repo,
+		trees:       repo,
 		pat:         pat,
 		out:         statefulOutput{ListLong: opts.ListLong, JSON: globalOptions.JSON},
 		ignoreTrees: restic.NewIDSet(),
 	}
 
+	var searchIndex *searchindex.Index
+	if opts.SearchIndex {
+		if searchIndex, err = openSearchIndex(repo); err != nil {
+			return err
+		}
+		f.trees = searchIndex
+	}
+
+	if cacheable && !opts.BlobID && !opts.TreeID && !opts.PackID {
+		f.queryCache = make(map[queryCacheKey][]queryResult)
+	}
+
 	if opts.BlobID {
 		f.blobIDs = make(map[string]struct{})
 		for _, pat := range f.pat.pattern {
@@ -574,12 +833,27 @@ func runFind(opts FindOptions, gopts GlobalOptions, args []string) error {
 			}
 			continue
 		}
+		if searchIndex != nil {
+			if err = addToSearchIndex(ctx, repo, searchIndex, sn); err != nil {
+				return err
+			}
+		}
+		if f.pat.query != nil || f.queryCache != nil {
+			if err = f.findQueryInSnapshot(ctx, sn); err != nil {
+				return err
+			}
+			continue
+		}
 		if err = f.findInSnapshot(ctx, sn); err != nil {
 			return err
 		}
 	}
 	f.out.Finish()
 
+	if searchIndex != nil {
+		saveSearchIndex(ctx, repo, searchIndex)
+	}
+
 	if opts.ShowPackID && (f.blobIDs != nil || f.treeIDs != nil) {
 		f.findObjectsPacks(ctx)
 	}
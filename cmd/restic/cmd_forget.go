@@ -5,6 +5,8 @@ import (
 	"encoding/json"
 	"io"
 
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/repository"
 	"github.com/restic/restic/internal/restic"
 	"github.com/spf13/cobra"
 )
@@ -31,14 +33,20 @@ Exit status is 0 if the command was successful, and non-zero if there was any er
 
 // ForgetOptions collects all options for the forget command.
 type ForgetOptions struct {
-	Last     int
-	Hourly   int
-	Daily    int
-	Weekly   int
-	Monthly  int
-	Yearly   int
-	Within   restic.Duration
-	KeepTags restic.TagLists
+	Last          int
+	Hourly        int
+	Daily         int
+	Weekly        int
+	Monthly       int
+	Yearly        int
+	Within        restic.Duration
+	WithinHourly  restic.Duration
+	WithinDaily   restic.Duration
+	WithinWeekly  restic.Duration
+	WithinMonthly restic.Duration
+	WithinYearly  restic.Duration
+	KeepTags      restic.TagLists
+	UseRepoPolicy bool
 
 	Hosts   []string
 	Tags    restic.TagLists
@@ -64,8 +72,14 @@ func init() {
 	f.IntVarP(&forgetOptions.Monthly, "keep-monthly", "m", 0, "keep the last `n` monthly snapshots")
 	f.IntVarP(&forgetOptions.Yearly, "keep-yearly", "y", 0, "keep the last `n` yearly snapshots")
 	f.VarP(&forgetOptions.Within, "keep-within", "", "keep snapshots that are newer than `duration` (eg. 1y5m7d2h) relative to the latest snapshot")
+	f.VarP(&forgetOptions.WithinHourly, "keep-within-hourly", "", "keep hourly snapshots that are newer than `duration` (eg. 1y5m7d2h) relative to the latest snapshot")
+	f.VarP(&forgetOptions.WithinDaily, "keep-within-daily", "", "keep daily snapshots that are newer than `duration` (eg. 1y5m7d2h) relative to the latest snapshot")
+	f.VarP(&forgetOptions.WithinWeekly, "keep-within-weekly", "", "keep weekly snapshots that are newer than `duration` (eg. 1y5m7d2h) relative to the latest snapshot")
+	f.VarP(&forgetOptions.WithinMonthly, "keep-within-monthly", "", "keep monthly snapshots that are newer than `duration` (eg. 1y5m7d2h) relative to the latest snapshot")
+	f.VarP(&forgetOptions.WithinYearly, "keep-within-yearly", "", "keep yearly snapshots that are newer than `duration` (eg. 1y5m7d2h) relative to the latest snapshot")
 
 	f.Var(&forgetOptions.KeepTags, "keep-tag", "keep snapshots with this `taglist` (can be specified multiple times)")
+	f.BoolVar(&forgetOptions.UseRepoPolicy, "use-repo-policy", false, "apply the retention policy stored in the repository, see 'restic policy'")
 	f.StringArrayVar(&forgetOptions.Hosts, "host", nil, "only consider snapshots with the given `host` (can be specified multiple times)")
 	f.StringArrayVar(&forgetOptions.Hosts, "hostname", nil, "only consider snapshots with the given `hostname` (can be specified multiple times)")
 	f.MarkDeprecated("hostname", "use --host")
@@ -80,14 +94,54 @@ func init() {
 	f.BoolVar(&forgetOptions.Prune, "prune", false, "automatically run the 'prune' command if snapshots have been removed")
 
 	f.SortFlags = false
+	addPruneOptions(cmdForget)
+}
+
+// keepPolicy returns the policy configured by the --keep-* options.
+func (opts ForgetOptions) keepPolicy() restic.ExpirePolicy {
+	return restic.ExpirePolicy{
+		Last:          opts.Last,
+		Hourly:        opts.Hourly,
+		Daily:         opts.Daily,
+		Weekly:        opts.Weekly,
+		Monthly:       opts.Monthly,
+		Yearly:        opts.Yearly,
+		Within:        opts.Within,
+		WithinHourly:  opts.WithinHourly,
+		WithinDaily:   opts.WithinDaily,
+		WithinWeekly:  opts.WithinWeekly,
+		WithinMonthly: opts.WithinMonthly,
+		WithinYearly:  opts.WithinYearly,
+		Tags:          opts.KeepTags,
+	}
 }
 
 func runForget(opts ForgetOptions, gopts GlobalOptions, args []string) error {
+	err := verifyPruneOptions(&pruneOptions)
+	if err != nil {
+		return err
+	}
+
+	if opts.UseRepoPolicy {
+		if len(args) > 0 {
+			return errors.Fatal("--use-repo-policy cannot be used together with snapshot IDs")
+		}
+		if !opts.keepPolicy().Empty() {
+			return errors.Fatal("--use-repo-policy cannot be used together with --keep-* options")
+		}
+	}
+
 	repo, err := OpenRepository(gopts)
 	if err != nil {
 		return err
 	}
 
+	if !opts.DryRun {
+		if err = checkAppendOnly(gopts, repo); err != nil {
+			return err
+		}
+	}
+
 	lock, err := lockRepoExclusive(gopts.ctx, repo)
 	defer unlockRepo(lock)
 	if err != nil {
@@ -111,22 +165,13 @@ func runForget(opts ForgetOptions, gopts GlobalOptions, args []string) error {
 		for _, sn := range snapshots {
 			removeSnIDs.Insert(*sn.ID())
 		}
-	} else {
-		snapshotGroups, _, err := restic.GroupSnapshots(snapshots, opts.GroupBy)
+	} else if opts.UseRepoPolicy {
+		jsonGroups, err = applyRepoPolicy(ctx, repo, opts, gopts, snapshots, removeSnIDs)
 		if err != nil {
 			return err
 		}
-
-		policy := restic.ExpirePolicy{
-			Last:    opts.Last,
-			Hourly:  opts.Hourly,
-			Daily:   opts.Daily,
-			Weekly:  opts.Weekly,
-			Monthly: opts.Monthly,
-			Yearly:  opts.Yearly,
-			Within:  opts.Within,
-			Tags:    opts.KeepTags,
-		}
+	} else {
+		policy := opts.keepPolicy()
 
 		if policy.Empty() && len(args) == 0 {
 			if !gopts.JSON {
@@ -139,47 +184,9 @@ func runForget(opts ForgetOptions, gopts GlobalOptions, args []string) error {
 				Verbosef("Applying Policy: %v\n", policy)
 			}
 
-			for k, snapshotGroup := range snapshotGroups {
-				if gopts.Verbose >= 1 && !gopts.JSON {
-					err = PrintSnapshotGroupHeader(gopts.stdout, k)
-					if err != nil {
-						return err
-					}
-				}
-
-				var key restic.SnapshotGroupKey
-				if json.Unmarshal([]byte(k), &key) != nil {
-					return err
-				}
-
-				var fg ForgetGroup
-				fg.Tags = key.Tags
-				fg.Host = key.Hostname
-				fg.Paths = key.Paths
-
-				keep, remove, reasons := restic.ApplyPolicy(snapshotGroup, policy)
-
-				if len(keep) != 0 && !gopts.Quiet && !gopts.JSON {
-					Printf("keep %d snapshots:\n", len(keep))
-					PrintSnapshots(globalOptions.stdout, keep, reasons, opts.Compact)
-					Printf("\n")
-				}
-				addJSONSnapshots(&fg.Keep, keep)
-
-				if len(remove) != 0 && !gopts.Quiet && !gopts.JSON {
-					Printf("remove %d snapshots:\n", len(remove))
-					PrintSnapshots(globalOptions.stdout, remove, nil, opts.Compact)
-					Printf("\n")
-				}
-				addJSONSnapshots(&fg.Remove, remove)
-
-				fg.Reasons = reasons
-
-				jsonGroups = append(jsonGroups, &fg)
-
-				for _, sn := range remove {
-					removeSnIDs.Insert(*sn.ID())
-				}
+			jsonGroups, err = applyPolicy(opts, gopts, snapshots, opts.GroupBy, policy, removeSnIDs)
+			if err != nil {
+				return err
 			}
 		}
 	}
@@ -205,12 +212,106 @@ func runForget(opts ForgetOptions, gopts GlobalOptions, args []string) error {
 	}
 
 	if len(removeSnIDs) > 0 && opts.Prune && !opts.DryRun {
-		return pruneRepository(gopts, repo)
+		return runPruneWithRepo(pruneOptions, gopts, repo)
 	}
 
 	return nil
 }
 
+// applyRepoPolicy applies the retention policy stored in the repository to
+// the snapshots and adds the snapshots to remove to removeSnIDs.
+func applyRepoPolicy(ctx context.Context, repo *repository.Repository, opts ForgetOptions, gopts GlobalOptions, snapshots restic.Snapshots, removeSnIDs restic.IDSet) ([]*ForgetGroup, error) {
+	repoPolicy, err := loadRetentionPolicy(ctx, repo)
+	if err != nil {
+		return nil, err
+	}
+
+	groupBy := opts.GroupBy
+	if repoPolicy.GroupBy != "" {
+		groupBy = repoPolicy.GroupBy
+	}
+
+	matched, unmatched := repoPolicy.Partition(snapshots)
+
+	var jsonGroups []*ForgetGroup
+	for i, rule := range repoPolicy.Rules {
+		if len(matched[i]) == 0 {
+			continue
+		}
+
+		if !gopts.JSON {
+			Verbosef("Applying Policy for %v: %v\n", rule, rule.Keep)
+		}
+
+		groups, err := applyPolicy(opts, gopts, matched[i], groupBy, rule.Keep, removeSnIDs)
+		if err != nil {
+			return nil, err
+		}
+		jsonGroups = append(jsonGroups, groups...)
+	}
+
+	if len(unmatched) > 0 && !gopts.JSON {
+		Verbosef("keeping %d snapshots which do not match any rule of the policy\n", len(unmatched))
+	}
+
+	return jsonGroups, nil
+}
+
+// applyPolicy groups the snapshots, applies the policy to each group and adds
+// the snapshots to remove to removeSnIDs.
+func applyPolicy(opts ForgetOptions, gopts GlobalOptions, snapshots restic.Snapshots, groupBy string, policy restic.ExpirePolicy, removeSnIDs restic.IDSet) ([]*ForgetGroup, error) {
+	snapshotGroups, _, err := restic.GroupSnapshots(snapshots, groupBy)
+	if err != nil {
+		return nil, err
+	}
+
+	var jsonGroups []*ForgetGroup
+	for k, snapshotGroup := range snapshotGroups {
+		if gopts.Verbose >= 1 && !gopts.JSON {
+			err = PrintSnapshotGroupHeader(gopts.stdout, k)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		var key restic.SnapshotGroupKey
+		if json.Unmarshal([]byte(k), &key) != nil {
+			return nil, err
+		}
+
+		var fg ForgetGroup
+		fg.Tags = key.Tags
+		fg.Host = key.Hostname
+		fg.Paths = key.Paths
+
+		keep, remove, reasons := restic.ApplyPolicy(snapshotGroup, policy)
+
+		if len(keep) != 0 && !gopts.Quiet && !gopts.JSON {
+			Printf("keep %d snapshots:\n", len(keep))
+			PrintSnapshots(globalOptions.stdout, keep, reasons, opts.Compact)
+			Printf("\n")
+		}
+		addJSONSnapshots(&fg.Keep, keep)
+
+		if len(remove) != 0 && !gopts.Quiet && !gopts.JSON {
+			Printf("remove %d snapshots:\n", len(remove))
+			PrintSnapshots(globalOptions.stdout, remove, nil, opts.Compact)
+			Printf("\n")
+		}
+		addJSONSnapshots(&fg.Remove, remove)
+
+		fg.Reasons = reasons
+
+		jsonGroups = append(jsonGroups, &fg)
+
+		for _, sn := range remove {
+			removeSnIDs.Insert(*sn.ID())
+		}
+	}
+
+	return jsonGroups, nil
+}
+
 // ForgetGroup helps to print what is forgotten in JSON.
 type ForgetGroup struct {
 	Tags    []string            `json:"tags"`
@@ -0,0 +1,18 @@
+package main
+
+import (
+	"github.com/spf13/cobra"
+)
+
+var cmdIndex = &cobra.Command{
+	Use:   "index",
+	Short: "Manage the index files of the repository",
+	Long: `
+The "index" commands maintain the index files of the repository, which list
+the blobs contained in each pack file.
+`,
+}
+
+func init() {
+	cmdRoot.AddCommand(cmdIndex)
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/repository"
+	"github.com/restic/restic/internal/restic"
+	"github.com/restic/restic/internal/ui/json"
+
+	"github.com/spf13/cobra"
+)
+
+var cmdIndexCompact = &cobra.Command{
+	Use:   "compact [flags]",
+	Short: "Merge many small index files into few large ones",
+	Long: `
+The "index compact" command merges the index files of the repository into as
+few index files as possible. Each backup adds new index files, and as most
+commands load all index files, many small index files slow them down.
+Contrary to "rebuild-index", the pack files are not read, the new index files
+are created from the existing ones.
+
+The new index files are saved before the old ones are removed. Commands which
+only add data to the repository, like "backup", can run at the same time, the
+index files they create are kept.
+
+EXIT STATUS
+===========
+
+Exit status is 0 if the command was successful, and non-zero if there was any error.
+`,
+	DisableAutoGenTag: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runIndexCompact(globalOptions)
+	},
+}
+
+func init() {
+	cmdIndex.AddCommand(cmdIndexCompact)
+}
+
+func runIndexCompact(gopts GlobalOptions) error {
+	repo, err := OpenRepository(gopts)
+	if err != nil {
+		return err
+	}
+
+	if err = checkAppendOnly(gopts, repo); err != nil {
+		return err
+	}
+
+	// other clients may add data, but no data may be removed
+	lock, err := lockRepo(gopts.ctx, repo)
+	defer unlockRepo(lock)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(gopts.ctx)
+	defer cancel()
+	return compactIndex(ctx, gopts, repo)
+}
+
+func compactIndex(ctx context.Context, gopts GlobalOptions, repo *repository.Repository) error {
+	Verbosef("loading indexes...\n")
+	err := repo.LoadIndex(ctx)
+	if err != nil {
+		return err
+	}
+
+	idx := repo.Index().(*repository.MasterIndex)
+	if idx.IsCompact() {
+		Verbosef("index is already compact\n")
+		if gopts.JSON {
+			printJSON(json.TypeSummary, &json.IndexCompactSummary{SavedIndexes: []string{}})
+		}
+		return nil
+	}
+
+	Verbosef("saving merged index files\n")
+	obsolete, saved, err := idx.Save(ctx, repo, nil)
+	if err != nil {
+		return errors.Fatalf("unable to save index, last error was: %v", err)
+	}
+	Verbosef("saved %d new index files\n", len(saved))
+
+	Verbosef("remove %d old index files\n", len(obsolete))
+	err = DeleteFilesChecked(gopts, repo, obsolete, restic.IndexFile)
+	if err != nil {
+		return errors.Fatalf("unable to remove an old index: %v\n", err)
+	}
+
+	if gopts.JSON {
+		summary := &json.IndexCompactSummary{
+			SavedIndexes:   []string{},
+			RemovedIndexes: len(obsolete),
+		}
+		for _, id := range saved {
+			summary.SavedIndexes = append(summary.SavedIndexes, id.String())
+		}
+		printJSON(json.TypeSummary, summary)
+	}
+
+	Verbosef("done\n")
+	return nil
+}
@@ -1,10 +1,14 @@
 package main
 
 import (
+	"strconv"
+
 	"github.com/restic/chunker"
 	"github.com/restic/restic/internal/backend/location"
 	"github.com/restic/restic/internal/errors"
 	"github.com/restic/restic/internal/repository"
+	"github.com/restic/restic/internal/restic"
+	"github.com/restic/restic/internal/ui/json"
 
 	"github.com/spf13/cobra"
 )
@@ -15,6 +19,12 @@ var cmdInit = &cobra.Command{
 	Long: `
 The "init" command initializes a new repository.
 
+The chunk size profile determines the size of the chunks files are split into:
+"small" (about 384 KiB on average) improves deduplication for large files
+which change in many places, like VM images, "large" (about 10 MiB on average)
+reduces the number of chunks for large files which rarely change, like media
+archives. The profile cannot be changed later on.
+
 EXIT STATUS
 ===========
 
@@ -30,6 +40,9 @@ Exit status is 0 if the command was successful, and non-zero if there was any er
 type InitOptions struct {
 	secondaryRepoOptions
 	CopyChunkerParameters bool
+	ChunkSizeProfile      string
+	RepositoryVersion     string
+	AppendOnly            bool
 }
 
 var initOptions InitOptions
@@ -40,6 +53,9 @@ func init() {
 	f := cmdInit.Flags()
 	initSecondaryRepoOptions(f, &initOptions.secondaryRepoOptions, "secondary", "to copy chunker parameters from")
 	f.BoolVar(&initOptions.CopyChunkerParameters, "copy-chunker-params", false, "copy chunker parameters from the secondary repository (useful with the copy command)")
+	f.StringVar(&initOptions.ChunkSizeProfile, "chunk-size-profile", "", "chunk size `profile` to use, allowed values are 'small', 'default' and 'large'")
+	f.StringVar(&initOptions.RepositoryVersion, "repository-version", "stable", "repository format version to use, allowed values are a format version, 'latest' and 'stable'")
+	f.BoolVar(&initOptions.AppendOnly, "append-only", false, "mark the repository as append-only, commands which delete files refuse to run")
 }
 
 func runInit(opts InitOptions, gopts GlobalOptions, args []string) error {
@@ -47,11 +63,27 @@ func runInit(opts InitOptions, gopts GlobalOptions, args []string) error {
 		return errors.Fatal("Please specify repository location (-r)")
 	}
 
-	chunkerPolynomial, err := maybeReadChunkerPolynomial(opts, gopts)
+	version, err := parseRepositoryVersion(opts.RepositoryVersion)
+	if err != nil {
+		return err
+	}
+
+	chunkerPolynomial, chunkerParams, err := maybeReadChunkerParams(opts, gopts)
 	if err != nil {
 		return err
 	}
 
+	packSize, err := parsePackSize(gopts.PackSize)
+	if err != nil {
+		return err
+	}
+
+	// older versions of restic would ignore the chunk sizes and the pack size
+	if version < restic.ChunkSizesRepoVersion && (chunkerParams != nil || packSize != 0) {
+		return errors.Fatalf("chunk size profiles and --pack-size require repository version %d or later, pass --repository-version %d",
+			restic.ChunkSizesRepoVersion, restic.ChunkSizesRepoVersion)
+	}
+
 	repo, err := ReadRepo(gopts)
 	if err != nil {
 		return err
@@ -71,11 +103,19 @@ func runInit(opts InitOptions, gopts GlobalOptions, args []string) error {
 
 	s := repository.New(be)
 
-	err = s.Init(gopts.ctx, gopts.password, chunkerPolynomial)
+	err = s.Init(gopts.ctx, version, gopts.password, chunkerPolynomial, chunkerParams, packSize, opts.AppendOnly)
 	if err != nil {
 		return errors.Fatalf("create key in repository at %s failed: %v\n", location.StripPassword(gopts.Repo), err)
 	}
 
+	if gopts.JSON {
+		printJSON(json.TypeSummary, &json.InitSummary{
+			RepositoryID: s.Config().ID,
+			Repository:   location.StripPassword(gopts.Repo),
+		})
+		return nil
+	}
+
 	Verbosef("created restic repository %v at %s\n", s.Config().ID[:10], location.StripPassword(gopts.Repo))
 	Verbosef("\n")
 	Verbosef("Please note that knowledge of your password is required to access\n")
@@ -85,24 +125,67 @@ func runInit(opts InitOptions, gopts GlobalOptions, args []string) error {
 	return nil
 }
 
-func maybeReadChunkerPolynomial(opts InitOptions, gopts GlobalOptions) (*chunker.Pol, error) {
+// parseRepositoryVersion returns the repository version selected by s, which
+// is either a version number or one of the aliases "latest" and "stable".
+func parseRepositoryVersion(s string) (uint, error) {
+	switch s {
+	case "latest", "":
+		return restic.MaxRepoVersion, nil
+	case "stable":
+		return restic.RepoVersion, nil
+	}
+
+	v, err := strconv.ParseUint(s, 10, 32)
+	if err != nil {
+		return 0, errors.Fatalf("invalid repository version %q", s)
+	}
+
+	if v < restic.MinRepoVersion || v > restic.MaxRepoVersion {
+		return 0, errors.Fatalf("unsupported repository version %v, must be between %v and %v",
+			v, restic.MinRepoVersion, restic.MaxRepoVersion)
+	}
+
+	return uint(v), nil
+}
+
+// maybeReadChunkerParams returns the chunker polynomial and parameters for
+// the new repository. They are either copied from the secondary repository
+// or, for the parameters, selected by the chunk size profile. nil means that
+// the defaults are used.
+func maybeReadChunkerParams(opts InitOptions, gopts GlobalOptions) (*chunker.Pol, *restic.ChunkerParams, error) {
 	if opts.CopyChunkerParameters {
+		if opts.ChunkSizeProfile != "" {
+			return nil, nil, errors.Fatal("--chunk-size-profile cannot be used with --copy-chunker-params")
+		}
+
 		otherGopts, err := fillSecondaryGlobalOpts(opts.secondaryRepoOptions, gopts, "secondary")
 		if err != nil {
-			return nil, err
+			return nil, nil, err
 		}
 
 		otherRepo, err := OpenRepository(otherGopts)
 		if err != nil {
-			return nil, err
+			return nil, nil, err
 		}
 
-		pol := otherRepo.Config().ChunkerPolynomial
-		return &pol, nil
+		cfg := otherRepo.Config()
+		return &cfg.ChunkerPolynomial, cfg.ChunkSizes, nil
 	}
 
 	if opts.Repo != "" {
-		return nil, errors.Fatal("Secondary repository must only be specified when copying the chunker parameters")
+		return nil, nil, errors.Fatal("Secondary repository must only be specified when copying the chunker parameters")
+	}
+
+	switch opts.ChunkSizeProfile {
+	case "", "default":
+		// repositories without chunker parameters use the defaults, which
+		// keeps them compatible with older versions of restic
+		return nil, nil, nil
+	}
+
+	params, ok := restic.ChunkerProfiles[opts.ChunkSizeProfile]
+	if !ok {
+		return nil, nil, errors.Fatalf("invalid chunk size profile %q, allowed values are 'small', 'default' and 'large'", opts.ChunkSizeProfile)
 	}
-	return nil, nil
+	return nil, &params, nil
 }
@@ -21,6 +21,10 @@ var cmdKey = &cobra.Command{
 	Long: `
 The "key" command manages keys (passwords) for accessing the repository.
 
+Keys added with --write-only can only be used to add new data, e.g. with the
+"backup" command. The data can only be read again with a regular key. These
+keys require a repository with version 2 or later.
+
 EXIT STATUS
 ===========
 
@@ -36,6 +40,7 @@ var (
 	newPasswordFile string
 	keyUsername     string
 	keyHostname     string
+	keyWriteOnly    bool
 )
 
 func init() {
@@ -45,15 +50,17 @@ func init() {
 	flags.StringVarP(&newPasswordFile, "new-password-file", "", "", "`file` from which to read the new password")
 	flags.StringVarP(&keyUsername, "user", "", "", "the username for new keys")
 	flags.StringVarP(&keyHostname, "host", "", "", "the hostname for new keys")
+	flags.BoolVar(&keyWriteOnly, "write-only", false, "the new key can only add data to the repository")
 }
 
 func listKeys(ctx context.Context, s *repository.Repository, gopts GlobalOptions) error {
 	type keyInfo struct {
-		Current  bool   `json:"current"`
-		ID       string `json:"id"`
-		UserName string `json:"userName"`
-		HostName string `json:"hostName"`
-		Created  string `json:"created"`
+		Current   bool   `json:"current"`
+		ID        string `json:"id"`
+		UserName  string `json:"userName"`
+		HostName  string `json:"hostName"`
+		Created   string `json:"created"`
+		WriteOnly bool   `json:"writeOnly"`
 	}
 
 	var keys []keyInfo
@@ -66,11 +73,12 @@ func listKeys(ctx context.Context, s *repository.Repository, gopts GlobalOptions
 		}
 
 		key := keyInfo{
-			Current:  id.String() == s.KeyName(),
-			ID:       id.Str(),
-			UserName: k.Username,
-			HostName: k.Hostname,
-			Created:  k.Created.Local().Format(TimeFormat),
+			Current:   id.String() == s.KeyName(),
+			ID:        id.Str(),
+			UserName:  k.Username,
+			HostName:  k.Hostname,
+			Created:   k.Created.Local().Format(TimeFormat),
+			WriteOnly: k.WriteOnly,
 		}
 
 		keys = append(keys, key)
@@ -90,6 +98,7 @@ func listKeys(ctx context.Context, s *repository.Repository, gopts GlobalOptions
 	tab.AddColumn("User", "{{ .UserName }}")
 	tab.AddColumn("Host", "{{ .HostName }}")
 	tab.AddColumn("Created", "{{ .Created }}")
+	tab.AddColumn("Mode", "{{if .WriteOnly}}write-only{{else}}full{{end}}")
 
 	for _, key := range keys {
 		tab.AddRow(key)
@@ -120,13 +129,32 @@ func getNewPassword(gopts GlobalOptions) (string, error) {
 		"enter password again: ")
 }
 
+// saveNewKey adds a key for password to the repository. A write-only key is
+// created if writeOnly is set or the repository was opened with a write-only
+// key.
+func saveNewKey(ctx context.Context, repo *repository.Repository, pw, username, hostname string, writeOnly bool) (*repository.Key, error) {
+	if !writeOnly && !repo.WriteOnly() {
+		return repository.AddKey(ctx, repo, pw, username, hostname, repo.Key())
+	}
+
+	pub, err := repo.PublicKey()
+	if err != nil {
+		return nil, err
+	}
+	return repository.AddWriteOnlyKey(ctx, repo, pw, username, hostname, pub, repo.Config())
+}
+
 func addKey(gopts GlobalOptions, repo *repository.Repository) error {
+	if repo.WriteOnly() && !keyWriteOnly {
+		return errors.Fatal("repository was opened with a write-only key, only write-only keys can be added (use --write-only)")
+	}
+
 	pw, err := getNewPassword(gopts)
 	if err != nil {
 		return err
 	}
 
-	id, err := repository.AddKey(gopts.ctx, repo, pw, keyUsername, keyHostname, repo.Key())
+	id, err := saveNewKey(gopts.ctx, repo, pw, keyUsername, keyHostname, keyWriteOnly)
 	if err != nil {
 		return errors.Fatalf("creating new key failed: %v\n", err)
 	}
@@ -157,7 +185,7 @@ func changePassword(gopts GlobalOptions, repo *repository.Repository) error {
 		return err
 	}
 
-	id, err := repository.AddKey(gopts.ctx, repo, pw, "", "", repo.Key())
+	id, err := saveNewKey(gopts.ctx, repo, pw, "", "", false)
 	if err != nil {
 		return errors.Fatalf("creating new key failed: %v\n", err)
 	}
@@ -204,6 +232,13 @@ func runKey(gopts GlobalOptions, args []string) error {
 
 		return addKey(gopts, repo)
 	case "remove":
+		if repo.WriteOnly() {
+			return errors.Fatal("removing keys requires a full key")
+		}
+		if err = checkAppendOnly(gopts, repo); err != nil {
+			return err
+		}
+
 		lock, err := lockRepoExclusive(ctx, repo)
 		defer unlockRepo(lock)
 		if err != nil {
@@ -217,6 +252,10 @@ func runKey(gopts GlobalOptions, args []string) error {
 
 		return deleteKey(gopts.ctx, repo, id)
 	case "passwd":
+		if err = checkAppendOnly(gopts, repo); err != nil {
+			return err
+		}
+
 		lock, err := lockRepoExclusive(ctx, repo)
 		defer unlockRepo(lock)
 		if err != nil {
@@ -9,7 +9,7 @@ import (
 )
 
 var cmdList = &cobra.Command{
-	Use:   "list [flags] [blobs|packs|index|snapshots|keys|locks]",
+	Use:   "list [flags] [blobs|packs|index|snapshots|keys|locks|policies]",
 	Short: "List objects in the repository",
 	Long: `
 The "list" command allows listing objects in the repository based on type.
@@ -59,6 +59,8 @@ func runList(cmd *cobra.Command, opts GlobalOptions, args []string) error {
 		t = restic.KeyFile
 	case "locks":
 		t = restic.LockFile
+	case "policies":
+		t = restic.PolicyFile
 	case "blobs":
 		return repo.List(opts.ctx, restic.IndexFile, func(id restic.ID, size int64) error {
 			idx, err := repository.LoadIndex(opts.ctx, repo, id)
@@ -12,6 +12,7 @@ import (
 	"github.com/restic/restic/internal/errors"
 	"github.com/restic/restic/internal/fs"
 	"github.com/restic/restic/internal/restic"
+	"github.com/restic/restic/internal/searchindex"
 	"github.com/restic/restic/internal/walker"
 )
 
@@ -34,6 +35,10 @@ will allow traversing into matching directories' subfolders.
 Any directory paths specified must be absolute (starting with
 a path separator); paths use the forward slash '/' as separator.
 
+With --search-index, the snapshot is read from the local search index
+in the cache directory instead of the repository. The snapshot is
+added to the index first if necessary.
+
 EXIT STATUS
 ===========
 
@@ -47,11 +52,12 @@ Exit status is 0 if the command was successful, and non-zero if there was any er
 
 // LsOptions collects all options for the ls command.
 type LsOptions struct {
-	ListLong  bool
-	Hosts     []string
-	Tags      restic.TagLists
-	Paths     []string
-	Recursive bool
+	ListLong    bool
+	Hosts       []string
+	Tags        restic.TagLists
+	Paths       []string
+	Recursive   bool
+	SearchIndex bool
 }
 
 var lsOptions LsOptions
@@ -65,6 +71,7 @@ func init() {
 	flags.Var(&lsOptions.Tags, "tag", "only consider snapshots which include this `taglist`, when no snapshot ID is given")
 	flags.StringArrayVar(&lsOptions.Paths, "path", nil, "only consider snapshots which include this (absolute) `path`, when no snapshot ID is given")
 	flags.BoolVar(&lsOptions.Recursive, "recursive", false, "include files in subfolders of the listed directories")
+	flags.BoolVar(&lsOptions.SearchIndex, "search-index", false, "read the snapshot from the local search index, which is updated first")
 }
 
 type lsSnapshot struct {
@@ -150,6 +157,15 @@ func runLs(opts LsOptions, gopts GlobalOptions, args []string) error {
 	ctx, cancel := context.WithCancel(gopts.ctx)
 	defer cancel()
 
+	var trees restic.TreeLoader = repo
+	var searchIndex *searchindex.Index
+	if opts.SearchIndex {
+		if searchIndex, err = openSearchIndex(repo); err != nil {
+			return err
+		}
+		trees = searchIndex
+	}
+
 	var (
 		printSnapshot func(sn *restic.Snapshot)
 		printNode     func(path string, node *restic.Node)
@@ -192,9 +208,16 @@ func runLs(opts LsOptions, gopts GlobalOptions, args []string) error {
 	}
 
 	for sn := range FindFilteredSnapshots(ctx, repo, opts.Hosts, opts.Tags, opts.Paths, args[:1]) {
+		if searchIndex != nil {
+			if err := addToSearchIndex(ctx, repo, searchIndex, sn); err != nil {
+				return err
+			}
+			saveSearchIndex(ctx, repo, searchIndex)
+		}
+
 		printSnapshot(sn)
 
-		err := walker.Walk(ctx, repo, *sn.Tree, nil, func(_ restic.ID, nodepath string, node *restic.Node, err error) (bool, error) {
+		err := walker.Walk(ctx, trees, *sn.Tree, nil, func(_ restic.ID, nodepath string, node *restic.Node, err error) (bool, error) {
 			if err != nil {
 				return false, err
 			}
@@ -109,5 +109,9 @@ func runMigrate(opts MigrateOptions, gopts GlobalOptions, args []string) error {
 		return checkMigrations(opts, gopts, repo)
 	}
 
+	if err = checkAppendOnly(gopts, repo); err != nil {
+		return err
+	}
+
 	return applyMigrations(opts, gopts, repo, args)
 }
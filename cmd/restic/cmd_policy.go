@@ -0,0 +1,245 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	encjson "encoding/json"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/repository"
+	"github.com/restic/restic/internal/restic"
+	"github.com/restic/restic/internal/ui/json"
+
+	"github.com/spf13/cobra"
+)
+
+var cmdPolicy = &cobra.Command{
+	Use:   "policy",
+	Short: "Manage the retention policy stored in the repository",
+	Long: `
+The "policy" commands manage the retention policy stored in the repository. The
+policy is applied by "forget --use-repo-policy", so all clients share the same
+rules for which snapshots are kept.
+`,
+}
+
+var cmdPolicySet = &cobra.Command{
+	Use:   "set [flags] file",
+	Short: "Store a retention policy in the repository",
+	Long: `
+The "policy set" command reads a retention policy in JSON format from the file
+(or stdin if the file is "-") and stores it in the repository, replacing the
+previous policy. The policy consists of a list of rules, each snapshot is
+handled by the first rule whose hosts, tags and paths match the snapshot:
+
+    {
+      "group_by": "host,paths",
+      "rules": [
+        {"hosts": ["db"], "keep": {"daily": 14, "within_weekly": "3m"}},
+        {"tags": [["important"]], "keep": {"within": "1y"}},
+        {"keep": {"last": 5, "daily": 7, "monthly": 12}}
+      ]
+    }
+
+The "keep" object accepts the keys last, hourly, daily, weekly, monthly,
+yearly, within, within_hourly, within_daily, within_weekly, within_monthly,
+within_yearly and tags, which correspond to the --keep-* options of the
+"forget" command. Snapshots which do not match any rule are kept.
+
+EXIT STATUS
+===========
+
+Exit status is 0 if the command was successful, and non-zero if there was any error.
+`,
+	DisableAutoGenTag: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runPolicySet(globalOptions, args)
+	},
+}
+
+var cmdPolicyShow = &cobra.Command{
+	Use:   "show",
+	Short: "Print the retention policy stored in the repository",
+	Long: `
+The "policy show" command prints the retention policy stored in the repository.
+
+EXIT STATUS
+===========
+
+Exit status is 0 if the command was successful, and non-zero if there was any error.
+`,
+	DisableAutoGenTag: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runPolicyShow(globalOptions, args)
+	},
+}
+
+func init() {
+	cmdRoot.AddCommand(cmdPolicy)
+	cmdPolicy.AddCommand(cmdPolicySet)
+	cmdPolicy.AddCommand(cmdPolicyShow)
+}
+
+// readPolicy parses the retention policy in filename, "-" reads from stdin.
+func readPolicy(filename string) (*restic.RetentionPolicy, error) {
+	var (
+		data []byte
+		err  error
+	)
+
+	if filename == "-" {
+		data, err = ioutil.ReadAll(os.Stdin)
+	} else {
+		data, err = ioutil.ReadFile(filename)
+	}
+	if err != nil {
+		return nil, errors.Fatalf("unable to read policy: %v", err)
+	}
+
+	var policy restic.RetentionPolicy
+	dec := encjson.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	err = dec.Decode(&policy)
+	if err != nil {
+		return nil, errors.Fatalf("invalid policy in %v: %v", filename, err)
+	}
+
+	err = policy.Valid()
+	if err == nil {
+		_, _, err = restic.GroupSnapshots(nil, policy.GroupBy)
+	}
+	if err != nil {
+		return nil, errors.Fatalf("invalid policy in %v: %v", filename, err)
+	}
+
+	return &policy, nil
+}
+
+func runPolicySet(gopts GlobalOptions, args []string) error {
+	if len(args) != 1 {
+		return errors.Fatal("please specify the file containing the policy")
+	}
+
+	policy, err := readPolicy(args[0])
+	if err != nil {
+		return err
+	}
+
+	repo, err := OpenRepository(gopts)
+	if err != nil {
+		return err
+	}
+
+	if !gopts.NoLock {
+		lock, err := lockRepoExclusive(gopts.ctx, repo)
+		defer unlockRepo(lock)
+		if err != nil {
+			return err
+		}
+	}
+
+	ctx, cancel := context.WithCancel(gopts.ctx)
+	defer cancel()
+
+	old := restic.NewIDSet()
+	err = repo.List(ctx, restic.PolicyFile, func(id restic.ID, size int64) error {
+		old.Insert(id)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	policy.Time = time.Now()
+	id, err := restic.SaveRetentionPolicy(ctx, repo, policy)
+	if err != nil {
+		return err
+	}
+
+	// the newest policy wins, so old policies which cannot be removed are
+	// ignored by later commands
+	old.Delete(id)
+	if checkAppendOnly(gopts, repo) != nil {
+		debug.Log("repository is append-only, keeping %d old policies", len(old))
+		old = restic.NewIDSet()
+	}
+	for oldID := range old {
+		h := restic.Handle{Type: restic.PolicyFile, Name: oldID.String()}
+		err = repo.Backend().Remove(ctx, h)
+		if err != nil {
+			Warnf("unable to remove old policy %v: %v\n", oldID.Str(), err)
+		}
+	}
+
+	if gopts.JSON {
+		return printJSONPolicy(policy)
+	}
+
+	Verbosef("saved policy %v\n", id.Str())
+	return nil
+}
+
+func runPolicyShow(gopts GlobalOptions, args []string) error {
+	if len(args) != 0 {
+		return errors.Fatal("the show command expects no arguments")
+	}
+
+	repo, err := OpenRepository(gopts)
+	if err != nil {
+		return err
+	}
+
+	if !gopts.NoLock {
+		lock, err := lockRepo(gopts.ctx, repo)
+		defer unlockRepo(lock)
+		if err != nil {
+			return err
+		}
+	}
+
+	policy, err := loadRetentionPolicy(gopts.ctx, repo)
+	if err != nil {
+		return err
+	}
+
+	if gopts.JSON {
+		return printJSONPolicy(policy)
+	}
+
+	Printf("policy %v, set at %v\n", policy.ID().Str(), policy.Time.Local().Format(TimeFormat))
+	if policy.GroupBy != "" {
+		Printf("snapshots are grouped by %v\n", policy.GroupBy)
+	}
+	for i, rule := range policy.Rules {
+		Printf("rule %d: %v: %v\n", i+1, rule, rule.Keep)
+	}
+	Printf("snapshots which do not match any rule are kept\n")
+
+	return nil
+}
+
+// loadRetentionPolicy returns the retention policy stored in repo.
+func loadRetentionPolicy(ctx context.Context, repo *repository.Repository) (*restic.RetentionPolicy, error) {
+	policy, err := restic.LoadRetentionPolicy(ctx, repo)
+	if err == restic.ErrNoRetentionPolicy {
+		return nil, errors.Fatal("the repository does not contain a retention policy, use \"policy set\" to add one")
+	}
+	return policy, err
+}
+
+func printJSONPolicy(policy *restic.RetentionPolicy) error {
+	buf, err := encjson.Marshal(policy)
+	if err != nil {
+		return err
+	}
+
+	printJSON(json.TypeSummary, &json.PolicySummary{
+		ID:     policy.ID().String(),
+		Policy: buf,
+	})
+	return nil
+}
@@ -1,11 +1,17 @@
 package main
 
 import (
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
 	"github.com/restic/restic/internal/debug"
 	"github.com/restic/restic/internal/errors"
-	"github.com/restic/restic/internal/index"
+	"github.com/restic/restic/internal/pack"
 	"github.com/restic/restic/internal/repository"
 	"github.com/restic/restic/internal/restic"
+	"github.com/restic/restic/internal/ui/json"
 
 	"github.com/spf13/cobra"
 )
@@ -17,6 +23,12 @@ var cmdPrune = &cobra.Command{
 The "prune" command checks the repository and removes data that is not
 referenced and therefore not needed any more.
 
+Pack files which only contain unused data are deleted. Pack files which
+contain both used and unused data are only downloaded and rewritten if this
+is needed to bring the amount of unused data below the limit set by
+--max-unused. The amount of data rewritten in a single run can be capped with
+--max-repack-size.
+
 EXIT STATUS
 ===========
 
@@ -24,12 +36,96 @@ Exit status is 0 if the command was successful, and non-zero if there was any er
 `,
 	DisableAutoGenTag: true,
 	RunE: func(cmd *cobra.Command, args []string) error {
-		return runPrune(globalOptions)
+		return runPrune(pruneOptions, globalOptions)
 	},
 }
 
+// PruneOptions collects all options for the cleanup command.
+type PruneOptions struct {
+	DryRun bool
+
+	MaxUnused      string
+	maxUnusedBytes func(used uint64) (unused uint64) // calculates the number of unused bytes after repacking, according to MaxUnused
+
+	MaxRepackSize  string
+	maxRepackBytes uint64
+}
+
+var pruneOptions PruneOptions
+
 func init() {
 	cmdRoot.AddCommand(cmdPrune)
+	f := cmdPrune.Flags()
+	f.BoolVarP(&pruneOptions.DryRun, "dry-run", "n", false, "do not modify the repository, just print what would be done")
+	addPruneOptions(cmdPrune)
+}
+
+func addPruneOptions(c *cobra.Command) {
+	f := c.Flags()
+	f.StringVar(&pruneOptions.MaxUnused, "max-unused", "5%", "tolerate given `limit` of unused data (absolute value in bytes with suffixes k/K, m/M, g/G, t/T, a value in % or the word 'unlimited')")
+	f.StringVar(&pruneOptions.MaxRepackSize, "max-repack-size", "", "maximum `size` to repack (allowed suffixes: k/K, m/M, g/G, t/T)")
+}
+
+func verifyPruneOptions(opts *PruneOptions) error {
+	if len(opts.MaxRepackSize) > 0 {
+		size, err := parseSizeStr(opts.MaxRepackSize)
+		if err != nil {
+			return errors.Fatalf("invalid size %q passed for --max-repack-size: %v", opts.MaxRepackSize, err)
+		}
+		if size < 0 {
+			return errors.Fatal("size for --max-repack-size must not be negative")
+		}
+		opts.maxRepackBytes = uint64(size)
+	}
+
+	maxUnused := strings.TrimSpace(opts.MaxUnused)
+	if maxUnused == "" {
+		return errors.Fatalf("invalid value for --max-unused: %q", opts.MaxUnused)
+	}
+
+	// parse MaxUnused either as unlimited, a percentage, or an absolute number of bytes
+	switch {
+	case maxUnused == "unlimited":
+		opts.maxUnusedBytes = func(used uint64) uint64 {
+			return math.MaxUint64
+		}
+
+	case strings.HasSuffix(maxUnused, "%"):
+		maxUnused = strings.TrimSuffix(maxUnused, "%")
+		p, err := strconv.ParseFloat(maxUnused, 64)
+		if err != nil {
+			return errors.Fatalf("invalid percentage %q passed for --max-unused: %v", opts.MaxUnused, err)
+		}
+
+		if p < 0 {
+			return errors.Fatal("percentage for --max-unused must be positive")
+		}
+
+		if p >= 100 {
+			return errors.Fatal("percentage for --max-unused must be below 100%")
+		}
+
+		// the percentage refers to the total size after pruning, which is
+		// the used size plus the tolerated unused size
+		opts.maxUnusedBytes = func(used uint64) uint64 {
+			return uint64(p / (100 - p) * float64(used))
+		}
+
+	default:
+		size, err := parseSizeStr(maxUnused)
+		if err != nil {
+			return errors.Fatalf("invalid number of bytes %q for --max-unused: %v", opts.MaxUnused, err)
+		}
+		if size < 0 {
+			return errors.Fatal("number of bytes for --max-unused must not be negative")
+		}
+
+		opts.maxUnusedBytes = func(used uint64) uint64 {
+			return uint64(size)
+		}
+	}
+
+	return nil
 }
 
 func shortenStatus(maxLength int, s string) string {
@@ -44,7 +140,12 @@ func shortenStatus(maxLength int, s string) string {
 	return s[:maxLength-3] + "..."
 }
 
-func runPrune(gopts GlobalOptions) error {
+func runPrune(opts PruneOptions, gopts GlobalOptions) error {
+	err := verifyPruneOptions(&opts)
+	if err != nil {
+		return err
+	}
+
 	repo, err := OpenRepository(gopts)
 	if err != nil {
 		return err
@@ -56,214 +157,463 @@ func runPrune(gopts GlobalOptions) error {
 		return err
 	}
 
-	// we do not need index updates while pruning!
-	repo.DisableAutoIndexUpdate()
-
-	return pruneRepository(gopts, repo)
+	return runPruneWithRepo(opts, gopts, repo)
 }
 
-func mixedBlobs(list []restic.Blob) bool {
-	var tree, data bool
-
-	for _, pb := range list {
-		switch pb.Type {
-		case restic.TreeBlob:
-			tree = true
-		case restic.DataBlob:
-			data = true
-		}
-
-		if tree && data {
-			return true
+func runPruneWithRepo(opts PruneOptions, gopts GlobalOptions, repo *repository.Repository) error {
+	if !opts.DryRun {
+		if err := checkAppendOnly(gopts, repo); err != nil {
+			return err
 		}
 	}
 
-	return false
-}
-
-func pruneRepository(gopts GlobalOptions, repo restic.Repository) error {
-	ctx := gopts.ctx
+	// we do not need index updates while pruning!
+	repo.DisableAutoIndexUpdate()
 
-	err := repo.LoadIndex(ctx)
+	Verbosef("loading indexes...\n")
+	err := repo.LoadIndex(gopts.ctx)
 	if err != nil {
 		return err
 	}
 
-	var stats struct {
-		blobs     int
-		packs     int
-		snapshots int
-		bytes     int64
-	}
+	return pruneRepository(gopts, opts, repo)
+}
 
-	Verbosef("counting files in repo\n")
-	err = repo.List(ctx, restic.PackFile, func(restic.ID, int64) error {
-		stats.packs++
-		return nil
-	})
-	if err != nil {
-		return err
-	}
+// packInfo collects the sizes of the blobs in a pack file, according to the
+// index.
+type packInfo struct {
+	usedBlobs      uint
+	unusedBlobs    uint
+	duplicateBlobs uint
+	usedSize       uint64
+	unusedSize     uint64
+	// tpe is the type of all blobs in the pack, or restic.InvalidBlob if the
+	// pack contains both data and tree blobs
+	tpe restic.BlobType
+}
 
-	Verbosef("building new index for repo\n")
+type packInfoWithID struct {
+	ID restic.ID
+	packInfo
+}
 
-	bar := newProgressMax(!gopts.Quiet, uint64(stats.packs), "packs")
-	idx, invalidFiles, err := index.New(ctx, repo, restic.NewIDSet(), bar)
-	if err != nil {
-		return err
+// pruneStats collects the numbers reported by prune.
+type pruneStats struct {
+	blobs struct {
+		used      uint
+		duplicate uint
+		unused    uint
+		remove    uint
+		repack    uint
+		repackrm  uint
 	}
-
-	for _, id := range invalidFiles {
-		Warnf("incomplete pack file (will be removed): %v\n", id)
+	size struct {
+		used      uint64
+		duplicate uint64
+		unused    uint64
+		remove    uint64
+		repack    uint64
+		repackrm  uint64
+		unref     uint64
 	}
-
-	blobs := 0
-	for _, pack := range idx.Packs {
-		stats.bytes += pack.Size
-		blobs += len(pack.Entries)
+	packs struct {
+		used       uint
+		unused     uint
+		partlyUsed uint
+		keep       uint
 	}
-	Verbosef("repository contains %v packs (%v blobs) with %v\n",
-		len(idx.Packs), blobs, formatBytes(uint64(stats.bytes)))
-
-	blobCount := make(map[restic.BlobHandle]int)
-	var duplicateBlobs uint64
-	var duplicateBytes uint64
-
-	// find duplicate blobs
-	for _, p := range idx.Packs {
-		for _, entry := range p.Entries {
-			stats.blobs++
-			h := restic.BlobHandle{ID: entry.ID, Type: entry.Type}
-			blobCount[h]++
+}
 
-			if blobCount[h] > 1 {
-				duplicateBlobs++
-				duplicateBytes += uint64(entry.Length)
-			}
-		}
-	}
+// pruneRepository removes all data from the repository which is not
+// referenced by a snapshot. It relies on the index being correct: pack files
+// which are not listed in the index are removed without inspecting them, and
+// the size of each pack file is checked against the index.
+func pruneRepository(gopts GlobalOptions, opts PruneOptions, repo restic.Repository) error {
+	ctx := gopts.ctx
 
-	Verbosef("processed %d blobs: %d duplicate blobs, %v duplicate\n",
-		stats.blobs, duplicateBlobs, formatBytes(uint64(duplicateBytes)))
-	Verbosef("load all snapshots\n")
+	var stats pruneStats
 
-	// find referenced blobs
+	Verbosef("loading all snapshots...\n")
 	snapshots, err := restic.LoadAllSnapshots(ctx, repo)
 	if err != nil {
 		return err
 	}
 
-	stats.snapshots = len(snapshots)
-
 	usedBlobs, err := getUsedBlobs(gopts, repo, snapshots)
 	if err != nil {
 		return err
 	}
 
-	var missingBlobs []restic.BlobHandle
-	for h := range usedBlobs {
-		if _, ok := blobCount[h]; !ok {
-			missingBlobs = append(missingBlobs, h)
+	Verbosef("searching used packs...\n")
+
+	keepBlobs := restic.NewBlobSet()
+	duplicateBlobs := restic.NewBlobSet()
+
+	// iterate over all blobs in index to find out which blobs are duplicates
+	for blob := range repo.Index().Each(ctx) {
+		h := restic.BlobHandle{ID: blob.ID, Type: blob.Type}
+		size := uint64(blob.Length)
+		switch {
+		case usedBlobs.Has(h): // used blob, move to keepBlobs
+			usedBlobs.Delete(h)
+			keepBlobs.Insert(h)
+			stats.size.used += size
+			stats.blobs.used++
+		case keepBlobs.Has(h): // duplicate blob
+			duplicateBlobs.Insert(h)
+			stats.size.duplicate += size
+			stats.blobs.duplicate++
+		default:
+			stats.size.unused += size
+			stats.blobs.unused++
 		}
 	}
-	if len(missingBlobs) > 0 {
-		return errors.Fatalf("%v not found in the new index\n"+
+
+	// all used blobs must have been found in the index
+	if len(usedBlobs) != 0 {
+		return errors.Fatalf("%v not found in the index\n"+
 			"Data blobs seem to be missing, aborting prune to prevent further data loss!\n"+
-			"Please report this error (along with the output of the 'prune' run) at\n"+
-			"https://github.com/restic/restic/issues/new/choose", missingBlobs)
+			"Run 'restic rebuild-index' to add blobs which exist in the repository to the index.\n"+
+			"If this doesn't help, please report this error (along with the output of the 'prune' run) at\n"+
+			"https://github.com/restic/restic/issues/new/choose", usedBlobs)
 	}
 
-	Verbosef("found %d of %d data blobs still in use, removing %d blobs\n",
-		len(usedBlobs), stats.blobs, stats.blobs-len(usedBlobs))
+	indexPack := make(map[restic.ID]packInfo)
 
-	// find packs that need a rewrite
-	rewritePacks := restic.NewIDSet()
-	for _, pack := range idx.Packs {
-		if mixedBlobs(pack.Entries) {
-			rewritePacks.Insert(pack.ID)
-			continue
+	// loop over all blobs in index to generate packInfo
+	for blob := range repo.Index().Each(ctx) {
+		ip, ok := indexPack[blob.PackID]
+		if !ok {
+			ip.tpe = blob.Type
+			// the header is accounted to the used size, the header entries
+			// are added for each blob below
+			ip.usedSize = pack.HeaderSize
 		}
 
-		for _, blob := range pack.Entries {
-			h := restic.BlobHandle{ID: blob.ID, Type: blob.Type}
-			if !usedBlobs.Has(h) {
-				rewritePacks.Insert(pack.ID)
-				continue
-			}
+		// mark mixed packs with "Invalid blob type"
+		if ip.tpe != blob.Type {
+			ip.tpe = restic.InvalidBlob
+		}
 
-			if blobCount[h] > 1 {
-				rewritePacks.Insert(pack.ID)
-			}
+		h := restic.BlobHandle{ID: blob.ID, Type: blob.Type}
+		size := uint64(blob.Length)
+		ip.usedSize += uint64(pack.CalculateEntrySize(blob.Blob))
+		switch {
+		case duplicateBlobs.Has(h): // duplicate blob
+			ip.usedSize += size
+			ip.duplicateBlobs++
+		case keepBlobs.Has(h): // used blob, not duplicate
+			ip.usedSize += size
+			ip.usedBlobs++
+		default: // unused blob
+			ip.unusedSize += size
+			ip.unusedBlobs++
 		}
+
+		indexPack[blob.PackID] = ip
 	}
 
-	removeBytes := duplicateBytes
+	Verbosef("collecting packs for deletion and repacking\n")
 
-	// find packs that are unneeded
+	removePacksFirst := restic.NewIDSet()
 	removePacks := restic.NewIDSet()
+	repackPacks := restic.NewIDSet()
+
+	var repackCandidates []packInfoWithID
+
+	err = repo.List(ctx, restic.PackFile, func(id restic.ID, packSize int64) error {
+		p, ok := indexPack[id]
+		if !ok {
+			// pack is not referenced in the index and thus not used => remove it first
+			debug.Log("pack %v is not indexed, will be removed", id)
+			removePacksFirst.Insert(id)
+			stats.size.unref += uint64(packSize)
+			return nil
+		}
+
+		unused := p.usedBlobs == 0 && p.duplicateBlobs == 0
+
+		if p.unusedSize+p.usedSize != uint64(packSize) && !unused {
+			// a needed pack whose size doesn't match the index can't be
+			// handled safely, unneeded packs are simply removed below
+			if gopts.JSON {
+				printJSONError("check pack size", id.String(),
+					errors.Errorf("calculated size %d does not match real size %d", p.unusedSize+p.usedSize, packSize))
+			} else {
+				Warnf("pack %s: calculated size %d does not match real size %d\n",
+					id.Str(), p.unusedSize+p.usedSize, packSize)
+			}
+			return errors.Fatal("pack size does not match the index, run 'restic rebuild-index'")
+		}
+
+		// statistics
+		switch {
+		case unused:
+			stats.packs.unused++
+		case p.unusedBlobs == 0 && p.duplicateBlobs == 0:
+			stats.packs.used++
+		default:
+			stats.packs.partlyUsed++
+		}
 
-	Verbosef("will remove %d invalid files\n", len(invalidFiles))
-	for _, id := range invalidFiles {
-		removePacks.Insert(id)
+		// decide what to do
+		switch {
+		case unused:
+			// all blobs in pack are no longer used => remove pack!
+			removePacks.Insert(id)
+			stats.blobs.remove += p.unusedBlobs
+			stats.size.remove += p.unusedSize
+
+		case p.unusedBlobs == 0 && p.duplicateBlobs == 0 && p.tpe != restic.InvalidBlob:
+			// all blobs in pack are used and not duplicates/mixed => keep pack!
+			stats.packs.keep++
+
+		default:
+			// all other packs are candidates for repacking
+			repackCandidates = append(repackCandidates, packInfoWithID{ID: id, packInfo: p})
+		}
+
+		delete(indexPack, id)
+		return nil
+	})
+	if err != nil {
+		return err
 	}
 
-	for packID, p := range idx.Packs {
+	// packs which are listed in the index but don't exist any more
+	missingPacks := restic.NewIDSet()
+	for id, p := range indexPack {
+		if p.usedBlobs == 0 && p.duplicateBlobs == 0 {
+			// unused, only the index needs to be updated
+			debug.Log("pack %v is missing but unused, removing it from the index", id)
+			missingPacks.Insert(id)
+			stats.blobs.remove += p.unusedBlobs
+			continue
+		}
 
-		hasActiveBlob := false
-		for _, blob := range p.Entries {
-			h := restic.BlobHandle{ID: blob.ID, Type: blob.Type}
-			if usedBlobs.Has(h) {
-				hasActiveBlob = true
-				continue
-			}
+		if gopts.JSON {
+			printJSONError("find packs", id.String(), errors.New("pack referenced by the index is missing from the repository"))
+		} else {
+			Warnf("pack %v referenced by the index is missing from the repository\n", id)
+		}
+	}
+
+	if len(missingPacks) != len(indexPack) {
+		return errors.Fatalf("%d needed pack files are missing from the repository\n"+
+			"Data blobs seem to be missing, aborting prune to prevent further data loss!",
+			len(indexPack)-len(missingPacks))
+	}
 
-			removeBytes += uint64(blob.Length)
+	// calculate limit for number of unused bytes in the repo after repacking
+	maxUnusedSizeAfter := opts.maxUnusedBytes(stats.size.used)
+
+	// Sort repackCandidates such that packs with the highest ratio of unused
+	// to used space are picked first. This is equivalent to sorting by
+	// unused / total space. Duplicates and packs containing trees are sorted
+	// to the beginning.
+	sort.Slice(repackCandidates, func(i, j int) bool {
+		pi := repackCandidates[i].packInfo
+		pj := repackCandidates[j].packInfo
+		switch {
+		case pi.duplicateBlobs > 0 && pj.duplicateBlobs == 0:
+			return true
+		case pj.duplicateBlobs > 0 && pi.duplicateBlobs == 0:
+			return false
+		case pi.tpe != restic.DataBlob && pj.tpe == restic.DataBlob:
+			return true
+		case pj.tpe != restic.DataBlob && pi.tpe == restic.DataBlob:
+			return false
 		}
+		// compare unused[i] / used[i] > unused[j] / used[j] without
+		// dividing, used is always non-zero for repack candidates
+		return float64(pi.unusedSize)*float64(pj.usedSize) > float64(pj.unusedSize)*float64(pi.usedSize)
+	})
 
-		if hasActiveBlob {
-			continue
+	repack := func(id restic.ID, p packInfo) {
+		repackPacks.Insert(id)
+		stats.blobs.repack += p.unusedBlobs + p.duplicateBlobs + p.usedBlobs
+		stats.size.repack += p.unusedSize + p.usedSize
+		stats.blobs.repackrm += p.unusedBlobs
+		stats.size.repackrm += p.unusedSize
+	}
+
+	for _, p := range repackCandidates {
+		reachedUnusedSizeAfter := stats.size.unused-stats.size.remove-stats.size.repackrm < maxUnusedSizeAfter
+
+		reachedRepackSize := false
+		if opts.maxRepackBytes > 0 {
+			reachedRepackSize = stats.size.repack+p.unusedSize+p.usedSize > opts.maxRepackBytes
 		}
 
-		removePacks.Insert(packID)
+		switch {
+		case reachedRepackSize:
+			stats.packs.keep++
+
+		case p.duplicateBlobs > 0, p.tpe != restic.DataBlob:
+			// repacking duplicates and trees is only limited by the repack size
+			repack(p.ID, p.packInfo)
+
+		case reachedUnusedSizeAfter:
+			// for all other packs stop repacking if the tolerated unused size is reached
+			stats.packs.keep++
 
-		if !rewritePacks.Has(packID) {
-			return errors.Fatalf("pack %v is unneeded, but not contained in rewritePacks", packID.Str())
+		default:
+			repack(p.ID, p.packInfo)
 		}
+	}
+
+	printPruneStats(gopts, stats, len(repackPacks), len(removePacks), len(removePacksFirst))
 
-		rewritePacks.Delete(packID)
+	if opts.DryRun {
+		if gopts.JSON {
+			summary := newPruneSummary(stats, len(repackPacks), len(removePacks), len(removePacksFirst))
+			summary.DryRun = true
+			printJSON(json.TypeSummary, summary)
+		} else if gopts.verbosity >= 2 {
+			if len(removePacksFirst) > 0 {
+				Printf("Would have removed the following unreferenced packs:\n%v\n\n", removePacksFirst)
+			}
+			Printf("Would have repacked and removed the following packs:\n%v\n\n", repackPacks)
+			Printf("Would have removed the following no longer used packs:\n%v\n\n", removePacks)
+		}
+		// always quit here if DryRun was set!
+		return nil
 	}
 
-	Verbosef("will delete %d packs and rewrite %d packs, this frees %s\n",
-		len(removePacks), len(rewritePacks), formatBytes(uint64(removeBytes)))
+	// unreferenced packs can be safely deleted first
+	if len(removePacksFirst) != 0 {
+		Verbosef("deleting unreferenced packs\n")
+		DeleteFiles(gopts, repo, removePacksFirst, restic.PackFile)
+	}
 
-	var obsoletePacks restic.IDSet
-	if len(rewritePacks) != 0 {
-		bar := newProgressMax(!gopts.Quiet, uint64(len(rewritePacks)), "packs rewritten")
-		obsoletePacks, err = repository.Repack(ctx, repo, rewritePacks, usedBlobs, bar)
+	if len(repackPacks) != 0 {
+		Verbosef("repacking packs\n")
+		bar := newProgressMax(!gopts.Quiet, uint64(len(repackPacks)), "packs repacked")
+		_, err := repository.Repack(ctx, repo, repackPacks, keepBlobs, bar)
 		if err != nil {
 			return err
 		}
-	}
 
-	removePacks.Merge(obsoletePacks)
+		// also remove repacked packs
+		removePacks.Merge(repackPacks)
+	}
 
-	if err = rebuildIndex(ctx, repo, removePacks); err != nil {
-		return err
+	if len(removePacks) != 0 || len(missingPacks) != 0 {
+		ignorePacks := restic.NewIDSet()
+		ignorePacks.Merge(removePacks)
+		ignorePacks.Merge(missingPacks)
+		err = rebuildIndexFiles(gopts, repo, ignorePacks)
+		if err != nil {
+			return err
+		}
 	}
 
 	if len(removePacks) != 0 {
-		Verbosef("remove %d old packs\n", len(removePacks))
+		Verbosef("removing %d old packs\n", len(removePacks))
 		DeleteFiles(gopts, repo, removePacks, restic.PackFile)
 	}
 
+	if gopts.JSON {
+		printJSON(json.TypeSummary, newPruneSummary(stats, len(repackPacks), len(removePacks), len(removePacksFirst)))
+	}
+
 	Verbosef("done\n")
 	return nil
 }
 
+func printPruneStats(gopts GlobalOptions, stats pruneStats, repackPacks, removePacks, removePacksFirst int) {
+	Verbosef("\nused:         %10d blobs / %s\n", stats.blobs.used, formatBytes(stats.size.used))
+	if stats.blobs.duplicate > 0 {
+		Verbosef("duplicates:   %10d blobs / %s\n", stats.blobs.duplicate, formatBytes(stats.size.duplicate))
+	}
+	Verbosef("unused:       %10d blobs / %s\n", stats.blobs.unused, formatBytes(stats.size.unused))
+	if stats.size.unref > 0 {
+		Verbosef("unreferenced:                    %s\n", formatBytes(stats.size.unref))
+	}
+	totalBlobs := stats.blobs.used + stats.blobs.unused + stats.blobs.duplicate
+	totalSize := stats.size.used + stats.size.duplicate + stats.size.unused + stats.size.unref
+	unusedSize := stats.size.duplicate + stats.size.unused
+	Verbosef("total:        %10d blobs / %s\n", totalBlobs, formatBytes(totalSize))
+	Verbosef("unused size: %s of total size\n", formatPercent(unusedSize, totalSize))
+
+	Verbosef("\nto repack:    %10d blobs / %s\n", stats.blobs.repack, formatBytes(stats.size.repack))
+	Verbosef("this removes: %10d blobs / %s\n", stats.blobs.repackrm, formatBytes(stats.size.repackrm))
+	Verbosef("to delete:    %10d blobs / %s\n", stats.blobs.remove, formatBytes(stats.size.remove+stats.size.unref))
+	totalPruneSize := stats.size.remove + stats.size.repackrm + stats.size.unref
+	Verbosef("total prune:  %10d blobs / %s\n", stats.blobs.remove+stats.blobs.repackrm, formatBytes(totalPruneSize))
+	Verbosef("remaining:    %10d blobs / %s\n", totalBlobs-(stats.blobs.remove+stats.blobs.repackrm), formatBytes(totalSize-totalPruneSize))
+	unusedAfter := unusedSize - stats.size.remove - stats.size.repackrm
+	Verbosef("unused size after prune: %s (%s of remaining size)\n",
+		formatBytes(unusedAfter), formatPercent(unusedAfter, totalSize-totalPruneSize))
+	Verbosef("\n")
+
+	Verbosef("data to download for repacking: %s\n", formatBytes(stats.size.repack))
+	Verbosef("space freed by prune:           %s\n\n", formatBytes(totalPruneSize))
+
+	if gopts.verbosity >= 2 && !gopts.JSON {
+		Printf("totally used packs: %10d\n", stats.packs.used)
+		Printf("partly used packs:  %10d\n", stats.packs.partlyUsed)
+		Printf("unused packs:       %10d\n\n", stats.packs.unused)
+
+		Printf("to keep:      %10d packs\n", stats.packs.keep)
+		Printf("to repack:    %10d packs\n", repackPacks)
+		Printf("to delete:    %10d packs\n", removePacks)
+		if removePacksFirst > 0 {
+			Printf("to delete:    %10d unreferenced packs\n", removePacksFirst)
+		}
+		Printf("\n")
+	}
+}
+
+// newPruneSummary returns the JSON summary for the given statistics.
+func newPruneSummary(stats pruneStats, repackPacks, removePacks, removePacksFirst int) *json.PruneSummary {
+	totalBlobs := stats.blobs.used + stats.blobs.unused + stats.blobs.duplicate
+	totalSize := stats.size.used + stats.size.duplicate + stats.size.unused + stats.size.unref
+	totalPruneSize := stats.size.remove + stats.size.repackrm + stats.size.unref
+
+	return &json.PruneSummary{
+		Used:              json.PruneBlobStats{Blobs: stats.blobs.used, Bytes: stats.size.used},
+		Duplicate:         json.PruneBlobStats{Blobs: stats.blobs.duplicate, Bytes: stats.size.duplicate},
+		Unused:            json.PruneBlobStats{Blobs: stats.blobs.unused, Bytes: stats.size.unused},
+		UnreferencedBytes: stats.size.unref,
+		Repack:            json.PruneBlobStats{Blobs: stats.blobs.repack, Bytes: stats.size.repack},
+		RepackRemove:      json.PruneBlobStats{Blobs: stats.blobs.repackrm, Bytes: stats.size.repackrm},
+		Remove:            json.PruneBlobStats{Blobs: stats.blobs.remove, Bytes: stats.size.remove + stats.size.unref},
+		Remaining: json.PruneBlobStats{
+			Blobs: totalBlobs - (stats.blobs.remove + stats.blobs.repackrm),
+			Bytes: totalSize - totalPruneSize,
+		},
+		Packs: json.PrunePackStats{
+			Used:         stats.packs.used,
+			PartlyUsed:   stats.packs.partlyUsed,
+			Unused:       stats.packs.unused,
+			Keep:         stats.packs.keep,
+			Repack:       repackPacks,
+			Remove:       removePacks,
+			Unreferenced: removePacksFirst,
+		},
+	}
+}
+
+// rebuildIndexFiles writes new index files for the data in the repository,
+// leaving out all packs in removePacks, and removes the old index files.
+func rebuildIndexFiles(gopts GlobalOptions, repo restic.Repository, removePacks restic.IDSet) error {
+	Verbosef("rebuilding index\n")
+
+	idx := repo.Index().(*repository.MasterIndex)
+	obsoleteIndexes, _, err := idx.Save(gopts.ctx, repo, removePacks)
+	if err != nil {
+		return errors.Fatalf("unable to save index, last error was: %v", err)
+	}
+
+	Verbosef("deleting obsolete index files\n")
+	return DeleteFilesChecked(gopts, repo, obsoleteIndexes, restic.IndexFile)
+}
+
 func getUsedBlobs(gopts GlobalOptions, repo restic.Repository, snapshots []*restic.Snapshot) (usedBlobs restic.BlobSet, err error) {
 	ctx := gopts.ctx
 
-	Verbosef("find data that is still in use for %d snapshots\n", len(snapshots))
+	Verbosef("finding data that is still in use for %d snapshots\n", len(snapshots))
 
 	usedBlobs = restic.NewBlobSet()
 
@@ -6,6 +6,7 @@ import (
 	"github.com/restic/restic/internal/errors"
 	"github.com/restic/restic/internal/index"
 	"github.com/restic/restic/internal/restic"
+	"github.com/restic/restic/internal/ui/json"
 
 	"github.com/spf13/cobra"
 )
@@ -38,6 +39,10 @@ func runRebuildIndex(gopts GlobalOptions) error {
 		return err
 	}
 
+	if err = checkAppendOnly(gopts, repo); err != nil {
+		return err
+	}
+
 	lock, err := lockRepoExclusive(gopts.ctx, repo)
 	defer unlockRepo(lock)
 	if err != nil {
@@ -67,7 +72,7 @@ func rebuildIndex(ctx context.Context, repo restic.Repository, ignorePacks resti
 		return err
 	}
 
-	if globalOptions.verbosity >= 2 {
+	if globalOptions.verbosity >= 2 && !globalOptions.JSON {
 		for _, id := range invalidFiles {
 			Printf("skipped incomplete pack file: %v\n", id)
 		}
@@ -97,5 +102,19 @@ func rebuildIndex(ctx context.Context, repo restic.Repository, ignorePacks resti
 		return errors.Fatalf("unable to remove an old index: %v\n", err)
 	}
 
+	if globalOptions.JSON {
+		summary := &json.RebuildIndexSummary{
+			SavedIndexes:   []string{},
+			RemovedIndexes: len(supersedes),
+		}
+		for _, id := range ids {
+			summary.SavedIndexes = append(summary.SavedIndexes, id.String())
+		}
+		for _, id := range invalidFiles {
+			summary.SkippedPacks = append(summary.SkippedPacks, id.String())
+		}
+		printJSON(json.TypeSummary, summary)
+	}
+
 	return nil
 }
@@ -0,0 +1,21 @@
+package main
+
+import (
+	"github.com/spf13/cobra"
+)
+
+var cmdRepair = &cobra.Command{
+	Use:   "repair",
+	Short: "Repair the repository",
+	Long: `
+The "repair" commands try to restore a consistent state of a damaged
+repository, so that "check" passes again. Data which cannot be read any more
+is lost, the commands report which data this concerns.
+
+Run "repair index" first, then "repair snapshots".
+`,
+}
+
+func init() {
+	cmdRoot.AddCommand(cmdRepair)
+}
@@ -0,0 +1,330 @@
+package main
+
+import (
+	"context"
+
+	"github.com/spf13/cobra"
+
+	"github.com/restic/restic/internal/crypto"
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/fs"
+	"github.com/restic/restic/internal/index"
+	"github.com/restic/restic/internal/repository"
+	"github.com/restic/restic/internal/restic"
+)
+
+var cmdRepairIndex = &cobra.Command{
+	Use:   "index [flags]",
+	Short: "Build a new index and salvage data from damaged pack files",
+	Long: `
+The "repair index" command creates a new index based on the pack files in the
+repository, like "rebuild-index". Pack files whose header is damaged, for
+example because the file was truncated, are removed. Before that, all blobs
+which are listed for these pack files in the existing index and which can still
+be read are saved to new pack files. Pack files which still contain blobs that
+could not be salvaged are kept. These blobs are reported as lost, run "repair
+snapshots" afterwards to remove references to them.
+
+If a pack file cannot be read for a different reason, for example because of a
+network error, the command aborts without modifying the repository.
+
+EXIT STATUS
+===========
+
+Exit status is 0 if the command was successful, and non-zero if there was any error.
+`,
+	DisableAutoGenTag: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runRepairIndex(globalOptions)
+	},
+}
+
+func init() {
+	cmdRepair.AddCommand(cmdRepairIndex)
+}
+
+func runRepairIndex(gopts GlobalOptions) error {
+	repo, err := OpenRepository(gopts)
+	if err != nil {
+		return err
+	}
+
+	if err = checkAppendOnly(gopts, repo); err != nil {
+		return err
+	}
+
+	lock, err := lockRepoExclusive(gopts.ctx, repo)
+	defer unlockRepo(lock)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(gopts.ctx)
+	defer cancel()
+
+	return repairIndex(ctx, gopts, repo)
+}
+
+func repairIndex(ctx context.Context, gopts GlobalOptions, repo *repository.Repository) error {
+	Verbosef("loading indexes...\n")
+	indexLoaded := true
+	err := repo.LoadIndex(ctx)
+	if err != nil {
+		indexLoaded = false
+		Warnf("unable to load the existing index, data in damaged pack files cannot be salvaged: %v\n", err)
+		err = repo.SetIndex(repository.NewMasterIndex())
+		if err != nil {
+			return err
+		}
+	}
+
+	Verbosef("counting files in repo\n")
+	packs := restic.NewIDSet()
+	err = repo.List(ctx, restic.PackFile, func(id restic.ID, size int64) error {
+		packs.Insert(id)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	Verbosef("reading pack files\n")
+	bar := newProgressMax(!gopts.Quiet, uint64(len(packs)), "packs")
+	idx, invalidFiles, err := index.New(ctx, repo, restic.NewIDSet(), bar)
+	if err != nil {
+		return err
+	}
+
+	// only pack files with a damaged header are handled, other errors may be
+	// temporary and the pack file must not be removed
+	damagedPacks := restic.NewIDSet(invalidFiles...)
+	err = checkPacksListed(packs, idx, damagedPacks)
+	if err != nil {
+		return err
+	}
+
+	removePacks := restic.NewIDSet()
+	if len(damagedPacks) > 0 {
+		Verbosef("salvaging data from %d damaged pack files\n", len(damagedPacks))
+		lost, salvagedPacks, err := salvagePacks(ctx, repo, idx, damagedPacks)
+		if err != nil {
+			return err
+		}
+
+		// add the newly written packs to the index
+		newPacks := restic.NewIDSet()
+		err = repo.List(ctx, restic.PackFile, func(id restic.ID, size int64) error {
+			if !packs.Has(id) {
+				newPacks.Insert(id)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		newIdx, newInvalid, err := index.New(ctx, repo, packs, nil)
+		if err != nil {
+			return err
+		}
+		err = checkPacksListed(newPacks, newIdx, restic.NewIDSet(newInvalid...))
+		if err != nil {
+			return err
+		}
+		if len(newInvalid) > 0 {
+			return errors.Fatalf("newly written pack files %v are damaged", newInvalid)
+		}
+
+		for id, pack := range newIdx.Packs {
+			err = idx.AddPack(id, pack.Size, pack.Entries)
+			if err != nil {
+				return err
+			}
+		}
+
+		if len(lost) > 0 {
+			Warnf("%d blobs could not be salvaged and are lost:\n", len(lost))
+			for h := range lost {
+				Warnf("  %v\n", h)
+			}
+			Warnf("run 'restic repair snapshots' to remove references to the lost data\n")
+		}
+
+		// without the old index it is unknown which blobs the damaged pack
+		// files contained, so they are kept
+		if indexLoaded {
+			removePacks = salvagedPacks
+		}
+		if len(removePacks) < len(damagedPacks) {
+			Warnf("keeping %d damaged pack files which may contain data that could not be salvaged\n",
+				len(damagedPacks)-len(removePacks))
+		}
+	}
+
+	Verbosef("finding old index files\n")
+	var supersedes restic.IDs
+	err = repo.List(ctx, restic.IndexFile, func(id restic.ID, size int64) error {
+		supersedes = append(supersedes, id)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	ids, err := idx.Save(ctx, repo, supersedes)
+	if err != nil {
+		return errors.Fatalf("unable to save index, last error was: %v", err)
+	}
+	Verbosef("saved new indexes as %v\n", ids)
+
+	Verbosef("remove %d old index files\n", len(supersedes))
+	err = DeleteFilesChecked(gopts, repo, restic.NewIDSet(supersedes...), restic.IndexFile)
+	if err != nil {
+		return errors.Fatalf("unable to remove an old index: %v\n", err)
+	}
+
+	if len(removePacks) > 0 {
+		Verbosef("remove %d damaged pack files\n", len(removePacks))
+		err = DeleteFilesChecked(gopts, repo, removePacks, restic.PackFile)
+		if err != nil {
+			return errors.Fatalf("unable to remove a damaged pack file: %v\n", err)
+		}
+	}
+
+	Verbosef("done\n")
+	return nil
+}
+
+// checkPacksListed returns an error if one of the packs is neither contained
+// in idx nor in invalid, which means that it could not be read.
+func checkPacksListed(packs restic.IDSet, idx *index.Index, invalid restic.IDSet) error {
+	unreadable := 0
+	for id := range packs {
+		if _, ok := idx.Packs[id]; !ok && !invalid.Has(id) {
+			unreadable++
+		}
+	}
+
+	if unreadable > 0 {
+		return errors.Fatalf("%d pack files could not be read, please try again", unreadable)
+	}
+	return nil
+}
+
+// salvagePacks saves all blobs which are listed for the damaged packs in the
+// index of repo and can still be read to new pack files, unless idx already
+// contains them. The blobs which could not be salvaged are returned together
+// with the damaged packs whose blobs are all available elsewhere now.
+func salvagePacks(ctx context.Context, repo *repository.Repository, idx *index.Index, damagedPacks restic.IDSet) (lost restic.BlobSet, salvagedPacks restic.IDSet, err error) {
+	known := restic.NewBlobSet()
+	for _, pack := range idx.Packs {
+		for _, blob := range pack.Entries {
+			known.Insert(restic.BlobHandle{ID: blob.ID, Type: blob.Type})
+		}
+	}
+
+	masterIndex := repo.Index().(*repository.MasterIndex)
+
+	lost = restic.NewBlobSet()
+	salvagedPacks = restic.NewIDSet()
+	for id := range damagedPacks {
+		blobs := masterIndex.ListPack(id)
+		debug.Log("pack %v is damaged, the index lists %d blobs", id, len(blobs))
+
+		salvaged, err := salvagePack(ctx, repo, id, blobs, known)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		complete := true
+		for _, blob := range blobs {
+			h := restic.BlobHandle{ID: blob.ID, Type: blob.Type}
+			if !known.Has(h) {
+				lost.Insert(h)
+				complete = false
+			}
+		}
+		if complete {
+			salvagedPacks.Insert(id)
+		}
+
+		Verbosef("pack %v: salvaged %d of %d blobs\n", id.Str(), salvaged, len(blobs))
+	}
+
+	err = repo.Flush(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return lost, salvagedPacks, nil
+}
+
+// salvagePack saves all blobs from the pack which can be read and are not
+// contained in known, they're added to known afterwards.
+func salvagePack(ctx context.Context, repo *repository.Repository, id restic.ID, blobs []restic.PackedBlob, known restic.BlobSet) (salvaged int, err error) {
+	if len(blobs) == 0 {
+		return 0, nil
+	}
+
+	h := restic.Handle{Type: restic.PackFile, Name: id.String()}
+	tempfile, _, size, err := repository.DownloadAndHash(ctx, repo.Backend(), h)
+	if err != nil {
+		return 0, errors.Fatalf("unable to download pack %v: %v", id.Str(), err)
+	}
+
+	defer func() {
+		_ = tempfile.Close()
+		_ = fs.RemoveIfExists(tempfile.Name())
+	}()
+
+	key := repo.Key()
+	for _, blob := range blobs {
+		bh := restic.BlobHandle{ID: blob.ID, Type: blob.Type}
+		if known.Has(bh) {
+			continue
+		}
+
+		if int64(blob.Offset+blob.Length) > size || blob.Length < crypto.Extension {
+			debug.Log("blob %v has an invalid position in the pack", bh)
+			continue
+		}
+
+		buf := make([]byte, blob.Length)
+		_, err := tempfile.ReadAt(buf, int64(blob.Offset))
+		if err != nil {
+			debug.Log("reading blob %v failed: %v", bh, err)
+			continue
+		}
+
+		nonce, ciphertext := buf[:key.NonceSize()], buf[key.NonceSize():]
+		plaintext, err := key.Open(ciphertext[:0], nonce, ciphertext, nil)
+		if err != nil {
+			debug.Log("decrypting blob %v failed: %v", bh, err)
+			continue
+		}
+
+		if blob.IsCompressed() {
+			plaintext, err = repository.DecompressBlob(nil, plaintext, blob.UncompressedLength)
+			if err != nil {
+				debug.Log("decompressing blob %v failed: %v", bh, err)
+				continue
+			}
+		}
+
+		if !restic.Hash(plaintext).Equal(blob.ID) {
+			debug.Log("blob %v has an invalid hash", bh)
+			continue
+		}
+
+		_, _, err = repo.SaveBlob(ctx, blob.Type, plaintext, blob.ID, true)
+		if err != nil {
+			return salvaged, err
+		}
+
+		known.Insert(bh)
+		salvaged++
+	}
+
+	return salvaged, nil
+}
@@ -0,0 +1,276 @@
+package main
+
+import (
+	"context"
+
+	"github.com/spf13/cobra"
+
+	"github.com/restic/restic/internal/crypto"
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/repository"
+	"github.com/restic/restic/internal/restic"
+	"github.com/restic/restic/internal/walker"
+)
+
+var cmdRepairSnapshots = &cobra.Command{
+	Use:   "snapshots [flags] [snapshot-ID ...]",
+	Short: "Repair snapshots which reference missing data",
+	Long: `
+The "repair snapshots" command creates new snapshots for all snapshots which
+reference data that is missing from the repository:
+
+* the content of a file which references missing data blobs is truncated,
+  all missing blobs are removed from the file
+* a directory whose tree is missing from the index or cannot be decrypted is
+  replaced with an empty directory
+
+Other errors while loading a tree, for example network errors, abort the
+command without modifying the snapshot.
+
+The new snapshots get the tag "repaired" and reference the damaged snapshot as
+their original snapshot. Snapshots whose root tree cannot be loaded cannot be
+repaired. Pass --forget to remove the damaged snapshots.
+
+Run "repair index" before this command, so that the index is consistent with
+the data in the repository. Afterwards, "check" should not report errors
+anymore. All data removed from the snapshots is reported, it is lost.
+
+When no snapshot-ID is given, all snapshots matching the host, tag and path
+filter criteria are checked.
+
+EXIT STATUS
+===========
+
+Exit status is 0 if the command was successful, and non-zero if there was any error.
+`,
+	DisableAutoGenTag: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runRepairSnapshots(repairSnapshotsOptions, globalOptions, args)
+	},
+}
+
+// RepairSnapshotsOptions collects all options for the repair snapshots command.
+type RepairSnapshotsOptions struct {
+	DryRun bool
+	Forget bool
+
+	Hosts []string
+	Paths []string
+	Tags  restic.TagLists
+}
+
+var repairSnapshotsOptions RepairSnapshotsOptions
+
+func init() {
+	cmdRepair.AddCommand(cmdRepairSnapshots)
+
+	f := cmdRepairSnapshots.Flags()
+	f.BoolVarP(&repairSnapshotsOptions.DryRun, "dry-run", "n", false, "do not do anything, just print what would be done")
+	f.BoolVarP(&repairSnapshotsOptions.Forget, "forget", "", false, "remove the damaged snapshots after creating the repaired ones")
+
+	f.StringArrayVarP(&repairSnapshotsOptions.Hosts, "host", "H", nil, "only consider snapshots for this `host`, when no snapshot ID is given (can be specified multiple times)")
+	f.Var(&repairSnapshotsOptions.Tags, "tag", "only consider snapshots which include this `taglist`, when no snapshot-ID is given")
+	f.StringArrayVar(&repairSnapshotsOptions.Paths, "path", nil, "only consider snapshots which include this (absolute) `path`, when no snapshot-ID is given")
+}
+
+// repairedTag is added to all repaired snapshots.
+const repairedTag = "repaired"
+
+// repairStats counts what has been removed from the snapshots.
+type repairStats struct {
+	files        int
+	dirs         int
+	repaired     int
+	unrepairable int
+}
+
+func repairSnapshot(ctx context.Context, repo *repository.Repository, sn *restic.Snapshot, opts RepairSnapshotsOptions, stats *repairStats) error {
+	if sn.Tree == nil {
+		return errors.Errorf("snapshot %v has nil tree", sn.ID().Str())
+	}
+
+	var saver walker.TreeLoadSaver = repo
+	if opts.DryRun {
+		saver = dryRunTreeSaver{repo}
+	}
+
+	rewriter := walker.NewTreeRewriter(walker.RewriteOpts{
+		RewriteNode: func(node *restic.Node, path string) *restic.Node {
+			if node.Type != "file" {
+				return node
+			}
+
+			content := restic.IDs{}
+			var size uint64
+			missing := 0
+			for _, id := range node.Content {
+				blobSize, found := repo.LookupBlobSize(id, restic.DataBlob)
+				if !found {
+					missing++
+					continue
+				}
+				content = append(content, id)
+				size += uint64(blobSize)
+			}
+
+			if missing == 0 {
+				return node
+			}
+
+			Printf("  file %q: removed %d of %d blobs with missing content, %d bytes are lost\n",
+				path, missing, len(node.Content), node.Size-size)
+			stats.files++
+
+			newNode := *node
+			newNode.Content = content
+			newNode.Size = size
+			return &newNode
+		},
+		RewriteFailedTree: func(nodeID restic.ID, path string, err error) (restic.ID, error) {
+			if !treeIsLost(repo, nodeID, err) {
+				// the error may be temporary, don't remove anything
+				return restic.ID{}, err
+			}
+
+			if path == "/" {
+				// the snapshot cannot be repaired
+				return restic.ID{}, nil
+			}
+
+			Printf("  dir %q: cannot be loaded, replaced with an empty directory\n", path)
+			stats.dirs++
+			return saver.SaveTree(ctx, restic.NewTree())
+		},
+		AllowUnstableSerialization: true,
+	})
+
+	newTree, err := rewriter.RewriteTree(ctx, saver, "/", *sn.Tree)
+	if err != nil {
+		return err
+	}
+
+	if newTree.IsNull() {
+		Printf("  root tree cannot be loaded, the snapshot cannot be repaired\n")
+		stats.unrepairable++
+
+		if opts.Forget && !opts.DryRun {
+			return removeSnapshot(ctx, repo, sn)
+		}
+		return nil
+	}
+
+	if newTree.Equal(*sn.Tree) {
+		debug.Log("snapshot %v is not damaged", sn.ID())
+		return nil
+	}
+	stats.repaired++
+
+	if opts.DryRun {
+		Verbosef("would save repaired snapshot\n")
+		return nil
+	}
+
+	// make sure all new trees are stored before the snapshot references them
+	err = repo.Flush(ctx)
+	if err != nil {
+		return err
+	}
+
+	newSn := *sn
+	newSn.Tree = &newTree
+	newSn.Tags = append([]string{}, sn.Tags...)
+	newSn.AddTags([]string{repairedTag})
+	if newSn.Original == nil {
+		newSn.Original = sn.ID()
+	}
+
+	id, err := repo.SaveJSONUnpacked(ctx, restic.SnapshotFile, newSn)
+	if err != nil {
+		return err
+	}
+	Verbosef("saved repaired snapshot %v\n", id.Str())
+
+	if opts.Forget {
+		return removeSnapshot(ctx, repo, sn)
+	}
+	return nil
+}
+
+// treeIsLost returns true if the tree with the given ID failed to load with
+// err because it is missing from the index or cannot be decrypted.
+func treeIsLost(repo restic.Repository, id restic.ID, err error) bool {
+	if _, found := repo.LookupBlobSize(id, restic.TreeBlob); !found {
+		return true
+	}
+	return errors.Cause(err) == crypto.ErrUnauthenticated
+}
+
+func removeSnapshot(ctx context.Context, repo *repository.Repository, sn *restic.Snapshot) error {
+	h := restic.Handle{Type: restic.SnapshotFile, Name: sn.ID().String()}
+	if err := repo.Backend().Remove(ctx, h); err != nil {
+		return err
+	}
+	Verbosef("removed damaged snapshot %v\n", sn.ID().Str())
+	return nil
+}
+
+func runRepairSnapshots(opts RepairSnapshotsOptions, gopts GlobalOptions, args []string) error {
+	repo, err := OpenRepository(gopts)
+	if err != nil {
+		return err
+	}
+
+	if opts.Forget && !opts.DryRun {
+		if err = checkAppendOnly(gopts, repo); err != nil {
+			return err
+		}
+	}
+
+	if !gopts.NoLock {
+		var lock *restic.Lock
+		if opts.Forget && !opts.DryRun {
+			Verbosef("create exclusive lock for repository\n")
+			lock, err = lockRepoExclusive(gopts.ctx, repo)
+		} else {
+			lock, err = lockRepo(gopts.ctx, repo)
+		}
+		defer unlockRepo(lock)
+		if err != nil {
+			return err
+		}
+	}
+
+	ctx, cancel := context.WithCancel(gopts.ctx)
+	defer cancel()
+
+	if err = repo.LoadIndex(ctx); err != nil {
+		return err
+	}
+
+	var stats repairStats
+	for sn := range FindFilteredSnapshots(ctx, repo, opts.Hosts, opts.Tags, opts.Paths, args) {
+		Verbosef("checking snapshot %s\n", sn.String())
+		err := repairSnapshot(ctx, repo, sn, opts, &stats)
+		if err != nil {
+			return errors.Fatalf("unable to repair snapshot ID %q: %v", sn.ID().Str(), err)
+		}
+	}
+
+	if stats.repaired == 0 && stats.unrepairable == 0 {
+		Verbosef("no damaged snapshots found\n")
+		return nil
+	}
+
+	Printf("\n%d files were truncated and %d directories were replaced with empty ones\n", stats.files, stats.dirs)
+	if opts.DryRun {
+		Printf("would have repaired %d snapshots\n", stats.repaired)
+	} else {
+		Printf("repaired %d snapshots\n", stats.repaired)
+	}
+	if stats.unrepairable > 0 {
+		Printf("%d snapshots cannot be repaired\n", stats.unrepairable)
+	}
+
+	return nil
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/restic/restic/internal/crypto"
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/repository"
+	"github.com/restic/restic/internal/restic"
+	rtest "github.com/restic/restic/internal/test"
+)
+
+func TestTreeIsLost(t *testing.T) {
+	repo, cleanup := repository.TestRepository(t)
+	defer cleanup()
+
+	id, err := repo.SaveTree(context.TODO(), restic.NewTree())
+	rtest.OK(t, err)
+	rtest.OK(t, repo.Flush(context.TODO()))
+
+	var tests = []struct {
+		id   restic.ID
+		err  error
+		lost bool
+	}{
+		{restic.NewRandomID(), errors.New("id not found in repository"), true},
+		{id, errors.Wrap(crypto.ErrUnauthenticated, "decrypting blob failed"), true},
+		{id, errors.New("connection reset by peer"), false},
+		{id, context.DeadlineExceeded, false},
+	}
+
+	for i, test := range tests {
+		lost := treeIsLost(repo, test.id, test.err)
+		rtest.Assert(t, lost == test.lost, "test %d: expected lost %v, got %v", i, test.lost, lost)
+	}
+}
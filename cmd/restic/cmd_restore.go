@@ -8,12 +8,13 @@ import (
 	"github.com/restic/restic/internal/filter"
 	"github.com/restic/restic/internal/restic"
 	"github.com/restic/restic/internal/restorer"
+	"github.com/restic/restic/internal/ui/json"
 
 	"github.com/spf13/cobra"
 )
 
 var cmdRestore = &cobra.Command{
-	Use:   "restore [flags] snapshotID",
+	Use:   "restore [flags] snapshotID [snapshotID ...]",
 	Short: "Extract the data from a snapshot",
 	Long: `
 The "restore" command extracts the data from a snapshot from the repository to
@@ -22,6 +23,18 @@ a directory.
 The special snapshot "latest" can be used to restore the latest snapshot in the
 repository.
 
+Files which already exist in the target directory are overwritten by default.
+Use --overwrite to keep files which have not changed ("if-changed"), which are
+newer than the file in the snapshot ("if-newer"), or to keep all existing files
+("never"). With --delete, files in the target directory which are not contained
+in the snapshot are removed.
+
+With --merge, several snapshots are restored as a single tree. The snapshots
+are either given as arguments, or all snapshots matching --host, --tag and
+--path are merged. For each path, the version with the newest modification
+time is restored. Paths which differ between the snapshots are reported as
+conflicts.
+
 EXIT STATUS
 ===========
 
@@ -44,6 +57,10 @@ type RestoreOptions struct {
 	Paths              []string
 	Tags               restic.TagLists
 	Verify             bool
+	Overwrite          restorer.OverwriteBehavior
+	Delete             bool
+	Sparse             bool
+	Merge              bool
 }
 
 var restoreOptions RestoreOptions
@@ -62,6 +79,10 @@ func init() {
 	flags.Var(&restoreOptions.Tags, "tag", "only consider snapshots which include this `taglist` for snapshot ID \"latest\"")
 	flags.StringArrayVar(&restoreOptions.Paths, "path", nil, "only consider snapshots which include this (absolute) `path` for snapshot ID \"latest\"")
 	flags.BoolVar(&restoreOptions.Verify, "verify", false, "verify restored files content")
+	flags.Var(&restoreOptions.Overwrite, "overwrite", "overwrite `behavior`, one of (always|if-changed|if-newer|never)")
+	flags.BoolVar(&restoreOptions.Delete, "delete", false, "delete files from the target directory which are not contained in the snapshot")
+	flags.BoolVar(&restoreOptions.Merge, "merge", false, "restore the newest version of each file from all given snapshots, or all snapshots matching --host, --tag and --path")
+	flags.BoolVar(&restoreOptions.Sparse, "sparse", false, "restore files as sparse files, the holes of sparse files in the snapshot are not written")
 }
 
 func runRestore(opts RestoreOptions, gopts GlobalOptions, args []string) error {
@@ -78,6 +99,7 @@ func runRestore(opts RestoreOptions, gopts GlobalOptions, args []string) error {
 	}
 
 	switch {
+	case opts.Merge:
 	case len(args) == 0:
 		return errors.Fatal("no snapshot ID specified")
 	case len(args) > 1:
@@ -92,9 +114,7 @@ func runRestore(opts RestoreOptions, gopts GlobalOptions, args []string) error {
 		return errors.Fatal("exclude and include patterns are mutually exclusive")
 	}
 
-	snapshotIDString := args[0]
-
-	debug.Log("restore %v to %v", snapshotIDString, opts.Target)
+	debug.Log("restore %v to %v", args, opts.Target)
 
 	repo, err := OpenRepository(gopts)
 	if err != nil {
@@ -114,32 +134,68 @@ func runRestore(opts RestoreOptions, gopts GlobalOptions, args []string) error {
 		return err
 	}
 
-	var id restic.ID
-
-	if snapshotIDString == "latest" {
-		id, err = restic.FindLatestSnapshot(ctx, repo, opts.Paths, opts.Tags, opts.Hosts)
-		if err != nil {
-			Exitf(1, "latest snapshot for criteria not found: %v Paths:%v Hosts:%v", err, opts.Paths, opts.Hosts)
+	var res *restorer.Restorer
+	if opts.Merge {
+		var ids restic.IDs
+		for sn := range FindFilteredSnapshots(ctx, repo, opts.Hosts, opts.Tags, opts.Paths, args) {
+			ids = append(ids, *sn.ID())
+		}
+		if len(ids) == 0 {
+			return errors.Fatal("no snapshots to merge")
 		}
+
+		res, err = restorer.NewMergingRestorer(ctx, repo, ids)
 	} else {
-		id, err = restic.FindSnapshot(ctx, repo, snapshotIDString)
-		if err != nil {
-			Exitf(1, "invalid id %q: %v", snapshotIDString, err)
+		var id restic.ID
+		snapshotIDString := args[0]
+
+		if snapshotIDString == "latest" {
+			id, err = restic.FindLatestSnapshot(ctx, repo, opts.Paths, opts.Tags, opts.Hosts)
+			if err != nil {
+				Exitf(1, "latest snapshot for criteria not found: %v Paths:%v Hosts:%v", err, opts.Paths, opts.Hosts)
+			}
+		} else {
+			id, err = restic.FindSnapshot(ctx, repo, snapshotIDString)
+			if err != nil {
+				Exitf(1, "invalid id %q: %v", snapshotIDString, err)
+			}
 		}
-	}
 
-	res, err := restorer.NewRestorer(ctx, repo, id)
+		res, err = restorer.NewRestorer(ctx, repo, id)
+	}
 	if err != nil {
 		Exitf(2, "creating restorer failed: %v\n", err)
 	}
 
 	totalErrors := 0
 	res.Error = func(location string, err error) error {
-		Warnf("ignoring error for %s: %s\n", location, err)
+		if gopts.JSON {
+			printJSONError("restore", location, err)
+		} else {
+			Warnf("ignoring error for %s: %s\n", location, err)
+		}
 		totalErrors++
 		return nil
 	}
 
+	conflicts := 0
+	res.Conflict = func(c restorer.Conflict) {
+		conflicts++
+		if gopts.JSON {
+			others := make([]string, 0, len(c.Others))
+			for _, id := range c.Others {
+				others = append(others, id.String())
+			}
+			printJSON(json.TypeConflict, &json.RestoreConflict{
+				Path:       c.Location,
+				SnapshotID: c.Snapshot.String(),
+				Others:     others,
+			})
+		} else {
+			Verbosef("conflict: %s restored from snapshot %s, differs in %v\n", c.Location, c.Snapshot.Str(), c.Others)
+		}
+	}
+
 	selectExcludeFilter := func(item string, dstpath string, node *restic.Node) (selectedForRestore bool, childMayBeSelected bool) {
 		matched, _, err := filter.List(opts.Exclude, item)
 		if err != nil {
@@ -178,23 +234,57 @@ func runRestore(opts RestoreOptions, gopts GlobalOptions, args []string) error {
 		return selectedForRestore, childMayBeSelected
 	}
 
+	res.Overwrite = opts.Overwrite
+	res.Delete = opts.Delete
+	res.Sparse = opts.Sparse
+
 	if hasExcludes {
 		res.SelectFilter = selectExcludeFilter
 	} else if hasIncludes {
 		res.SelectFilter = selectIncludeFilter
 	}
 
-	Verbosef("restoring %s to %s\n", res.Snapshot(), opts.Target)
+	if opts.Merge {
+		Verbosef("restoring %d merged snapshots to %s\n", len(res.Snapshots()), opts.Target)
+		for _, sn := range res.Snapshots() {
+			Verbosef("  %s\n", sn)
+		}
+	} else {
+		Verbosef("restoring %s to %s\n", res.Snapshot(), opts.Target)
+	}
 
 	err = res.RestoreTo(ctx, opts.Target)
+	var verified int
 	if err == nil && opts.Verify {
 		Verbosef("verifying files in %s\n", opts.Target)
-		var count int
-		count, err = res.VerifyFiles(ctx, opts.Target)
-		Verbosef("finished verifying %d files in %s\n", count, opts.Target)
+		verified, err = res.VerifyFiles(ctx, opts.Target)
+		Verbosef("finished verifying %d files in %s\n", verified, opts.Target)
 	}
-	if totalErrors > 0 {
-		Printf("There were %d errors\n", totalErrors)
+
+	if gopts.JSON {
+		if err == nil {
+			summary := &json.RestoreSummary{
+				Target:        opts.Target,
+				TotalErrors:   totalErrors,
+				FilesVerified: verified,
+				Conflicts:     conflicts,
+			}
+			if opts.Merge {
+				for _, sn := range res.Snapshots() {
+					summary.SnapshotIDs = append(summary.SnapshotIDs, sn.ID().String())
+				}
+			} else {
+				summary.SnapshotID = res.Snapshot().ID().String()
+			}
+			printJSON(json.TypeSummary, summary)
+		}
+	} else {
+		if conflicts > 0 {
+			Printf("There were %d conflicts\n", conflicts)
+		}
+		if totalErrors > 0 {
+			Printf("There were %d errors\n", totalErrors)
+		}
 	}
 	return err
 }
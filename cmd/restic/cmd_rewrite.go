@@ -0,0 +1,230 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/spf13/cobra"
+
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/repository"
+	"github.com/restic/restic/internal/restic"
+	"github.com/restic/restic/internal/walker"
+)
+
+var cmdRewrite = &cobra.Command{
+	Use:   "rewrite [flags] [snapshot-ID ...]",
+	Short: "Rewrite snapshots to exclude unwanted files",
+	Long: `
+The "rewrite" command excludes files from existing snapshots. It creates new
+snapshots containing the same data as the original ones, but without the files
+matching the exclude patterns. The new snapshots reference the snapshot they
+were created from as their original snapshot.
+
+By default the original snapshots are kept. Pass --forget to remove them once
+the new snapshots have been saved. Please note that the data of the removed
+files is only removed from the repository by the "prune" command, after all
+snapshots referencing it have been removed.
+
+When no snapshot-ID is given, all snapshots matching the host, tag and path
+filter criteria are rewritten.
+
+EXIT STATUS
+===========
+
+Exit status is 0 if the command was successful, and non-zero if there was any error.
+`,
+	DisableAutoGenTag: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runRewrite(rewriteOptions, globalOptions, args)
+	},
+}
+
+// RewriteOptions collects all options for the rewrite command.
+type RewriteOptions struct {
+	Forget bool
+	DryRun bool
+
+	Hosts []string
+	Paths []string
+	Tags  restic.TagLists
+
+	Excludes                []string
+	InsensitiveExcludes     []string
+	ExcludeFiles            []string
+	InsensitiveExcludeFiles []string
+}
+
+var rewriteOptions RewriteOptions
+
+func init() {
+	cmdRoot.AddCommand(cmdRewrite)
+
+	f := cmdRewrite.Flags()
+	f.BoolVarP(&rewriteOptions.Forget, "forget", "", false, "remove the original snapshots after creating the new ones")
+	f.BoolVarP(&rewriteOptions.DryRun, "dry-run", "n", false, "do not do anything, just print what would be done")
+
+	f.StringArrayVarP(&rewriteOptions.Hosts, "host", "H", nil, "only consider snapshots for this `host`, when no snapshot ID is given (can be specified multiple times)")
+	f.Var(&rewriteOptions.Tags, "tag", "only consider snapshots which include this `taglist`, when no snapshot-ID is given")
+	f.StringArrayVar(&rewriteOptions.Paths, "path", nil, "only consider snapshots which include this (absolute) `path`, when no snapshot-ID is given")
+
+	f.StringArrayVarP(&rewriteOptions.Excludes, "exclude", "e", nil, "exclude a `pattern` (can be specified multiple times)")
+	f.StringArrayVar(&rewriteOptions.InsensitiveExcludes, "iexclude", nil, "same as --exclude `pattern` but ignores the casing of filenames")
+	f.StringArrayVar(&rewriteOptions.ExcludeFiles, "exclude-file", nil, "read exclude patterns from a `file` (can be specified multiple times)")
+	f.StringArrayVar(&rewriteOptions.InsensitiveExcludeFiles, "iexclude-file", nil, "same as --exclude-file but ignores casing of `file`names in patterns")
+}
+
+// dryRunTreeSaver computes the IDs of trees without saving them.
+type dryRunTreeSaver struct {
+	restic.Repository
+}
+
+// SaveTree returns the ID the tree would get when saved to the repository,
+// the encoding must match Repository.SaveTree.
+func (dryRunTreeSaver) SaveTree(ctx context.Context, t *restic.Tree) (restic.ID, error) {
+	buf, err := json.Marshal(t)
+	if err != nil {
+		return restic.ID{}, errors.Wrap(err, "MarshalJSON")
+	}
+	buf = append(buf, '\n')
+
+	return restic.Hash(buf), nil
+}
+
+func rewriteSnapshot(ctx context.Context, repo *repository.Repository, sn *restic.Snapshot, opts RewriteOptions, rejectFuncs []RejectByNameFunc) (bool, error) {
+	if sn.Tree == nil {
+		return false, errors.Errorf("snapshot %v has nil tree", sn.ID().Str())
+	}
+
+	rewriter := walker.NewTreeRewriter(walker.RewriteOpts{
+		RewriteNode: func(node *restic.Node, path string) *restic.Node {
+			for _, reject := range rejectFuncs {
+				if reject(path) {
+					Verbosef("excluding %s\n", path)
+					return nil
+				}
+			}
+			return node
+		},
+	})
+
+	var saver walker.TreeLoadSaver = repo
+	if opts.DryRun {
+		saver = dryRunTreeSaver{repo}
+	}
+
+	filteredTree, err := rewriter.RewriteTree(ctx, saver, "/", *sn.Tree)
+	if err != nil {
+		return false, err
+	}
+
+	if filteredTree.Equal(*sn.Tree) {
+		debug.Log("snapshot %v not modified", sn.ID())
+		return false, nil
+	}
+
+	if opts.DryRun {
+		Verbosef("would save new snapshot\n")
+		if opts.Forget {
+			Verbosef("would remove old snapshot\n")
+		}
+		return true, nil
+	}
+
+	// make sure all new trees are stored before the snapshot references them
+	err = repo.Flush(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	// retain the original snapshot id over all modifications
+	newSn := *sn
+	newSn.Tree = &filteredTree
+	if newSn.Original == nil {
+		newSn.Original = sn.ID()
+	}
+
+	id, err := repo.SaveJSONUnpacked(ctx, restic.SnapshotFile, newSn)
+	if err != nil {
+		return false, err
+	}
+	Verbosef("saved new snapshot %v\n", id.Str())
+
+	if opts.Forget {
+		h := restic.Handle{Type: restic.SnapshotFile, Name: sn.ID().String()}
+		if err = repo.Backend().Remove(ctx, h); err != nil {
+			return false, err
+		}
+		debug.Log("removed old snapshot %v", sn.ID())
+		Verbosef("removed old snapshot %v\n", sn.ID().Str())
+	}
+
+	return true, nil
+}
+
+func runRewrite(opts RewriteOptions, gopts GlobalOptions, args []string) error {
+	rejectFuncs, err := collectRejectByPatternFuncs(opts.Excludes, opts.InsensitiveExcludes,
+		opts.ExcludeFiles, opts.InsensitiveExcludeFiles)
+	if err != nil {
+		return err
+	}
+
+	if len(rejectFuncs) == 0 {
+		return errors.Fatal("nothing to do, no exclude patterns specified")
+	}
+
+	repo, err := OpenRepository(gopts)
+	if err != nil {
+		return err
+	}
+
+	if opts.Forget && !opts.DryRun {
+		if err = checkAppendOnly(gopts, repo); err != nil {
+			return err
+		}
+	}
+
+	if !gopts.NoLock {
+		var lock *restic.Lock
+		if opts.Forget && !opts.DryRun {
+			Verbosef("create exclusive lock for repository\n")
+			lock, err = lockRepoExclusive(gopts.ctx, repo)
+		} else {
+			lock, err = lockRepo(gopts.ctx, repo)
+		}
+		defer unlockRepo(lock)
+		if err != nil {
+			return err
+		}
+	}
+
+	ctx, cancel := context.WithCancel(gopts.ctx)
+	defer cancel()
+
+	if err = repo.LoadIndex(ctx); err != nil {
+		return err
+	}
+
+	changedCount := 0
+	for sn := range FindFilteredSnapshots(ctx, repo, opts.Hosts, opts.Tags, opts.Paths, args) {
+		Verbosef("checking snapshot %s\n", sn.String())
+		changed, err := rewriteSnapshot(ctx, repo, sn, opts, rejectFuncs)
+		if err != nil {
+			return errors.Fatalf("unable to rewrite snapshot ID %q: %v", sn.ID().Str(), err)
+		}
+		if changed {
+			changedCount++
+		}
+	}
+
+	if changedCount == 0 {
+		Verbosef("no snapshots were modified\n")
+	} else if opts.DryRun {
+		Verbosef("would have modified %d snapshots\n", changedCount)
+	} else {
+		Verbosef("modified %d snapshots\n", changedCount)
+	}
+
+	return nil
+}
@@ -9,6 +9,7 @@ import (
 	"github.com/restic/restic/internal/errors"
 	"github.com/restic/restic/internal/repository"
 	"github.com/restic/restic/internal/restic"
+	"github.com/restic/restic/internal/ui/json"
 )
 
 var cmdTag = &cobra.Command{
@@ -58,7 +59,9 @@ func init() {
 	tagFlags.StringArrayVar(&tagOptions.Paths, "path", nil, "only consider snapshots which include this (absolute) `path`, when no snapshot-ID is given")
 }
 
-func changeTags(ctx context.Context, repo *repository.Repository, sn *restic.Snapshot, setTags, addTags, removeTags []string) (bool, error) {
+// changeTags modifies the tags of sn and saves it as a new snapshot, the ID of
+// which is returned. If the tags are unchanged, the returned ID is null.
+func changeTags(ctx context.Context, repo *repository.Repository, sn *restic.Snapshot, setTags, addTags, removeTags []string) (restic.ID, error) {
 	var changed bool
 
 	if len(setTags) != 0 {
@@ -84,24 +87,25 @@ func changeTags(ctx context.Context, repo *repository.Repository, sn *restic.Sna
 		// Save the new snapshot.
 		id, err := repo.SaveJSONUnpacked(ctx, restic.SnapshotFile, sn)
 		if err != nil {
-			return false, err
+			return restic.ID{}, err
 		}
 
 		debug.Log("new snapshot saved as %v", id)
 
 		if err = repo.Flush(ctx); err != nil {
-			return false, err
+			return restic.ID{}, err
 		}
 
 		// Remove the old snapshot.
 		h := restic.Handle{Type: restic.SnapshotFile, Name: sn.ID().String()}
 		if err = repo.Backend().Remove(ctx, h); err != nil {
-			return false, err
+			return restic.ID{}, err
 		}
 
 		debug.Log("old snapshot %v removed", sn.ID())
+		return id, nil
 	}
-	return changed, nil
+	return restic.ID{}, nil
 }
 
 func runTag(opts TagOptions, gopts GlobalOptions, args []string) error {
@@ -117,6 +121,10 @@ func runTag(opts TagOptions, gopts GlobalOptions, args []string) error {
 		return err
 	}
 
+	if err = checkAppendOnly(gopts, repo); err != nil {
+		return err
+	}
+
 	if !gopts.NoLock {
 		Verbosef("create exclusive lock for repository\n")
 		lock, err := lockRepoExclusive(gopts.ctx, repo)
@@ -130,16 +138,30 @@ func runTag(opts TagOptions, gopts GlobalOptions, args []string) error {
 	ctx, cancel := context.WithCancel(gopts.ctx)
 	defer cancel()
 	for sn := range FindFilteredSnapshots(ctx, repo, opts.Hosts, opts.Tags, opts.Paths, args) {
-		changed, err := changeTags(ctx, repo, sn, opts.SetTags, opts.AddTags, opts.RemoveTags)
+		newID, err := changeTags(ctx, repo, sn, opts.SetTags, opts.AddTags, opts.RemoveTags)
 		if err != nil {
-			Warnf("unable to modify the tags for snapshot ID %q, ignoring: %v\n", sn.ID(), err)
+			if gopts.JSON {
+				printJSONError("modify tags", sn.ID().String(), err)
+			} else {
+				Warnf("unable to modify the tags for snapshot ID %q, ignoring: %v\n", sn.ID(), err)
+			}
 			continue
 		}
-		if changed {
+		if !newID.IsNull() {
 			changeCnt++
+			if gopts.JSON {
+				printJSON(json.TypeVerboseStatus, &json.SnapshotAction{
+					Action:     "modified",
+					SnapshotID: sn.ID().String(),
+					NewID:      newID.String(),
+				})
+			}
 		}
 	}
-	if changeCnt == 0 {
+
+	if gopts.JSON {
+		printJSON(json.TypeSummary, &json.TagSummary{ChangedSnapshots: changeCnt})
+	} else if changeCnt == 0 {
 		Verbosef("no snapshots were modified\n")
 	} else {
 		Verbosef("modified tags on %v snapshots\n", changeCnt)
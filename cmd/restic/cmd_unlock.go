@@ -41,6 +41,10 @@ func runUnlock(opts UnlockOptions, gopts GlobalOptions) error {
 		return err
 	}
 
+	if err = checkAppendOnly(gopts, repo); err != nil {
+		return err
+	}
+
 	fn := restic.RemoveStaleLocks
 	if opts.RemoveAll {
 		fn = restic.RemoveAllLocks
@@ -32,7 +32,7 @@ func deleteFiles(gopts GlobalOptions, ignoreError bool, repo restic.Repository,
 		close(fileChan)
 	}()
 
-	bar := newProgressMax(!gopts.JSON && !gopts.Quiet, uint64(totalCount), "files deleted")
+	bar := newProgressMax(!gopts.Quiet, uint64(totalCount), "files deleted")
 	wg, ctx := errgroup.WithContext(gopts.ctx)
 	bar.Start()
 	for i := 0; i < numDeleteWorkers; i++ {
@@ -41,7 +41,9 @@ func deleteFiles(gopts GlobalOptions, ignoreError bool, repo restic.Repository,
 				h := restic.Handle{Type: fileType, Name: id.String()}
 				err := repo.Backend().Remove(ctx, h)
 				if err != nil {
-					if !gopts.JSON {
+					if gopts.JSON {
+						printJSONError("delete "+string(fileType), id.String(), err)
+					} else {
 						Warnf("unable to remove %v from the repository\n", h)
 					}
 					if !ignoreError {
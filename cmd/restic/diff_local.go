@@ -0,0 +1,449 @@
+package main
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/restic/restic/internal/archiver"
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/fs"
+	"github.com/restic/restic/internal/restic"
+	"github.com/restic/restic/internal/ui/json"
+)
+
+// toSlashPath converts the absolute local path p to the path used in
+// snapshots, i.e. with slashes as separator. Volume names are stored as the
+// first directory without the colon, like the archiver does.
+func toSlashPath(local fs.FS, p string) string {
+	volume := local.VolumeName(p)
+	rest := p[len(volume):]
+	volume = strings.TrimSuffix(volume, ":")
+
+	return path.Join("/", volume, filepath.ToSlash(rest))
+}
+
+// snapshotPaths returns the paths in the snapshot sn at which the local file
+// target may be stored. Targets passed to backup as absolute paths are stored
+// at their absolute path, relative targets below the root directory.
+func snapshotPaths(local fs.FS, sn *restic.Snapshot, target string) ([]string, error) {
+	abs, err := local.Abs(target)
+	if err != nil {
+		return nil, err
+	}
+
+	paths := []string{toSlashPath(local, abs)}
+	for _, p := range sn.Paths {
+		if !fs.HasPathPrefix(p, abs) {
+			continue
+		}
+
+		rel, err := filepath.Rel(p, abs)
+		if err != nil {
+			return nil, err
+		}
+		paths = append(paths, path.Join("/", local.Base(p), filepath.ToSlash(rel)))
+	}
+
+	return paths, nil
+}
+
+// findNode returns the node at the path p in the tree id. For the root
+// directory, nil is returned. If the path does not exist, found is false.
+func findNode(ctx context.Context, repo restic.Repository, id restic.ID, p string) (node *restic.Node, found bool, err error) {
+	if p == "/" {
+		return nil, true, nil
+	}
+
+	for _, name := range splitPath(p) {
+		if node != nil {
+			if node.Type != "dir" || node.Subtree == nil {
+				return nil, false, nil
+			}
+			id = *node.Subtree
+		}
+
+		tree, err := repo.LoadTree(ctx, id)
+		if err != nil {
+			return nil, false, err
+		}
+
+		node = tree.Find(name)
+		if node == nil {
+			return nil, false, nil
+		}
+	}
+
+	return node, true, nil
+}
+
+// localNode returns the node for the file at target together with its file
+// info.
+func (c *Comparer) localNode(target string) (*restic.Node, os.FileInfo, error) {
+	fi, err := c.local.Lstat(target)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	node, err := restic.NodeFromFileInfo(target, fi)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return node, fi, nil
+}
+
+// readLocalDir returns the sorted names of the entries in the directory dir.
+func (c *Comparer) readLocalDir(dir string) ([]string, error) {
+	f, err := c.local.Open(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	names, err := f.Readdirnames(-1)
+	if err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+
+	sort.Strings(names)
+	return names, f.Close()
+}
+
+// chunkLocalFile splits the file at target into chunks like the archiver
+// does and returns their IDs.
+func (c *Comparer) chunkLocalFile(target string) (restic.IDs, error) {
+	f, err := c.local.Open(target)
+	if err != nil {
+		return nil, err
+	}
+
+	var ids restic.IDs
+	cfg := c.repo.Config()
+	chnker := cfg.ChunkerParams().NewChunker(f, cfg.ChunkerPolynomial)
+	buf := make([]byte, cfg.ChunkerParams().MinSize)
+	for {
+		chunk, err := chnker.Next(buf)
+		if errors.Cause(err) == io.EOF {
+			break
+		}
+		if err != nil {
+			_ = f.Close()
+			return nil, err
+		}
+
+		buf = chunk.Data
+		ids = append(ids, restic.Hash(chunk.Data))
+	}
+
+	return ids, f.Close()
+}
+
+// localFileChanged returns true if the content of the file at target differs
+// from node. Without --content, the file is considered unchanged if its
+// metadata matches the node, like during backup.
+func (c *Comparer) localFileChanged(node *restic.Node, target string, fi os.FileInfo) (bool, error) {
+	if !c.opts.Content {
+		return archiver.FileChanged(fi, node, c.opts.IgnoreInode), nil
+	}
+
+	if uint64(fi.Size()) != node.Size {
+		return true, nil
+	}
+
+	ids, err := c.chunkLocalFile(target)
+	if err != nil {
+		return false, err
+	}
+
+	if len(ids) != len(node.Content) {
+		return true, nil
+	}
+	for i, id := range ids {
+		if !id.Equal(node.Content[i]) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// sameLocalMetadata returns true if the metadata of the local node matches
+// the node in the snapshot. The content is compared separately.
+func (c *Comparer) sameLocalMetadata(node, local *restic.Node) bool {
+	other := *local
+	other.Name = node.Name
+	other.Content = node.Content
+	other.Subtree = node.Subtree
+	if node.AccessTime.Equal(node.ModTime) {
+		// the access time is only saved with --with-atime
+		other.AccessTime = other.ModTime
+	}
+	if c.opts.IgnoreInode {
+		other.Inode = node.Inode
+		other.ChangeTime = node.ChangeTime
+	}
+	return node.Equals(other)
+}
+
+// diffLocalContent compares the content of the modified file node with the
+// local file at target.
+func (c *Comparer) diffLocalContent(ctx context.Context, node *restic.Node, target string, fi os.FileInfo) (*json.DiffPatch, error) {
+	if node.Size > c.maxSize || uint64(fi.Size()) > c.maxSize {
+		return &json.DiffPatch{TooLarge: true, OldSize: node.Size, NewSize: uint64(fi.Size())}, nil
+	}
+
+	old, err := c.loadFile(ctx, node)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := c.local.Open(target)
+	if err != nil {
+		return nil, err
+	}
+	new, err := ioutil.ReadAll(f)
+	if err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+	if err = f.Close(); err != nil {
+		return nil, err
+	}
+
+	return newPatch(old, new), nil
+}
+
+// printLocalDir prints all entries below the local directory dir as added.
+func (c *Comparer) printLocalDir(stats *DiffStat, prefix, dir string) error {
+	names, err := c.readLocalDir(dir)
+	if err != nil {
+		return err
+	}
+
+	for _, name := range names {
+		target := c.local.Join(dir, name)
+		node, _, err := c.localNode(target)
+		if err != nil {
+			c.printError(err)
+			continue
+		}
+
+		p := path.Join(prefix, name)
+		if node.Type == "dir" {
+			p += "/"
+		}
+		c.printChange("+", p)
+		stats.Add(node)
+
+		if node.Type == "dir" {
+			err := c.printLocalDir(stats, p, target)
+			if err != nil {
+				c.printError(err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// diffLocalNode compares the node in the snapshot with the local file at
+// target, which is printed as name.
+func (c *Comparer) diffLocalNode(ctx context.Context, stats *DiffStats, name string, node *restic.Node, target string) error {
+	local, fi, err := c.localNode(target)
+	if err != nil {
+		return err
+	}
+
+	mod := ""
+	if node.Type != local.Type {
+		mod += "T"
+	}
+
+	if local.Type == "dir" {
+		name += "/"
+	}
+
+	changed := false
+	if node.Type == "file" && local.Type == "file" {
+		changed, err = c.localFileChanged(node, target, fi)
+		if err != nil {
+			return err
+		}
+	}
+
+	if changed {
+		mod += "M"
+		stats.ChangedFiles++
+	} else if c.opts.ShowMetadata && !c.sameLocalMetadata(node, local) {
+		mod += "U"
+	}
+
+	switch {
+	case mod == "M" && c.opts.Patch:
+		patch, err := c.diffLocalContent(ctx, node, target, fi)
+		if err != nil {
+			c.printError(err)
+			c.printChange(mod, name)
+			break
+		}
+		c.printPatch(mod, name, patch)
+	case mod != "":
+		c.printChange(mod, name)
+	}
+
+	if node.Type == "dir" && local.Type == "dir" {
+		return c.diffLocalTree(ctx, stats, name, *node.Subtree, target)
+	}
+	return nil
+}
+
+// diffLocalTree compares the tree id with the local directory dir.
+func (c *Comparer) diffLocalTree(ctx context.Context, stats *DiffStats, prefix string, id restic.ID, dir string) error {
+	debug.Log("diffing %v to local directory %v", id, dir)
+	tree, err := c.repo.LoadTree(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	localNames, err := c.readLocalDir(dir)
+	if err != nil {
+		return err
+	}
+
+	nodes := make(map[string]*restic.Node, len(tree.Nodes))
+	names := make(map[string]struct{}, len(tree.Nodes)+len(localNames))
+	for _, node := range tree.Nodes {
+		nodes[node.Name] = node
+		names[node.Name] = struct{}{}
+	}
+
+	local := make(map[string]struct{}, len(localNames))
+	for _, name := range localNames {
+		local[name] = struct{}{}
+		names[name] = struct{}{}
+	}
+
+	uniqueNames := make([]string, 0, len(names))
+	for name := range names {
+		uniqueNames = append(uniqueNames, name)
+	}
+	sort.Strings(uniqueNames)
+
+	for _, name := range uniqueNames {
+		node, t1 := nodes[name]
+		_, t2 := local[name]
+		target := c.local.Join(dir, name)
+
+		addBlobs(stats.BlobsBefore, node)
+
+		switch {
+		case t1 && t2:
+			err := c.diffLocalNode(ctx, stats, path.Join(prefix, name), node, target)
+			if err != nil {
+				c.printError(err)
+			}
+		case t1 && !t2:
+			prefix := path.Join(prefix, name)
+			if node.Type == "dir" {
+				prefix += "/"
+			}
+			c.printChange("-", prefix)
+			stats.Removed.Add(node)
+
+			if node.Type == "dir" {
+				err := c.printDir(ctx, "-", &stats.Removed, stats.BlobsBefore, prefix, *node.Subtree)
+				if err != nil {
+					c.printError(err)
+				}
+			}
+		case !t1 && t2:
+			localNode, _, err := c.localNode(target)
+			if err != nil {
+				c.printError(err)
+				continue
+			}
+
+			prefix := path.Join(prefix, name)
+			if localNode.Type == "dir" {
+				prefix += "/"
+			}
+			c.printChange("+", prefix)
+			stats.Added.Add(localNode)
+
+			if localNode.Type == "dir" {
+				err := c.printLocalDir(&stats.Added, prefix, target)
+				if err != nil {
+					c.printError(err)
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// diffLocal compares the snapshot sn with the local file or directory target
+// and prints the differences and a summary.
+func (c *Comparer) diffLocal(ctx context.Context, sn *restic.Snapshot, target string) error {
+	if sn.Tree == nil {
+		return errors.Errorf("snapshot %v has nil tree", sn.ID().Str())
+	}
+
+	paths, err := snapshotPaths(c.local, sn, target)
+	if err != nil {
+		return err
+	}
+
+	Verbosef("comparing snapshot %v to local path %v:\n\n", sn.ID().Str(), target)
+
+	var node *restic.Node
+	var p string
+	found := false
+	for _, p = range paths {
+		node, found, err = findNode(ctx, c.repo, *sn.Tree, p)
+		if err != nil {
+			return err
+		}
+		if found {
+			break
+		}
+	}
+	if !found {
+		return errors.Fatalf("path %v not found in snapshot %v", paths[0], sn.ID().Str())
+	}
+	debug.Log("comparing %v to local path %v", p, target)
+
+	stats := NewDiffStats()
+	if node == nil {
+		err = c.diffLocalTree(ctx, stats, "/", *sn.Tree, target)
+	} else {
+		err = c.diffLocalNode(ctx, stats, p, node, target)
+	}
+	if err != nil {
+		return err
+	}
+
+	if c.json {
+		printJSON(json.TypeSummary, &json.DiffSummary{
+			SourceSnapshot: sn.ID().String(),
+			TargetPath:     target,
+			ChangedFiles:   stats.ChangedFiles,
+			Added:          json.DiffStat(stats.Added),
+			Removed:        json.DiffStat(stats.Removed),
+		})
+		return nil
+	}
+
+	Printf("\n")
+	Printf("Files:       %5d new, %5d removed, %5d changed\n", stats.Added.Files, stats.Removed.Files, stats.ChangedFiles)
+	Printf("Dirs:        %5d new, %5d removed\n", stats.Added.Dirs, stats.Removed.Dirs)
+	Printf("Others:      %5d new, %5d removed\n", stats.Added.Others, stats.Removed.Others)
+
+	return nil
+}
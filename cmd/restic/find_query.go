@@ -0,0 +1,453 @@
+package main
+
+import (
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/filter"
+	"github.com/restic/restic/internal/restic"
+)
+
+// findQuery reports whether the node at path matches a query.
+type findQuery func(path string, node *restic.Node) bool
+
+// queryToken is a word, an operator or a parenthesis in a query.
+type queryToken struct {
+	text   string
+	quoted bool
+	pos    int
+}
+
+const queryOperatorChars = "=!<>~&"
+
+// tokenizeQuery splits the query s into tokens. Values which contain spaces,
+// parentheses or operator characters can be quoted with single or double
+// quotes.
+func tokenizeQuery(s string) ([]queryToken, error) {
+	var tokens []queryToken
+	for i := 0; i < len(s); {
+		c, size := utf8.DecodeRuneInString(s[i:])
+		switch {
+		case unicode.IsSpace(c):
+			i += size
+		case c == '(' || c == ')':
+			tokens = append(tokens, queryToken{text: s[i : i+1], pos: i})
+			i++
+		case c == '"' || c == '\'':
+			end := strings.IndexByte(s[i+1:], s[i])
+			if end < 0 {
+				return nil, errors.Errorf("unterminated quote at position %d", i)
+			}
+			tokens = append(tokens, queryToken{text: s[i+1 : i+1+end], quoted: true, pos: i})
+			i += end + 2
+		case strings.ContainsRune(queryOperatorChars, c):
+			// all operator characters are ASCII
+			start := i
+			for i < len(s) && strings.IndexByte(queryOperatorChars, s[i]) >= 0 {
+				i++
+			}
+			tokens = append(tokens, queryToken{text: s[start:i], pos: start})
+		default:
+			start := i
+			for i < len(s) {
+				c, size := utf8.DecodeRuneInString(s[i:])
+				if unicode.IsSpace(c) || strings.ContainsRune(queryOperatorChars+"()\"'", c) {
+					break
+				}
+				i += size
+			}
+			tokens = append(tokens, queryToken{text: s[start:i], pos: start})
+		}
+	}
+	return tokens, nil
+}
+
+// queryParser is a recursive descent parser for queries:
+//
+//	query      = and { "or" and }
+//	and        = not { "and" not }
+//	not        = "not" not | "(" query ")" | comparison
+//	comparison = field operator value
+type queryParser struct {
+	tokens   []queryToken
+	pos      int
+	usesPath bool
+}
+
+func (p *queryParser) peek() (queryToken, bool) {
+	if p.pos >= len(p.tokens) {
+		return queryToken{}, false
+	}
+	return p.tokens[p.pos], true
+}
+
+// keyword returns true and consumes the next token if it is the keyword kw.
+func (p *queryParser) keyword(kw string) bool {
+	t, ok := p.peek()
+	if !ok || t.quoted || !strings.EqualFold(t.text, kw) {
+		return false
+	}
+	p.pos++
+	return true
+}
+
+func (p *queryParser) next(what string) (queryToken, error) {
+	t, ok := p.peek()
+	if !ok {
+		return queryToken{}, errors.Errorf("expected %v at end of query", what)
+	}
+	p.pos++
+	return t, nil
+}
+
+func (p *queryParser) parseOr() (findQuery, error) {
+	q, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.keyword("or") {
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left := q
+		q = func(path string, node *restic.Node) bool {
+			return left(path, node) || right(path, node)
+		}
+	}
+	return q, nil
+}
+
+func (p *queryParser) parseAnd() (findQuery, error) {
+	q, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.keyword("and") {
+		right, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		left := q
+		q = func(path string, node *restic.Node) bool {
+			return left(path, node) && right(path, node)
+		}
+	}
+	return q, nil
+}
+
+func (p *queryParser) parseNot() (findQuery, error) {
+	if p.keyword("not") {
+		q, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return func(path string, node *restic.Node) bool {
+			return !q(path, node)
+		}, nil
+	}
+
+	t, ok := p.peek()
+	if ok && !t.quoted && t.text == "(" {
+		p.pos++
+		q, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		t, err := p.next(`")"`)
+		if err != nil {
+			return nil, err
+		}
+		if t.quoted || t.text != ")" {
+			return nil, errors.Errorf(`expected ")" at position %d, found %q`, t.pos, t.text)
+		}
+		return q, nil
+	}
+
+	return p.parseComparison()
+}
+
+func (p *queryParser) parseComparison() (findQuery, error) {
+	field, err := p.next("field name")
+	if err != nil {
+		return nil, err
+	}
+	if field.quoted || field.text == "(" || field.text == ")" || strings.ContainsRune(queryOperatorChars, rune(field.text[0])) {
+		return nil, errors.Errorf("expected field name at position %d, found %q", field.pos, field.text)
+	}
+
+	op, err := p.next("operator")
+	if err != nil {
+		return nil, err
+	}
+	if op.quoted || !strings.ContainsRune(queryOperatorChars, rune(op.text[0])) {
+		return nil, errors.Errorf("expected operator after %q at position %d, found %q", field.text, op.pos, op.text)
+	}
+
+	value, err := p.next("value")
+	if err != nil {
+		return nil, err
+	}
+	if !value.quoted && (value.text == "(" || value.text == ")") {
+		return nil, errors.Errorf("expected value at position %d, found %q", value.pos, value.text)
+	}
+
+	q, err := p.comparison(strings.ToLower(field.text), op.text, value.text)
+	if err != nil {
+		return nil, errors.Errorf("invalid condition %v%v%v at position %d: %v", field.text, op.text, value.text, field.pos, err)
+	}
+	return q, nil
+}
+
+// comparison returns the query for a single condition.
+func (p *queryParser) comparison(field, op, value string) (findQuery, error) {
+	if value == "" {
+		return nil, errors.New("value is empty")
+	}
+
+	switch field {
+	case "name":
+		return stringQuery(op, value, func(_ string, node *restic.Node) string { return node.Name })
+	case "path":
+		p.usesPath = true
+		return stringQuery(op, value, func(path string, _ *restic.Node) string { return path })
+	case "type":
+		switch value {
+		case "file", "dir", "symlink", "dev", "chardev", "fifo", "socket":
+		default:
+			return nil, errors.Errorf("unknown type %q", value)
+		}
+		if op != "=" && op != "!=" {
+			return nil, errors.Errorf("operator %q is not supported, use = or !=", op)
+		}
+		return stringQuery(op, value, func(_ string, node *restic.Node) string { return node.Type })
+	case "user":
+		return stringQuery(op, value, func(_ string, node *restic.Node) string { return node.User })
+	case "group":
+		return stringQuery(op, value, func(_ string, node *restic.Node) string { return node.Group })
+	case "target":
+		return stringQuery(op, value, func(_ string, node *restic.Node) string { return node.LinkTarget })
+	case "size":
+		size, err := parseSizeStr(value)
+		if err != nil {
+			return nil, err
+		}
+		return numberQuery(op, uint64(size), func(node *restic.Node) uint64 { return node.Size })
+	case "uid", "gid", "links", "inode":
+		n, err := strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		fields := map[string]func(*restic.Node) uint64{
+			"uid":   func(node *restic.Node) uint64 { return uint64(node.UID) },
+			"gid":   func(node *restic.Node) uint64 { return uint64(node.GID) },
+			"links": func(node *restic.Node) uint64 { return node.Links },
+			"inode": func(node *restic.Node) uint64 { return node.Inode },
+		}
+		return numberQuery(op, n, fields[field])
+	case "mode":
+		return modeQuery(op, value)
+	case "mtime", "ctime", "atime":
+		t, err := parseTime(value)
+		if err != nil {
+			return nil, err
+		}
+		fields := map[string]func(*restic.Node) time.Time{
+			"mtime": func(node *restic.Node) time.Time { return node.ModTime },
+			"ctime": func(node *restic.Node) time.Time { return node.ChangeTime },
+			"atime": func(node *restic.Node) time.Time { return node.AccessTime },
+		}
+		return timeQuery(op, t, fields[field])
+	case "xattr":
+		return xattrQuery(op, value)
+	}
+
+	return nil, errors.Errorf("unknown field %q", field)
+}
+
+// stringQuery compares a string field of a node. The operators = and != match
+// glob patterns, ~ and !~ match regular expressions.
+func stringQuery(op, value string, field func(string, *restic.Node) string) (findQuery, error) {
+	switch op {
+	case "=", "!=":
+		// check that the pattern is valid
+		if _, err := filter.Match(value, "x"); err != nil {
+			return nil, err
+		}
+		want := op == "="
+		return func(path string, node *restic.Node) bool {
+			match, _ := filter.Match(value, field(path, node))
+			return match == want
+		}, nil
+	case "~", "!~":
+		re, err := regexp.Compile(value)
+		if err != nil {
+			return nil, err
+		}
+		want := op == "~"
+		return func(path string, node *restic.Node) bool {
+			return re.MatchString(field(path, node)) == want
+		}, nil
+	}
+	return nil, errors.Errorf("operator %q is not supported, use =, !=, ~ or !~", op)
+}
+
+// compareOp returns the function which checks the result of a comparison for
+// the operator op.
+func compareOp(op string) (func(cmp int) bool, error) {
+	switch op {
+	case "=":
+		return func(cmp int) bool { return cmp == 0 }, nil
+	case "!=":
+		return func(cmp int) bool { return cmp != 0 }, nil
+	case "<":
+		return func(cmp int) bool { return cmp < 0 }, nil
+	case "<=":
+		return func(cmp int) bool { return cmp <= 0 }, nil
+	case ">":
+		return func(cmp int) bool { return cmp > 0 }, nil
+	case ">=":
+		return func(cmp int) bool { return cmp >= 0 }, nil
+	}
+	return nil, errors.Errorf("operator %q is not supported, use =, !=, <, <=, > or >=", op)
+}
+
+func numberQuery(op string, value uint64, field func(*restic.Node) uint64) (findQuery, error) {
+	check, err := compareOp(op)
+	if err != nil {
+		return nil, err
+	}
+	return func(_ string, node *restic.Node) bool {
+		n := field(node)
+		switch {
+		case n < value:
+			return check(-1)
+		case n > value:
+			return check(1)
+		}
+		return check(0)
+	}, nil
+}
+
+func timeQuery(op string, value time.Time, field func(*restic.Node) time.Time) (findQuery, error) {
+	check, err := compareOp(op)
+	if err != nil {
+		return nil, err
+	}
+	return func(_ string, node *restic.Node) bool {
+		t := field(node)
+		switch {
+		case t.Before(value):
+			return check(-1)
+		case t.After(value):
+			return check(1)
+		}
+		return check(0)
+	}, nil
+}
+
+// modeBits contains the bits of a mode which can be compared in a query, in
+// the notation of chmod.
+func modeBits(node *restic.Node) uint32 {
+	mode := uint32(node.Mode.Perm())
+	if node.Mode&os.ModeSetuid != 0 {
+		mode |= 04000
+	}
+	if node.Mode&os.ModeSetgid != 0 {
+		mode |= 02000
+	}
+	if node.Mode&os.ModeSticky != 0 {
+		mode |= 01000
+	}
+	return mode
+}
+
+// modeQuery compares the permission bits with an octal value. The operator &
+// matches if all of the given bits are set.
+func modeQuery(op, value string) (findQuery, error) {
+	n, err := strconv.ParseUint(value, 8, 32)
+	if err != nil {
+		return nil, err
+	}
+	if n > 07777 {
+		return nil, errors.New("mode is out of range")
+	}
+	bits := uint32(n)
+
+	switch op {
+	case "=":
+		return func(_ string, node *restic.Node) bool { return modeBits(node) == bits }, nil
+	case "!=":
+		return func(_ string, node *restic.Node) bool { return modeBits(node) != bits }, nil
+	case "&":
+		return func(_ string, node *restic.Node) bool { return modeBits(node)&bits == bits }, nil
+	}
+	return nil, errors.Errorf("operator %q is not supported, use =, != or &", op)
+}
+
+// xattrQuery checks whether the node has an extended attribute with a name
+// matching the glob pattern (= and !=) or regular expression (~ and !~).
+func xattrQuery(op, value string) (findQuery, error) {
+	match := func(string) bool { return false }
+	want := true
+	switch op {
+	case "=", "!=":
+		if _, err := filter.Match(value, "x"); err != nil {
+			return nil, err
+		}
+		match = func(name string) bool {
+			ok, _ := filter.Match(value, name)
+			return ok
+		}
+		want = op == "="
+	case "~", "!~":
+		re, err := regexp.Compile(value)
+		if err != nil {
+			return nil, err
+		}
+		match = re.MatchString
+		want = op == "~"
+	default:
+		return nil, errors.Errorf("operator %q is not supported, use =, !=, ~ or !~", op)
+	}
+
+	return func(_ string, node *restic.Node) bool {
+		for _, attr := range node.ExtendedAttributes {
+			if match(attr.Name) {
+				return want
+			}
+		}
+		return !want
+	}, nil
+}
+
+// parseFindQuery parses a query for the find command. usesPath is set if the
+// result depends on the path of a node, not only on the node itself.
+func parseFindQuery(s string) (q findQuery, usesPath bool, err error) {
+	tokens, err := tokenizeQuery(s)
+	if err != nil {
+		return nil, false, errors.Fatalf("invalid query: %v", err)
+	}
+	if len(tokens) == 0 {
+		return nil, false, errors.Fatal("invalid query: query is empty")
+	}
+
+	p := &queryParser{tokens: tokens}
+	q, err = p.parseOr()
+	if err != nil {
+		return nil, false, errors.Fatalf("invalid query: %v", err)
+	}
+	if t, ok := p.peek(); ok {
+		return nil, false, errors.Fatalf("invalid query: unexpected %q at position %d", t.text, t.pos)
+	}
+
+	return q, p.usesPath, nil
+}
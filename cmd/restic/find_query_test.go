@@ -0,0 +1,123 @@
+package main
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/restic/restic/internal/restic"
+	rtest "github.com/restic/restic/internal/test"
+)
+
+func TestParseFindQuery(t *testing.T) {
+	mtime := time.Date(2023, 6, 1, 12, 0, 0, 0, time.Local)
+	file := &restic.Node{
+		Name:    "data.db",
+		Type:    "file",
+		Mode:    0640 | os.ModeSetgid,
+		ModTime: mtime,
+		UID:     1000,
+		User:    "postgres",
+		Group:   "postgres",
+		Size:    2 << 30,
+		ExtendedAttributes: []restic.ExtendedAttribute{
+			{Name: "user.checksum", Value: []byte("abc")},
+		},
+	}
+	dir := &restic.Node{
+		Name:    "etc",
+		Type:    "dir",
+		Mode:    os.ModeDir | 0755,
+		ModTime: mtime.AddDate(1, 0, 0),
+		User:    "root",
+	}
+
+	var tests = []struct {
+		query     string
+		file, dir bool
+		usesPath  bool
+	}{
+		{query: "type=file", file: true},
+		{query: "type != file", dir: true},
+		{query: "size>1G", file: true},
+		{query: "size>=2g and size<=2G", file: true},
+		{query: "size<1k", dir: true},
+		{query: "mtime<2024-01-01", file: true},
+		{query: `mtime>"2023-06-01 12:00"`, dir: true},
+		{query: "size>1G and mtime<2024-01-01 and user=postgres", file: true},
+		{query: "user=postgres or user=root", file: true, dir: true},
+		{query: "user~^post", file: true},
+		{query: "user!~^post", dir: true},
+		{query: "uid=1000", file: true},
+		{query: "gid!=0"},
+		{query: "mode=0755", dir: true},
+		{query: "mode&2000", file: true},
+		{query: "mode&0004", file: false, dir: true},
+		{query: "xattr=user.*", file: true},
+		{query: "xattr!=user.*", dir: true},
+		{query: "xattr~checksum", file: true},
+		{query: "name=*.db", file: true},
+		{query: "NOT name=*.db", dir: true},
+		{query: "not (type=dir or size<1M)", file: true},
+		{query: "type=dir and (user=root or size>1G)", dir: true},
+		{query: "type=file or type=dir and user=nobody", file: true},
+		{query: "path=/srv/**", file: true, usesPath: true},
+		{query: `path~"^/(etc|srv)$"`, dir: true, usesPath: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.query, func(t *testing.T) {
+			q, usesPath, err := parseFindQuery(test.query)
+			rtest.OK(t, err)
+			rtest.Equals(t, test.usesPath, usesPath)
+			rtest.Equals(t, test.file, q("/srv/db/data.db", file))
+			rtest.Equals(t, test.dir, q("/etc", dir))
+		})
+	}
+}
+
+func TestParseFindQueryUnicode(t *testing.T) {
+	// "à" and "Å" are encoded with the bytes 0xA0 and 0x85, which are spaces
+	// in Latin-1
+	for _, name := range []string{"voilà.txt", "Åland", "日本語"} {
+		node := &restic.Node{Name: name, Type: "file"}
+		for _, query := range []string{"name=" + name, "(name=" + name + ")", "name='" + name + "'", "name~^" + name + "$"} {
+			t.Run(query, func(t *testing.T) {
+				q, _, err := parseFindQuery(query)
+				rtest.OK(t, err)
+				rtest.Assert(t, q("/"+name, node), "query %q does not match %q", query, name)
+				rtest.Assert(t, !q("/other", &restic.Node{Name: "other", Type: "file"}),
+					"query %q matches other name", query)
+			})
+		}
+	}
+}
+
+func TestParseFindQueryInvalid(t *testing.T) {
+	for _, query := range []string{
+		"",
+		"size",
+		"size>",
+		"size>x",
+		"size~1G",
+		"foo=bar",
+		"type=folder",
+		"type~file",
+		"mode=999",
+		"mode<0644",
+		"mtime>yesterday",
+		"user~(",
+		"name=[",
+		"user=root and",
+		"(user=root",
+		"user=root)",
+		"user=root user=postgres",
+		`name="foo`,
+		`name=""`,
+	} {
+		t.Run(query, func(t *testing.T) {
+			_, _, err := parseFindQuery(query)
+			rtest.Assert(t, err != nil, "expected error for query %q", query)
+		})
+	}
+}
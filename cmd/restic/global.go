@@ -56,12 +56,17 @@ type GlobalOptions struct {
 	Quiet           bool
 	Verbose         int
 	NoLock          bool
+	AllowDelete     bool
 	JSON            bool
 	CacheDir        string
 	NoCache         bool
 	CACerts         []string
 	TLSClientCert   string
 	CleanupCache    bool
+	PackSize        string
+
+	UploadConcurrency uint
+	UploadBudget      string
 
 	LimitUploadKb   int
 	LimitDownloadKb int
@@ -109,12 +114,16 @@ func init() {
 	f.BoolVarP(&globalOptions.Quiet, "quiet", "q", false, "do not output comprehensive progress report")
 	f.CountVarP(&globalOptions.Verbose, "verbose", "v", "be verbose (specify multiple times or a level using --verbose=`n`, max level/times is 3)")
 	f.BoolVar(&globalOptions.NoLock, "no-lock", false, "do not lock the repository, this allows some operations on read-only repositories")
+	f.BoolVar(&globalOptions.AllowDelete, "allow-delete", false, "allow commands to delete files in an append-only repository")
 	f.BoolVarP(&globalOptions.JSON, "json", "", false, "set output mode to JSON for commands that support it")
 	f.StringVar(&globalOptions.CacheDir, "cache-dir", "", "set the cache `directory`. (default: use system default cache directory)")
 	f.BoolVar(&globalOptions.NoCache, "no-cache", false, "do not use a local cache")
 	f.StringSliceVar(&globalOptions.CACerts, "cacert", nil, "`file` to load root certificates from (default: use system certificates)")
 	f.StringVar(&globalOptions.TLSClientCert, "tls-client-cert", "", "path to a `file` containing PEM encoded TLS client certificate and private key")
 	f.BoolVar(&globalOptions.CleanupCache, "cleanup-cache", false, "auto remove old cache directories")
+	f.StringVar(&globalOptions.PackSize, "pack-size", os.Getenv("RESTIC_PACK_SIZE"), "target `size` of pack files, overrides the size configured for the repository (allowed suffixes: k/K, m/M, g/G) (default: $RESTIC_PACK_SIZE)")
+	f.UintVar(&globalOptions.UploadConcurrency, "upload-concurrency", 0, fmt.Sprintf("upload `n` pack files concurrently (default: %d)", repository.DefaultUploadConcurrency))
+	f.StringVar(&globalOptions.UploadBudget, "upload-budget", "", "maximum `size` of the pack files waiting to be uploaded, stored in temporary files (allowed suffixes: k/K, m/M, g/G) (default: two pack files per concurrent upload)")
 	f.IntVar(&globalOptions.LimitUploadKb, "limit-upload", 0, "limits uploads to a maximum rate in KiB/s. (default: unlimited)")
 	f.IntVar(&globalOptions.LimitDownloadKb, "limit-download", 0, "limits downloads to a maximum rate in KiB/s. (default: unlimited)")
 	f.StringSliceVarP(&globalOptions.Options, "option", "o", []string{}, "set extended option (`key=value`, can be specified multiple times)")
@@ -122,6 +131,27 @@ func init() {
 	restoreTerminal()
 }
 
+// parsePackSize parses the size passed to --pack-size. It returns zero if no
+// size was specified.
+func parsePackSize(s string) (uint, error) {
+	if s == "" {
+		return 0, nil
+	}
+
+	size, err := parseSizeStr(s)
+	if err != nil {
+		return 0, errors.Fatalf("invalid pack size %q: %v", s, err)
+	}
+	if size < 0 {
+		return 0, errors.Fatalf("invalid pack size %q", s)
+	}
+	if err := restic.CheckPackSize(uint(size)); err != nil {
+		return 0, errors.Fatalf("invalid pack size %q: %v", s, err)
+	}
+
+	return uint(size), nil
+}
+
 // checkErrno returns nil when err is set to syscall.Errno(0), since this is no
 // error condition.
 func checkErrno(err error) error {
@@ -226,7 +256,7 @@ func Println(args ...interface{}) {
 
 // Verbosef calls Printf to write the message when the verbose flag is set.
 func Verbosef(format string, args ...interface{}) {
-	if globalOptions.verbosity >= 1 {
+	if globalOptions.verbosity >= 1 && !globalOptions.JSON {
 		Printf(format, args...)
 	}
 }
@@ -465,6 +495,30 @@ func OpenRepository(opts GlobalOptions) (*repository.Repository, error) {
 		return nil, errors.Fatalf("%s", err)
 	}
 
+	packSize, err := parsePackSize(opts.PackSize)
+	if err != nil {
+		return nil, err
+	}
+	if packSize != 0 {
+		// the size has already been validated
+		_ = s.SetPackSize(packSize)
+	}
+
+	if opts.UploadConcurrency != 0 {
+		// zero selects the default
+		_ = s.SetUploadConcurrency(opts.UploadConcurrency)
+	}
+	if opts.UploadBudget != "" {
+		budget, err := parseSizeStr(opts.UploadBudget)
+		if err != nil {
+			return nil, errors.Fatalf("invalid upload budget %q: %v", opts.UploadBudget, err)
+		}
+		if budget <= 0 {
+			return nil, errors.Fatalf("invalid upload budget %q", opts.UploadBudget)
+		}
+		s.SetUploadBudget(uint(budget))
+	}
+
 	if stdoutIsTerminal() && !opts.JSON {
 		id := s.Config().ID
 		if len(id) > 8 {
@@ -523,6 +577,15 @@ func OpenRepository(opts GlobalOptions) (*repository.Repository, error) {
 	return s, nil
 }
 
+// checkAppendOnly returns an error if the repository is append-only and
+// deleting files was not allowed with --allow-delete.
+func checkAppendOnly(gopts GlobalOptions, repo restic.Repository) error {
+	if repo.Config().AppendOnly && !gopts.AllowDelete {
+		return errors.Fatal("the repository is append-only and this operation deletes files, use --allow-delete to run it anyway")
+	}
+	return nil
+}
+
 func parseConfig(loc location.Location, opts options.Options) (interface{}, error) {
 	// only apply options for a particular backend here
 	opts = opts.Extract(loc.Scheme)
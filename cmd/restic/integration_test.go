@@ -19,12 +19,15 @@ import (
 	"testing"
 	"time"
 
+	"github.com/restic/restic/internal/checker"
 	"github.com/restic/restic/internal/errors"
 	"github.com/restic/restic/internal/filter"
+	"github.com/restic/restic/internal/fs"
 	"github.com/restic/restic/internal/repository"
 	"github.com/restic/restic/internal/restic"
 	rtest "github.com/restic/restic/internal/test"
 	"github.com/restic/restic/internal/ui/termstatus"
+	"github.com/restic/restic/internal/walker"
 	"golang.org/x/sync/errgroup"
 )
 
@@ -269,8 +272,8 @@ func testRunForgetJSON(t testing.TB, gopts GlobalOptions, args ...string) {
 		"Expected 2 snapshots to be removed, got %v", len(forgets[0].Remove))
 }
 
-func testRunPrune(t testing.TB, gopts GlobalOptions) {
-	rtest.OK(t, runPrune(gopts))
+func testRunPrune(t testing.TB, gopts GlobalOptions, opts PruneOptions) {
+	rtest.OK(t, runPrune(opts, gopts))
 }
 
 func testSetupBackupData(t testing.TB, env *testEnvironment) string {
@@ -334,6 +337,33 @@ func TestBackup(t *testing.T) {
 	testRunCheck(t, env.gopts)
 }
 
+func TestBackupDryRun(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+
+	testSetupBackupData(t, env)
+	opts := BackupOptions{DryRun: true}
+
+	// a dry run does not write anything to the repository
+	testRunBackup(t, filepath.Dir(env.testdata), []string{"testdata"}, opts, env.gopts)
+	rtest.Equals(t, 0, len(testRunList(t, "snapshots", env.gopts)))
+	rtest.Equals(t, 0, len(listPacks(env.gopts, t)))
+	rtest.Equals(t, 0, len(testRunList(t, "index", env.gopts)))
+
+	testRunBackup(t, filepath.Dir(env.testdata), []string{"testdata"}, BackupOptions{}, env.gopts)
+	packsBefore := listPacks(env.gopts, t)
+	indexesBefore := restic.NewIDSet(testRunList(t, "index", env.gopts)...)
+
+	rtest.OK(t, appendRandomData(filepath.Join(env.testdata, "new-file"), 1024))
+	testRunBackup(t, filepath.Dir(env.testdata), []string{"testdata"}, opts, env.gopts)
+	rtest.Equals(t, 1, len(testRunList(t, "snapshots", env.gopts)))
+	rtest.Assert(t, packsBefore.Equals(listPacks(env.gopts, t)),
+		"dry run of backup modified the pack files")
+	rtest.Assert(t, indexesBefore.Equals(restic.NewIDSet(testRunList(t, "index", env.gopts)...)),
+		"dry run of backup modified the index files")
+	testRunCheck(t, env.gopts)
+}
+
 func TestBackupNonExistingFile(t *testing.T) {
 	env, cleanup := withTestEnvironment(t)
 	defer cleanup()
@@ -494,6 +524,52 @@ func TestBackupExclude(t *testing.T) {
 		"expected file %q not in first snapshot, but it's included", "passwords.txt")
 }
 
+func TestBackupJob(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+
+	testRunInit(t, env.gopts)
+
+	datadir := filepath.Join(env.base, "testdata")
+
+	for _, filename := range backupExcludeFilenames {
+		fp := filepath.Join(datadir, filename)
+		rtest.OK(t, os.MkdirAll(filepath.Dir(fp), 0755))
+		rtest.OK(t, ioutil.WriteFile(fp, []byte(filename), 0644))
+	}
+
+	// the exclude patterns of each source only apply to its own paths
+	job := `
+sources:
+  - paths: [testdata/work]
+    exclude: ["*.txt"]
+  - paths: [testdata/private]
+    exclude: ["*.c"]
+  - paths: [testdata/foo.tar.gz, testdata/testfile1]
+    exclude: [testfile1]
+`
+	jobfile := filepath.Join(env.base, "job.yaml")
+	rtest.OK(t, ioutil.WriteFile(jobfile, []byte(job), 0644))
+
+	opts := BackupOptions{Job: jobfile}
+	testRunBackup(t, filepath.Dir(env.testdata), nil, opts, env.gopts)
+
+	snapshotIDs := testRunList(t, "snapshots", env.gopts)
+	rtest.Assert(t, len(snapshotIDs) == 1,
+		"expected one snapshot, got %v", snapshotIDs)
+
+	files := testRunLs(t, env.gopts, snapshotIDs[0].String())
+	for _, filename := range []string{"foo.tar.gz", "private/secret/passwords.txt", "work/source/test.c"} {
+		rtest.Assert(t, includes(files, "/testdata/"+filename),
+			"expected file %q in snapshot, but it's not included", filename)
+	}
+	rtest.Assert(t, !includes(files, "/testdata/testfile1"),
+		"expected file %q not in snapshot, but it's included", "testfile1")
+
+	err := testRunBackupAssumeFailure(t, filepath.Dir(env.testdata), []string{"testdata"}, opts, env.gopts)
+	rtest.Assert(t, err != nil, "expected error for --job with files/dirs as arguments")
+}
+
 func TestBackupErrors(t *testing.T) {
 	if runtime.GOOS == "windows" {
 		return
@@ -613,6 +689,52 @@ func TestBackupTags(t *testing.T) {
 		"expected parent to be %v, got %v", parent.ID, newest.Parent)
 }
 
+func testRunPolicySet(t testing.TB, dir string, gopts GlobalOptions, policy string) {
+	filename := filepath.Join(dir, "policy.json")
+	rtest.OK(t, ioutil.WriteFile(filename, []byte(policy), 0600))
+	rtest.OK(t, runPolicySet(gopts, []string{filename}))
+}
+
+func TestForgetRepoPolicy(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+
+	testSetupBackupData(t, env)
+
+	opts := BackupOptions{Tags: []string{"daily"}}
+	for i := 0; i < 3; i++ {
+		testRunBackup(t, "", []string{env.testdata}, opts, env.gopts)
+	}
+	opts.Tags = nil
+	for i := 0; i < 2; i++ {
+		testRunBackup(t, "", []string{env.testdata}, opts, env.gopts)
+	}
+
+	err := runForget(ForgetOptions{UseRepoPolicy: true}, env.gopts, nil)
+	rtest.Assert(t, err != nil, "expected error for missing policy")
+
+	testRunPolicySet(t, env.base, env.gopts, `{"rules": [{"keep": {"last": 2}}]}`)
+	testRunPolicySet(t, env.base, env.gopts, `{"rules": [{"tags": [["daily"]], "keep": {"last": 1}}]}`)
+	rtest.Equals(t, 1, len(testRunList(t, "policies", env.gopts)))
+
+	err = runForget(ForgetOptions{UseRepoPolicy: true, Last: 1}, env.gopts, nil)
+	rtest.Assert(t, err != nil, "expected error for --use-repo-policy with --keep-last")
+
+	rtest.OK(t, runForget(ForgetOptions{UseRepoPolicy: true}, env.gopts, nil))
+
+	// snapshots without the tag don't match any rule and are kept
+	_, snapshots := testRunSnapshots(t, env.gopts)
+	rtest.Equals(t, 3, len(snapshots))
+
+	tagged := 0
+	for _, sn := range snapshots {
+		if len(sn.Tags) > 0 {
+			tagged++
+		}
+	}
+	rtest.Equals(t, 1, tagged)
+}
+
 func testRunCopy(t testing.TB, srcGopts GlobalOptions, dstGopts GlobalOptions) {
 	copyOpts := CopyOptions{
 		secondaryRepoOptions: secondaryRepoOptions{
@@ -760,6 +882,118 @@ func TestInitCopyChunkerParams(t *testing.T) {
 		otherRepo.Config().ChunkerPolynomial)
 }
 
+func TestInitChunkSizeProfile(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+	env2, cleanup2 := withTestEnvironment(t)
+	defer cleanup2()
+
+	testRunInit(t, env2.gopts)
+
+	err := runInit(InitOptions{ChunkSizeProfile: "huge"}, env.gopts, nil)
+	rtest.Assert(t, err != nil, "expected invalid chunk size profile to fail")
+
+	initOpts := InitOptions{
+		secondaryRepoOptions: secondaryRepoOptions{
+			Repo:     env2.gopts.Repo,
+			password: env2.gopts.password,
+		},
+		CopyChunkerParameters: true,
+		ChunkSizeProfile:      "large",
+	}
+	rtest.Assert(t, runInit(initOpts, env.gopts, nil) != nil, "expected --chunk-size-profile with --copy-chunker-params to fail")
+
+	// older versions of restic would ignore the profile
+	err = runInit(InitOptions{ChunkSizeProfile: "large", RepositoryVersion: "1"}, env.gopts, nil)
+	rtest.Assert(t, err != nil, "expected chunk size profile to fail for repository version 1")
+
+	rtest.OK(t, runInit(InitOptions{ChunkSizeProfile: "large"}, env.gopts, nil))
+
+	repo, err := OpenRepository(env.gopts)
+	rtest.OK(t, err)
+	rtest.Equals(t, restic.ChunkerProfiles["large"], repo.Config().ChunkerParams())
+
+	// the full profile is copied to new repositories
+	env3, cleanup3 := withTestEnvironment(t)
+	defer cleanup3()
+
+	initOpts.secondaryRepoOptions.Repo = env.gopts.Repo
+	initOpts.secondaryRepoOptions.password = env.gopts.password
+	initOpts.ChunkSizeProfile = ""
+	rtest.OK(t, runInit(initOpts, env3.gopts, nil))
+
+	repo3, err := OpenRepository(env3.gopts)
+	rtest.OK(t, err)
+	rtest.Equals(t, repo.Config().ChunkerPolynomial, repo3.Config().ChunkerPolynomial)
+	rtest.Equals(t, restic.ChunkerProfiles["large"], repo3.Config().ChunkerParams())
+
+	// without a profile, no parameters are stored in the config
+	repo2, err := OpenRepository(env2.gopts)
+	rtest.OK(t, err)
+	rtest.Assert(t, repo2.Config().ChunkSizes == nil, "unexpected chunker parameters %v", repo2.Config().ChunkSizes)
+}
+
+func TestCopyRechunk(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+	env2, cleanup2 := withTestEnvironment(t)
+	defer cleanup2()
+
+	testSetupBackupData(t, env)
+	rtest.OK(t, appendRandomData(filepath.Join(env.testdata, "0", "large"), 5*1024*1024))
+	testRunBackup(t, "", []string{env.testdata}, BackupOptions{}, env.gopts)
+
+	params := restic.ChunkerProfiles["small"]
+	rtest.OK(t, runInit(InitOptions{ChunkSizeProfile: "small"}, env2.gopts, nil))
+	testRunCopy(t, env.gopts, env2.gopts)
+	testRunCheck(t, env2.gopts)
+
+	// copying again must not create new snapshots
+	testRunCopy(t, env.gopts, env2.gopts)
+	snapshotIDs := testRunList(t, "snapshots", env.gopts)
+	copiedSnapshotIDs := testRunList(t, "snapshots", env2.gopts)
+	rtest.Equals(t, 1, len(copiedSnapshotIDs))
+
+	// the chunks in the destination respect its chunk size profile
+	repo, err := OpenRepository(env2.gopts)
+	rtest.OK(t, err)
+	rtest.OK(t, repo.LoadIndex(context.TODO()))
+
+	sn, err := restic.LoadSnapshot(context.TODO(), repo, copiedSnapshotIDs[0])
+	rtest.OK(t, err)
+	var largeFiles int
+	err = walker.Walk(context.TODO(), repo, *sn.Tree, nil, func(_ restic.ID, nodepath string, node *restic.Node, err error) (bool, error) {
+		if err != nil || node == nil || node.Type != "file" {
+			return false, err
+		}
+
+		var size uint64
+		for i, id := range node.Content {
+			blobSize, found := repo.LookupBlobSize(id, restic.DataBlob)
+			rtest.Assert(t, found, "blob %v of %v not found", id.Str(), nodepath)
+			rtest.Assert(t, blobSize <= params.MaxSize, "blob %v of %v larger than %d bytes", id.Str(), nodepath, params.MaxSize)
+			if i < len(node.Content)-1 {
+				rtest.Assert(t, blobSize >= params.MinSize, "blob %v of %v smaller than %d bytes", id.Str(), nodepath, params.MinSize)
+			}
+			size += uint64(blobSize)
+		}
+		rtest.Equals(t, node.Size, size)
+
+		if node.Size > uint64(params.MaxSize) {
+			largeFiles++
+		}
+		return false, nil
+	})
+	rtest.OK(t, err)
+	rtest.Equals(t, 1, largeFiles)
+
+	restoredir := filepath.Join(env.base, "restore")
+	testRunRestore(t, env.gopts, restoredir, snapshotIDs[0])
+	copydir := filepath.Join(env2.base, "restore")
+	testRunRestore(t, env2.gopts, copydir, copiedSnapshotIDs[0])
+	rtest.Equals(t, "", directoriesContentsDiff(restoredir, copydir))
+}
+
 func testRunTag(t testing.TB, opts TagOptions, gopts GlobalOptions) {
 	rtest.OK(t, runTag(opts, gopts, []string{}))
 }
@@ -932,6 +1166,114 @@ func TestKeyAddRemove(t *testing.T) {
 	testRunKeyAddNewKeyUserHost(t, env.gopts)
 }
 
+func TestAppendOnly(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+
+	repository.TestUseLowSecurityKDFParameters(t)
+	restic.TestDisableCheckPolynomial(t)
+	restic.TestSetLockTimeout(t, 0)
+	// locks usually cannot be removed, let them expire immediately
+	restic.TestSetAppendOnlyLockExpiry(t, 0)
+	rtest.OK(t, runInit(InitOptions{AppendOnly: true}, env.gopts, nil))
+
+	rtest.SetupTarTestFixture(t, env.testdata, filepath.Join("testdata", "backup-data.tar.gz"))
+	testRunBackup(t, filepath.Dir(env.testdata), []string{"testdata"}, BackupOptions{}, env.gopts)
+	testRunBackup(t, filepath.Dir(env.testdata), []string{"testdata"}, BackupOptions{}, env.gopts)
+
+	forgetOpts := ForgetOptions{Last: 1}
+	err := runForget(forgetOpts, env.gopts, nil)
+	rtest.Assert(t, err != nil && strings.Contains(err.Error(), "append-only"),
+		"expected forget to be refused, got %v", err)
+	rtest.Assert(t, runUnlock(UnlockOptions{}, env.gopts) != nil, "expected unlock to be refused")
+	rtest.Assert(t, runPrune(PruneOptions{MaxUnused: "5%"}, env.gopts) != nil, "expected prune to be refused")
+	rtest.Equals(t, 2, len(testRunList(t, "snapshots", env.gopts)))
+
+	// the local backend allows deleting files, check must point this out
+	out, err := testRunCheckOutput(env.gopts)
+	rtest.OK(t, err)
+	rtest.Assert(t, strings.Contains(out, checker.ErrDeleteAllowed.Error()),
+		"expected check to report that deleting files is possible, got\n%v", out)
+
+	env.gopts.AllowDelete = true
+	rtest.OK(t, runForget(forgetOpts, env.gopts, nil))
+	rtest.Equals(t, 1, len(testRunList(t, "snapshots", env.gopts)))
+}
+
+func TestKeyWriteOnly(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+
+	repository.TestUseLowSecurityKDFParameters(t)
+	restic.TestDisableCheckPolynomial(t)
+	rtest.OK(t, runInit(InitOptions{RepositoryVersion: "2"}, env.gopts, nil))
+
+	keyWriteOnly = true
+	testRunKeyAddNewKey(t, "write-only", env.gopts)
+	keyWriteOnly = false
+
+	wopts := env.gopts
+	wopts.password = "write-only"
+
+	rtest.SetupTarTestFixture(t, env.testdata, filepath.Join("testdata", "backup-data.tar.gz"))
+	testRunBackup(t, filepath.Dir(env.testdata), []string{"testdata"}, BackupOptions{}, wopts)
+	testRunBackup(t, filepath.Dir(env.testdata), []string{"testdata"}, BackupOptions{}, wopts)
+
+	// the write-only key cannot read anything
+	rtest.Assert(t, runCheck(CheckOptions{}, wopts, nil) != nil, "checking with a write-only key succeeded")
+	rtest.Assert(t, runKey(wopts, []string{"remove", "abc"}) != nil, "removing a key with a write-only key succeeded")
+	rtest.Assert(t, runKey(wopts, []string{"add"}) != nil, "adding a full key with a write-only key succeeded")
+
+	// the full key can read and restore both snapshots
+	snapshotIDs := testRunList(t, "snapshots", env.gopts)
+	rtest.Assert(t, len(snapshotIDs) == 2, "expected two snapshots, got %v", snapshotIDs)
+	testRunCheck(t, env.gopts)
+
+	for i, id := range snapshotIDs {
+		restoredir := filepath.Join(env.base, fmt.Sprintf("restore%d", i))
+		testRunRestore(t, env.gopts, restoredir, id)
+		diff := directoriesContentsDiff(env.testdata, filepath.Join(restoredir, "testdata"))
+		rtest.Assert(t, diff == "", "directories are not equal: %v", diff)
+	}
+
+	// prune removes the data saved twice
+	testRunPrune(t, env.gopts, PruneOptions{MaxUnused: "0"})
+	testRunCheck(t, env.gopts)
+}
+
+func TestKeyWriteOnlyExclusiveLock(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+
+	repository.TestUseLowSecurityKDFParameters(t)
+	restic.TestDisableCheckPolynomial(t)
+	restic.TestSetLockTimeout(t, 0)
+	rtest.OK(t, runInit(InitOptions{RepositoryVersion: "2"}, env.gopts, nil))
+
+	keyWriteOnly = true
+	testRunKeyAddNewKey(t, "write-only", env.gopts)
+	keyWriteOnly = false
+
+	wopts := env.gopts
+	wopts.password = "write-only"
+
+	// hold an exclusive lock like prune does
+	repo, err := OpenRepository(env.gopts)
+	rtest.OK(t, err)
+	lock, err := restic.NewExclusiveLock(env.gopts.ctx, repo)
+	rtest.OK(t, err)
+
+	rtest.SetupTarTestFixture(t, env.testdata, filepath.Join("testdata", "backup-data.tar.gz"))
+	err = testRunBackupAssumeFailure(t, filepath.Dir(env.testdata), []string{"testdata"}, BackupOptions{}, wopts)
+	rtest.Assert(t, restic.IsAlreadyLocked(errors.Cause(err)),
+		"expected backup with write-only key to fail with ErrAlreadyLocked, got %v", err)
+
+	rtest.OK(t, lock.Unlock())
+	rtest.Equals(t, 0, len(testRunList(t, "snapshots", env.gopts)))
+	testRunBackup(t, filepath.Dir(env.testdata), []string{"testdata"}, BackupOptions{}, wopts)
+	rtest.Equals(t, 1, len(testRunList(t, "snapshots", env.gopts)))
+}
+
 func testFileSize(filename string, size int64) error {
 	fi, err := os.Stat(filename)
 	if err != nil {
@@ -1087,6 +1429,35 @@ func TestRestoreLatest(t *testing.T) {
 	}
 }
 
+func TestRestoreMerge(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+
+	testRunInit(t, env.gopts)
+
+	back := rtest.Chdir(t, env.base)
+	defer back()
+
+	opts := BackupOptions{}
+	for i, dir := range []string{"p1", "p2"} {
+		p := filepath.Join(env.base, dir, "testfile.c")
+		rtest.OK(t, os.MkdirAll(filepath.Dir(p), 0755))
+		rtest.OK(t, appendRandomData(p, uint(100+i)))
+		testRunBackup(t, "", []string{dir}, opts, env.gopts)
+	}
+
+	// modify p1 and save it again, so that the snapshots contain two versions
+	p1 := filepath.Join(env.base, "p1", "testfile.c")
+	rtest.OK(t, appendRandomData(p1, 50))
+	testRunBackup(t, "", []string{"p1"}, opts, env.gopts)
+
+	target := filepath.Join(env.base, "restore")
+	rtest.OK(t, runRestore(RestoreOptions{Target: target, Merge: true}, env.gopts, nil))
+
+	rtest.OK(t, testFileSize(filepath.Join(target, "p1", "testfile.c"), int64(150)))
+	rtest.OK(t, testFileSize(filepath.Join(target, "p2", "testfile.c"), int64(101)))
+}
+
 func TestRestoreWithPermissionFailure(t *testing.T) {
 	env, cleanup := withTestEnvironment(t)
 	defer cleanup()
@@ -1229,6 +1600,159 @@ func TestFindJSON(t *testing.T) {
 	rtest.Assert(t, matches[0].Hits == 3, "expected hits to show 3 matches (%v)", datafile)
 }
 
+func testRunFindQuery(t testing.TB, gopts GlobalOptions, query string, patterns ...string) []testMatches {
+	buf := bytes.NewBuffer(nil)
+	globalOptions.stdout = buf
+	globalOptions.JSON = true
+	defer func() {
+		globalOptions.stdout = os.Stdout
+		globalOptions.JSON = false
+	}()
+
+	rtest.OK(t, runFind(FindOptions{Query: query}, gopts, patterns))
+
+	matches := []testMatches{}
+	rtest.OK(t, json.Unmarshal(buf.Bytes(), &matches))
+	return matches
+}
+
+func TestFindQuery(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+
+	testSetupBackupData(t, env)
+	testRunBackup(t, "", []string{env.testdata}, BackupOptions{}, env.gopts)
+	// the second snapshot shares all trees except the root with the first
+	rtest.OK(t, appendRandomData(filepath.Join(env.testdata, "0", "large"), 300*1024))
+	testRunBackup(t, "", []string{env.testdata}, BackupOptions{}, env.gopts)
+
+	for _, test := range []struct {
+		query    string
+		patterns []string
+		hits     []int
+	}{
+		{query: "name=testfile", hits: []int{1, 1}},
+		{query: "name=testfile*", hits: []int{3, 3}},
+		{query: "size>200k and type=file", hits: []int{1}},
+		{query: "type=dir and name=0", hits: []int{2, 2}},
+		{query: "path~/tests/testfile$", hits: []int{1, 1}},
+		{query: "type=file", patterns: []string{"testfile*"}, hits: []int{2, 2}},
+		{query: "name=unexistingfile", hits: nil},
+	} {
+		t.Run(test.query, func(t *testing.T) {
+			matches := testRunFindQuery(t, env.gopts, test.query, test.patterns...)
+			var hits []int
+			for _, m := range matches {
+				rtest.Equals(t, m.Hits, len(m.Matches))
+				hits = append(hits, m.Hits)
+			}
+			rtest.Equals(t, test.hits, hits)
+		})
+	}
+}
+
+func TestFindNamePatterns(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+
+	testSetupBackupData(t, env)
+	testRunBackup(t, "", []string{env.testdata}, BackupOptions{}, env.gopts)
+	rtest.OK(t, appendRandomData(filepath.Join(env.testdata, "0", "large"), 300*1024))
+	testRunBackup(t, "", []string{env.testdata}, BackupOptions{}, env.gopts)
+
+	// name patterns are searched with cached results for each tree, a leading
+	// "**/" matches the same paths but disables the cache
+	for _, patterns := range [][]string{
+		{"0"},
+		{"testfile*"},
+		{"tests", "testfile"},
+	} {
+		t.Run(strings.Join(patterns, ","), func(t *testing.T) {
+			var uncached []string
+			for _, pat := range patterns {
+				uncached = append(uncached, "**/"+pat)
+			}
+
+			for _, opts := range []FindOptions{{}, {Query: "type=file"}} {
+				want := testRunFindOutput(t, env.gopts, opts, uncached...)
+				rtest.Assert(t, strings.Contains(want, `"hits"`), "no matches found for %v", uncached)
+				rtest.Equals(t, want, testRunFindOutput(t, env.gopts, opts, patterns...))
+			}
+		})
+	}
+}
+
+func testRunFindOutput(t testing.TB, gopts GlobalOptions, opts FindOptions, patterns ...string) string {
+	buf := bytes.NewBuffer(nil)
+	globalOptions.stdout = buf
+	globalOptions.JSON = true
+	defer func() {
+		globalOptions.stdout = os.Stdout
+		globalOptions.JSON = false
+	}()
+
+	rtest.OK(t, runFind(opts, gopts, patterns))
+	return buf.String()
+}
+
+func testRunLsOutput(t testing.TB, gopts GlobalOptions, opts LsOptions, args ...string) string {
+	buf := bytes.NewBuffer(nil)
+	globalOptions.stdout = buf
+	globalOptions.JSON = true
+	defer func() {
+		globalOptions.stdout = os.Stdout
+		globalOptions.JSON = false
+	}()
+
+	rtest.OK(t, runLs(opts, gopts, args))
+	return buf.String()
+}
+
+func TestSearchIndex(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+
+	testSetupBackupData(t, env)
+	testRunBackup(t, "", []string{env.testdata}, BackupOptions{}, env.gopts)
+	rtest.OK(t, appendRandomData(filepath.Join(env.testdata, "0", "large"), 300*1024))
+	testRunBackup(t, "", []string{env.testdata}, BackupOptions{}, env.gopts)
+
+	searches := []FindOptions{
+		{},
+		{Query: "size>200k and type=file"},
+		{Query: "type=dir and name=0"},
+	}
+
+	check := func() {
+		for _, opts := range searches {
+			want := testRunFindOutput(t, env.gopts, opts, "testfile*")
+			opts.SearchIndex = true
+			rtest.Equals(t, want, testRunFindOutput(t, env.gopts, opts, "testfile*"))
+		}
+
+		want := testRunLsOutput(t, env.gopts, LsOptions{}, "latest")
+		rtest.Equals(t, want, testRunLsOutput(t, env.gopts, LsOptions{SearchIndex: true}, "latest"))
+	}
+
+	check()
+	segments, err := filepath.Glob(filepath.Join(env.cache, "*", "search", "*"))
+	rtest.OK(t, err)
+	rtest.Assert(t, len(segments) > 0, "search index not found in cache directory %v", env.cache)
+
+	// new snapshots are added to the index, removed ones are dropped
+	testRunForget(t, env.gopts, testRunList(t, "snapshots", env.gopts)[0].String())
+	rtest.OK(t, appendRandomData(filepath.Join(env.testdata, "0", "new"), 10*1024))
+	testRunBackup(t, "", []string{env.testdata}, BackupOptions{}, env.gopts)
+	check()
+
+	gopts := env.gopts
+	gopts.NoCache = true
+	err = runFind(FindOptions{SearchIndex: true}, gopts, []string{"testfile"})
+	rtest.Assert(t, err != nil, "expected error for --search-index with --no-cache")
+	err = runFind(FindOptions{SearchIndex: true, BlobID: true}, env.gopts, []string{"testfile"})
+	rtest.Assert(t, err != nil, "expected error for --search-index with --blob")
+}
+
 func TestRebuildIndex(t *testing.T) {
 	env, cleanup := withTestEnvironment(t)
 	defer cleanup()
@@ -1325,6 +1849,32 @@ func TestCheckRestoreNoLock(t *testing.T) {
 }
 
 func TestPrune(t *testing.T) {
+	t.Run("0", func(t *testing.T) {
+		opts := PruneOptions{MaxUnused: "0%"}
+		checkOpts := CheckOptions{ReadData: true, CheckUnused: true}
+		testPrune(t, opts, checkOpts)
+	})
+
+	t.Run("50", func(t *testing.T) {
+		opts := PruneOptions{MaxUnused: "50%"}
+		checkOpts := CheckOptions{ReadData: true}
+		testPrune(t, opts, checkOpts)
+	})
+
+	t.Run("unlimited", func(t *testing.T) {
+		opts := PruneOptions{MaxUnused: "unlimited"}
+		checkOpts := CheckOptions{ReadData: true}
+		testPrune(t, opts, checkOpts)
+	})
+
+	t.Run("MaxRepackSize", func(t *testing.T) {
+		opts := PruneOptions{MaxUnused: "0%", MaxRepackSize: "1"}
+		checkOpts := CheckOptions{ReadData: true}
+		testPrune(t, opts, checkOpts)
+	})
+}
+
+func testPrune(t *testing.T, pruneOpts PruneOptions, checkOpts CheckOptions) {
 	env, cleanup := withTestEnvironment(t)
 	defer cleanup()
 
@@ -1345,8 +1895,35 @@ func TestPrune(t *testing.T) {
 
 	testRunForgetJSON(t, env.gopts)
 	testRunForget(t, env.gopts, firstSnapshot[0].String())
-	testRunPrune(t, env.gopts)
-	testRunCheck(t, env.gopts)
+	testRunPrune(t, env.gopts, pruneOpts)
+	rtest.OK(t, runCheck(checkOpts, env.gopts, nil))
+}
+
+func TestPruneDryRun(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+
+	testSetupBackupData(t, env)
+	opts := BackupOptions{}
+
+	testRunBackup(t, "", []string{filepath.Join(env.testdata, "0", "0", "9", "2")}, opts, env.gopts)
+	firstSnapshot := testRunList(t, "snapshots", env.gopts)
+	testRunBackup(t, "", []string{filepath.Join(env.testdata, "0", "0", "9", "3")}, opts, env.gopts)
+	testRunForget(t, env.gopts, firstSnapshot[0].String())
+
+	packsBefore := listPacks(env.gopts, t)
+	indexesBefore := restic.NewIDSet(testRunList(t, "index", env.gopts)...)
+
+	testRunPrune(t, env.gopts, PruneOptions{DryRun: true, MaxUnused: "0%"})
+
+	rtest.Assert(t, packsBefore.Equals(listPacks(env.gopts, t)),
+		"dry run of prune modified the pack files")
+	rtest.Assert(t, indexesBefore.Equals(restic.NewIDSet(testRunList(t, "index", env.gopts)...)),
+		"dry run of prune modified the index files")
+
+	// the unused data is still there
+	rtest.Assert(t, runCheck(CheckOptions{CheckUnused: true}, env.gopts, nil) != nil,
+		"check should have reported unused data")
 }
 
 func listPacks(gopts GlobalOptions, t *testing.T) restic.IDSet {
@@ -1391,7 +1968,7 @@ func TestPruneWithDamagedRepository(t *testing.T) {
 		"expected one snapshot, got %v", snapshotIDs)
 
 	// prune should fail
-	err := runPrune(env.gopts)
+	err := runPrune(PruneOptions{MaxUnused: "5%"}, env.gopts)
 	if err == nil {
 		t.Fatalf("expected prune to fail")
 	}
@@ -1412,9 +1989,9 @@ func TestEdgeCaseRepos(t *testing.T) {
 	})
 
 	// repo where an existing and used blob is missing from the index
-	// => check should fail, prune should heal this
+	// => check and prune should fail, as prune relies on the index
 	t.Run("index-missing-blob", func(t *testing.T) {
-		testEdgeCaseRepo(t, "repo-index-missing-blob.tar.gz", opts, false, true)
+		testEdgeCaseRepo(t, "repo-index-missing-blob.tar.gz", opts, false, false)
 	})
 
 	// repo where a blob is missing
@@ -1467,11 +2044,12 @@ func testEdgeCaseRepo(t *testing.T, tarfile string, options CheckOptions, checkO
 			"check should have reported an error")
 	}
 
+	pruneOpts := PruneOptions{MaxUnused: "0%"}
 	if pruneOK {
-		testRunPrune(t, env.gopts)
+		testRunPrune(t, env.gopts, pruneOpts)
 		testRunCheck(t, env.gopts)
 	} else {
-		rtest.Assert(t, runPrune(env.gopts) != nil,
+		rtest.Assert(t, runPrune(pruneOpts, env.gopts) != nil,
 			"prune should have reported an error")
 	}
 }
@@ -1681,3 +2259,481 @@ func TestDiff(t *testing.T) {
 		rtest.Assert(t, r.MatchString(out), "expected pattern %v in output, got\n%v", pattern, out)
 	}
 }
+
+func TestDiffPatch(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+
+	testRunInit(t, env.gopts)
+
+	datadir := filepath.Join(env.base, "testdata")
+	textfile := filepath.Join(datadir, "text")
+	binfile := filepath.Join(datadir, "binary")
+	largefile := filepath.Join(datadir, "large")
+
+	rtest.OK(t, ioutil.WriteFile(textfile, []byte("a\nb\nc\n"), 0644))
+	rtest.OK(t, ioutil.WriteFile(binfile, []byte("a\x00b"), 0644))
+	rtest.OK(t, ioutil.WriteFile(largefile, bytes.Repeat([]byte("a\n"), 1024), 0644))
+
+	snapshots := make(map[string]struct{})
+	testRunBackup(t, "", []string{datadir}, BackupOptions{}, env.gopts)
+	snapshots, firstSnapshotID := lastSnapshot(snapshots, loadSnapshotMap(t, env.gopts))
+
+	rtest.OK(t, ioutil.WriteFile(textfile, []byte("a\nx\nc\n"), 0644))
+	rtest.OK(t, ioutil.WriteFile(binfile, []byte("a\x00c"), 0644))
+	rtest.OK(t, ioutil.WriteFile(largefile, bytes.Repeat([]byte("b\n"), 1024), 0644))
+
+	testRunBackup(t, "", []string{datadir}, BackupOptions{}, env.gopts)
+	_, secondSnapshotID := lastSnapshot(snapshots, loadSnapshotMap(t, env.gopts))
+
+	opts := DiffOptions{Patch: true, MaxSize: "1k"}
+
+	buf := bytes.NewBuffer(nil)
+	globalOptions.stdout = buf
+	err := runDiff(opts, env.gopts, []string{firstSnapshotID, secondSnapshotID})
+	globalOptions.stdout = os.Stdout
+	rtest.OK(t, err)
+
+	out := buf.String()
+	for _, expected := range []string{
+		"--- a" + textfile + "\n+++ b" + textfile + "\n@@ -1,3 +1,3 @@\n a\n-b\n+x\n c\n",
+		"Binary files a" + binfile + " and b" + binfile + " differ",
+		"File a" + largefile + " or b" + largefile + " is too large to compare",
+	} {
+		rtest.Assert(t, strings.Contains(out, expected), "expected %q in output, got\n%v", expected, out)
+	}
+
+	msgs := testRunJSON(t, env.gopts, func(gopts GlobalOptions) error {
+		return runDiff(opts, gopts, []string{firstSnapshotID, secondSnapshotID})
+	})
+	patches := make(map[string]map[string]interface{})
+	for _, msg := range msgs[:len(msgs)-1] {
+		rtest.Equals(t, "change", msg["message_type"])
+		if patch, ok := msg["patch"]; ok {
+			patches[msg["path"].(string)] = patch.(map[string]interface{})
+		}
+	}
+	rtest.Equals(t, 3, len(patches))
+
+	hunks := patches[textfile]["hunks"].([]interface{})
+	rtest.Equals(t, 1, len(hunks))
+	rtest.Equals(t, []interface{}{" a", "-b", "+x", " c"}, hunks[0].(map[string]interface{})["lines"])
+	rtest.Equals(t, true, patches[binfile]["binary"])
+	rtest.Equals(t, true, patches[largefile]["too_large"])
+	rtest.Equals(t, float64(2048), patches[largefile]["new_size"])
+}
+
+func TestDiffToLocal(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+
+	testRunInit(t, env.gopts)
+
+	datadir := filepath.Join(env.base, "testdata")
+	subdir := filepath.Join(datadir, "subdir")
+	rtest.OK(t, os.MkdirAll(subdir, 0755))
+	for _, name := range []string{"modified", "removed", "touched", filepath.Join("subdir", "unchanged")} {
+		rtest.OK(t, ioutil.WriteFile(filepath.Join(datadir, name), []byte(name), 0644))
+	}
+
+	testRunBackup(t, "", []string{datadir}, BackupOptions{}, env.gopts)
+	snapshotID := testRunList(t, "snapshots", env.gopts)[0].String()
+
+	rtest.OK(t, ioutil.WriteFile(filepath.Join(datadir, "modified"), []byte("changed"), 0644))
+	rtest.OK(t, os.Remove(filepath.Join(datadir, "removed")))
+	rtest.OK(t, os.Mkdir(filepath.Join(datadir, "added"), 0755))
+	rtest.OK(t, ioutil.WriteFile(filepath.Join(datadir, "added", "file"), []byte("new"), 0644))
+	mtime := time.Now().Add(-time.Hour)
+	rtest.OK(t, os.Chtimes(filepath.Join(datadir, "touched"), mtime, mtime))
+
+	prefix := toSlashPath(fs.Local{}, datadir)
+	changes := func(opts DiffOptions, target string) []string {
+		msgs := testRunJSON(t, env.gopts, func(gopts GlobalOptions) error {
+			return runDiff(opts, gopts, []string{snapshotID})
+		})
+
+		var changes []string
+		for _, msg := range msgs[:len(msgs)-1] {
+			rtest.Equals(t, "change", msg["message_type"])
+			changes = append(changes, fmt.Sprintf("%v %v", msg["modifier"], strings.TrimPrefix(msg["path"].(string), prefix)))
+		}
+		rtest.Equals(t, target, lastJSONSummary(t, msgs)["target_path"])
+		return changes
+	}
+
+	// the metadata of touched has changed, its content is the same
+	rtest.Equals(t, []string{"+ /added/", "+ /added/file", "M /modified", "- /removed", "M /touched"},
+		changes(DiffOptions{ToLocal: datadir}, datadir))
+	rtest.Equals(t, []string{"+ /added/", "+ /added/file", "M /modified", "- /removed"},
+		changes(DiffOptions{ToLocal: datadir, Content: true}, datadir))
+	rtest.Equals(t, []string(nil), changes(DiffOptions{ToLocal: subdir, Content: true}, subdir))
+
+	err := runDiff(DiffOptions{ToLocal: env.repo}, env.gopts, []string{snapshotID})
+	rtest.Assert(t, err != nil, "expected error for path not contained in the snapshot")
+}
+
+// testRunJSON runs fn with JSON output enabled and returns the messages
+// printed to stdout.
+func testRunJSON(t testing.TB, gopts GlobalOptions, fn func(gopts GlobalOptions) error) []map[string]interface{} {
+	buf := bytes.NewBuffer(nil)
+	globalOptions.stdout = buf
+	globalOptions.JSON = true
+	defer func() {
+		globalOptions.stdout = os.Stdout
+		globalOptions.JSON = false
+	}()
+
+	gopts.JSON = true
+	gopts.stdout = buf
+	rtest.OK(t, fn(gopts))
+
+	var msgs []map[string]interface{}
+	sc := bufio.NewScanner(buf)
+	for sc.Scan() {
+		var msg map[string]interface{}
+		err := json.Unmarshal(sc.Bytes(), &msg)
+		if err != nil {
+			t.Fatalf("invalid JSON output %q: %v", sc.Text(), err)
+		}
+		rtest.Equals(t, float64(1), msg["schema_version"])
+		msgs = append(msgs, msg)
+	}
+	rtest.OK(t, sc.Err())
+	rtest.Assert(t, len(msgs) > 0, "no messages were printed")
+
+	return msgs
+}
+
+// lastJSONSummary returns the last message, which must be a summary.
+func lastJSONSummary(t testing.TB, msgs []map[string]interface{}) map[string]interface{} {
+	summary := msgs[len(msgs)-1]
+	rtest.Equals(t, "summary", summary["message_type"])
+	return summary
+}
+
+func TestJSONOutput(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+
+	repository.TestUseLowSecurityKDFParameters(t)
+	restic.TestDisableCheckPolynomial(t)
+	restic.TestSetLockTimeout(t, 0)
+	msgs := testRunJSON(t, env.gopts, func(gopts GlobalOptions) error {
+		return runInit(InitOptions{}, gopts, nil)
+	})
+	rtest.Equals(t, 1, len(msgs))
+	rtest.Equals(t, env.gopts.Repo, lastJSONSummary(t, msgs)["repository"])
+
+	rtest.SetupTarTestFixture(t, env.testdata, filepath.Join("testdata", "backup-data.tar.gz"))
+	snapshots := make(map[string]struct{})
+	testRunBackup(t, filepath.Dir(env.testdata), []string{"testdata"}, BackupOptions{}, env.gopts)
+	snapshots, firstSnapshotID := lastSnapshot(snapshots, loadSnapshotMap(t, env.gopts))
+	rtest.OK(t, appendRandomData(filepath.Join(env.testdata, "new-file"), 1024))
+	testRunBackup(t, filepath.Dir(env.testdata), []string{"testdata"}, BackupOptions{}, env.gopts)
+	_, secondSnapshotID := lastSnapshot(snapshots, loadSnapshotMap(t, env.gopts))
+
+	msgs = testRunJSON(t, env.gopts, func(gopts GlobalOptions) error {
+		// enable the status messages
+		gopts.Quiet = false
+		return runCheck(CheckOptions{ReadData: true}, gopts, nil)
+	})
+	summary := lastJSONSummary(t, msgs)
+	rtest.Equals(t, float64(0), summary["num_errors"])
+	rtest.Equals(t, "status", msgs[len(msgs)-2]["message_type"])
+	rtest.Equals(t, "packs", msgs[len(msgs)-2]["action"])
+	rtest.Equals(t, summary["read_packs"], msgs[len(msgs)-2]["done"])
+
+	msgs = testRunJSON(t, env.gopts, func(gopts GlobalOptions) error {
+		return runDiff(DiffOptions{}, gopts, []string{firstSnapshotID, secondSnapshotID})
+	})
+	var changes []string
+	for _, msg := range msgs[:len(msgs)-1] {
+		rtest.Equals(t, "change", msg["message_type"])
+		changes = append(changes, fmt.Sprintf("%v %v", msg["modifier"], msg["path"]))
+	}
+	rtest.Assert(t, len(changes) == 1 && strings.HasSuffix(changes[0], "/testdata/new-file"),
+		"unexpected changes %v", changes)
+	rtest.Equals(t, float64(1), lastJSONSummary(t, msgs)["added"].(map[string]interface{})["files"])
+
+	msgs = testRunJSON(t, env.gopts, func(gopts GlobalOptions) error {
+		return runTag(TagOptions{AddTags: []string{"foo"}}, gopts, nil)
+	})
+	rtest.Equals(t, float64(2), lastJSONSummary(t, msgs)["changed_snapshots"])
+	rtest.Equals(t, "modified", msgs[0]["action"])
+
+	msgs = testRunJSON(t, env.gopts, func(gopts GlobalOptions) error {
+		return runRestore(RestoreOptions{Target: filepath.Join(env.base, "restore"), Verify: true}, gopts, []string{"latest"})
+	})
+	summary = lastJSONSummary(t, msgs)
+	rtest.Equals(t, float64(0), summary["total_errors"])
+	rtest.Assert(t, summary["files_verified"].(float64) > 0, "no files were verified")
+
+	msgs = testRunJSON(t, env.gopts, runRebuildIndex)
+	rtest.Equals(t, 1, len(lastJSONSummary(t, msgs)["saved_indexes"].([]interface{})))
+
+	testRunForget(t, env.gopts, testRunList(t, "snapshots", env.gopts)[0].String())
+	msgs = testRunJSON(t, env.gopts, func(gopts GlobalOptions) error {
+		return runPrune(PruneOptions{MaxUnused: "0"}, gopts)
+	})
+	summary = lastJSONSummary(t, msgs)
+	removed := summary["remove"].(map[string]interface{})["blobs"].(float64) +
+		summary["repack_remove"].(map[string]interface{})["blobs"].(float64)
+	rtest.Assert(t, removed > 0, "prune removed no blobs")
+	testRunCheck(t, env.gopts)
+}
+
+func testRunRewriteExclude(t testing.TB, gopts GlobalOptions, excludes []string, forget bool) {
+	opts := RewriteOptions{
+		Excludes: excludes,
+		Forget:   forget,
+	}
+
+	rtest.OK(t, runRewrite(opts, gopts, nil))
+}
+
+func createBasicRewriteRepo(t testing.TB, env *testEnvironment) restic.ID {
+	testSetupBackupData(t, env)
+
+	// create backup
+	testRunBackup(t, filepath.Dir(env.testdata), []string{"testdata"}, BackupOptions{}, env.gopts)
+	snapshotIDs := testRunList(t, "snapshots", env.gopts)
+	rtest.Assert(t, len(snapshotIDs) == 1, "expected one snapshot, got %v", snapshotIDs)
+	testRunCheck(t, env.gopts)
+
+	return snapshotIDs[0]
+}
+
+func countExcluded(files []string, name string) int {
+	n := 0
+	for _, file := range files {
+		if filepath.Base(file) == name {
+			n++
+		}
+	}
+	return n
+}
+
+func TestRewrite(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+	originalID := createBasicRewriteRepo(t, env)
+	rtest.Assert(t, countExcluded(testRunLs(t, env.gopts, originalID.String()), "3") > 0,
+		"test data does not contain the excluded files")
+
+	// exclude some data
+	testRunRewriteExclude(t, env.gopts, []string{"3"}, false)
+	snapshotIDs := testRunList(t, "snapshots", env.gopts)
+	rtest.Assert(t, len(snapshotIDs) == 2, "expected two snapshots, got %v", snapshotIDs)
+	testRunCheck(t, env.gopts)
+
+	for _, id := range snapshotIDs {
+		if id.Equal(originalID) {
+			continue
+		}
+
+		rtest.Equals(t, 0, countExcluded(testRunLs(t, env.gopts, id.String()), "3"))
+
+		repo, err := OpenRepository(env.gopts)
+		rtest.OK(t, err)
+		sn, err := restic.LoadSnapshot(env.gopts.ctx, repo, id)
+		rtest.OK(t, err)
+		rtest.Assert(t, sn.Original != nil && sn.Original.Equal(originalID),
+			"new snapshot does not reference the original snapshot")
+	}
+
+	// only the original snapshot still contains the excluded files
+	testRunRewriteExclude(t, env.gopts, []string{"3"}, false)
+	snapshotIDs = testRunList(t, "snapshots", env.gopts)
+	rtest.Assert(t, len(snapshotIDs) == 3, "expected three snapshots, got %v", snapshotIDs)
+}
+
+func TestRewriteForget(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+	originalID := createBasicRewriteRepo(t, env)
+
+	// exclude some data and remove the original snapshot
+	testRunRewriteExclude(t, env.gopts, []string{"3"}, true)
+	snapshotIDs := testRunList(t, "snapshots", env.gopts)
+	rtest.Assert(t, len(snapshotIDs) == 1, "expected one snapshot, got %v", snapshotIDs)
+	rtest.Assert(t, !snapshotIDs[0].Equal(originalID), "original snapshot was not removed")
+	// the data of the excluded files is unused now
+	rtest.OK(t, runCheck(CheckOptions{ReadData: true}, env.gopts, nil))
+}
+
+func TestRewriteDryRun(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+	createBasicRewriteRepo(t, env)
+	packsBefore := listPacks(env.gopts, t)
+
+	opts := RewriteOptions{
+		Excludes: []string{"3"},
+		Forget:   true,
+		DryRun:   true,
+	}
+	rtest.OK(t, runRewrite(opts, env.gopts, nil))
+
+	snapshotIDs := testRunList(t, "snapshots", env.gopts)
+	rtest.Assert(t, len(snapshotIDs) == 1, "expected one snapshot, got %v", snapshotIDs)
+	rtest.Assert(t, packsBefore.Equals(listPacks(env.gopts, t)),
+		"dry run of rewrite modified the pack files")
+}
+
+func testRunRepairIndex(t testing.TB, gopts GlobalOptions) {
+	globalOptions.stdout = ioutil.Discard
+	defer func() {
+		globalOptions.stdout = os.Stdout
+	}()
+
+	rtest.OK(t, runRepairIndex(gopts))
+}
+
+func testRunRepairSnapshots(t testing.TB, gopts GlobalOptions, forget bool) {
+	globalOptions.stdout = ioutil.Discard
+	defer func() {
+		globalOptions.stdout = os.Stdout
+	}()
+
+	rtest.OK(t, runRepairSnapshots(RepairSnapshotsOptions{Forget: forget}, gopts, nil))
+}
+
+func TestRepairSnapshotsMissingData(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+	originalID := createBasicRewriteRepo(t, env)
+
+	// lose all file contents
+	removeDataPacksExcept(env.gopts, t, restic.NewIDSet())
+	_, err := testRunCheckOutput(env.gopts)
+	rtest.Assert(t, err != nil, "expected check to fail for damaged repository")
+
+	testRunRepairIndex(t, env.gopts)
+	testRunRepairSnapshots(t, env.gopts, true)
+
+	snapshotIDs := testRunList(t, "snapshots", env.gopts)
+	rtest.Assert(t, len(snapshotIDs) == 1, "expected one snapshot, got %v", snapshotIDs)
+	rtest.Assert(t, !snapshotIDs[0].Equal(originalID), "damaged snapshot was not removed")
+
+	repo, err := OpenRepository(env.gopts)
+	rtest.OK(t, err)
+	sn, err := restic.LoadSnapshot(env.gopts.ctx, repo, snapshotIDs[0])
+	rtest.OK(t, err)
+	rtest.Assert(t, sn.HasTags([]string{repairedTag}), "repaired snapshot is missing the %q tag", repairedTag)
+	rtest.Assert(t, sn.Original != nil && sn.Original.Equal(originalID),
+		"repaired snapshot does not reference the damaged snapshot")
+
+	rtest.OK(t, runCheck(CheckOptions{ReadData: true}, env.gopts, nil))
+
+	// the repaired snapshot is not modified again
+	testRunRepairSnapshots(t, env.gopts, false)
+	rtest.Equals(t, snapshotIDs, testRunList(t, "snapshots", env.gopts))
+}
+
+func TestRepairIndexTruncatedPack(t *testing.T) {
+	var tests = []struct {
+		name     string
+		truncate func(size int64) int64
+		removed  bool
+	}{
+		// only the header length is damaged, all blobs can be salvaged
+		{"header", func(size int64) int64 { return size - 1 }, true},
+		// blobs are lost, so the pack file must be kept
+		{"data", func(size int64) int64 { return size / 2 }, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			env, cleanup := withTestEnvironment(t)
+			defer cleanup()
+			createBasicRewriteRepo(t, env)
+
+			// truncate a pack file, so that its header cannot be read
+			packs := listPacks(env.gopts, t)
+			var packID restic.ID
+			for id := range packs {
+				packID = id
+				break
+			}
+			packFile := filepath.Join(env.repo, "data", packID.String()[:2], packID.String())
+			fi, err := os.Stat(packFile)
+			rtest.OK(t, err)
+			rtest.OK(t, os.Chmod(packFile, 0644))
+			rtest.OK(t, os.Truncate(packFile, test.truncate(fi.Size())))
+
+			testRunRepairIndex(t, env.gopts)
+			rtest.Assert(t, listPacks(env.gopts, t).Has(packID) != test.removed,
+				"damaged pack file removed: expected %v", test.removed)
+
+			testRunRepairSnapshots(t, env.gopts, true)
+			rtest.OK(t, runCheck(CheckOptions{ReadData: true}, env.gopts, nil))
+		})
+	}
+}
+
+func TestInitPackSize(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+
+	for _, size := range []string{"1M", "1G", "foo", "-5"} {
+		gopts := env.gopts
+		gopts.PackSize = size
+		rtest.Assert(t, runInit(InitOptions{}, gopts, nil) != nil, "expected invalid pack size %q to fail", size)
+	}
+
+	gopts := env.gopts
+	gopts.PackSize = "16M"
+	rtest.Assert(t, runInit(InitOptions{RepositoryVersion: "1"}, gopts, nil) != nil,
+		"expected pack size to fail for repository version 1")
+	rtest.OK(t, runInit(InitOptions{}, gopts, nil))
+
+	// the pack size is stored in the config and used by default
+	repo, err := OpenRepository(env.gopts)
+	rtest.OK(t, err)
+	rtest.Equals(t, uint(16*1024*1024), repo.Config().PackSize)
+	rtest.Equals(t, uint(16*1024*1024), repo.PackSize())
+
+	// but can be overridden for a single run
+	gopts.PackSize = "32m"
+	repo, err = OpenRepository(gopts)
+	rtest.OK(t, err)
+	rtest.Equals(t, uint(32*1024*1024), repo.PackSize())
+
+	gopts.PackSize = "256M"
+	_, err = OpenRepository(gopts)
+	rtest.Assert(t, err != nil, "expected too large pack size to fail")
+}
+
+func TestIndexCompact(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+
+	testSetupBackupData(t, env)
+	for i := 0; i < 3; i++ {
+		rtest.OK(t, appendRandomData(filepath.Join(env.testdata, "0", "0", "9", "0"), 1024*1024))
+		testRunBackup(t, "", []string{env.testdata}, BackupOptions{}, env.gopts)
+	}
+
+	indexIDs := testRunList(t, "index", env.gopts)
+	rtest.Assert(t, len(indexIDs) >= 3, "expected at least three index files, got %v", indexIDs)
+	packIDs := testRunList(t, "packs", env.gopts)
+
+	rtest.OK(t, runIndexCompact(env.gopts))
+	compacted := testRunList(t, "index", env.gopts)
+	rtest.Equals(t, 1, len(compacted))
+	testRunCheck(t, env.gopts)
+
+	// the pack files are not touched
+	rtest.Equals(t, restic.NewIDSet(packIDs...), restic.NewIDSet(testRunList(t, "packs", env.gopts)...))
+
+	// the new index supersedes all old index files
+	repo, err := OpenRepository(env.gopts)
+	rtest.OK(t, err)
+	rtest.OK(t, repo.LoadIndex(context.TODO()))
+	idx := repo.Index().(*repository.MasterIndex).All()[0]
+	rtest.Equals(t, restic.NewIDSet(indexIDs...), restic.NewIDSet(idx.Supersedes()...))
+
+	// compacting again does nothing
+	rtest.OK(t, runIndexCompact(env.gopts))
+	rtest.Equals(t, compacted, testRunList(t, "index", env.gopts))
+}
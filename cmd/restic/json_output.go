@@ -0,0 +1,30 @@
+package main
+
+import (
+	"github.com/restic/restic/internal/ui/json"
+)
+
+// printJSON writes msg with the given message type as a single line to
+// stdout.
+func printJSON(messageType string, msg json.Message) {
+	err := json.Print(globalOptions.stdout, messageType, msg)
+	if err != nil {
+		Warnf("unable to write JSON message: %v\n", err)
+	}
+}
+
+// printJSONError writes an error message to stderr. During describes the
+// operation which failed, item is the object the error is about and may be
+// empty.
+func printJSONError(during, item string, err error) {
+	msg := &json.ErrorMessage{
+		Error:  err.Error(),
+		During: during,
+		Item:   item,
+	}
+
+	e := json.Print(globalOptions.stderr, json.TypeError, msg)
+	if e != nil {
+		Warnf("unable to write JSON message: %v\n", e)
+	}
+}
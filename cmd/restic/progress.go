@@ -5,6 +5,7 @@ import (
 	"time"
 
 	"github.com/restic/restic/internal/restic"
+	"github.com/restic/restic/internal/ui/json"
 )
 
 // newProgressMax returns a progress that counts blobs.
@@ -15,6 +16,32 @@ func newProgressMax(show bool, max uint64, description string) *restic.Progress
 
 	p := restic.NewProgress()
 
+	if globalOptions.JSON {
+		status := func(s restic.Stat, d time.Duration) {
+			msg := &json.StatusMessage{
+				Action:         description,
+				SecondsElapsed: uint64(d / time.Second),
+				Total:          max,
+				Done:           s.Blobs,
+			}
+			if max > 0 {
+				msg.PercentDone = float64(s.Blobs) / float64(max)
+			}
+			printJSON(json.TypeStatus, msg)
+		}
+
+		// only print a status message every tick and when done
+		p.OnUpdate = func(s restic.Stat, d time.Duration, ticker bool) {
+			if ticker {
+				status(s, d)
+			}
+		}
+		p.OnDone = func(s restic.Stat, d time.Duration, ticker bool) {
+			status(s, d)
+		}
+		return p
+	}
+
 	p.OnUpdate = func(s restic.Stat, d time.Duration, ticker bool) {
 		status := fmt.Sprintf("[%s] %s  %d / %d %s",
 			formatDuration(d),
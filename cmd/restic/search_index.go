@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/repository"
+	"github.com/restic/restic/internal/restic"
+	"github.com/restic/restic/internal/searchindex"
+)
+
+// openSearchIndex opens the local search index, which is stored in the cache
+// directory of the repository.
+func openSearchIndex(repo *repository.Repository) (*searchindex.Index, error) {
+	if repo.Cache == nil {
+		return nil, errors.Fatal("the search index is stored in the cache and cannot be used with --no-cache")
+	}
+
+	return searchindex.Open(filepath.Join(repo.Cache.Dir(), "search"), repo.Key())
+}
+
+// addToSearchIndex adds the snapshot sn to the search index if it is not
+// yet indexed.
+func addToSearchIndex(ctx context.Context, repo restic.Repository, idx *searchindex.Index, sn *restic.Snapshot) error {
+	if idx.Has(*sn.ID()) {
+		return nil
+	}
+
+	Verbosef("adding snapshot %s to the search index\n", sn.ID().Str())
+	return idx.Add(ctx, repo, sn)
+}
+
+// saveSearchIndex saves the snapshots added to the search index and removes
+// the snapshots which no longer exist in the repository. As the index is only
+// a cache, errors are printed as warnings.
+func saveSearchIndex(ctx context.Context, repo restic.Repository, idx *searchindex.Index) {
+	current := restic.NewIDSet()
+	err := repo.List(ctx, restic.SnapshotFile, func(id restic.ID, size int64) error {
+		current.Insert(id)
+		return nil
+	})
+	if err == nil {
+		err = idx.Save(current)
+	}
+
+	if err != nil {
+		Warnf("unable to save search index: %v\n", err)
+	}
+}
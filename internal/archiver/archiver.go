@@ -97,6 +97,13 @@ type Options struct {
 	// SaveTreeConcurrency sets how many trees are marshalled and saved to the
 	// repo concurrently.
 	SaveTreeConcurrency uint
+
+	// CheckpointInterval sets how often the index for all pack files which
+	// have been uploaded so far is written to the repo while a snapshot is
+	// created. An interrupted backup leaves these pack files in the
+	// repository, so a later backup can reuse them instead of uploading the
+	// data again. Checkpoints are disabled if the interval is zero.
+	CheckpointInterval time.Duration
 }
 
 // ApplyDefaults returns a copy of o with the default options set for all unset
@@ -365,7 +372,7 @@ func (arch *Archiver) Save(ctx context.Context, snPath, target string, previous
 
 		// check if the file has not changed before performing a fopen operation (more expensive, specially
 		// in network filesystems)
-		if previous != nil && !fileChanged(fi, previous, arch.IgnoreInode) {
+		if previous != nil && !FileChanged(fi, previous, arch.IgnoreInode) {
 			if arch.allBlobsPresent(previous) {
 				debug.Log("%v hasn't changed, using old list of blobs", target)
 				arch.CompleteItem(snPath, previous, previous, ItemStats{}, time.Since(start))
@@ -464,9 +471,9 @@ func (arch *Archiver) Save(ctx context.Context, snPath, target string, previous
 	return fn, false, nil
 }
 
-// fileChanged returns true if the file's content has changed since the node
+// FileChanged returns true if the file's content has changed since the node
 // was created.
-func fileChanged(fi os.FileInfo, node *restic.Node, ignoreInode bool) bool {
+func FileChanged(fi os.FileInfo, node *restic.Node, ignoreInode bool) bool {
 	if node == nil {
 		return true
 	}
@@ -742,11 +749,54 @@ func (arch *Archiver) runWorkers(ctx context.Context, t *tomb.Tomb) {
 	arch.fileSaver = NewFileSaver(ctx, t,
 		arch.blobSaver.Save,
 		arch.Repo.Config().ChunkerPolynomial,
+		arch.Repo.Config().ChunkerParams(),
 		arch.Options.FileReadConcurrency, arch.Options.SaveBlobConcurrency)
 	arch.fileSaver.CompleteBlob = arch.CompleteBlob
 	arch.fileSaver.NodeFromFileInfo = arch.nodeFromFileInfo
 
 	arch.treeSaver = NewTreeSaver(ctx, t, arch.Options.SaveTreeConcurrency, arch.saveTree, arch.Error)
+
+	if arch.Options.CheckpointInterval > 0 {
+		t.Go(func() error {
+			return arch.saveCheckpoints(t.Context(ctx), arch.Options.CheckpointInterval)
+		})
+	}
+}
+
+// saveCheckpoints periodically writes the index for all pack files uploaded
+// so far to the repo, until ctx is cancelled.
+func (arch *Archiver) saveCheckpoints(ctx context.Context, interval time.Duration) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			debug.Log("saving checkpoint index")
+			err := arch.Repo.SaveIndex(ctx)
+			if err != nil && ctx.Err() == nil {
+				return err
+			}
+		}
+	}
+}
+
+// checkpointTimeout limits how long saving the remaining data after an
+// aborted snapshot may take.
+const checkpointTimeout = time.Minute
+
+// saveAbortedCheckpoint uploads all blobs which have been saved so far
+// together with their index, so that a subsequent backup does not need to
+// upload them again. This is done on a best effort basis, errors are ignored.
+func (arch *Archiver) saveAbortedCheckpoint() {
+	// the original context is probably cancelled already
+	ctx, cancel := context.WithTimeout(context.Background(), checkpointTimeout)
+	defer cancel()
+
+	err := arch.Repo.Flush(ctx)
+	debug.Log("saving data for aborted snapshot returned %v", err)
 }
 
 // Snapshot saves several targets and returns a snapshot.
@@ -792,6 +842,7 @@ func (arch *Archiver) Snapshot(ctx context.Context, targets []string, opts Snaps
 
 	if err != nil {
 		debug.Log("error while saving tree: %v", err)
+		arch.saveAbortedCheckpoint()
 		return nil, restic.ID{}, err
 	}
 
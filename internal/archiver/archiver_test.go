@@ -15,6 +15,7 @@ import (
 	"time"
 
 	"github.com/google/go-cmp/cmp"
+	"github.com/restic/restic/internal/backend/mem"
 	"github.com/restic/restic/internal/checker"
 	"github.com/restic/restic/internal/errors"
 	"github.com/restic/restic/internal/fs"
@@ -91,7 +92,7 @@ func saveFile(t testing.TB, repo restic.Repository, filename string, filesystem
 		t.Fatal(err)
 	}
 
-	err = repo.Flush(ctx)
+	err = repo.Flush(context.Background())
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -649,7 +650,7 @@ func TestFileChanged(t *testing.T) {
 			fiBefore := lstat(t, filename)
 			node := nodeFromFI(t, filename, fiBefore)
 
-			if fileChanged(fiBefore, node, false) {
+			if FileChanged(fiBefore, node, false) {
 				t.Fatalf("unchanged file detected as changed")
 			}
 
@@ -659,12 +660,12 @@ func TestFileChanged(t *testing.T) {
 
 			if test.SameFile {
 				// file should be detected as unchanged
-				if fileChanged(fiAfter, node, test.IgnoreInode) {
+				if FileChanged(fiAfter, node, test.IgnoreInode) {
 					t.Fatalf("unmodified file detected as changed")
 				}
 			} else {
 				// file should be detected as changed
-				if !fileChanged(fiAfter, node, test.IgnoreInode) && !test.SameFile {
+				if !FileChanged(fiAfter, node, test.IgnoreInode) && !test.SameFile {
 					t.Fatalf("modified file detected as unchanged")
 				}
 			}
@@ -682,7 +683,7 @@ func TestFilChangedSpecialCases(t *testing.T) {
 
 	t.Run("nil-node", func(t *testing.T) {
 		fi := lstat(t, filename)
-		if !fileChanged(fi, nil, false) {
+		if !FileChanged(fi, nil, false) {
 			t.Fatal("nil node detected as unchanged")
 		}
 	})
@@ -691,7 +692,7 @@ func TestFilChangedSpecialCases(t *testing.T) {
 		fi := lstat(t, filename)
 		node := nodeFromFI(t, filename, fi)
 		node.Type = "symlink"
-		if !fileChanged(fi, node, false) {
+		if !FileChanged(fi, node, false) {
 			t.Fatal("node with changed type detected as unchanged")
 		}
 	})
@@ -846,7 +847,7 @@ func TestArchiverSaveDir(t *testing.T) {
 				t.Fatal(err)
 			}
 
-			err = repo.Flush(ctx)
+			err = repo.Flush(context.Background())
 			if err != nil {
 				t.Fatal(err)
 			}
@@ -934,7 +935,7 @@ func TestArchiverSaveDirIncremental(t *testing.T) {
 
 		t.Logf("node subtree %v", node.Subtree)
 
-		err = repo.Flush(ctx)
+		err = repo.Flush(context.Background())
 		if err != nil {
 			t.Fatal(err)
 		}
@@ -1091,7 +1092,7 @@ func TestArchiverSaveTree(t *testing.T) {
 				t.Fatal(err)
 			}
 
-			err = repo.Flush(ctx)
+			err = repo.Flush(context.Background())
 			if err != nil {
 				t.Fatal(err)
 			}
@@ -1956,6 +1957,118 @@ func TestArchiverAbortEarlyOnError(t *testing.T) {
 	}
 }
 
+func TestArchiverAbortSavesCheckpoint(t *testing.T) {
+	var testErr = errors.New("test error")
+
+	src := TestDir{
+		"dir": TestDir{
+			"file1": TestFile{Content: string(restictest.Random(1, 1024))},
+			"file2": TestFile{Content: string(restictest.Random(2, 1024))},
+			"file3": TestFile{Content: string(restictest.Random(3, 1024))},
+			"file4": TestFile{Content: string(restictest.Random(4, 1024))},
+			"file5": TestFile{Content: string(restictest.Random(5, 1024))},
+		},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	tempdir, repo, cleanup := prepareTempdirRepoSrc(t, src)
+	defer cleanup()
+
+	back := restictest.Chdir(t, tempdir)
+	defer back()
+
+	testRepo := &failSaveRepo{
+		Repository: repo,
+		failAfter:  3,
+		err:        testErr,
+	}
+
+	arch := New(testRepo, fs.Track{FS: fs.Local{}}, Options{})
+	_, _, err := arch.Snapshot(ctx, []string{"."}, SnapshotOptions{Time: time.Now()})
+	if errors.Cause(err) != testErr {
+		t.Fatalf("expected error (%v) not found, got %v", testErr, errors.Cause(err))
+	}
+
+	// the blobs saved before the error must be referenced by an index file in
+	// the repo, so that the next backup can reuse them
+	blobs := restic.NewBlobSet()
+	err = repo.List(ctx, restic.IndexFile, func(id restic.ID, size int64) error {
+		idx, err := repository.LoadIndex(ctx, repo, id)
+		if err != nil {
+			return err
+		}
+
+		for pb := range idx.Each(ctx) {
+			blobs.Insert(restic.BlobHandle{ID: pb.ID, Type: pb.Type})
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(blobs) != 2 {
+		t.Fatalf("expected two blobs in the index files, got %v", blobs)
+	}
+
+	for h := range blobs {
+		if !repo.Index().Has(h.ID, h.Type) {
+			t.Errorf("blob %v is not in the index", h)
+		}
+	}
+}
+
+// slowBackend delays saving files and fails if the context is cancelled in the
+// meantime.
+type slowBackend struct {
+	restic.Backend
+}
+
+func (be *slowBackend) Save(ctx context.Context, h restic.Handle, rd restic.RewindReader) error {
+	time.Sleep(50 * time.Millisecond)
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return be.Backend.Save(ctx, h, rd)
+}
+
+// Regression test: packs which are still being uploaded when all files were
+// saved must not be aborted.
+func TestArchiverSlowBackend(t *testing.T) {
+	src := TestDir{
+		"dir": TestDir{
+			"file1": TestFile{Content: string(restictest.Random(1, 6*1024*1024))},
+			"file2": TestFile{Content: string(restictest.Random(2, 6*1024*1024))},
+		},
+	}
+
+	tempdir, removeTempdir := restictest.TempDir(t)
+	defer removeTempdir()
+	TestCreateFiles(t, tempdir, src)
+
+	repo, cleanup := repository.TestRepositoryWithBackend(t, &slowBackend{Backend: mem.New()})
+	defer cleanup()
+	// upload packs while the files are saved
+	restictest.OK(t, repo.(*repository.Repository).SetPackSize(restic.MinPackSize))
+
+	back := restictest.Chdir(t, tempdir)
+	defer back()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	arch := New(repo, fs.Track{FS: fs.Local{}}, Options{})
+	_, snapshotID, err := arch.Snapshot(ctx, []string{"."}, SnapshotOptions{Time: time.Now()})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	TestEnsureSnapshot(t, repo, snapshotID, src)
+	checker.TestCheckRepo(t, repo)
+}
+
 func snapshot(t testing.TB, repo restic.Repository, fs fs.FS, parent restic.ID, filename string) (restic.ID, *restic.Node) {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
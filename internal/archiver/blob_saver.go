@@ -12,12 +12,16 @@ import (
 type Saver interface {
 	SaveBlob(ctx context.Context, t restic.BlobType, data []byte, id restic.ID, storeDuplicate bool) (restic.ID, bool, error)
 	Index() restic.MasterIndex
+	Config() restic.Config
 }
 
 // BlobSaver concurrently saves incoming blobs to the repo.
 type BlobSaver struct {
 	repo Saver
 	ch   chan<- saveBlobJob
+
+	// zeroChunkSize is the size of the chunks which only contain zeros
+	zeroChunkSize int
 }
 
 // NewBlobSaver returns a new blob. A worker pool is started, it is stopped
@@ -25,8 +29,9 @@ type BlobSaver struct {
 func NewBlobSaver(ctx context.Context, t *tomb.Tomb, repo Saver, workers uint) *BlobSaver {
 	ch := make(chan saveBlobJob)
 	s := &BlobSaver{
-		repo: repo,
-		ch:   ch,
+		repo:          repo,
+		ch:            ch,
+		zeroChunkSize: int(repo.Config().ChunkerParams().MinSize),
 	}
 
 	for i := uint(0); i < workers; i++ {
@@ -99,7 +104,14 @@ type saveBlobResponse struct {
 }
 
 func (s *BlobSaver) saveBlob(ctx context.Context, t restic.BlobType, buf []byte) (saveBlobResponse, error) {
-	id, known, err := s.repo.SaveBlob(ctx, t, buf, restic.ID{}, false)
+	var id restic.ID
+	// holes in sparse files are split into many chunks which only contain
+	// zeros, checking that is much cheaper than hashing them
+	if t == restic.DataBlob && len(buf) == s.zeroChunkSize && restic.ZeroPrefixLen(buf) == len(buf) {
+		id = restic.ZeroChunk(uint(s.zeroChunkSize))
+	}
+
+	id, known, err := s.repo.SaveBlob(ctx, t, buf, id, false)
 
 	if err != nil {
 		return saveBlobResponse{}, err
@@ -34,6 +34,10 @@ func (b *saveFail) Index() restic.MasterIndex {
 	return b.idx
 }
 
+func (b *saveFail) Config() restic.Config {
+	return restic.Config{}
+}
+
 func TestBlobSaver(t *testing.T) {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
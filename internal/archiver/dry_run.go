@@ -0,0 +1,93 @@
+package archiver
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/restic"
+)
+
+// DryRunRepository wraps a repository for a dry run of a backup. Blobs are
+// only looked up in the index of the underlying repository and never
+// uploaded, nothing is written to the repository at all. All other methods are
+// passed through, so the parent snapshot can still be loaded.
+type DryRunRepository struct {
+	restic.Repository
+
+	m     sync.Mutex
+	known restic.BlobSet
+}
+
+// NewDryRunRepository returns a new wrapper around repo.
+func NewDryRunRepository(repo restic.Repository) *DryRunRepository {
+	return &DryRunRepository{
+		Repository: repo,
+		known:      restic.NewBlobSet(),
+	}
+}
+
+// SaveBlob computes the ID of the blob and reports whether it is already
+// contained in the repository or has been saved before during this run.
+func (r *DryRunRepository) SaveBlob(ctx context.Context, t restic.BlobType, buf []byte, id restic.ID, storeDuplicate bool) (restic.ID, bool, error) {
+	if id.IsNull() {
+		id = restic.Hash(buf)
+	}
+
+	if r.Index().Has(id, t) {
+		return id, true, nil
+	}
+
+	h := restic.BlobHandle{ID: id, Type: t}
+
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	if r.known.Has(h) {
+		return id, true, nil
+	}
+	r.known.Insert(h)
+
+	debug.Log("dry run: would save %v", h)
+	return id, false, nil
+}
+
+// SaveTree computes the ID of the tree, the encoding must match
+// Repository.SaveTree.
+func (r *DryRunRepository) SaveTree(ctx context.Context, t *restic.Tree) (restic.ID, error) {
+	buf, err := json.Marshal(t)
+	if err != nil {
+		return restic.ID{}, errors.Wrap(err, "MarshalJSON")
+	}
+	buf = append(buf, '\n')
+
+	id, _, err := r.SaveBlob(ctx, restic.TreeBlob, buf, restic.ID{}, false)
+	return id, err
+}
+
+// SaveUnpacked does nothing, the returned ID is null.
+func (r *DryRunRepository) SaveUnpacked(ctx context.Context, t restic.FileType, buf []byte) (restic.ID, error) {
+	return restic.ID{}, nil
+}
+
+// SaveJSONUnpacked does nothing, the returned ID is null.
+func (r *DryRunRepository) SaveJSONUnpacked(ctx context.Context, t restic.FileType, item interface{}) (restic.ID, error) {
+	return restic.ID{}, nil
+}
+
+// Flush does nothing.
+func (r *DryRunRepository) Flush(ctx context.Context) error {
+	return nil
+}
+
+// SaveIndex does nothing.
+func (r *DryRunRepository) SaveIndex(ctx context.Context) error {
+	return nil
+}
+
+// SaveFullIndex does nothing.
+func (r *DryRunRepository) SaveFullIndex(ctx context.Context) error {
+	return nil
+}
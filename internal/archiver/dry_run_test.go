@@ -0,0 +1,89 @@
+package archiver
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/restic/restic/internal/fs"
+	"github.com/restic/restic/internal/restic"
+	restictest "github.com/restic/restic/internal/test"
+)
+
+func countFiles(t testing.TB, repo restic.Repository, tpe restic.FileType) int {
+	n := 0
+	err := repo.List(context.TODO(), tpe, func(restic.ID, int64) error {
+		n++
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func TestArchiverDryRun(t *testing.T) {
+	src := TestDir{
+		"dir": TestDir{
+			"file1": TestFile{Content: string(restictest.Random(1, 1024))},
+			"file2": TestFile{Content: string(restictest.Random(2, 1024))},
+			// duplicate content is only counted once
+			"file3": TestFile{Content: string(restictest.Random(2, 1024))},
+		},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	tempdir, repo, cleanup := prepareTempdirRepoSrc(t, src)
+	defer cleanup()
+
+	back := restictest.Chdir(t, tempdir)
+	defer back()
+
+	dryRun := func(parent restic.ID) ItemStats {
+		var m sync.Mutex
+		var stats ItemStats
+
+		arch := New(NewDryRunRepository(repo), fs.Track{FS: fs.Local{}}, Options{})
+		arch.CompleteItem = func(item string, previous, current *restic.Node, s ItemStats, d time.Duration) {
+			m.Lock()
+			stats.Add(s)
+			m.Unlock()
+		}
+
+		_, id, err := arch.Snapshot(ctx, []string{"."}, SnapshotOptions{Time: time.Now(), ParentSnapshot: parent})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !id.IsNull() {
+			t.Errorf("dry run returned snapshot ID %v", id)
+		}
+
+		return stats
+	}
+
+	stats := dryRun(restic.ID{})
+	if stats.DataBlobs != 2 || stats.DataSize == 0 {
+		t.Errorf("wrong stats for first dry run: %+v", stats)
+	}
+
+	for _, tpe := range []restic.FileType{restic.PackFile, restic.IndexFile, restic.SnapshotFile} {
+		if n := countFiles(t, repo, tpe); n != 0 {
+			t.Errorf("dry run saved %d files of type %v", n, tpe)
+		}
+	}
+
+	// after a real backup, nothing would be added anymore
+	arch := New(repo, fs.Track{FS: fs.Local{}}, Options{})
+	_, id, err := arch.Snapshot(ctx, []string{"."}, SnapshotOptions{Time: time.Now()})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stats = dryRun(id)
+	if stats.DataBlobs != 0 || stats.TreeBlobs != 0 {
+		t.Errorf("wrong stats for dry run after backup: %+v", stats)
+	}
+}
@@ -56,7 +56,8 @@ type FileSaver struct {
 	saveFilePool *BufferPool
 	saveBlob     SaveBlobFn
 
-	pol chunker.Pol
+	pol    chunker.Pol
+	params restic.ChunkerParams
 
 	ch chan<- saveFileJob
 
@@ -65,9 +66,10 @@ type FileSaver struct {
 	NodeFromFileInfo func(filename string, fi os.FileInfo) (*restic.Node, error)
 }
 
-// NewFileSaver returns a new file saver. A worker pool with fileWorkers is
-// started, it is stopped when ctx is cancelled.
-func NewFileSaver(ctx context.Context, t *tomb.Tomb, save SaveBlobFn, pol chunker.Pol, fileWorkers, blobWorkers uint) *FileSaver {
+// NewFileSaver returns a new file saver, which splits files into chunks using
+// the polynomial pol and the parameters params. A worker pool with
+// fileWorkers is started, it is stopped when ctx is cancelled.
+func NewFileSaver(ctx context.Context, t *tomb.Tomb, save SaveBlobFn, pol chunker.Pol, params restic.ChunkerParams, fileWorkers, blobWorkers uint) *FileSaver {
 	ch := make(chan saveFileJob)
 
 	debug.Log("new file saver with %v file workers and %v blob workers", fileWorkers, blobWorkers)
@@ -76,8 +78,9 @@ func NewFileSaver(ctx context.Context, t *tomb.Tomb, save SaveBlobFn, pol chunke
 
 	s := &FileSaver{
 		saveBlob:     save,
-		saveFilePool: NewBufferPool(ctx, int(poolSize), chunker.MaxSize),
+		saveFilePool: NewBufferPool(ctx, int(poolSize), int(params.MaxSize)),
 		pol:          pol,
+		params:       params,
 		ch:           ch,
 
 		CompleteBlob: func(string, uint64) {},
@@ -156,7 +159,7 @@ func (s *FileSaver) saveFile(ctx context.Context, chnker *chunker.Chunker, snPat
 	}
 
 	// reuse the chunker
-	chnker.Reset(f, s.pol)
+	s.params.ResetChunker(chnker, f, s.pol)
 
 	var results []FutureBlob
 
@@ -222,7 +225,7 @@ func (s *FileSaver) saveFile(ctx context.Context, chnker *chunker.Chunker, snPat
 
 func (s *FileSaver) worker(ctx context.Context, jobs <-chan saveFileJob) {
 	// a worker has one chunker which is reused for each file (because it contains a rather large buffer)
-	chnker := chunker.New(nil, s.pol)
+	chnker := s.params.NewChunker(nil, s.pol)
 
 	for {
 		var job saveFileJob
@@ -45,7 +45,7 @@ func startFileSaver(ctx context.Context, t testing.TB) (*FileSaver, context.Cont
 		t.Fatal(err)
 	}
 
-	s := NewFileSaver(ctx, tmb, saveBlob, pol, workers, workers)
+	s := NewFileSaver(ctx, tmb, saveBlob, pol, restic.DefaultChunkerParams, workers, workers)
 	s.NodeFromFileInfo = restic.NodeFromFileInfo
 
 	return s, ctx, tmb
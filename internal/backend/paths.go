@@ -9,6 +9,7 @@ var Paths = struct {
 	Index     string
 	Locks     string
 	Keys      string
+	Policies  string
 	Temp      string
 	Config    string
 }{
@@ -17,6 +18,7 @@ var Paths = struct {
 	"index",
 	"locks",
 	"keys",
+	"policies",
 	"tmp",
 	"config",
 }
@@ -446,7 +446,8 @@ func (b *Backend) Delete(ctx context.Context) error {
 		restic.KeyFile,
 		restic.LockFile,
 		restic.SnapshotFile,
-		restic.IndexFile}
+		restic.IndexFile,
+		restic.PolicyFile}
 
 	for _, t := range alltypes {
 		err := b.removeKeys(ctx, t)
@@ -260,3 +260,8 @@ func (c *Cache) Wrap(be restic.Backend) restic.Backend {
 func (c *Cache) BaseDir() string {
 	return c.Base
 }
+
+// Dir returns the directory of the cache for the repository.
+func (c *Cache) Dir() string {
+	return c.path
+}
@@ -6,6 +6,7 @@ import (
 	"io"
 	"os"
 	"sync"
+	"time"
 
 	"github.com/restic/restic/internal/debug"
 	"github.com/restic/restic/internal/errors"
@@ -264,6 +265,40 @@ func (c *Checker) Packs(ctx context.Context, errChan chan<- error) {
 	}
 }
 
+// ErrDeleteAllowed is returned by AppendOnly when the backend of an append-only
+// repository allowed deleting a file. This is not necessarily a problem: in
+// an S3 bucket with versioning and object lock, deleting a file only adds a
+// delete marker and the locked version is kept, which cannot be detected
+// through the backend.
+var ErrDeleteAllowed = errors.New("the backend allowed deleting a file from the append-only repository, " +
+	"this is only safe if deleting keeps a version of the file, e.g. S3 with versioning and object lock")
+
+// AppendOnly checks that the backend of an append-only repository rejects
+// deleting files. For this, an expired lock is saved and then removed. As
+// expected, the lock usually cannot be removed, but it is ignored by all
+// other processes. If it can be removed, ErrDeleteAllowed is returned, which
+// should be reported as a hint rather than an error.
+func (c *Checker) AppendOnly(ctx context.Context) error {
+	if !c.repo.Config().AppendOnly {
+		return nil
+	}
+
+	now := time.Now()
+	lock := &restic.Lock{Time: now, Expires: &now}
+	id, err := c.repo.SaveJSONUnpacked(ctx, restic.LockFile, lock)
+	if err != nil {
+		return err
+	}
+
+	err = c.repo.Backend().Remove(ctx, restic.Handle{Type: restic.LockFile, Name: id.String()})
+	if err == nil {
+		return ErrDeleteAllowed
+	}
+
+	debug.Log("backend rejected removing lock %v: %v", id, err)
+	return nil
+}
+
 // Error is an error that occurred while checking a repository.
 type Error struct {
 	TreeID restic.ID
@@ -756,6 +791,15 @@ func checkPack(ctx context.Context, r restic.Repository, id restic.ID) error {
 			continue
 		}
 
+		if blob.IsCompressed() {
+			plaintext, err = repository.DecompressBlob(nil, plaintext, blob.UncompressedLength)
+			if err != nil {
+				debug.Log("  error decompressing blob %v: %v", blob.ID, err)
+				errs = append(errs, errors.Errorf("blob %v: %v", i, err))
+				continue
+			}
+		}
+
 		hash := restic.Hash(plaintext)
 		if !hash.Equal(blob.ID) {
 			debug.Log("  Blob ID does not match, want %v, got %v", blob.ID, hash)
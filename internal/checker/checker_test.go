@@ -618,3 +618,33 @@ func BenchmarkCheckerSnapshotScaling(b *testing.B) {
 		})
 	}
 }
+
+// appendOnlyBackend rejects removing files.
+type appendOnlyBackend struct {
+	restic.Backend
+}
+
+func (be appendOnlyBackend) Remove(ctx context.Context, h restic.Handle) error {
+	return errors.New("removing files is not allowed")
+}
+
+func TestCheckerAppendOnly(t *testing.T) {
+	repo, cleanup := repository.TestRepository(t)
+	defer cleanup()
+
+	chkr := checker.New(repo)
+	test.OK(t, chkr.AppendOnly(context.TODO()))
+
+	repo, cleanup = repository.TestAppendOnlyRepository(t, nil)
+	defer cleanup()
+
+	chkr = checker.New(repo)
+	test.Equals(t, checker.ErrDeleteAllowed, chkr.AppendOnly(context.TODO()))
+
+	be, _ := repository.TestBackend(t)
+	repo, cleanup = repository.TestAppendOnlyRepository(t, appendOnlyBackend{be})
+	defer cleanup()
+
+	chkr = checker.New(repo)
+	test.OK(t, chkr.AppendOnly(context.TODO()))
+}
@@ -9,6 +9,7 @@ import (
 
 	"github.com/restic/restic/internal/errors"
 
+	lru "github.com/hashicorp/golang-lru"
 	"golang.org/x/crypto/poly1305"
 )
 
@@ -36,6 +37,10 @@ var (
 type Key struct {
 	MACKey        `json:"mac"`
 	EncryptionKey `json:"encrypt"`
+
+	// sessionKeys contains the session keys of sealed data recently opened
+	// with this key, it is created on first use.
+	sessionKeys *lru.Cache
 }
 
 // EncryptionKey is key used for encryption
@@ -322,7 +327,19 @@ func (k *Key) Seal(dst, nonce, plaintext, additionalData []byte) []byte {
 //
 // Even if the function fails, the contents of dst, up to its capacity,
 // may be overwritten.
+//
+// Ciphertexts which were sealed for the public key of k are opened as well.
+// For these, nonce and ciphertext together contain the sealed ciphertext.
 func (k *Key) Open(dst, nonce, ciphertext, additionalData []byte) ([]byte, error) {
+	plaintext, err := k.open(dst, nonce, ciphertext)
+	if err == ErrUnauthenticated {
+		return k.openSealed(dst, nonce, ciphertext)
+	}
+	return plaintext, err
+}
+
+// open decrypts and authenticates ciphertext with k.
+func (k *Key) open(dst, nonce, ciphertext []byte) ([]byte, error) {
 	if !k.Valid() {
 		return nil, errors.New("invalid key")
 	}
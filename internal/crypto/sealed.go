@@ -0,0 +1,236 @@
+package crypto
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"io"
+	"sync"
+
+	"github.com/restic/restic/internal/errors"
+
+	lru "github.com/hashicorp/golang-lru"
+	"golang.org/x/crypto/curve25519"
+	"golang.org/x/crypto/hkdf"
+)
+
+// Data saved with a write-only key is sealed: it is encrypted with a session
+// key which is derived from an ephemeral X25519 key pair and the public key of
+// the repository. The public key in turn is derived from the master key, so
+// only the master key can open sealed data again.
+//
+// A sealed ciphertext consists of the ephemeral public key, followed by the
+// nonce, the ciphertext and the MAC as produced by Seal() of the session key.
+
+const (
+	// PublicKeySize is the length of a public key in bytes.
+	PublicKeySize = curve25519.PointSize
+
+	// SealedExtension is the number of bytes a plaintext is enlarged by
+	// sealing it.
+	SealedExtension = PublicKeySize + Extension
+)
+
+const (
+	privateKeyInfo = "restic private key"
+	sessionKeyInfo = "restic session key"
+)
+
+// PublicKey is the key data is sealed for.
+type PublicKey [PublicKeySize]byte
+
+// MarshalJSON converts the PublicKey to JSON.
+func (p *PublicKey) MarshalJSON() ([]byte, error) {
+	return json.Marshal(p[:])
+}
+
+// UnmarshalJSON fills the key p with data from the JSON representation.
+func (p *PublicKey) UnmarshalJSON(data []byte) error {
+	d := make([]byte, PublicKeySize)
+	err := json.Unmarshal(data, &d)
+	if err != nil {
+		return errors.Wrap(err, "Unmarshal")
+	}
+	if len(d) != PublicKeySize {
+		return errors.New("invalid public key length")
+	}
+	copy(p[:], d)
+
+	return nil
+}
+
+// privateKey derives the private key from the master key k.
+func (k *Key) privateKey() []byte {
+	secret := make([]byte, 0, aesKeySize+macKeySizeK)
+	secret = append(secret, k.EncryptionKey[:]...)
+	secret = append(secret, k.MACKey.K[:]...)
+
+	priv := make([]byte, curve25519.ScalarSize)
+	_, err := io.ReadFull(hkdf.New(sha256.New, secret, nil, []byte(privateKeyInfo)), priv)
+	if err != nil {
+		panic(err)
+	}
+	return priv
+}
+
+// PublicKey returns the public key for the master key k. Data sealed for the
+// public key can only be opened with k.
+func (k *Key) PublicKey() (PublicKey, error) {
+	var pub PublicKey
+	if !k.Valid() {
+		return pub, errors.New("invalid key")
+	}
+
+	buf, err := curve25519.X25519(k.privateKey(), curve25519.Basepoint)
+	if err != nil {
+		return pub, errors.Wrap(err, "X25519")
+	}
+	copy(pub[:], buf)
+
+	return pub, nil
+}
+
+// deriveSessionKey computes the session key from the shared secret of the
+// ephemeral key pair and the recipient.
+func deriveSessionKey(shared, ephemeral, recipient []byte) *Key {
+	salt := make([]byte, 0, 2*PublicKeySize)
+	salt = append(salt, ephemeral...)
+	salt = append(salt, recipient...)
+
+	buf := make([]byte, aesKeySize+macKeySize)
+	_, err := io.ReadFull(hkdf.New(sha256.New, shared, salt, []byte(sessionKeyInfo)), buf)
+	if err != nil {
+		panic(err)
+	}
+
+	k := &Key{}
+	copy(k.EncryptionKey[:], buf[:aesKeySize])
+	macKeyFromSlice(&k.MACKey, buf[aesKeySize:])
+	return k
+}
+
+// SessionKey is used to seal data for a public key.
+type SessionKey struct {
+	Key       *Key
+	Ephemeral PublicKey
+}
+
+// NewSessionKey returns a new random session key for the public key pub.
+func NewSessionKey(pub PublicKey) (*SessionKey, error) {
+	priv := make([]byte, curve25519.ScalarSize)
+	_, err := rand.Read(priv)
+	if err != nil {
+		panic("unable to read enough random bytes for ephemeral key")
+	}
+
+	eph, err := curve25519.X25519(priv, curve25519.Basepoint)
+	if err != nil {
+		return nil, errors.Wrap(err, "X25519")
+	}
+
+	// X25519 rejects low order points, so pub cannot force a known secret
+	shared, err := curve25519.X25519(priv, pub[:])
+	if err != nil {
+		return nil, errors.Wrap(err, "X25519")
+	}
+
+	s := &SessionKey{Key: deriveSessionKey(shared, eph, pub[:])}
+	copy(s.Ephemeral[:], eph)
+	return s, nil
+}
+
+// Seal encrypts and authenticates plaintext and appends the result together
+// with the ephemeral public key and a new random nonce to dst.
+func (s *SessionKey) Seal(dst, plaintext []byte) []byte {
+	nonce := NewRandomNonce()
+	dst = append(dst, s.Ephemeral[:]...)
+	dst = append(dst, nonce...)
+	return s.Key.Seal(dst, nonce, plaintext, nil)
+}
+
+// sessionKeysMu protects creating the session key cache of a Key.
+var sessionKeysMu sync.Mutex
+
+// sessionKeyCache returns the cache of recently used session keys of k, most
+// sealed ciphertexts share their session key with the other blobs in the same
+// pack.
+func (k *Key) sessionKeyCache() *lru.Cache {
+	sessionKeysMu.Lock()
+	defer sessionKeysMu.Unlock()
+
+	if k.sessionKeys == nil {
+		k.sessionKeys, _ = lru.New(1024)
+	}
+	return k.sessionKeys
+}
+
+// ClearSessionKeys removes all cached session keys of k.
+func (k *Key) ClearSessionKeys() {
+	sessionKeysMu.Lock()
+	defer sessionKeysMu.Unlock()
+
+	if k.sessionKeys != nil {
+		k.sessionKeys.Purge()
+	}
+}
+
+// OpenSessionKey returns the session key for data which was sealed for the
+// public key of k with the ephemeral public key eph.
+func (k *Key) OpenSessionKey(eph []byte) (*Key, error) {
+	if len(eph) != PublicKeySize {
+		return nil, errors.New("invalid ephemeral public key length")
+	}
+
+	var ephemeral PublicKey
+	copy(ephemeral[:], eph)
+
+	cache := k.sessionKeyCache()
+	if sk, ok := cache.Get(ephemeral); ok {
+		return sk.(*Key), nil
+	}
+
+	priv := k.privateKey()
+	shared, err := curve25519.X25519(priv, eph)
+	if err != nil {
+		return nil, errors.Wrap(err, "X25519")
+	}
+
+	pub, err := curve25519.X25519(priv, curve25519.Basepoint)
+	if err != nil {
+		return nil, errors.Wrap(err, "X25519")
+	}
+
+	sk := deriveSessionKey(shared, eph, pub)
+	cache.Add(ephemeral, sk)
+	return sk, nil
+}
+
+// openSealed tries to open a sealed ciphertext. For compatibility with callers
+// of Open, the first bytes of the ephemeral public key are passed in prefix.
+func (k *Key) openSealed(dst, prefix, ciphertext []byte) ([]byte, error) {
+	rest := PublicKeySize - len(prefix)
+	if rest < 0 || len(ciphertext) < rest+ivSize+macSize {
+		return nil, ErrUnauthenticated
+	}
+
+	eph := make([]byte, 0, PublicKeySize)
+	eph = append(eph, prefix...)
+	eph = append(eph, ciphertext[:rest]...)
+
+	sk, err := k.OpenSessionKey(eph)
+	if err != nil {
+		return nil, ErrUnauthenticated
+	}
+
+	// dst may alias the ciphertext, which is shifted by the ephemeral public
+	// key and the nonce, so decrypt into a separate buffer first
+	nonce, ciphertext := ciphertext[rest:rest+ivSize], ciphertext[rest+ivSize:]
+	plaintext, err := sk.open(nil, nonce, ciphertext)
+	if err != nil {
+		return nil, err
+	}
+
+	ret, out := sliceForAppend(dst, len(plaintext))
+	copy(out, plaintext)
+	return ret, nil
+}
@@ -0,0 +1,115 @@
+package crypto_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/restic/restic/internal/crypto"
+	rtest "github.com/restic/restic/internal/test"
+)
+
+func TestSealOpen(t *testing.T) {
+	k := crypto.NewRandomKey()
+	pub, err := k.PublicKey()
+	rtest.OK(t, err)
+
+	sk, err := crypto.NewSessionKey(pub)
+	rtest.OK(t, err)
+
+	for _, size := range []int{0, 5, 23, 2<<18 + 23} {
+		data := rtest.Random(23, size)
+
+		buf := sk.Seal(nil, data)
+		rtest.Equals(t, len(data)+crypto.SealedExtension, len(buf))
+
+		// open the sealed ciphertext in place, like the repository does
+		nonce, ciphertext := buf[:k.NonceSize()], buf[k.NonceSize():]
+		plaintext, err := k.Open(ciphertext[:0], nonce, ciphertext, nil)
+		rtest.OK(t, err)
+		rtest.Assert(t, bytes.Equal(data, plaintext), "wrong plaintext returned for size %d", size)
+	}
+}
+
+func TestSealOpenWrongKey(t *testing.T) {
+	k := crypto.NewRandomKey()
+	pub, err := k.PublicKey()
+	rtest.OK(t, err)
+
+	sk, err := crypto.NewSessionKey(pub)
+	rtest.OK(t, err)
+	buf := sk.Seal(nil, rtest.Random(23, 100))
+
+	other := crypto.NewRandomKey()
+	nonce, ciphertext := buf[:other.NonceSize()], buf[other.NonceSize():]
+	_, err = other.Open(nil, nonce, ciphertext, nil)
+	rtest.Assert(t, err == crypto.ErrUnauthenticated, "expected ErrUnauthenticated, got %v", err)
+
+	// modify the ciphertext
+	buf[len(buf)/2] ^= 0x01
+	_, err = k.Open(nil, nonce, ciphertext, nil)
+	rtest.Assert(t, err == crypto.ErrUnauthenticated, "expected ErrUnauthenticated, got %v", err)
+}
+
+func TestSessionKeyOpen(t *testing.T) {
+	k := crypto.NewRandomKey()
+	pub, err := k.PublicKey()
+	rtest.OK(t, err)
+
+	sk, err := crypto.NewSessionKey(pub)
+	rtest.OK(t, err)
+
+	key, err := k.OpenSessionKey(sk.Ephemeral[:])
+	rtest.OK(t, err)
+	rtest.Equals(t, sk.Key.EncryptionKey, key.EncryptionKey)
+	rtest.Equals(t, sk.Key.MACKey.K, key.MACKey.K)
+	rtest.Equals(t, sk.Key.MACKey.R, key.MACKey.R)
+}
+
+func TestSessionKeyCache(t *testing.T) {
+	k := crypto.NewRandomKey()
+	pub, err := k.PublicKey()
+	rtest.OK(t, err)
+
+	sk, err := crypto.NewSessionKey(pub)
+	rtest.OK(t, err)
+
+	key, err := k.OpenSessionKey(sk.Ephemeral[:])
+	rtest.OK(t, err)
+	cached, err := k.OpenSessionKey(sk.Ephemeral[:])
+	rtest.OK(t, err)
+	rtest.Assert(t, key == cached, "session key was not cached")
+
+	// the cache is not shared with other keys
+	other, err := crypto.NewRandomKey().OpenSessionKey(sk.Ephemeral[:])
+	rtest.OK(t, err)
+	rtest.Assert(t, other.EncryptionKey != key.EncryptionKey, "other key returned the cached session key")
+
+	k.ClearSessionKeys()
+	derived, err := k.OpenSessionKey(sk.Ephemeral[:])
+	rtest.OK(t, err)
+	rtest.Assert(t, key != derived, "session key was cached after clearing the cache")
+	rtest.Equals(t, key.EncryptionKey, derived.EncryptionKey)
+}
+
+func TestSessionKeyLowOrderPoint(t *testing.T) {
+	var pub crypto.PublicKey
+	_, err := crypto.NewSessionKey(pub)
+	rtest.Assert(t, err != nil, "expected error for all-zero public key")
+}
+
+func TestPublicKeyJSON(t *testing.T) {
+	k := crypto.NewRandomKey()
+	pub, err := k.PublicKey()
+	rtest.OK(t, err)
+
+	buf, err := json.Marshal(&pub)
+	rtest.OK(t, err)
+
+	var pub2 crypto.PublicKey
+	rtest.OK(t, json.Unmarshal(buf, &pub2))
+	rtest.Equals(t, pub, pub2)
+
+	err = json.Unmarshal([]byte(`"AAAA"`), &pub2)
+	rtest.Assert(t, err != nil, "expected error for short public key")
+}
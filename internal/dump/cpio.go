@@ -0,0 +1,170 @@
+package dump
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/restic"
+)
+
+// cpio "newc" format, as written by "cpio -H newc" and used for the Linux
+// initramfs. The header consists of the magic and 13 fields, each encoded as
+// an eight digit hexadecimal number. Name and content are padded to a
+// multiple of four bytes.
+const (
+	cpioMagic      = "070701"
+	cpioHeaderSize = 110
+	cpioTrailer    = "TRAILER!!!"
+
+	cpioModeDir     = 0040000
+	cpioModeRegular = 0100000
+	cpioModeSymlink = 0120000
+	cpioModeSetuid  = 04000
+	cpioModeSetgid  = 02000
+	cpioModeSticky  = 01000
+)
+
+type cpioDumper struct {
+	w *bufio.Writer
+	// inode is incremented for every entry, as the inode numbers of the
+	// original files can collide, hard links are not preserved.
+	inode uint32
+}
+
+// Statically ensure that cpioDumper implements dumper.
+var _ dumper = &cpioDumper{}
+
+// WriteCpio will write the contents of the given tree, encoded as a cpio
+// archive in the "newc" format to the given destination. It will loop over
+// all nodes in the tree and dump them recursively. The format has no place for
+// extended attributes, they are not included.
+func WriteCpio(ctx context.Context, repo restic.Repository, tree *restic.Tree, rootPath string, dst io.Writer) error {
+	dmp := &cpioDumper{w: bufio.NewWriter(dst)}
+
+	return writeDump(ctx, repo, tree, rootPath, dmp)
+}
+
+func (dmp *cpioDumper) Close() error {
+	err := dmp.writeHeader(cpioTrailer, 0, 0, 0, 1, 0, 0)
+	if err != nil {
+		return err
+	}
+
+	return errors.Wrap(dmp.w.Flush(), "Flush")
+}
+
+func (dmp *cpioDumper) dumpNode(ctx context.Context, node *restic.Node, repo restic.Repository) error {
+	relPath, err := filepath.Rel("/", node.Path)
+	if err != nil {
+		return err
+	}
+
+	mode := uint32(node.Mode.Perm())
+	if node.Mode&os.ModeSetuid != 0 {
+		mode |= cpioModeSetuid
+	}
+	if node.Mode&os.ModeSetgid != 0 {
+		mode |= cpioModeSetgid
+	}
+	if node.Mode&os.ModeSticky != 0 {
+		mode |= cpioModeSticky
+	}
+
+	var size uint64
+	nlink := uint32(1)
+	switch {
+	case IsDir(node):
+		mode |= cpioModeDir
+		nlink = 2
+	case IsLink(node):
+		mode |= cpioModeSymlink
+		size = uint64(len(node.LinkTarget))
+	default:
+		mode |= cpioModeRegular
+		size = node.Size
+	}
+
+	if size > 0xffffffff {
+		return errors.Errorf("file %v is too large for the cpio format", node.Path)
+	}
+
+	var mtime uint32
+	if sec := node.ModTime.Unix(); sec > 0 && sec <= 0xffffffff {
+		mtime = uint32(sec)
+	}
+
+	dmp.inode++
+	err = dmp.writeHeader(filepath.ToSlash(relPath), mode, node.UID, node.GID, nlink, mtime, uint32(size))
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case IsDir(node):
+		return nil
+	case IsLink(node):
+		_, err = dmp.w.WriteString(node.LinkTarget)
+		if err != nil {
+			return errors.Wrap(err, "Write")
+		}
+	default:
+		cw := &countingWriter{w: dmp.w}
+		err = GetNodeData(ctx, cw, repo, node)
+		if err != nil {
+			return err
+		}
+		// the size is part of the header, more or less data corrupts the archive
+		if cw.n != size {
+			return errors.Errorf("file %v: size is %d, but content has %d bytes", node.Path, size, cw.n)
+		}
+	}
+
+	return dmp.pad(size)
+}
+
+func (dmp *cpioDumper) writeHeader(name string, mode, uid, gid, nlink, mtime, size uint32) error {
+	// the name is terminated by a null byte
+	namesize := uint32(len(name) + 1)
+
+	_, err := fmt.Fprintf(dmp.w, "%s%08x%08x%08x%08x%08x%08x%08x%08x%08x%08x%08x%08x%08x",
+		cpioMagic, dmp.inode, mode, uid, gid, nlink, mtime, size,
+		0, 0, // device of the file, major and minor
+		0, 0, // device for special files, major and minor
+		namesize, 0)
+	if err != nil {
+		return errors.Wrap(err, "Write")
+	}
+
+	_, err = dmp.w.WriteString(name)
+	if err == nil {
+		err = dmp.w.WriteByte(0)
+	}
+	if err != nil {
+		return errors.Wrap(err, "Write")
+	}
+
+	return dmp.pad(uint64(cpioHeaderSize + namesize))
+}
+
+// pad writes null bytes until n is a multiple of four.
+func (dmp *cpioDumper) pad(n uint64) error {
+	var zero [3]byte
+	_, err := dmp.w.Write(zero[:(4-n%4)%4])
+	return errors.Wrap(err, "Write")
+}
+
+type countingWriter struct {
+	w io.Writer
+	n uint64
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n += uint64(n)
+	return n, err
+}
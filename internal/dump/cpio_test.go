@@ -0,0 +1,143 @@
+package dump
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestWriteCpio(t *testing.T) {
+	WriteTest(t, WriteCpio, checkCpio)
+}
+
+type cpioEntry struct {
+	name    string
+	mode    uint32
+	mtime   time.Time
+	content []byte
+}
+
+// readCpio parses a cpio archive in the "newc" format.
+func readCpio(r *bytes.Buffer) ([]cpioEntry, error) {
+	var entries []cpioEntry
+	offset := 0
+
+	skipPadding := func(n int) {
+		r.Next((4 - n%4) % 4)
+		offset += (4 - n%4) % 4
+	}
+
+	for {
+		hdr := r.Next(cpioHeaderSize)
+		if len(hdr) != cpioHeaderSize {
+			return nil, io.ErrUnexpectedEOF
+		}
+		offset += cpioHeaderSize
+		if string(hdr[:6]) != cpioMagic {
+			return nil, fmt.Errorf("invalid magic %q at offset %d", hdr[:6], offset)
+		}
+
+		var fields [13]uint32
+		for i := range fields {
+			v, err := strconv.ParseUint(string(hdr[6+8*i:14+8*i]), 16, 32)
+			if err != nil {
+				return nil, err
+			}
+			fields[i] = uint32(v)
+		}
+
+		namesize, size := int(fields[11]), int(fields[6])
+		name := r.Next(namesize)
+		if len(name) != namesize || name[namesize-1] != 0 {
+			return nil, fmt.Errorf("invalid name %q", name)
+		}
+		offset += namesize
+		skipPadding(offset)
+
+		if string(name[:namesize-1]) == cpioTrailer {
+			return entries, nil
+		}
+
+		content := r.Next(size)
+		if len(content) != size {
+			return nil, io.ErrUnexpectedEOF
+		}
+		offset += size
+		skipPadding(offset)
+
+		entries = append(entries, cpioEntry{
+			name:    string(name[:namesize-1]),
+			mode:    fields[1],
+			mtime:   time.Unix(int64(fields[5]), 0),
+			content: content,
+		})
+	}
+}
+
+func checkCpio(t *testing.T, testDir string, srcCpio *bytes.Buffer) error {
+	entries, err := readCpio(srcCpio)
+	if err != nil {
+		return err
+	}
+
+	fileNumber := 0
+	err = filepath.Walk(testDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.Name() != filepath.Base(testDir) {
+			fileNumber++
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		matchPath := filepath.Join(testDir, entry.name)
+		match, err := os.Lstat(matchPath)
+		if err != nil {
+			return err
+		}
+
+		// check metadata, cpio header contains time rounded to seconds
+		fileTime := match.ModTime().Truncate(time.Second)
+		if !fileTime.Equal(entry.mtime) {
+			return fmt.Errorf("modTime does not match, got: %s, want: %s", entry.mtime, fileTime)
+		}
+		if entry.mode&0777 != uint32(match.Mode().Perm()) {
+			return fmt.Errorf("mode does not match, got: %o, want: %o", entry.mode&0777, match.Mode().Perm())
+		}
+
+		if match.IsDir() {
+			if entry.mode&0170000 != cpioModeDir {
+				return fmt.Errorf("%v is not a directory in the archive, mode %o", entry.name, entry.mode)
+			}
+			continue
+		}
+
+		if entry.mode&0170000 != cpioModeRegular {
+			return fmt.Errorf("%v is not a regular file in the archive, mode %o", entry.name, entry.mode)
+		}
+		contentsFile, err := ioutil.ReadFile(matchPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(entry.content) != string(contentsFile) {
+			return fmt.Errorf("contents does not match, got %s want %s", entry.content, contentsFile)
+		}
+	}
+
+	if len(entries) != fileNumber {
+		return fmt.Errorf("not the same amount of files got %v want %v", len(entries), fileNumber)
+	}
+
+	return nil
+}
@@ -0,0 +1,92 @@
+package dump
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/binary"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/restic"
+)
+
+// zipExtraUnixID is the header ID of the Info-ZIP "New Unix" extra field,
+// which stores the owner of a file.
+const zipExtraUnixID = 0x7875
+
+type zipDumper struct {
+	w *zip.Writer
+}
+
+// Statically ensure that zipDumper implements dumper.
+var _ dumper = zipDumper{}
+
+// WriteZip will write the contents of the given tree, encoded as a zip to the given destination.
+// It will loop over all nodes in the tree and dump them recursively. The zip
+// format has no place for extended attributes, they are not included.
+func WriteZip(ctx context.Context, repo restic.Repository, tree *restic.Tree, rootPath string, dst io.Writer) error {
+	dmp := zipDumper{w: zip.NewWriter(dst)}
+
+	return writeDump(ctx, repo, tree, rootPath, dmp)
+}
+
+func (dmp zipDumper) Close() error {
+	return dmp.w.Close()
+}
+
+func (dmp zipDumper) dumpNode(ctx context.Context, node *restic.Node, repo restic.Repository) error {
+	relPath, err := filepath.Rel("/", node.Path)
+	if err != nil {
+		return err
+	}
+
+	header := &zip.FileHeader{
+		Name:     filepath.ToSlash(relPath),
+		Modified: node.ModTime,
+		Extra:    zipExtraUnix(node.UID, node.GID),
+	}
+
+	mode := node.Mode
+	switch {
+	case IsDir(node):
+		mode |= os.ModeDir
+		// directories are marked by a trailing slash and have no content
+		header.Name += "/"
+	case IsLink(node):
+		mode |= os.ModeSymlink
+	default:
+		header.Method = zip.Deflate
+	}
+	header.SetMode(mode)
+
+	w, err := dmp.w.CreateHeader(header)
+	if err != nil {
+		return errors.Wrap(err, "ZipHeader")
+	}
+
+	switch {
+	case IsDir(node):
+		return nil
+	case IsLink(node):
+		// the target of a symlink is stored as its content
+		_, err = w.Write([]byte(node.LinkTarget))
+		return errors.Wrap(err, "Write")
+	}
+
+	return GetNodeData(ctx, w, repo, node)
+}
+
+// zipExtraUnix returns an extra field which stores uid and gid.
+func zipExtraUnix(uid, gid uint32) []byte {
+	buf := make([]byte, 15)
+	binary.LittleEndian.PutUint16(buf[0:], zipExtraUnixID)
+	binary.LittleEndian.PutUint16(buf[2:], 11)
+	buf[4] = 1 // version
+	buf[5] = 4 // size of the uid
+	binary.LittleEndian.PutUint32(buf[6:], uid)
+	buf[10] = 4 // size of the gid
+	binary.LittleEndian.PutUint32(buf[11:], gid)
+	return buf
+}
@@ -94,6 +94,7 @@ func (m *S3Layout) Apply(ctx context.Context, repo restic.Repository) error {
 		restic.PackFile,
 		restic.KeyFile,
 		restic.LockFile,
+		restic.PolicyFile,
 	} {
 		err := m.moveFiles(ctx, be, newLayout, t)
 		if err != nil {
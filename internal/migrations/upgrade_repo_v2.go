@@ -0,0 +1,105 @@
+package migrations
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/restic/restic/internal/backend"
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/restic"
+)
+
+func init() {
+	register(&UpgradeRepoV2{})
+}
+
+// UpgradeRepoV2 upgrades a repository from version 1 to version 2, which
+// allows storing compressed data and tree blobs. Existing blobs are left
+// untouched, only the config file is rewritten.
+type UpgradeRepoV2 struct{}
+
+// Name returns the name for this migration.
+func (*UpgradeRepoV2) Name() string {
+	return "upgrade_repo_v2"
+}
+
+// Desc returns a short description what the migration does.
+func (*UpgradeRepoV2) Desc() string {
+	return "upgrade a repository to version 2, which supports compression"
+}
+
+// Check tests whether the migration can be applied.
+func (*UpgradeRepoV2) Check(ctx context.Context, repo restic.Repository) (bool, error) {
+	isV1 := repo.Config().Version == 1
+	if !isV1 {
+		debug.Log("repository version is %v", repo.Config().Version)
+	}
+	return isV1, nil
+}
+
+// Apply runs the migration. The config file is the only file modified by the
+// upgrade, a copy of the old file is kept in a temporary directory until the
+// new config has been saved successfully.
+func (m *UpgradeRepoV2) Apply(ctx context.Context, repo restic.Repository) error {
+	tempdir, err := ioutil.TempDir("", "restic-migrate-upgrade-repo-v2-")
+	if err != nil {
+		return errors.Wrap(err, "create temp dir failed")
+	}
+
+	h := restic.Handle{Type: restic.ConfigFile}
+
+	// read the raw config file and save it to a temp dir, just in case
+	rawConfigFile, err := backend.LoadAll(ctx, nil, repo.Backend(), h)
+	if err != nil {
+		return errors.Wrap(err, "load config file failed")
+	}
+
+	backupFileName := filepath.Join(tempdir, "config")
+	err = ioutil.WriteFile(backupFileName, rawConfigFile, 0600)
+	if err != nil {
+		return errors.Wrap(err, "write config file backup failed")
+	}
+
+	// run the upgrade
+	err = m.upgrade(ctx, repo)
+	if err != nil {
+		// try contingency methods, reupload the original file
+		_ = repo.Backend().Remove(ctx, h)
+		uploadError := repo.Backend().Save(ctx, h, restic.NewByteReader(rawConfigFile))
+		if uploadError != nil {
+			return errors.Errorf("upgrade failed: %v, error uploading the original config (%v), it is available at %v",
+				err, uploadError, backupFileName)
+		}
+
+		_ = os.Remove(backupFileName)
+		_ = os.Remove(tempdir)
+		return errors.Wrap(err, "upgrade failed, the original config file has been restored")
+	}
+
+	_ = os.Remove(backupFileName)
+	_ = os.Remove(tempdir)
+	return nil
+}
+
+func (*UpgradeRepoV2) upgrade(ctx context.Context, repo restic.Repository) error {
+	h := restic.Handle{Type: restic.ConfigFile}
+
+	// now remove the config file, most backends refuse to overwrite files
+	err := repo.Backend().Remove(ctx, h)
+	if err != nil {
+		return errors.Wrap(err, "remove config failed")
+	}
+
+	cfg := repo.Config()
+	cfg.Version = restic.CompressedRepoVersion
+
+	_, err = repo.SaveJSONUnpacked(ctx, restic.ConfigFile, cfg)
+	if err != nil {
+		return errors.Wrap(err, "save new config file failed")
+	}
+
+	return nil
+}
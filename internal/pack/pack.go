@@ -30,9 +30,11 @@ func NewPacker(k *crypto.Key, wr io.Writer) *Packer {
 	return &Packer{k: k, wr: wr}
 }
 
-// Add saves the data read from rd as a new blob to the packer. Returned is the
-// number of bytes written to the pack.
-func (p *Packer) Add(t restic.BlobType, id restic.ID, data []byte) (int, error) {
+// Add saves the data read from rd as a new blob to the packer. If the data is
+// compressed, uncompressedLength must be set to the length of the data before
+// compression, otherwise it must be zero. Returned is the number of bytes
+// written to the pack.
+func (p *Packer) Add(t restic.BlobType, id restic.ID, data []byte, uncompressedLength int) (int, error) {
 	p.m.Lock()
 	defer p.m.Unlock()
 
@@ -41,13 +43,20 @@ func (p *Packer) Add(t restic.BlobType, id restic.ID, data []byte) (int, error)
 	n, err := p.wr.Write(data)
 	c.Length = uint(n)
 	c.Offset = p.bytes
+	c.UncompressedLength = uint(uncompressedLength)
 	p.bytes += uint(n)
 	p.blobs = append(p.blobs, c)
 
 	return n, errors.Wrap(err, "Write")
 }
 
-var entrySize = uint(binary.Size(restic.BlobType(0)) + binary.Size(uint32(0)) + len(restic.ID{}))
+var (
+	// entrySize is the size of a header entry for an uncompressed blob
+	entrySize = uint(binary.Size(restic.BlobType(0)) + binary.Size(uint32(0)) + len(restic.ID{}))
+	// compressedEntrySize is the size of a header entry for a compressed
+	// blob, it additionally contains the uncompressed length
+	compressedEntrySize = entrySize + uint(binary.Size(uint32(0)))
+)
 
 // headerEntry is used with encoding/binary to read and write header entries
 type headerEntry struct {
@@ -56,6 +65,24 @@ type headerEntry struct {
 	ID     restic.ID
 }
 
+// compressedHeaderEntry is used with encoding/binary to read and write header
+// entries for compressed blobs
+type compressedHeaderEntry struct {
+	Type               uint8
+	Length             uint32
+	UncompressedLength uint32
+	ID                 restic.ID
+}
+
+// header entry types, the compressed variants are only used in repositories
+// with version 2 or later
+const (
+	entryTypeData           = 0
+	entryTypeTree           = 1
+	entryTypeCompressedData = 2
+	entryTypeCompressedTree = 3
+)
+
 // Finalize writes the header for all added blobs and finalizes the pack.
 // Returned are the number of bytes written, including the header.
 func (p *Packer) Finalize() (uint, error) {
@@ -89,7 +116,7 @@ func (p *Packer) Finalize() (uint, error) {
 	bytesWritten += uint(hdrBytes)
 
 	// write length
-	err = binary.Write(p.wr, binary.LittleEndian, uint32(restic.CiphertextLength(len(p.blobs)*int(entrySize))))
+	err = binary.Write(p.wr, binary.LittleEndian, uint32(hdrBytes))
 	if err != nil {
 		return 0, errors.Wrap(err, "binary.Write")
 	}
@@ -102,26 +129,46 @@ func (p *Packer) Finalize() (uint, error) {
 // writeHeader constructs and writes the header to wr.
 func (p *Packer) writeHeader(wr io.Writer) (bytesWritten uint, err error) {
 	for _, b := range p.blobs {
-		entry := headerEntry{
-			Length: uint32(b.Length),
-			ID:     b.ID,
-		}
-
+		var entryType uint8
 		switch b.Type {
 		case restic.DataBlob:
-			entry.Type = 0
+			entryType = entryTypeData
 		case restic.TreeBlob:
-			entry.Type = 1
+			entryType = entryTypeTree
 		default:
 			return 0, errors.Errorf("invalid blob type %v", b.Type)
 		}
 
+		if !b.IsCompressed() {
+			entry := headerEntry{
+				Type:   entryType,
+				Length: uint32(b.Length),
+				ID:     b.ID,
+			}
+
+			err := binary.Write(wr, binary.LittleEndian, entry)
+			if err != nil {
+				return bytesWritten, errors.Wrap(err, "binary.Write")
+			}
+
+			bytesWritten += entrySize
+			continue
+		}
+
+		entry := compressedHeaderEntry{
+			// the compressed entry types directly follow the plain ones
+			Type:               entryType + entryTypeCompressedData,
+			Length:             uint32(b.Length),
+			UncompressedLength: uint32(b.UncompressedLength),
+			ID:                 b.ID,
+		}
+
 		err := binary.Write(wr, binary.LittleEndian, entry)
 		if err != nil {
 			return bytesWritten, errors.Wrap(err, "binary.Write")
 		}
 
-		bytesWritten += entrySize
+		bytesWritten += compressedEntrySize
 	}
 
 	return
@@ -151,6 +198,15 @@ func (p *Packer) Blobs() []restic.Blob {
 	return p.blobs
 }
 
+// HeaderFull returns true if the header of the pack cannot hold another
+// entry, so no more blobs may be added to the pack.
+func (p *Packer) HeaderFull() bool {
+	p.m.Lock()
+	defer p.m.Unlock()
+
+	return uint(len(p.blobs)+1)*compressedEntrySize+crypto.Extension > maxHeaderSize
+}
+
 // Writer return the underlying writer.
 func (p *Packer) Writer() io.Writer {
 	return p.wr
@@ -176,7 +232,9 @@ const (
 // readRecords reads up to max records from the underlying ReaderAt, returning
 // the raw header, the total number of records in the header, and any error.
 // If the header contains fewer than max entries, the header is truncated to
-// the appropriate size.
+// the appropriate size. As entries for compressed blobs are larger than
+// those for uncompressed blobs, the returned number of records is an upper
+// bound computed from the size of plain entries.
 func readRecords(rd io.ReaderAt, size int64, max int) ([]byte, int, error) {
 	var bufsize int
 	bufsize += max * int(entrySize)
@@ -203,8 +261,6 @@ func readRecords(rd io.ReaderAt, size int64, max int) ([]byte, int, error) {
 		err = InvalidFileError{Message: "header length is zero"}
 	case hlen < crypto.Extension:
 		err = InvalidFileError{Message: "header length is too small"}
-	case (hlen-crypto.Extension)%uint32(entrySize) != 0:
-		err = InvalidFileError{Message: "header length is invalid"}
 	case int64(hlen) > size-int64(headerLengthSize):
 		err = InvalidFileError{Message: "header is larger than file"}
 	case int64(hlen) > maxHeaderSize:
@@ -214,8 +270,9 @@ func readRecords(rd io.ReaderAt, size int64, max int) ([]byte, int, error) {
 		return nil, 0, errors.Wrap(err, "readHeader")
 	}
 
-	total := (int(hlen) - crypto.Extension) / int(entrySize)
-	if total < max {
+	// round up so that a header containing compressed entries is read completely
+	total := (int(hlen) - crypto.Extension + int(entrySize) - 1) / int(entrySize)
+	if int(hlen) <= len(b) {
 		// truncate to the beginning of the pack header
 		b = b[len(b)-int(hlen):]
 	}
@@ -260,6 +317,23 @@ func (e InvalidFileError) Error() string {
 	return e.Message
 }
 
+// openSealedHeader decrypts the header of a pack which was saved with a
+// write-only key. The header is encrypted with the session key of the pack,
+// the ephemeral public key is stored at the beginning of the first blob.
+func openSealedHeader(k *crypto.Key, rd io.ReaderAt, nonce, ciphertext []byte) ([]byte, error) {
+	eph := make([]byte, crypto.PublicKeySize)
+	if _, err := rd.ReadAt(eph, 0); err != nil {
+		return nil, err
+	}
+
+	sk, err := k.OpenSessionKey(eph)
+	if err != nil {
+		return nil, crypto.ErrUnauthenticated
+	}
+
+	return sk.Open(ciphertext[:0], nonce, ciphertext, nil)
+}
+
 // List returns the list of entries found in a pack file.
 func List(k *crypto.Key, rd io.ReaderAt, size int64) (entries []restic.Blob, err error) {
 	buf, err := readHeader(rd, size)
@@ -268,50 +342,96 @@ func List(k *crypto.Key, rd io.ReaderAt, size int64) (entries []restic.Blob, err
 	}
 
 	if len(buf) < k.NonceSize()+k.Overhead() {
-		return nil, errors.New("invalid header, too small")
+		return nil, InvalidFileError{Message: "invalid header, too small"}
 	}
 
 	nonce, buf := buf[:k.NonceSize()], buf[k.NonceSize():]
-	buf, err = k.Open(buf[:0], nonce, buf, nil)
+	plaintext, err := k.Open(buf[:0], nonce, buf, nil)
+	if err == crypto.ErrUnauthenticated {
+		plaintext, err = openSealedHeader(k, rd, nonce, buf)
+	}
+	if err == crypto.ErrUnauthenticated {
+		return nil, InvalidFileError{Message: "header cannot be decrypted"}
+	}
 	if err != nil {
 		return nil, err
 	}
-
-	hdrRd := bytes.NewReader(buf)
+	buf = plaintext
 
 	entries = make([]restic.Blob, 0, uint(len(buf))/entrySize)
 
 	pos := uint(0)
-	for {
-		e := headerEntry{}
-		err = binary.Read(hdrRd, binary.LittleEndian, &e)
-		if errors.Cause(err) == io.EOF {
-			break
-		}
-
+	for len(buf) > 0 {
+		entry, size, err := parseHeaderEntry(buf)
 		if err != nil {
-			return nil, errors.Wrap(err, "binary.Read")
+			return nil, InvalidFileError{Message: err.Error()}
 		}
+		entry.Offset = pos
 
-		entry := restic.Blob{
-			Length: uint(e.Length),
-			ID:     e.ID,
-			Offset: pos,
-		}
+		entries = append(entries, entry)
+		pos += entry.Length
+		buf = buf[size:]
+	}
 
-		switch e.Type {
-		case 0:
-			entry.Type = restic.DataBlob
-		case 1:
-			entry.Type = restic.TreeBlob
-		default:
-			return nil, errors.Errorf("invalid type %d", e.Type)
-		}
+	return entries, nil
+}
 
-		entries = append(entries, entry)
+// parseHeaderEntry decodes the first header entry in buf, returned are the
+// blob and the size of the entry in bytes.
+func parseHeaderEntry(buf []byte) (restic.Blob, uint, error) {
+	var entry restic.Blob
 
-		pos += uint(e.Length)
+	if uint(len(buf)) < entrySize {
+		return entry, 0, errors.Errorf("header entry is too short: %d bytes", len(buf))
 	}
 
-	return entries, nil
+	size := entrySize
+	switch buf[0] {
+	case entryTypeData:
+		entry.Type = restic.DataBlob
+	case entryTypeTree:
+		entry.Type = restic.TreeBlob
+	case entryTypeCompressedData:
+		entry.Type = restic.DataBlob
+		size = compressedEntrySize
+	case entryTypeCompressedTree:
+		entry.Type = restic.TreeBlob
+		size = compressedEntrySize
+	default:
+		return entry, 0, errors.Errorf("invalid type %d", buf[0])
+	}
+
+	if uint(len(buf)) < size {
+		return entry, 0, errors.Errorf("header entry is too short: %d bytes", len(buf))
+	}
+
+	entry.Length = uint(binary.LittleEndian.Uint32(buf[1:5]))
+	if size == compressedEntrySize {
+		entry.UncompressedLength = uint(binary.LittleEndian.Uint32(buf[5:9]))
+	}
+	copy(entry.ID[:], buf[size-uint(len(restic.ID{})):size])
+
+	return entry, size, nil
+}
+
+// HeaderSize is the size of the parts of a pack header which do not depend on
+// the blobs stored in the pack: the encryption overhead and the header length.
+const HeaderSize = crypto.Extension + 4
+
+// CalculateEntrySize returns the size of the header entry for blob.
+func CalculateEntrySize(blob restic.Blob) int {
+	if blob.IsCompressed() {
+		return int(compressedEntrySize)
+	}
+	return int(entrySize)
+}
+
+// CalculateHeaderSize returns the size of the pack header for a pack file
+// containing the given blobs.
+func CalculateHeaderSize(blobs []restic.Blob) int {
+	size := HeaderSize
+	for _, blob := range blobs {
+		size += CalculateEntrySize(blob)
+	}
+	return size
 }
@@ -8,6 +8,8 @@ import (
 	"encoding/binary"
 	"encoding/json"
 	"io"
+	"io/ioutil"
+	"os"
 	"testing"
 
 	"github.com/restic/restic/internal/backend/mem"
@@ -38,7 +40,7 @@ func newPack(t testing.TB, k *crypto.Key, lengths []int) ([]Buf, []byte, uint) {
 	// pack blobs
 	p := pack.NewPacker(k, new(bytes.Buffer))
 	for _, b := range bufs {
-		p.Add(restic.TreeBlob, b.id, b.data)
+		p.Add(restic.TreeBlob, b.id, b.data, 0)
 	}
 
 	_, err := p.Finalize()
@@ -94,6 +96,92 @@ func TestCreatePack(t *testing.T) {
 	verifyBlobs(t, bufs, k, bytes.NewReader(packData), packSize)
 }
 
+func TestCreatePackCompressed(t *testing.T) {
+	k := crypto.NewRandomKey()
+
+	// mix entries for compressed and uncompressed blobs
+	p := pack.NewPacker(k, new(bytes.Buffer))
+	var blobs []restic.Blob
+	for i, l := range testLens {
+		b := rtest.Random(i, l)
+		id := restic.Hash(b)
+		uncompressedLength := 0
+		tpe := restic.DataBlob
+		if i%2 == 0 {
+			uncompressedLength = 2*l + 1
+			tpe = restic.TreeBlob
+		}
+
+		_, err := p.Add(tpe, id, b, uncompressedLength)
+		rtest.OK(t, err)
+		blobs = append(blobs, restic.Blob{Type: tpe, ID: id, Length: uint(l), UncompressedLength: uint(uncompressedLength)})
+	}
+
+	_, err := p.Finalize()
+	rtest.OK(t, err)
+
+	packData := p.Writer().(*bytes.Buffer).Bytes()
+	rtest.Equals(t, uint(len(packData)), p.Size())
+
+	entries, err := pack.List(k, bytes.NewReader(packData), int64(len(packData)))
+	rtest.OK(t, err)
+	rtest.Equals(t, len(blobs), len(entries))
+
+	offset := uint(0)
+	for i, e := range entries {
+		blobs[i].Offset = offset
+		rtest.Equals(t, blobs[i], e)
+		offset += e.Length
+	}
+
+	// the pack consists of the blobs followed by the header
+	rtest.Equals(t, uint(pack.CalculateHeaderSize(blobs))+offset, p.Size())
+}
+
+func TestCreatePackSealed(t *testing.T) {
+	k := crypto.NewRandomKey()
+	pub, err := k.PublicKey()
+	rtest.OK(t, err)
+	sk, err := crypto.NewSessionKey(pub)
+	rtest.OK(t, err)
+
+	// blobs and header are encrypted with the session key, like a pack saved
+	// with a write-only key
+	p := pack.NewPacker(sk.Key, new(bytes.Buffer))
+	var bufs [][]byte
+	for i, l := range testLens {
+		b := rtest.Random(i, l)
+		bufs = append(bufs, b)
+
+		_, err := p.Add(restic.DataBlob, restic.Hash(b), sk.Seal(nil, b), l)
+		rtest.OK(t, err)
+	}
+
+	_, err = p.Finalize()
+	rtest.OK(t, err)
+
+	packData := p.Writer().(*bytes.Buffer).Bytes()
+	rtest.Equals(t, uint(len(packData)), p.Size())
+
+	entries, err := pack.List(k, bytes.NewReader(packData), int64(len(packData)))
+	rtest.OK(t, err)
+	rtest.Equals(t, len(bufs), len(entries))
+
+	for i, e := range entries {
+		rtest.Equals(t, restic.Hash(bufs[i]), e.ID)
+
+		buf := packData[e.Offset : e.Offset+e.Length]
+		nonce, ciphertext := buf[:k.NonceSize()], buf[k.NonceSize():]
+		plaintext, err := k.Open(nil, nonce, ciphertext, nil)
+		rtest.OK(t, err)
+		rtest.Assert(t, bytes.Equal(bufs[i], plaintext), "data for blob %v doesn't match", i)
+	}
+
+	// the pack cannot be listed with a different key
+	_, err = pack.List(crypto.NewRandomKey(), bytes.NewReader(packData), int64(len(packData)))
+	rtest.Assert(t, err != nil, "expected error for wrong key")
+}
+
 var blobTypeJSON = []struct {
 	t   restic.BlobType
 	res string
@@ -143,3 +231,84 @@ func TestShortPack(t *testing.T) {
 	rtest.OK(t, b.Save(context.TODO(), handle, restic.NewByteReader(packData)))
 	verifyBlobs(t, bufs, k, restic.ReaderAt(context.TODO(), b, handle), packSize)
 }
+
+func TestDamagedHeader(t *testing.T) {
+	k := crypto.NewRandomKey()
+	_, packData, packSize := newPack(t, k, testLens)
+
+	// flip a bit in the encrypted header
+	hdrLen := binary.LittleEndian.Uint32(packData[len(packData)-4:])
+	damaged := append([]byte{}, packData...)
+	damaged[len(damaged)-4-int(hdrLen)+k.NonceSize()] ^= 1
+
+	_, err := pack.List(k, bytes.NewReader(damaged), int64(packSize))
+	_, ok := err.(pack.InvalidFileError)
+	rtest.Assert(t, ok, "expected InvalidFileError, got %v", err)
+
+	// a pack saved with a different key is not readable either
+	_, err = pack.List(crypto.NewRandomKey(), bytes.NewReader(packData), int64(packSize))
+	_, ok = err.(pack.InvalidFileError)
+	rtest.Assert(t, ok, "expected InvalidFileError, got %v", err)
+}
+
+func TestLargePack(t *testing.T) {
+	k := crypto.NewRandomKey()
+
+	f, err := ioutil.TempFile("", "restic-test-pack-")
+	rtest.OK(t, err)
+	defer func() {
+		rtest.OK(t, f.Close())
+		rtest.OK(t, os.Remove(f.Name()))
+	}()
+
+	// fill a pack of the maximum size with large blobs
+	data := rtest.Random(23, 4*1024*1024)
+	p := pack.NewPacker(k, f)
+	var ids restic.IDs
+	for i := 0; p.Size() < restic.MaxPackSize; i++ {
+		id := restic.NewRandomID()
+		_, err := p.Add(restic.DataBlob, id, data, 0)
+		rtest.OK(t, err)
+		ids = append(ids, id)
+	}
+
+	size, err := p.Finalize()
+	rtest.OK(t, err)
+
+	entries, err := pack.List(k, f, int64(size))
+	rtest.OK(t, err)
+	rtest.Equals(t, len(ids), len(entries))
+
+	for i, e := range entries {
+		rtest.Equals(t, ids[i], e.ID)
+		rtest.Equals(t, uint(i*len(data)), e.Offset)
+		rtest.Equals(t, uint(len(data)), e.Length)
+	}
+
+	// check the data of the last blob
+	buf := make([]byte, len(data))
+	_, err = f.ReadAt(buf, int64(entries[len(entries)-1].Offset))
+	rtest.OK(t, err)
+	rtest.Assert(t, bytes.Equal(data, buf), "data of last blob doesn't match")
+}
+
+func TestPackHeaderFull(t *testing.T) {
+	k := crypto.NewRandomKey()
+
+	// add tiny blobs until the header cannot hold any more entries
+	p := pack.NewPacker(k, new(bytes.Buffer))
+	n := 0
+	for !p.HeaderFull() {
+		_, err := p.Add(restic.DataBlob, restic.NewRandomID(), []byte{byte(n)}, 2)
+		rtest.OK(t, err)
+		n++
+	}
+
+	_, err := p.Finalize()
+	rtest.OK(t, err)
+
+	packData := p.Writer().(*bytes.Buffer).Bytes()
+	entries, err := pack.List(k, bytes.NewReader(packData), int64(len(packData)))
+	rtest.OK(t, err)
+	rtest.Equals(t, n, len(entries))
+}
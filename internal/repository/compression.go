@@ -0,0 +1,78 @@
+package repository
+
+import (
+	"sync"
+
+	"github.com/restic/restic/internal/errors"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Blobs are compressed with zstd in repositories with version 2 or later.
+// Encoders and decoders are expensive to create but safe for concurrent use
+// via EncodeAll and DecodeAll, so they are shared by all repositories.
+var (
+	zstdEncoderOnce sync.Once
+	zstdEncoder     *zstd.Encoder
+
+	zstdDecoderOnce sync.Once
+	zstdDecoder     *zstd.Decoder
+)
+
+func getZstdEncoder() *zstd.Encoder {
+	zstdEncoderOnce.Do(func() {
+		enc, err := zstd.NewWriter(nil,
+			zstd.WithEncoderLevel(zstd.SpeedDefault),
+			zstd.WithEncoderCRC(false),
+		)
+		if err != nil {
+			panic(err)
+		}
+		zstdEncoder = enc
+	})
+	return zstdEncoder
+}
+
+func getZstdDecoder() *zstd.Decoder {
+	zstdDecoderOnce.Do(func() {
+		dec, err := zstd.NewReader(nil)
+		if err != nil {
+			panic(err)
+		}
+		zstdDecoder = dec
+	})
+	return zstdDecoder
+}
+
+// compressBlob compresses data. If compression does not reduce the size of
+// the data, ok is false and the data should be stored uncompressed.
+func compressBlob(data []byte) (compressed []byte, ok bool) {
+	compressed = getZstdEncoder().EncodeAll(data, nil)
+	if len(compressed) >= len(data) {
+		return nil, false
+	}
+	return compressed, true
+}
+
+// DecompressBlob decompresses the plaintext of a blob which was stored
+// compressed. The data is appended to dst, which may be nil.
+func DecompressBlob(dst []byte, data []byte, uncompressedLength uint) ([]byte, error) {
+	if cap(dst)-len(dst) < int(uncompressedLength) {
+		buf := make([]byte, len(dst), len(dst)+int(uncompressedLength))
+		copy(buf, dst)
+		dst = buf
+	}
+
+	start := len(dst)
+	dst, err := getZstdDecoder().DecodeAll(data, dst)
+	if err != nil {
+		return nil, errors.Wrap(err, "DecodeAll")
+	}
+
+	if uint(len(dst)-start) != uncompressedLength {
+		return nil, errors.Errorf("decompressed data has wrong length, want %d, got %d",
+			uncompressedLength, len(dst)-start)
+	}
+
+	return dst, nil
+}
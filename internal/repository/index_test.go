@@ -410,6 +410,39 @@ func TestIndexPacks(t *testing.T) {
 	rtest.Assert(t, packs.Equals(idxPacks), "packs in index do not match packs added to index")
 }
 
+func TestIndexLargePack(t *testing.T) {
+	idx := repository.NewIndex()
+	packID := restic.NewRandomID()
+
+	// blobs filling a pack of the maximum size
+	var blobs []restic.Blob
+	for offset := uint(0); offset < restic.MaxPackSize; {
+		blob := restic.Blob{
+			Type:   restic.DataBlob,
+			ID:     restic.NewRandomID(),
+			Offset: offset,
+			Length: 8*1024*1024 - 17,
+		}
+		idx.Store(restic.PackedBlob{Blob: blob, PackID: packID})
+		blobs = append(blobs, blob)
+		offset += blob.Length
+	}
+
+	wr := bytes.NewBuffer(nil)
+	rtest.OK(t, idx.Encode(wr))
+	idx2, _, err := repository.DecodeIndex(wr.Bytes(), restic.NewRandomID())
+	rtest.OK(t, err)
+
+	for _, i := range []*repository.Index{idx, idx2} {
+		for _, blob := range blobs {
+			list := i.Lookup(blob.ID, blob.Type, nil)
+			rtest.Equals(t, 1, len(list))
+			rtest.Equals(t, packID, list[0].PackID)
+			rtest.Equals(t, blob, list[0].Blob)
+		}
+	}
+}
+
 const maxPackSize = 16 * 1024 * 1024
 
 // This function generates a (insecure) random ID, similar to NewRandomID
@@ -15,17 +15,19 @@ import (
 // IndexMap uses some optimizations that are not compatible with supporting
 // deletions.
 //
-// The buckets in this hash table contain only pointers, rather than inlined
-// key-value pairs like the standard Go map. This way, only a pointer array
-// needs to be resized when the table grows, preventing memory usage spikes.
+// The entries are stored in a hashedArrayTree and are referenced by their
+// position instead of a pointer. As the entries contain no pointers, the
+// garbage collector does not have to scan them, and the buckets only need
+// four bytes per entry. Only the bucket array needs to be resized when the
+// table grows, preventing memory usage spikes.
 type indexMap struct {
 	// The number of buckets is always a power of two and never zero.
-	buckets    []*indexEntry
+	buckets    []uint32
 	numentries uint
 
 	key0, key1 uint64 // Key for hash randomization.
 
-	free *indexEntry // Free list.
+	entries hashedArrayTree
 }
 
 const (
@@ -35,7 +37,7 @@ const (
 
 // add inserts an indexEntry for the given arguments into the map,
 // using id as the key.
-func (m *indexMap) add(id restic.ID, packIdx int, offset, length uint32) {
+func (m *indexMap) add(id restic.ID, packIdx int, offset, length uint32, uncompressedLength uint32) {
 	switch {
 	case m.numentries == 0: // Lazy initialization.
 		m.init()
@@ -44,25 +46,24 @@ func (m *indexMap) add(id restic.ID, packIdx int, offset, length uint32) {
 	}
 
 	h := m.hash(id)
-	e := m.newEntry()
+	pos, e := m.entries.alloc()
 	e.id = id
 	e.next = m.buckets[h] // Prepend to existing chain.
-	e.packIndex = packIdx
+	e.packIndex = uint32(packIdx)
 	e.offset = offset
 	e.length = length
+	e.uncompressedLength = uncompressedLength
 
-	m.buckets[h] = e
+	m.buckets[h] = pos
 	m.numentries++
 }
 
 // foreach calls fn for all entries in the map, until fn returns false.
 func (m *indexMap) foreach(fn func(*indexEntry) bool) {
-	for _, e := range m.buckets {
-		for e != nil {
-			if !fn(e) {
-				return
-			}
-			e = e.next
+	// position zero is unused, see init
+	for pos := uint32(1); uint(pos) < m.entries.size; pos++ {
+		if !fn(m.entries.ref(pos)) {
+			return
 		}
 	}
 }
@@ -74,7 +75,9 @@ func (m *indexMap) foreachWithID(id restic.ID, fn func(*indexEntry)) {
 	}
 
 	h := m.hash(id)
-	for e := m.buckets[h]; e != nil; e = e.next {
+	for pos := m.buckets[h]; pos != 0; {
+		e := m.entries.ref(pos)
+		pos = e.next
 		if e.id != id {
 			continue
 		}
@@ -89,26 +92,24 @@ func (m *indexMap) get(id restic.ID) *indexEntry {
 	}
 
 	h := m.hash(id)
-	for e := m.buckets[h]; e != nil; e = e.next {
+	for pos := m.buckets[h]; pos != 0; {
+		e := m.entries.ref(pos)
 		if e.id == id {
 			return e
 		}
+		pos = e.next
 	}
 	return nil
 }
 
 func (m *indexMap) grow() {
-	old := m.buckets
-	m.buckets = make([]*indexEntry, growthFactor*len(m.buckets))
-
-	for _, e := range old {
-		for e != nil {
-			h := m.hash(e.id)
-			next := e.next
-			e.next = m.buckets[h]
-			m.buckets[h] = e
-			e = next
-		}
+	m.buckets = make([]uint32, growthFactor*len(m.buckets))
+
+	for pos := uint32(1); uint(pos) < m.entries.size; pos++ {
+		e := m.entries.ref(pos)
+		h := m.hash(e.id)
+		e.next = m.buckets[h]
+		m.buckets[h] = pos
 	}
 }
 
@@ -124,7 +125,7 @@ func (m *indexMap) hash(id restic.ID) uint {
 
 func (m *indexMap) init() {
 	const initialBuckets = 64
-	m.buckets = make([]*indexEntry, initialBuckets)
+	m.buckets = make([]uint32, initialBuckets)
 
 	var buf [16]byte
 	if _, err := rand.Read(buf[:]); err != nil {
@@ -132,37 +133,82 @@ func (m *indexMap) init() {
 	}
 	m.key0 = binary.LittleEndian.Uint64(buf[:8])
 	m.key1 = binary.LittleEndian.Uint64(buf[8:])
+
+	// position zero marks the end of a chain, so it must not hold an entry
+	m.entries.alloc()
 }
 
 func (m *indexMap) len() uint { return m.numentries }
 
-func (m *indexMap) newEntry() *indexEntry {
-	// Allocating in batches means that we get closer to optimal space usage,
-	// as Go's malloc will overallocate for structures of size 56 (indexEntry
-	// on amd64).
-	//
-	// 256*56 and 256*48 both have minimal malloc overhead among reasonable sizes.
-	// See src/runtime/sizeclasses.go in the standard library.
-	const entryAllocBatch = 256
-
-	if m.free == nil {
-		free := new([entryAllocBatch]indexEntry)
-		for i := range free[:len(free)-1] {
-			free[i].next = &free[i+1]
-		}
-		m.free = &free[0]
+type indexEntry struct {
+	id                 restic.ID
+	next               uint32 // Position of the next entry in the chain, zero for none.
+	packIndex          uint32 // Position in containing Index's packs field.
+	offset             uint32
+	length             uint32
+	uncompressedLength uint32 // Zero for blobs stored uncompressed.
+}
+
+// A hashedArrayTree is a growable array of indexEntries. The entries are
+// stored in blocks, the number of blocks and the block size are the same
+// power of two. When all blocks are full, the block size is doubled by
+// merging pairs of blocks. Contrary to a slice, growing the array only
+// requires memory for one additional block of entries instead of a complete
+// copy, and at most O(sqrt(n)) entries are allocated but unused.
+type hashedArrayTree struct {
+	blockShift uint // log2 of the block size
+	size       uint
+	blocks     [][]indexEntry
+}
+
+// alloc appends an entry to the array and returns its position.
+func (h *hashedArrayTree) alloc() (uint32, *indexEntry) {
+	if h.size >= maxuint32 {
+		panic("too many entries in index")
+	}
+	if h.blocks == nil {
+		const initialBlockShift = 2
+		h.blockShift = initialBlockShift
+		h.blocks = make([][]indexEntry, 1<<initialBlockShift)
 	}
 
-	e := m.free
-	m.free = m.free.next
+	idx, subIdx := h.index(h.size)
+	if idx == uint(len(h.blocks)) {
+		h.grow()
+		idx, subIdx = h.index(h.size)
+	}
+	if subIdx == 0 {
+		h.blocks[idx] = make([]indexEntry, 1<<h.blockShift)
+	}
 
-	return e
+	pos := h.size
+	h.size++
+	return uint32(pos), &h.blocks[idx][subIdx]
 }
 
-type indexEntry struct {
-	id        restic.ID
-	next      *indexEntry
-	packIndex int // Position in containing Index's packs field.
-	offset    uint32
-	length    uint32
+// ref returns the entry at position pos.
+func (h *hashedArrayTree) ref(pos uint32) *indexEntry {
+	idx, subIdx := h.index(uint(pos))
+	return &h.blocks[idx][subIdx]
+}
+
+func (h *hashedArrayTree) index(pos uint) (idx, subIdx uint) {
+	return pos >> h.blockShift, pos & (1<<h.blockShift - 1)
+}
+
+// grow doubles the number of blocks and the block size.
+func (h *hashedArrayTree) grow() {
+	old := h.blocks
+	h.blockShift++
+	h.blocks = make([][]indexEntry, 1<<h.blockShift)
+
+	for i := 0; i < len(old); i += 2 {
+		block := make([]indexEntry, 0, 1<<h.blockShift)
+		block = append(block, old[i]...)
+		block = append(block, old[i+1]...)
+		h.blocks[i/2] = block
+
+		// allow the garbage collector to free the old blocks right away
+		old[i], old[i+1] = nil, nil
+	}
 }
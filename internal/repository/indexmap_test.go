@@ -22,7 +22,7 @@ func TestIndexMapBasic(t *testing.T) {
 		r.Read(id[:])
 		rtest.Assert(t, m.get(id) == nil, "%v retrieved but not added", id)
 
-		m.add(id, 0, 0, 0)
+		m.add(id, 0, 0, 0, 0)
 		rtest.Assert(t, m.get(id) != nil, "%v added but not retrieved", id)
 		rtest.Equals(t, uint(i), m.len())
 	}
@@ -41,16 +41,17 @@ func TestIndexMapForeach(t *testing.T) {
 	for i := 0; i < N; i++ {
 		var id restic.ID
 		id[0] = byte(i)
-		m.add(id, i, uint32(i), uint32(i))
+		m.add(id, i, uint32(i), uint32(i), uint32(i/2))
 	}
 
 	seen := make(map[int]struct{})
 	m.foreach(func(e *indexEntry) bool {
 		i := int(e.id[0])
 		rtest.Assert(t, i < N, "unknown id %v in indexMap", e.id)
-		rtest.Equals(t, i, e.packIndex)
+		rtest.Equals(t, i, int(e.packIndex))
 		rtest.Equals(t, i, int(e.length))
 		rtest.Equals(t, i, int(e.offset))
+		rtest.Equals(t, i/2, int(e.uncompressedLength))
 
 		seen[i] = struct{}{}
 		return true
@@ -85,13 +86,13 @@ func TestIndexMapForeachWithID(t *testing.T) {
 
 	// Test insertion and retrieval of duplicates.
 	for i := 0; i < ndups; i++ {
-		m.add(id, i, 0, 0)
+		m.add(id, i, 0, 0, 0)
 	}
 
 	for i := 0; i < 100; i++ {
 		var otherid restic.ID
 		r.Read(otherid[:])
-		m.add(otherid, -1, 0, 0)
+		m.add(otherid, -1, 0, 0, 0)
 	}
 
 	n = 0
@@ -114,8 +115,8 @@ func TestIndexMapHash(t *testing.T) {
 
 	id := restic.NewRandomID()
 	// Add to both maps to initialize them.
-	m1.add(id, 0, 0, 0)
-	m2.add(id, 0, 0, 0)
+	m1.add(id, 0, 0, 0, 0)
+	m2.add(id, 0, 0, 0, 0)
 
 	h1 := m1.hash(id)
 	h2 := m2.hash(id)
@@ -133,9 +134,35 @@ func TestIndexMapHash(t *testing.T) {
 	}
 }
 
+func TestHashedArrayTree(t *testing.T) {
+	t.Parallel()
+
+	var h hashedArrayTree
+	const N = 10000
+
+	for i := 0; i < N; i++ {
+		pos, e := h.alloc()
+		rtest.Equals(t, uint32(i), pos)
+		e.offset = uint32(i)
+	}
+	rtest.Equals(t, uint(N), h.size)
+
+	// entries keep their values when the blocks are merged
+	for i := 0; i < N; i++ {
+		rtest.Equals(t, uint32(i), h.ref(uint32(i)).offset)
+	}
+
+	// the unused part of the last block is small
+	var allocated int
+	for _, block := range h.blocks {
+		allocated += len(block)
+	}
+	rtest.Assert(t, allocated < N+N/10, "%d entries allocated for %d entries", allocated, N)
+}
+
 func BenchmarkIndexMapHash(b *testing.B) {
 	var m indexMap
-	m.add(restic.ID{}, 0, 0, 0) // Trigger lazy initialization.
+	m.add(restic.ID{}, 0, 0, 0, 0) // Trigger lazy initialization.
 
 	ids := make([]restic.ID, 128) // 4 KiB.
 	r := rand.New(rand.NewSource(time.Now().UnixNano()))
@@ -37,12 +37,23 @@ type Key struct {
 	Salt []byte `json:"salt"`
 	Data []byte `json:"data"`
 
-	user   *crypto.Key
-	master *crypto.Key
+	// WriteOnly is set for keys which do not contain the master key. Data
+	// then contains the public key and the repository config instead.
+	WriteOnly bool `json:"write_only,omitempty"`
+
+	user      *crypto.Key
+	master    *crypto.Key
+	writeOnly *writeOnlyKey
 
 	name string
 }
 
+// writeOnlyKey is stored encrypted in the Data field of write-only keys.
+type writeOnlyKey struct {
+	PublicKey crypto.PublicKey `json:"public_key"`
+	Config    restic.Config    `json:"config"`
+}
+
 // Params tracks the parameters used for the KDF. If not set, it will be
 // calibrated on the first run of AddKey().
 var Params *crypto.Params
@@ -93,8 +104,13 @@ func OpenKey(ctx context.Context, s *Repository, name string, password string) (
 	}
 
 	// restore json
-	k.master = &crypto.Key{}
-	err = json.Unmarshal(buf, k.master)
+	if k.WriteOnly {
+		k.writeOnly = &writeOnlyKey{}
+		err = json.Unmarshal(buf, k.writeOnly)
+	} else {
+		k.master = &crypto.Key{}
+		err = json.Unmarshal(buf, k.master)
+	}
 	if err != nil {
 		debug.Log("Unmarshal() returned error %v", err)
 		return nil, errors.Wrap(err, "Unmarshal")
@@ -200,6 +216,64 @@ func LoadKey(ctx context.Context, s *Repository, name string) (k *Key, err error
 
 // AddKey adds a new key to an already existing repository.
 func AddKey(ctx context.Context, s *Repository, password, username, hostname string, template *crypto.Key) (*Key, error) {
+	newkey, err := newKey(password, username, hostname)
+	if err != nil {
+		return nil, err
+	}
+
+	if template == nil {
+		// generate new random master keys
+		newkey.master = crypto.NewRandomKey()
+	} else {
+		// copy master keys from old key
+		newkey.master = template
+	}
+
+	// encrypt master keys (as json) with user key
+	buf, err := json.Marshal(newkey.master)
+	if err != nil {
+		return nil, errors.Wrap(err, "Marshal")
+	}
+
+	err = saveKey(ctx, s, newkey, buf)
+	if err != nil {
+		return nil, err
+	}
+
+	return newkey, nil
+}
+
+// AddWriteOnlyKey adds a new key to an already existing repository which can
+// only be used to add data. The data is sealed for the public key pub, cfg is
+// the config of the repository.
+func AddWriteOnlyKey(ctx context.Context, s *Repository, password, username, hostname string, pub crypto.PublicKey, cfg restic.Config) (*Key, error) {
+	if !cfg.SupportsCompression() {
+		return nil, errors.Fatalf("write-only keys require repository version %d or later", restic.CompressedRepoVersion)
+	}
+
+	newkey, err := newKey(password, username, hostname)
+	if err != nil {
+		return nil, err
+	}
+
+	newkey.WriteOnly = true
+	newkey.writeOnly = &writeOnlyKey{PublicKey: pub, Config: cfg}
+
+	buf, err := json.Marshal(newkey.writeOnly)
+	if err != nil {
+		return nil, errors.Wrap(err, "Marshal")
+	}
+
+	err = saveKey(ctx, s, newkey, buf)
+	if err != nil {
+		return nil, err
+	}
+
+	return newkey, nil
+}
+
+// newKey returns a new key for password, the user key is already derived.
+func newKey(password, username, hostname string) (*Key, error) {
 	// make sure we have valid KDF parameters
 	if Params == nil {
 		p, err := crypto.Calibrate(KDFTimeout, KDFMemory)
@@ -247,30 +321,22 @@ func AddKey(ctx context.Context, s *Repository, password, username, hostname str
 		return nil, err
 	}
 
-	if template == nil {
-		// generate new random master keys
-		newkey.master = crypto.NewRandomKey()
-	} else {
-		// copy master keys from old key
-		newkey.master = template
-	}
-
-	// encrypt master keys (as json) with user key
-	buf, err := json.Marshal(newkey.master)
-	if err != nil {
-		return nil, errors.Wrap(err, "Marshal")
-	}
+	return newkey, nil
+}
 
+// saveKey encrypts plaintext with the user key, stores it in the Data field
+// and saves the key in the repository.
+func saveKey(ctx context.Context, s *Repository, newkey *Key, plaintext []byte) error {
 	nonce := crypto.NewRandomNonce()
-	ciphertext := make([]byte, 0, len(buf)+newkey.user.Overhead()+newkey.user.NonceSize())
+	ciphertext := make([]byte, 0, len(plaintext)+newkey.user.Overhead()+newkey.user.NonceSize())
 	ciphertext = append(ciphertext, nonce...)
-	ciphertext = newkey.user.Seal(ciphertext, nonce, buf, nil)
+	ciphertext = newkey.user.Seal(ciphertext, nonce, plaintext, nil)
 	newkey.Data = ciphertext
 
 	// dump as json
-	buf, err = json.Marshal(newkey)
+	buf, err := json.Marshal(newkey)
 	if err != nil {
-		return nil, errors.Wrap(err, "Marshal")
+		return errors.Wrap(err, "Marshal")
 	}
 
 	// store in repository and return
@@ -281,12 +347,12 @@ func AddKey(ctx context.Context, s *Repository, password, username, hostname str
 
 	err = s.be.Save(ctx, h, restic.NewByteReader(buf))
 	if err != nil {
-		return nil, err
+		return err
 	}
 
 	newkey.name = h.Name
 
-	return newkey, nil
+	return nil
 }
 
 func (k *Key) String() string {
@@ -303,5 +369,8 @@ func (k Key) Name() string {
 
 // Valid tests whether the mac and encryption keys are valid (i.e. not zero)
 func (k *Key) Valid() bool {
+	if k.writeOnly != nil {
+		return k.user.Valid()
+	}
 	return k.user.Valid() && k.master.Valid()
 }
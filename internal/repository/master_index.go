@@ -2,6 +2,7 @@ package repository
 
 import (
 	"context"
+	"encoding/binary"
 	"sync"
 
 	"github.com/restic/restic/internal/restic"
@@ -310,3 +311,105 @@ func (mi *MasterIndex) RebuildIndex(ctx context.Context, packBlacklist restic.ID
 
 	return newIndex, nil
 }
+
+// indexFilesFor returns the number of index files needed to store the given
+// number of blobs.
+func indexFilesFor(blobs uint) int {
+	return int(blobs/indexMaxBlobs + 1)
+}
+
+// IsCompact returns true if the final indexes were loaded from no more index
+// files than Save would write for the known blobs.
+func (mi *MasterIndex) IsCompact() bool {
+	mi.idxMutex.RLock()
+	defer mi.idxMutex.RUnlock()
+
+	var blobs uint
+	var files int
+	for _, idx := range mi.idx {
+		blobs += idx.Count(restic.DataBlob) + idx.Count(restic.TreeBlob)
+
+		if idx.Final() {
+			ids, err := idx.IDs()
+			if err == nil {
+				files += len(ids)
+			}
+		}
+	}
+
+	return files <= indexFilesFor(blobs)
+}
+
+// Save writes the contents of all known indexes to new index files, leaving
+// out any packs whose ID is contained in packBlacklist. The packs are spread
+// over as many index files as needed to store on average at most
+// indexMaxBlobs blobs per file, all blobs of a pack end up in the same file.
+// The first new index file supersedes all known index files. The IDs of these
+// are returned so that the caller can remove them afterwards, together with
+// the IDs of the new index files.
+func (mi *MasterIndex) Save(ctx context.Context, repo restic.Repository, packBlacklist restic.IDSet) (obsolete restic.IDSet, saved restic.IDs, err error) {
+	mi.idxMutex.Lock()
+	defer mi.idxMutex.Unlock()
+
+	debug.Log("start saving %d indexes, pack blacklist: %v", len(mi.idx), packBlacklist)
+
+	obsolete = restic.NewIDSet()
+	var blobs uint
+	for i, idx := range mi.idx {
+		blobs += idx.Count(restic.DataBlob) + idx.Count(restic.TreeBlob)
+
+		if !idx.Final() {
+			debug.Log("index %d isn't final, don't add to supersedes field", i)
+			continue
+		}
+
+		ids, err := idx.IDs()
+		if err != nil {
+			debug.Log("index %d does not have an ID: %v", i, err)
+			return nil, nil, err
+		}
+		obsolete.Merge(restic.NewIDSet(ids...))
+	}
+
+	newIndexes := make([]*Index, indexFilesFor(blobs))
+	for i := range newIndexes {
+		newIndexes[i] = NewIndex()
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	for _, idx := range mi.idx {
+		for pb := range idx.Each(ctx) {
+			if packBlacklist.Has(pb.PackID) {
+				continue
+			}
+
+			// pack IDs are random, so this distributes the packs evenly
+			n := binary.LittleEndian.Uint32(pb.PackID[:4]) % uint32(len(newIndexes))
+			newIndexes[n].Store(pb)
+		}
+	}
+
+	err = newIndexes[0].AddToSupersedes(obsolete.List()...)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for i, idx := range newIndexes {
+		// the first index is saved even if it's empty, as it carries the
+		// list of superseded indexes
+		if i > 0 && len(idx.Packs()) == 0 {
+			continue
+		}
+
+		id, err := SaveIndex(ctx, repo, idx)
+		if err != nil {
+			return nil, nil, err
+		}
+		debug.Log("saved new index %d as %v", i, id)
+		saved = append(saved, id)
+	}
+
+	return obsolete, saved, nil
+}
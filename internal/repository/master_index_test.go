@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"math/rand"
+	"runtime"
 	"testing"
 
 	"github.com/restic/restic/internal/repository"
@@ -213,6 +214,83 @@ func TestMasterMergeFinalIndexes(t *testing.T) {
 	rtest.Equals(t, 2, blobCount)
 }
 
+func TestMasterIndexSave(t *testing.T) {
+	r, cleanup := repository.TestRepository(t)
+	defer cleanup()
+
+	repo := r.(*repository.Repository)
+
+	// add 5 packs, each with its own index
+	for i := 0; i < 5; i++ {
+		saveRandomDataBlobs(t, repo, 5, 1<<15)
+		rtest.OK(t, repo.Flush(context.TODO()))
+	}
+
+	// reload the index from the repository
+	repo.SetIndex(repository.NewMasterIndex())
+	rtest.OK(t, repo.LoadIndex(context.TODO()))
+
+	packs := repo.Index().(*repository.MasterIndex).All()[0].Packs()
+	rtest.Equals(t, 5, len(packs))
+	removePack := packs.List()[0]
+
+	obsolete, _, err := repo.Index().(*repository.MasterIndex).Save(context.TODO(), repo, restic.NewIDSet(removePack))
+	rtest.OK(t, err)
+	rtest.Equals(t, 5, len(obsolete))
+
+	for id := range obsolete {
+		rtest.OK(t, repo.Backend().Remove(context.TODO(), restic.Handle{Type: restic.IndexFile, Name: id.String()}))
+	}
+
+	repo.SetIndex(repository.NewMasterIndex())
+	rtest.OK(t, repo.LoadIndex(context.TODO()))
+
+	newPacks := restic.NewIDSet()
+	for _, idx := range repo.Index().(*repository.MasterIndex).All() {
+		newPacks.Merge(idx.Packs())
+	}
+	packs.Delete(removePack)
+	rtest.Equals(t, packs, newPacks)
+}
+
+func TestMasterIndexIsCompact(t *testing.T) {
+	r, cleanup := repository.TestRepository(t)
+	defer cleanup()
+
+	repo := r.(*repository.Repository)
+
+	// a single index file is compact
+	saveRandomDataBlobs(t, repo, 5, 1<<15)
+	rtest.OK(t, repo.Flush(context.TODO()))
+
+	repo.SetIndex(repository.NewMasterIndex())
+	rtest.OK(t, repo.LoadIndex(context.TODO()))
+	rtest.Assert(t, repo.Index().(*repository.MasterIndex).IsCompact(), "single index file is not compact")
+
+	// but two small ones are not
+	saveRandomDataBlobs(t, repo, 5, 1<<15)
+	rtest.OK(t, repo.Flush(context.TODO()))
+
+	repo.SetIndex(repository.NewMasterIndex())
+	rtest.OK(t, repo.LoadIndex(context.TODO()))
+	mi := repo.Index().(*repository.MasterIndex)
+	rtest.Assert(t, !mi.IsCompact(), "two small index files are compact")
+
+	// saving the index merges them
+	obsolete, saved, err := mi.Save(context.TODO(), repo, nil)
+	rtest.OK(t, err)
+	rtest.Equals(t, 2, len(obsolete))
+	rtest.Equals(t, 1, len(saved))
+
+	for id := range obsolete {
+		rtest.OK(t, repo.Backend().Remove(context.TODO(), restic.Handle{Type: restic.IndexFile, Name: id.String()}))
+	}
+
+	repo.SetIndex(repository.NewMasterIndex())
+	rtest.OK(t, repo.LoadIndex(context.TODO()))
+	rtest.Assert(t, repo.Index().(*repository.MasterIndex).IsCompact(), "merged index file is not compact")
+}
+
 func createRandomMasterIndex(rng *rand.Rand, num, size int) (*repository.MasterIndex, restic.ID) {
 	mIdx := repository.NewMasterIndex()
 	for i := 0; i < num-1; i++ {
@@ -237,6 +315,31 @@ func BenchmarkMasterIndexAlloc(b *testing.B) {
 	}
 }
 
+// BenchmarkMasterIndexMemory reports the heap memory used by the master
+// index per blob after merging the given number of index files.
+func BenchmarkMasterIndexMemory(b *testing.B) {
+	for _, num := range []int{1, 1000} {
+		b.Run(fmt.Sprintf("indexes=%d", num), func(b *testing.B) {
+			rng := rand.New(rand.NewSource(0))
+
+			for i := 0; i < b.N; i++ {
+				var before, after runtime.MemStats
+				runtime.GC()
+				runtime.ReadMemStats(&before)
+
+				mIdx, _ := createRandomMasterIndex(rng, num, 20000/num)
+
+				runtime.GC()
+				runtime.ReadMemStats(&after)
+
+				blobs := mIdx.Count(restic.DataBlob)
+				b.ReportMetric(float64(after.HeapAlloc-before.HeapAlloc)/float64(blobs), "bytes/blob")
+				runtime.KeepAlive(mIdx)
+			}
+		})
+	}
+}
+
 func BenchmarkMasterIndexLookupSingleIndex(b *testing.B) {
 	mIdx, lookupID := createRandomMasterIndex(rand.New(rand.NewSource(0)), 1, 200000)
 
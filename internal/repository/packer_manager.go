@@ -27,18 +27,20 @@ type Packer struct {
 	*pack.Packer
 	hw      *hashing.Writer
 	tmpfile *os.File
+
+	// sessionKey is used to seal the blobs of the pack for a write-only key
+	sessionKey *crypto.SessionKey
 }
 
 // packerManager keeps a list of open packs and creates new on demand.
 type packerManager struct {
-	be      Saver
-	key     *crypto.Key
-	pm      sync.Mutex
-	packers []*Packer
+	be        Saver
+	key       *crypto.Key
+	publicKey *crypto.PublicKey
+	pm        sync.Mutex
+	packers   []*Packer
 }
 
-const minPackSize = 4 * 1024 * 1024
-
 // newPackerManager returns an new packer manager which writes temporary files
 // to a temporary directory
 func newPackerManager(be Saver, key *crypto.Key) *packerManager {
@@ -68,12 +70,26 @@ func (r *packerManager) findPacker() (packer *Packer, err error) {
 		return nil, errors.Wrap(err, "fs.TempFile")
 	}
 
+	// packs saved with a write-only key are encrypted with a new session key
+	key := r.key
+	var sessionKey *crypto.SessionKey
+	if r.publicKey != nil {
+		sessionKey, err = crypto.NewSessionKey(*r.publicKey)
+		if err != nil {
+			_ = tmpfile.Close()
+			_ = fs.RemoveIfExists(tmpfile.Name())
+			return nil, err
+		}
+		key = sessionKey.Key
+	}
+
 	hw := hashing.NewWriter(tmpfile, sha256.New())
-	p := pack.NewPacker(r.key, hw)
+	p := pack.NewPacker(key, hw)
 	packer = &Packer{
-		Packer:  p,
-		hw:      hw,
-		tmpfile: tmpfile,
+		Packer:     p,
+		hw:         hw,
+		tmpfile:    tmpfile,
+		sessionKey: sessionKey,
 	}
 
 	return packer, nil
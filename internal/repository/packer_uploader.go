@@ -0,0 +1,169 @@
+package repository
+
+import (
+	"context"
+	"sync"
+
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/fs"
+	"github.com/restic/restic/internal/restic"
+)
+
+// DefaultUploadConcurrency is the number of pack files which are uploaded
+// concurrently unless configured otherwise.
+const DefaultUploadConcurrency = 5
+
+// packerUploader uploads finished packs in the background, so that saving
+// blobs does not have to wait for the backend. The number of concurrent
+// uploads is limited, as is the size of the packs which are queued or being
+// uploaded. Packs are stored in temporary files until they are uploaded, so
+// the limit bounds the temporary disk space (or memory for a tmpfs).
+//
+// Uploads do not use the context of the caller which queued the pack, which
+// may be cancelled as soon as the blobs are saved, but a context owned by the
+// uploader. It is only cancelled when Wait is aborted.
+type packerUploader struct {
+	save       func(context.Context, restic.BlobType, *Packer) error
+	onComplete func(bytes uint64)
+
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	m    sync.Mutex
+	cond *sync.Cond
+	wg   sync.WaitGroup
+
+	concurrency uint
+	active      uint
+	queued      uint
+	err         error
+}
+
+// newPackerUploader returns a new uploader which saves packs using save.
+func newPackerUploader(save func(context.Context, restic.BlobType, *Packer) error) *packerUploader {
+	u := &packerUploader{
+		save:        save,
+		concurrency: DefaultUploadConcurrency,
+	}
+	u.ctx, u.cancel = context.WithCancel(context.Background())
+	u.cond = sync.NewCond(&u.m)
+
+	// wake up all goroutines waiting in Queue or upload when uploads are
+	// aborted
+	go func() {
+		<-u.ctx.Done()
+		u.m.Lock()
+		u.cond.Broadcast()
+		u.m.Unlock()
+	}()
+
+	return u
+}
+
+// SetConcurrency sets the number of packs which are uploaded concurrently.
+func (u *packerUploader) SetConcurrency(n uint) {
+	u.m.Lock()
+	defer u.m.Unlock()
+
+	u.concurrency = n
+	u.cond.Broadcast()
+}
+
+// Queue starts uploading the pack p in the background. If the packs which
+// are queued or being uploaded would exceed budget bytes together with p, it
+// blocks until enough uploads have finished. A single pack is always queued,
+// regardless of its size. Once an upload has failed or the uploads were
+// aborted, the error is returned and no more packs are uploaded.
+func (u *packerUploader) Queue(t restic.BlobType, p *Packer, budget uint) error {
+	size := p.Size()
+
+	u.m.Lock()
+	for u.err == nil && u.ctx.Err() == nil && u.queued > 0 && u.queued+size > budget {
+		u.cond.Wait()
+	}
+	err := u.err
+	if err == nil {
+		err = u.ctx.Err()
+	}
+	if err == nil {
+		u.queued += size
+	}
+	u.m.Unlock()
+
+	if err != nil {
+		_ = p.tmpfile.Close()
+		_ = fs.RemoveIfExists(p.tmpfile.Name())
+		return err
+	}
+
+	debug.Log("queue pack with %d bytes for upload", size)
+	u.wg.Add(1)
+	go u.upload(t, p, size)
+	return nil
+}
+
+func (u *packerUploader) upload(t restic.BlobType, p *Packer, size uint) {
+	defer u.wg.Done()
+
+	u.m.Lock()
+	for u.ctx.Err() == nil && u.active >= u.concurrency {
+		u.cond.Wait()
+	}
+	if u.err != nil || u.ctx.Err() != nil {
+		// another upload has failed or the uploads were aborted, don't
+		// bother uploading this pack
+		u.queued -= size
+		u.cond.Broadcast()
+		u.m.Unlock()
+
+		_ = p.tmpfile.Close()
+		_ = fs.RemoveIfExists(p.tmpfile.Name())
+		return
+	}
+	u.active++
+	u.m.Unlock()
+
+	err := u.save(u.ctx, t, p)
+
+	u.m.Lock()
+	u.active--
+	u.queued -= size
+	if err != nil && u.err == nil {
+		u.err = err
+	}
+	u.cond.Broadcast()
+	u.m.Unlock()
+
+	if err == nil && u.onComplete != nil {
+		u.onComplete(uint64(size))
+	}
+}
+
+// Wait waits until all queued packs are uploaded and returns the first error
+// which occurred. When ctx is cancelled, the running uploads are aborted and
+// no more packs are uploaded afterwards.
+func (u *packerUploader) Wait(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		u.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-ctx.Done():
+		debug.Log("abort uploads: %v", ctx.Err())
+		u.cancel()
+		<-done
+
+		u.m.Lock()
+		if u.err == nil {
+			u.err = ctx.Err()
+		}
+		u.m.Unlock()
+	}
+
+	u.m.Lock()
+	defer u.m.Unlock()
+	return u.err
+}
@@ -0,0 +1,202 @@
+package repository
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/restic/restic/internal/crypto"
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/fs"
+	"github.com/restic/restic/internal/restic"
+	rtest "github.com/restic/restic/internal/test"
+)
+
+// newTestPacker returns a packer containing a single blob of size bytes.
+func newTestPacker(t testing.TB, pm *packerManager, size int) *Packer {
+	p, err := pm.findPacker()
+	rtest.OK(t, err)
+	_, err = p.Add(restic.DataBlob, restic.NewRandomID(), make([]byte, size), 0)
+	rtest.OK(t, err)
+	return p
+}
+
+// blockingSaver records the packs being saved and blocks each save until it
+// is released or the context is cancelled.
+type blockingSaver struct {
+	m         sync.Mutex
+	active    int
+	maxActive int
+	saved     int
+	err       error
+	release   chan struct{}
+}
+
+func (s *blockingSaver) save(ctx context.Context, t restic.BlobType, p *Packer) error {
+	s.m.Lock()
+	s.active++
+	if s.active > s.maxActive {
+		s.maxActive = s.active
+	}
+	s.m.Unlock()
+
+	err := s.err
+	select {
+	case <-s.release:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	s.m.Lock()
+	defer s.m.Unlock()
+	s.active--
+	s.saved++
+
+	_ = p.tmpfile.Close()
+	_ = fs.RemoveIfExists(p.tmpfile.Name())
+	return err
+}
+
+func TestPackerUploaderConcurrency(t *testing.T) {
+	pm := newPackerManager(nil, crypto.NewRandomKey())
+	s := &blockingSaver{release: make(chan struct{})}
+	u := newPackerUploader(s.save)
+	u.SetConcurrency(3)
+
+	var uploaded uint64
+	u.onComplete = func(bytes uint64) {
+		s.m.Lock()
+		uploaded += bytes
+		s.m.Unlock()
+	}
+
+	for i := 0; i < 10; i++ {
+		rtest.OK(t, u.Queue(restic.DataBlob, newTestPacker(t, pm, 1000), 1<<20))
+	}
+	// wait until the maximum number of uploads is running
+	for {
+		s.m.Lock()
+		active := s.active
+		s.m.Unlock()
+		if active == 3 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	for i := 0; i < 10; i++ {
+		s.release <- struct{}{}
+	}
+	rtest.OK(t, u.Wait(context.TODO()))
+
+	rtest.Equals(t, 3, s.maxActive)
+	rtest.Equals(t, 10, s.saved)
+	rtest.Equals(t, uint64(10*1000), uploaded)
+}
+
+func TestPackerUploaderBudget(t *testing.T) {
+	pm := newPackerManager(nil, crypto.NewRandomKey())
+	s := &blockingSaver{release: make(chan struct{})}
+	u := newPackerUploader(s.save)
+
+	// the budget allows two packs
+	rtest.OK(t, u.Queue(restic.DataBlob, newTestPacker(t, pm, 1000), 2500))
+	rtest.OK(t, u.Queue(restic.DataBlob, newTestPacker(t, pm, 1000), 2500))
+
+	queued := make(chan error)
+	go func() {
+		queued <- u.Queue(restic.DataBlob, newTestPacker(t, pm, 1000), 2500)
+	}()
+
+	select {
+	case <-queued:
+		t.Fatal("third pack was queued although the budget is exhausted")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// finishing an upload frees the budget for the third pack
+	s.release <- struct{}{}
+	rtest.OK(t, <-queued)
+
+	s.release <- struct{}{}
+	s.release <- struct{}{}
+	rtest.OK(t, u.Wait(context.TODO()))
+	rtest.Equals(t, 3, s.saved)
+
+	// a pack larger than the budget is queued if no other upload is pending
+	rtest.OK(t, u.Queue(restic.DataBlob, newTestPacker(t, pm, 5000), 2500))
+	s.release <- struct{}{}
+	rtest.OK(t, u.Wait(context.TODO()))
+}
+
+func TestPackerUploaderError(t *testing.T) {
+	pm := newPackerManager(nil, crypto.NewRandomKey())
+	s := &blockingSaver{release: make(chan struct{}), err: errors.New("upload failed")}
+	u := newPackerUploader(s.save)
+
+	rtest.OK(t, u.Queue(restic.DataBlob, newTestPacker(t, pm, 1000), 1<<20))
+	close(s.release)
+	rtest.Assert(t, u.Wait(context.TODO()) != nil, "expected error from Wait")
+
+	// no more packs are uploaded after an error
+	err := u.Queue(restic.DataBlob, newTestPacker(t, pm, 1000), 1<<20)
+	rtest.Assert(t, err != nil, "expected error from Queue")
+	rtest.Equals(t, 1, s.saved)
+}
+
+func TestPackerUploaderCancel(t *testing.T) {
+	pm := newPackerManager(nil, crypto.NewRandomKey())
+	s := &blockingSaver{release: make(chan struct{})}
+	u := newPackerUploader(s.save)
+
+	rtest.OK(t, u.Queue(restic.DataBlob, newTestPacker(t, pm, 1000), 1<<20))
+	for {
+		s.m.Lock()
+		active := s.active
+		s.m.Unlock()
+		if active == 1 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	// cancelling the context passed to Wait aborts the running upload
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	rtest.Equals(t, context.Canceled, u.Wait(ctx))
+	rtest.Equals(t, 1, s.saved)
+
+	err := u.Queue(restic.DataBlob, newTestPacker(t, pm, 1000), 1<<20)
+	rtest.Equals(t, context.Canceled, err)
+}
+
+func TestPackerUploaderCancelQueue(t *testing.T) {
+	pm := newPackerManager(nil, crypto.NewRandomKey())
+	release := make(chan struct{})
+	// the upload ignores the context
+	u := newPackerUploader(func(ctx context.Context, t restic.BlobType, p *Packer) error {
+		<-release
+		_ = p.tmpfile.Close()
+		return fs.RemoveIfExists(p.tmpfile.Name())
+	})
+
+	rtest.OK(t, u.Queue(restic.DataBlob, newTestPacker(t, pm, 1000), 1500))
+
+	queued := make(chan error)
+	go func() {
+		queued <- u.Queue(restic.DataBlob, newTestPacker(t, pm, 1000), 1500)
+	}()
+
+	// aborting the uploads wakes up Queue, which waits for the budget
+	u.cancel()
+	select {
+	case err := <-queued:
+		rtest.Equals(t, context.Canceled, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("Queue did not return after the uploads were aborted")
+	}
+
+	close(release)
+	u.wg.Wait()
+}
@@ -78,6 +78,13 @@ func Repack(ctx context.Context, repo restic.Repository, packs restic.IDSet, kee
 				return nil, err
 			}
 
+			if entry.IsCompressed() {
+				plaintext, err = DecompressBlob(nil, plaintext, entry.UncompressedLength)
+				if err != nil {
+					return nil, err
+				}
+			}
+
 			id := restic.Hash(plaintext)
 			if !id.Equal(entry.ID) {
 				debug.Log("read blob %v/%v from %v: wrong data returned, hash is %v",
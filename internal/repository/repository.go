@@ -7,6 +7,7 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"runtime"
 
 	"github.com/restic/chunker"
 	"github.com/restic/restic/internal/cache"
@@ -22,6 +23,10 @@ import (
 	"golang.org/x/sync/errgroup"
 )
 
+// ErrWriteOnly is returned when data is read from a repository which was
+// opened with a write-only key.
+var ErrWriteOnly = errors.Fatal("repository was opened with a write-only key, reading data requires a full key")
+
 // Repository is used to access a repository in a backend.
 type Repository struct {
 	be      restic.Backend
@@ -33,8 +38,21 @@ type Repository struct {
 
 	noAutoIndexUpdate bool
 
-	treePM *packerManager
-	dataPM *packerManager
+	// packSize overrides the pack size from the config if it is not zero
+	packSize uint
+
+	// uploadBudget limits the size of the packs which are queued for upload,
+	// if zero, a budget of two packs per concurrent upload is used
+	uploadBudget      uint
+	uploadConcurrency uint
+
+	// publicKey is set if the repository was opened with a write-only key,
+	// all data is then sealed for it and key is nil
+	publicKey *crypto.PublicKey
+
+	treePM   *packerManager
+	dataPM   *packerManager
+	uploader *packerUploader
 }
 
 // New returns a new repository with backend be.
@@ -45,6 +63,8 @@ func New(be restic.Backend) *Repository {
 		dataPM: newPackerManager(be, nil),
 		treePM: newPackerManager(be, nil),
 	}
+	repo.uploader = newPackerUploader(repo.savePacker)
+	repo.uploadConcurrency = DefaultUploadConcurrency
 
 	return repo
 }
@@ -55,6 +75,56 @@ func (r *Repository) DisableAutoIndexUpdate() {
 	r.noAutoIndexUpdate = true
 }
 
+// SetPackSize sets the size at which pack files are finished, overriding the
+// size stored in the config.
+func (r *Repository) SetPackSize(size uint) error {
+	if err := restic.CheckPackSize(size); err != nil {
+		return err
+	}
+	r.packSize = size
+	return nil
+}
+
+// PackSize returns the size at which pack files are finished.
+func (r *Repository) PackSize() uint {
+	if r.packSize != 0 {
+		return r.packSize
+	}
+	return r.cfg.TargetPackSize()
+}
+
+// SetUploadConcurrency sets the number of pack files which are uploaded to
+// the backend concurrently.
+func (r *Repository) SetUploadConcurrency(n uint) error {
+	if n == 0 {
+		return errors.New("upload concurrency must be at least one")
+	}
+	r.uploadConcurrency = n
+	r.uploader.SetConcurrency(n)
+	return nil
+}
+
+// SetUploadBudget limits the total size of the pack files which are waiting
+// for or in the process of being uploaded. Saving blobs blocks while the
+// budget is exhausted. If size is zero, the budget allows two pack files per
+// concurrent upload.
+func (r *Repository) SetUploadBudget(size uint) {
+	r.uploadBudget = size
+}
+
+// SetUploadProgress sets a function which is called with the size of each
+// pack file after it was uploaded.
+func (r *Repository) SetUploadProgress(fn func(bytes uint64)) {
+	r.uploader.onComplete = fn
+}
+
+func (r *Repository) currentUploadBudget() uint {
+	if r.uploadBudget != 0 {
+		return r.uploadBudget
+	}
+	return 2 * r.uploadConcurrency * r.PackSize()
+}
+
 // Config returns the repository configuration.
 func (r *Repository) Config() restic.Config {
 	return r.cfg
@@ -84,6 +154,10 @@ func (r *Repository) LoadAndDecrypt(ctx context.Context, buf []byte, t restic.Fi
 		panic("buf is not empty")
 	}
 
+	if r.WriteOnly() {
+		return nil, ErrWriteOnly
+	}
+
 	debug.Log("load %v with id %v", t, id)
 
 	h := restic.Handle{Type: t, Name: id.String()}
@@ -106,13 +180,22 @@ func (r *Repository) LoadAndDecrypt(ctx context.Context, buf []byte, t restic.Fi
 		return nil, errors.Errorf("load %v: invalid data returned", h)
 	}
 
-	nonce, ciphertext := buf[:r.key.NonceSize()], buf[r.key.NonceSize():]
-	plaintext, err := r.key.Open(ciphertext[:0], nonce, ciphertext, nil)
-	if err != nil {
-		return nil, err
+	return r.Decrypt(buf)
+}
+
+// Decrypt decrypts and authenticates ciphertext which was returned by Encrypt.
+// The plaintext is stored in the same buffer.
+func (r *Repository) Decrypt(ciphertext []byte) ([]byte, error) {
+	if r.WriteOnly() {
+		return nil, ErrWriteOnly
+	}
+
+	if len(ciphertext) < r.key.NonceSize() {
+		return nil, errors.New("ciphertext too short")
 	}
 
-	return plaintext, nil
+	nonce, ciphertext := ciphertext[:r.key.NonceSize()], ciphertext[r.key.NonceSize():]
+	return r.key.Open(ciphertext[:0], nonce, ciphertext, nil)
 }
 
 type haver interface {
@@ -149,6 +232,10 @@ func sortCachedPacksFirst(cache haver, blobs []restic.PackedBlob) {
 func (r *Repository) LoadBlob(ctx context.Context, t restic.BlobType, id restic.ID, buf []byte) ([]byte, error) {
 	debug.Log("load %v with id %v (buf len %v, cap %d)", t, id, len(buf), cap(buf))
 
+	if r.WriteOnly() {
+		return nil, ErrWriteOnly
+	}
+
 	// lookup packs
 	blobs := r.idx.Lookup(id, t)
 	if len(blobs) == 0 {
@@ -195,10 +282,24 @@ func (r *Repository) LoadBlob(ctx context.Context, t restic.BlobType, id restic.
 		nonce, ciphertext := buf[:r.key.NonceSize()], buf[r.key.NonceSize():]
 		plaintext, err := r.key.Open(ciphertext[:0], nonce, ciphertext, nil)
 		if err != nil {
-			lastError = errors.Errorf("decrypting blob %v failed: %v", id, err)
+			lastError = errors.Wrapf(err, "decrypting blob %v failed", id)
 			continue
 		}
 
+		if blob.IsCompressed() {
+			plaintext, err = DecompressBlob(nil, plaintext, blob.UncompressedLength)
+			if err != nil {
+				lastError = errors.Errorf("decompressing blob %v failed: %v", id, err)
+				continue
+			}
+
+			// the decompressed data may be larger than buf
+			if cap(buf) < len(plaintext) {
+				buf = make([]byte, len(plaintext))
+			}
+			buf = buf[:len(plaintext)]
+		}
+
 		// check hash
 		if !restic.Hash(plaintext).Equal(id) {
 			lastError = errors.Errorf("blob %v returned invalid hash", id)
@@ -239,13 +340,22 @@ func (r *Repository) LookupBlobSize(id restic.ID, tpe restic.BlobType) (uint, bo
 func (r *Repository) SaveAndEncrypt(ctx context.Context, t restic.BlobType, data []byte, id restic.ID) error {
 	debug.Log("save id %v (%v, %d bytes)", id, t, len(data))
 
-	nonce := crypto.NewRandomNonce()
-
-	ciphertext := make([]byte, 0, restic.CiphertextLength(len(data)))
-	ciphertext = append(ciphertext, nonce...)
-
-	// encrypt blob
-	ciphertext = r.key.Seal(ciphertext, nonce, data, nil)
+	// compress blob if the repository supports it and it is worth the effort
+	uncompressedLength := 0
+	if r.cfg.SupportsCompression() {
+		compressed, ok := compressBlob(data)
+		// the plaintext length of a sealed blob cannot be computed from its
+		// ciphertext length, so it is always stored compressed. Empty blobs
+		// cannot be marked as compressed, restic.BlobPlaintextLength
+		// recognizes them by their ID instead.
+		if !ok && r.WriteOnly() && len(data) > 0 {
+			compressed, ok = getZstdEncoder().EncodeAll(data, nil), true
+		}
+		if ok {
+			uncompressedLength = len(data)
+			data = compressed
+		}
+	}
 
 	// find suitable packer and add blob
 	var pm *packerManager
@@ -264,21 +374,33 @@ func (r *Repository) SaveAndEncrypt(ctx context.Context, t restic.BlobType, data
 		return err
 	}
 
+	// encrypt blob
+	var ciphertext []byte
+	if packer.sessionKey != nil {
+		ciphertext = make([]byte, 0, len(data)+crypto.SealedExtension)
+		ciphertext = packer.sessionKey.Seal(ciphertext, data)
+	} else {
+		nonce := crypto.NewRandomNonce()
+		ciphertext = make([]byte, 0, restic.CiphertextLength(len(data)))
+		ciphertext = append(ciphertext, nonce...)
+		ciphertext = r.key.Seal(ciphertext, nonce, data, nil)
+	}
+
 	// save ciphertext
-	_, err = packer.Add(t, id, ciphertext)
+	_, err = packer.Add(t, id, ciphertext, uncompressedLength)
 	if err != nil {
 		return err
 	}
 
 	// if the pack is not full enough, put back to the list
-	if packer.Size() < minPackSize {
+	if packer.Size() < r.PackSize() && !packer.HeaderFull() {
 		debug.Log("pack is not full enough (%d bytes)", packer.Size())
 		pm.insertPacker(packer)
 		return nil
 	}
 
-	// else write the pack to the backend
-	return r.savePacker(ctx, t, packer)
+	// else upload the pack to the backend in the background
+	return r.uploader.Queue(t, packer, r.currentUploadBudget())
 }
 
 // SaveJSONUnpacked serialises item as JSON and encrypts and saves it in the
@@ -296,12 +418,10 @@ func (r *Repository) SaveJSONUnpacked(ctx context.Context, t restic.FileType, it
 // SaveUnpacked encrypts data and stores it in the backend. Returned is the
 // storage hash.
 func (r *Repository) SaveUnpacked(ctx context.Context, t restic.FileType, p []byte) (id restic.ID, err error) {
-	ciphertext := restic.NewBlobBuffer(len(p))
-	ciphertext = ciphertext[:0]
-	nonce := crypto.NewRandomNonce()
-	ciphertext = append(ciphertext, nonce...)
-
-	ciphertext = r.key.Seal(ciphertext, nonce, p, nil)
+	ciphertext, err := r.Encrypt(p)
+	if err != nil {
+		return restic.ID{}, err
+	}
 
 	id = restic.Hash(ciphertext)
 	h := restic.Handle{Type: t, Name: id.String()}
@@ -316,6 +436,26 @@ func (r *Repository) SaveUnpacked(ctx context.Context, t restic.FileType, p []by
 	return id, nil
 }
 
+// Encrypt encrypts p the same way as files saved with SaveUnpacked. If the
+// repository was opened with a write-only key, the data is sealed for the
+// master key.
+func (r *Repository) Encrypt(p []byte) ([]byte, error) {
+	ciphertext := restic.NewBlobBuffer(len(p))
+	ciphertext = ciphertext[:0]
+
+	if r.WriteOnly() {
+		sk, err := crypto.NewSessionKey(*r.publicKey)
+		if err != nil {
+			return nil, err
+		}
+		return sk.Seal(ciphertext, p), nil
+	}
+
+	nonce := crypto.NewRandomNonce()
+	ciphertext = append(ciphertext, nonce...)
+	return r.key.Seal(ciphertext, nonce, p, nil), nil
+}
+
 // Flush saves all remaining packs and the index
 func (r *Repository) Flush(ctx context.Context) error {
 	if err := r.FlushPacks(ctx); err != nil {
@@ -326,7 +466,8 @@ func (r *Repository) Flush(ctx context.Context) error {
 	return r.SaveIndex(ctx)
 }
 
-// FlushPacks saves all remaining packs.
+// FlushPacks saves all remaining packs and waits until all packs are
+// uploaded.
 func (r *Repository) FlushPacks(ctx context.Context) error {
 	pms := []struct {
 		t  restic.BlobType
@@ -341,7 +482,7 @@ func (r *Repository) FlushPacks(ctx context.Context) error {
 
 		debug.Log("manually flushing %d packs", len(p.pm.packers))
 		for _, packer := range p.pm.packers {
-			err := r.savePacker(ctx, p.t, packer)
+			err := r.uploader.Queue(p.t, packer, r.currentUploadBudget())
 			if err != nil {
 				p.pm.pm.Unlock()
 				return err
@@ -350,7 +491,7 @@ func (r *Repository) FlushPacks(ctx context.Context) error {
 		p.pm.packers = p.pm.packers[:0]
 		p.pm.pm.Unlock()
 	}
-	return nil
+	return r.uploader.Wait(ctx)
 }
 
 // Backend returns the backend for the repository.
@@ -403,6 +544,9 @@ func (r *Repository) saveIndex(ctx context.Context, indexes ...*Index) error {
 		if err != nil {
 			return err
 		}
+		if err := idx.SetID(sid); err != nil {
+			return err
+		}
 
 		debug.Log("Saved index %d as %v", i, sid)
 	}
@@ -428,6 +572,10 @@ const loadIndexParallelism = 4
 func (r *Repository) LoadIndex(ctx context.Context) error {
 	debug.Log("Loading index")
 
+	if r.WriteOnly() {
+		return ErrWriteOnly
+	}
+
 	// track spawned goroutines using wg, create a new context which is
 	// cancelled as soon as an error occurs.
 	wg, ctx := errgroup.WithContext(ctx)
@@ -452,9 +600,13 @@ func (r *Repository) LoadIndex(ctx context.Context) error {
 		})
 	})
 
-	// a worker receives an index ID from ch, loads the index, and sends it to indexCh
+	// a worker receives index IDs from ch, loads the indexes and merges them.
+	// The merged index is sent to indexCh once ch is closed. Merging in the
+	// workers allows freeing the decoded indexes right away and spreads the
+	// work of merging over all workers.
 	worker := func() error {
 		var buf []byte
+		var merged *Index
 		for fi := range ch {
 			var err error
 			buf, err = r.LoadAndDecrypt(ctx, buf[:0], restic.IndexFile, fi.ID)
@@ -466,19 +618,35 @@ func (r *Repository) LoadIndex(ctx context.Context) error {
 				return errors.Wrapf(err, "unable to decode index %s", fi.ID.Str())
 			}
 
+			if merged == nil {
+				merged = idx
+				continue
+			}
+			err = merged.merge(idx)
+			if err != nil {
+				return errors.Wrapf(err, "unable to merge index %s", fi.ID.Str())
+			}
+		}
+
+		if merged != nil {
 			select {
-			case indexCh <- idx:
+			case indexCh <- merged:
 			case <-ctx.Done():
 			}
 		}
-
 		return nil
 	}
 
+	// decoding is CPU bound, so use all available CPUs
+	workers := loadIndexParallelism
+	if n := runtime.GOMAXPROCS(0); n > workers {
+		workers = n
+	}
+
 	// run workers on ch
 	wg.Go(func() error {
 		defer close(indexCh)
-		return RunWorkers(loadIndexParallelism, worker)
+		return RunWorkers(workers, worker)
 	})
 
 	// receive decoded indexes
@@ -595,10 +763,22 @@ func (r *Repository) SearchKey(ctx context.Context, password string, maxKeys int
 		return err
 	}
 
+	r.keyName = key.Name()
+
+	if key.writeOnly != nil {
+		// the config is encrypted with the master key, use the copy
+		// contained in the key instead
+		debug.Log("using write-only key %v", key.Name())
+		r.publicKey = &key.writeOnly.PublicKey
+		r.dataPM.publicKey = r.publicKey
+		r.treePM.publicKey = r.publicKey
+		r.cfg = key.writeOnly.Config
+		return nil
+	}
+
 	r.key = key.master
 	r.dataPM.key = key.master
 	r.treePM.key = key.master
-	r.keyName = key.Name()
 	r.cfg, err = restic.LoadConfig(ctx, r)
 	if err != nil {
 		return errors.Fatalf("config cannot be loaded: %v", err)
@@ -607,8 +787,11 @@ func (r *Repository) SearchKey(ctx context.Context, password string, maxKeys int
 }
 
 // Init creates a new master key with the supplied password, initializes and
-// saves the repository config.
-func (r *Repository) Init(ctx context.Context, password string, chunkerPolynomial *chunker.Pol) error {
+// saves the repository config for a repository with the given version. If
+// chunkerParams is nil, the default chunker parameters are used. If packSize
+// is zero, the default pack size is used. If appendOnly is set, the
+// repository is marked as append-only.
+func (r *Repository) Init(ctx context.Context, version uint, password string, chunkerPolynomial *chunker.Pol, chunkerParams *restic.ChunkerParams, packSize uint, appendOnly bool) error {
 	has, err := r.be.Test(ctx, restic.Handle{Type: restic.ConfigFile})
 	if err != nil {
 		return err
@@ -617,13 +800,30 @@ func (r *Repository) Init(ctx context.Context, password string, chunkerPolynomia
 		return errors.New("repository master key and config already initialized")
 	}
 
-	cfg, err := restic.CreateConfig()
+	cfg, err := restic.CreateConfig(version)
 	if err != nil {
 		return err
 	}
 	if chunkerPolynomial != nil {
 		cfg.ChunkerPolynomial = *chunkerPolynomial
 	}
+	if chunkerParams != nil {
+		if err := chunkerParams.Valid(); err != nil {
+			return err
+		}
+		cfg.ChunkSizes = chunkerParams
+	}
+	if packSize != 0 {
+		if err := restic.CheckPackSize(packSize); err != nil {
+			return err
+		}
+		cfg.PackSize = packSize
+	}
+	cfg.AppendOnly = appendOnly
+
+	if err := cfg.CheckVersion(); err != nil {
+		return err
+	}
 
 	return r.init(ctx, password, cfg)
 }
@@ -645,11 +845,26 @@ func (r *Repository) init(ctx context.Context, password string, cfg restic.Confi
 	return err
 }
 
-// Key returns the current master key.
+// Key returns the current master key. It is nil if the repository was opened
+// with a write-only key.
 func (r *Repository) Key() *crypto.Key {
 	return r.key
 }
 
+// WriteOnly returns true if the repository was opened with a write-only key.
+func (r *Repository) WriteOnly() bool {
+	return r.publicKey != nil
+}
+
+// PublicKey returns the public key data is sealed for when the repository is
+// opened with a write-only key.
+func (r *Repository) PublicKey() (crypto.PublicKey, error) {
+	if r.publicKey != nil {
+		return *r.publicKey, nil
+	}
+	return r.key.PublicKey()
+}
+
 // KeyName returns the name of the current key in the backend.
 func (r *Repository) KeyName() string {
 	return r.keyName
@@ -670,6 +885,10 @@ func (r *Repository) List(ctx context.Context, t restic.FileType, fn func(restic
 // ListPack returns the list of blobs saved in the pack id and the length of
 // the file as stored in the backend.
 func (r *Repository) ListPack(ctx context.Context, id restic.ID, size int64) ([]restic.Blob, int64, error) {
+	if r.WriteOnly() {
+		return nil, 0, ErrWriteOnly
+	}
+
 	h := restic.Handle{Type: restic.PackFile, Name: id.String()}
 
 	blobs, err := pack.List(r.Key(), restic.ReaderAt(ctx, r.Backend(), h), size)
@@ -686,8 +905,12 @@ func (r *Repository) Delete(ctx context.Context) error {
 	return r.be.Delete(ctx)
 }
 
-// Close closes the repository by closing the backend.
+// Close closes the repository by closing the backend. Cached key material is
+// removed.
 func (r *Repository) Close() error {
+	if r.key != nil {
+		r.key.ClearSessionKeys()
+	}
 	return r.be.Close()
 }
 
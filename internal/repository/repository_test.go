@@ -90,6 +90,58 @@ func TestSaveFrom(t *testing.T) {
 	}
 }
 
+func TestSaveCompressed(t *testing.T) {
+	repo, cleanup := repository.TestRepositoryWithVersion(t, restic.CompressedRepoVersion)
+	defer cleanup()
+
+	for _, size := range testSizes {
+		// use compressible data: random bytes interleaved with runs of zeroes
+		data := make([]byte, size)
+		_, err := io.ReadFull(rnd, data[:size/2])
+		rtest.OK(t, err)
+
+		id := restic.Hash(data)
+
+		sid, _, err := repo.SaveBlob(context.TODO(), restic.DataBlob, data, restic.ID{}, false)
+		rtest.OK(t, err)
+		rtest.Equals(t, id, sid)
+
+		rtest.OK(t, repo.Flush(context.Background()))
+
+		blobs := repo.Index().Lookup(id, restic.DataBlob)
+		rtest.Assert(t, len(blobs) == 1, "expected one index entry, got %v", len(blobs))
+		if size > 100 {
+			rtest.Assert(t, blobs[0].IsCompressed(), "blob with %d bytes was not compressed", size)
+			rtest.Equals(t, uint(size), blobs[0].UncompressedLength)
+			rtest.Assert(t, blobs[0].Length < uint(size), "compressed blob is larger than the plaintext")
+		}
+
+		size2, found := repo.LookupBlobSize(id, restic.DataBlob)
+		rtest.Assert(t, found, "blob not found in index")
+		rtest.Equals(t, uint(size), size2)
+
+		buf, err := repo.LoadBlob(context.TODO(), restic.DataBlob, id, nil)
+		rtest.OK(t, err)
+		rtest.Assert(t, bytes.Equal(buf, data),
+			"data does not match: expected %02x, got %02x",
+			data, buf)
+	}
+}
+
+func TestSaveUncompressedInVersion1(t *testing.T) {
+	repo, cleanup := repository.TestRepository(t)
+	defer cleanup()
+
+	data := make([]byte, 1<<20)
+	id, _, err := repo.SaveBlob(context.TODO(), restic.DataBlob, data, restic.ID{}, false)
+	rtest.OK(t, err)
+	rtest.OK(t, repo.Flush(context.Background()))
+
+	blobs := repo.Index().Lookup(id, restic.DataBlob)
+	rtest.Assert(t, len(blobs) == 1, "expected one index entry, got %v", len(blobs))
+	rtest.Assert(t, !blobs[0].IsCompressed(), "blob in version 1 repository was compressed")
+}
+
 func BenchmarkSaveAndEncrypt(t *testing.B) {
 	repo, cleanup := repository.TestRepository(t)
 	defer cleanup()
@@ -389,6 +441,53 @@ func TestRepositoryIncrementalIndex(t *testing.T) {
 	}
 }
 
+func TestRepositoryLoadIndexMerged(t *testing.T) {
+	r, cleanup := repository.TestRepository(t)
+	defer cleanup()
+	repo := r.(*repository.Repository)
+
+	defer func(f func(*repository.Index) bool) { repository.IndexFull = f }(repository.IndexFull)
+	repository.IndexFull = func(*repository.Index) bool { return true }
+
+	// write one index file per pack
+	ctx := context.TODO()
+	var blobs restic.IDs
+	for i := 0; i < 30; i++ {
+		for j := 0; j < 3; j++ {
+			id, _, err := repo.SaveBlob(ctx, restic.DataBlob, rtest.Random(i*3+j, 1000), restic.ID{}, false)
+			rtest.OK(t, err)
+			blobs = append(blobs, id)
+		}
+		rtest.OK(t, repo.Flush(ctx))
+	}
+
+	repo2 := repository.New(repo.Backend())
+	rtest.OK(t, repo2.SearchKey(ctx, rtest.TestPassword, 1, ""))
+	rtest.OK(t, repo2.LoadIndex(ctx))
+
+	// all index files are merged into a single index
+	rtest.Equals(t, 1, len(repo2.Index().(*repository.MasterIndex).All()))
+
+	var indexFiles restic.IDs
+	rtest.OK(t, repo.List(ctx, restic.IndexFile, func(id restic.ID, size int64) error {
+		indexFiles = append(indexFiles, id)
+		return nil
+	}))
+	rtest.Equals(t, 30, len(indexFiles))
+
+	var ids restic.IDs
+	for _, idx := range repo2.Index().(*repository.MasterIndex).All() {
+		idxIDs, err := idx.IDs()
+		rtest.OK(t, err)
+		ids = append(ids, idxIDs...)
+	}
+	rtest.Equals(t, restic.NewIDSet(indexFiles...), restic.NewIDSet(ids...))
+
+	for _, id := range blobs {
+		rtest.Equals(t, 1, len(repo2.Index().Lookup(id, restic.DataBlob)))
+	}
+}
+
 type backend struct {
 	rd io.Reader
 }
@@ -492,3 +591,130 @@ func TestDownloadAndHashErrors(t *testing.T) {
 		})
 	}
 }
+
+func TestWriteOnlyKey(t *testing.T) {
+	r, cleanup := repository.TestRepositoryWithVersion(t, restic.CompressedRepoVersion)
+	defer cleanup()
+	repo := r.(*repository.Repository)
+
+	pub, err := repo.PublicKey()
+	rtest.OK(t, err)
+	_, err = repository.AddWriteOnlyKey(context.TODO(), repo, "write-only", "", "", pub, repo.Config())
+	rtest.OK(t, err)
+
+	wrepo := repository.New(repo.Backend())
+	rtest.OK(t, wrepo.SearchKey(context.TODO(), "write-only", 0, ""))
+	rtest.Assert(t, wrepo.WriteOnly(), "repository was not opened with a write-only key")
+	rtest.Equals(t, repo.Config(), wrepo.Config())
+
+	// save blobs, including incompressible and empty ones, and a snapshot
+	sizes := append([]int{0}, testSizes...)
+	var ids restic.IDs
+	for _, size := range sizes {
+		data := make([]byte, size)
+		_, err := io.ReadFull(rnd, data)
+		rtest.OK(t, err)
+
+		id, _, err := wrepo.SaveBlob(context.TODO(), restic.DataBlob, data, restic.ID{}, false)
+		rtest.OK(t, err)
+		ids = append(ids, id)
+	}
+	rtest.OK(t, wrepo.Flush(context.TODO()))
+
+	sn := restic.Snapshot{Hostname: "foo", Paths: []string{"/"}}
+	snID, err := wrepo.SaveJSONUnpacked(context.TODO(), restic.SnapshotFile, &sn)
+	rtest.OK(t, err)
+
+	// reading data requires the master key
+	_, err = wrepo.LoadBlob(context.TODO(), restic.DataBlob, ids[0], nil)
+	rtest.Equals(t, repository.ErrWriteOnly, err)
+	rtest.Equals(t, repository.ErrWriteOnly, wrepo.LoadIndex(context.TODO()))
+	rtest.Equals(t, repository.ErrWriteOnly, wrepo.LoadJSONUnpacked(context.TODO(), restic.SnapshotFile, snID, &sn))
+
+	// the repository opened with the master key can read everything
+	rtest.OK(t, repo.LoadIndex(context.TODO()))
+	for i, id := range ids {
+		buf, err := repo.LoadBlob(context.TODO(), restic.DataBlob, id, nil)
+		rtest.OK(t, err)
+		rtest.Equals(t, sizes[i], len(buf))
+
+		size, found := repo.LookupBlobSize(id, restic.DataBlob)
+		rtest.Assert(t, found, "blob %v not found in index", id)
+		rtest.Equals(t, uint(sizes[i]), size)
+	}
+
+	var sn2 restic.Snapshot
+	rtest.OK(t, repo.LoadJSONUnpacked(context.TODO(), restic.SnapshotFile, snID, &sn2))
+	rtest.Equals(t, sn.Hostname, sn2.Hostname)
+
+	rtest.OK(t, repo.List(context.TODO(), restic.PackFile, func(id restic.ID, size int64) error {
+		blobs, _, err := repo.ListPack(context.TODO(), id, size)
+		rtest.OK(t, err)
+		rtest.Assert(t, len(blobs) > 0, "no blobs found in pack %v", id)
+		return nil
+	}))
+}
+
+func TestWriteOnlyKeyVersion1(t *testing.T) {
+	r, cleanup := repository.TestRepositoryWithVersion(t, 1)
+	defer cleanup()
+	repo := r.(*repository.Repository)
+
+	pub, err := repo.PublicKey()
+	rtest.OK(t, err)
+	_, err = repository.AddWriteOnlyKey(context.TODO(), repo, "write-only", "", "", pub, repo.Config())
+	rtest.Assert(t, err != nil, "expected error for repository version 1")
+}
+
+func TestRepositoryPackSize(t *testing.T) {
+	r, cleanup := repository.TestRepository(t)
+	defer cleanup()
+	repo := r.(*repository.Repository)
+
+	rtest.Equals(t, uint(restic.DefaultPackSize), repo.PackSize())
+	rtest.Assert(t, repo.SetPackSize(restic.MinPackSize-1) != nil, "expected error for too small pack size")
+	rtest.Assert(t, repo.SetPackSize(restic.MaxPackSize+1) != nil, "expected error for too large pack size")
+
+	const packSize = 16 * 1024 * 1024
+	rtest.OK(t, repo.SetPackSize(packSize))
+	rtest.Equals(t, uint(packSize), repo.PackSize())
+
+	ctx := context.TODO()
+	blobs := restic.NewIDSet()
+	inPack := make(map[restic.ID]restic.ID)
+	for i := 0; i < 40; i++ {
+		id, _, err := repo.SaveBlob(ctx, restic.DataBlob, rtest.Random(i, 1024*1024), restic.ID{}, false)
+		rtest.OK(t, err)
+		blobs.Insert(id)
+	}
+	rtest.OK(t, repo.Flush(ctx))
+
+	// all packs except the last one reach the pack size
+	var small int
+	var packs restic.IDs
+	rtest.OK(t, repo.List(ctx, restic.PackFile, func(id restic.ID, size int64) error {
+		if size < packSize {
+			small++
+		}
+		packs = append(packs, id)
+
+		entries, _, err := repo.ListPack(ctx, id, size)
+		rtest.OK(t, err)
+		for _, e := range entries {
+			rtest.Assert(t, blobs.Has(e.ID), "unexpected blob %v in pack %v", e.ID.Str(), id.Str())
+			blobs.Delete(e.ID)
+			inPack[e.ID] = id
+		}
+		return nil
+	}))
+	rtest.Equals(t, 3, len(packs))
+	rtest.Equals(t, 1, small)
+	rtest.Equals(t, 0, len(blobs))
+
+	rtest.OK(t, repo.LoadIndex(ctx))
+	for blobID, packID := range inPack {
+		list := repo.Index().Lookup(blobID, restic.DataBlob)
+		rtest.Equals(t, 1, len(list))
+		rtest.Equals(t, packID, list[0].PackID)
+	}
+}
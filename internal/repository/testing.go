@@ -42,6 +42,16 @@ const testChunkerPol = chunker.Pol(0x3DA3358B4DC173)
 // password. If be is nil, an in-memory backend is used. A constant polynomial
 // is used for the chunker and low-security test parameters.
 func TestRepositoryWithBackend(t testing.TB, be restic.Backend) (r restic.Repository, cleanup func()) {
+	t.Helper()
+	return testRepositoryWithBackend(t, be, restic.RepoVersion)
+}
+
+func testRepositoryWithBackend(t testing.TB, be restic.Backend, version uint) (r restic.Repository, cleanup func()) {
+	t.Helper()
+	return testRepositoryWithConfig(t, be, restic.TestCreateConfig(t, testChunkerPol, version))
+}
+
+func testRepositoryWithConfig(t testing.TB, be restic.Backend, cfg restic.Config) (r restic.Repository, cleanup func()) {
 	t.Helper()
 	TestUseLowSecurityKDFParameters(t)
 	restic.TestDisableCheckPolynomial(t)
@@ -53,7 +63,6 @@ func TestRepositoryWithBackend(t testing.TB, be restic.Backend) (r restic.Reposi
 
 	repo := New(be)
 
-	cfg := restic.TestCreateConfig(t, testChunkerPol)
 	err := repo.init(context.TODO(), test.TestPassword, cfg)
 	if err != nil {
 		t.Fatalf("TestRepository(): initialize repo failed: %v", err)
@@ -91,6 +100,52 @@ func TestRepository(t testing.TB) (r restic.Repository, cleanup func()) {
 	return TestRepositoryWithBackend(t, nil)
 }
 
+// TestRepositoryWithVersion returns a repository with the given version
+// initialized with a test password on an in-memory backend.
+func TestRepositoryWithVersion(t testing.TB, version uint) (r restic.Repository, cleanup func()) {
+	t.Helper()
+	return testRepositoryWithBackend(t, nil, version)
+}
+
+// TestAppendOnlyRepository returns an append-only repository initialized with
+// a test password. If be is nil, an in-memory backend is used.
+func TestAppendOnlyRepository(t testing.TB, be restic.Backend) (r restic.Repository, cleanup func()) {
+	t.Helper()
+	return TestAppendOnlyRepositoryWithVersion(t, be, restic.RepoVersion)
+}
+
+// TestAppendOnlyRepositoryWithVersion returns an append-only repository with
+// the given version.
+func TestAppendOnlyRepositoryWithVersion(t testing.TB, be restic.Backend, version uint) (r restic.Repository, cleanup func()) {
+	t.Helper()
+	cfg := restic.TestCreateConfig(t, testChunkerPol, version)
+	cfg.AppendOnly = true
+	return testRepositoryWithConfig(t, be, cfg)
+}
+
+// TestWriteOnlyRepository adds a write-only key to repo and returns the
+// repository opened with this key.
+func TestWriteOnlyRepository(t testing.TB, repo *Repository) *Repository {
+	t.Helper()
+	pub, err := repo.PublicKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = AddWriteOnlyKey(context.TODO(), repo, "write-only", "", "", pub, repo.Config())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wrepo := New(repo.Backend())
+	err = wrepo.SearchKey(context.TODO(), "write-only", 0, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return wrepo
+}
+
 // TestOpenLocal opens a local repository.
 func TestOpenLocal(t testing.TB, dir string) (r restic.Repository) {
 	be, err := local.Open(local.Config{Path: dir})
@@ -12,11 +12,41 @@ type Blob struct {
 	Length uint
 	ID     ID
 	Offset uint
+
+	// UncompressedLength is the length of the plaintext before compression.
+	// It is zero for blobs which are stored uncompressed.
+	UncompressedLength uint
 }
 
 func (b Blob) String() string {
-	return fmt.Sprintf("<Blob (%v) %v, offset %v, length %v>",
-		b.Type, b.ID.Str(), b.Offset, b.Length)
+	return fmt.Sprintf("<Blob (%v) %v, offset %v, length %v, uncompressed length %v>",
+		b.Type, b.ID.Str(), b.Offset, b.Length, b.UncompressedLength)
+}
+
+// IsCompressed returns true if the blob is stored compressed.
+func (b Blob) IsCompressed() bool {
+	return b.UncompressedLength != 0
+}
+
+// DataLength returns the length of the blob's plaintext content.
+func (b Blob) DataLength() uint {
+	if b.IsCompressed() {
+		return b.UncompressedLength
+	}
+	return BlobPlaintextLength(b.ID, b.Length)
+}
+
+// emptyBlobID is the ID of a blob without content.
+var emptyBlobID = Hash(nil)
+
+// BlobPlaintextLength returns the plaintext length of the uncompressed blob
+// with the given ID and ciphertext length. Sealed blobs have a larger
+// overhead, they're always stored compressed unless they're empty.
+func BlobPlaintextLength(id ID, ciphertextLength uint) uint {
+	if id == emptyBlobID {
+		return 0
+	}
+	return uint(PlaintextLength(int(ciphertextLength)))
 }
 
 // PackedBlob is a blob stored within a file.
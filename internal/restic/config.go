@@ -2,6 +2,7 @@ package restic
 
 import (
 	"context"
+	"io"
 	"testing"
 
 	"github.com/restic/restic/internal/errors"
@@ -16,43 +17,193 @@ type Config struct {
 	Version           uint        `json:"version"`
 	ID                string      `json:"id"`
 	ChunkerPolynomial chunker.Pol `json:"chunker_polynomial"`
+
+	// AppendOnly marks a repository whose backend does not allow deleting
+	// files. Commands which remove data refuse to run unless this is
+	// overridden explicitly, and locks expire instead of being removed.
+	AppendOnly bool `json:"append_only,omitempty"`
+
+	// ChunkSizes are the parameters used to split files into chunks. If
+	// nil, DefaultChunkerParams are used.
+	ChunkSizes *ChunkerParams `json:"chunk_sizes,omitempty"`
+
+	// PackSize is the size in bytes at which pack files are finished. If
+	// zero, DefaultPackSize is used.
+	PackSize uint `json:"pack_size,omitempty"`
+}
+
+// DefaultPackSize is the size at which pack files are finished unless a
+// different size is configured. MinPackSize and MaxPackSize are the limits
+// for the configured size. Larger packs would require too much temporary
+// disk space while saving data and make the header of packs with many small
+// blobs too large.
+const (
+	DefaultPackSize = 4 * 1024 * 1024
+	MinPackSize     = 4 * 1024 * 1024
+	MaxPackSize     = 128 * 1024 * 1024
+)
+
+// CheckPackSize returns an error if size is not a valid pack size.
+func CheckPackSize(size uint) error {
+	if size < MinPackSize || size > MaxPackSize {
+		return errors.Errorf("pack size %d not between %d MiB and %d MiB", size, MinPackSize/1024/1024, MaxPackSize/1024/1024)
+	}
+	return nil
+}
+
+// TargetPackSize returns the size at which pack files are finished.
+func (cfg Config) TargetPackSize() uint {
+	if cfg.PackSize == 0 {
+		return DefaultPackSize
+	}
+	return cfg.PackSize
+}
+
+// ChunkerParams are the parameters of the content defined chunker which
+// determine the size of the chunks files are split into. Chunks are cut when
+// the lowest AverageBits bits of the rolling hash are zero, but never before
+// MinSize and always at MaxSize bytes.
+type ChunkerParams struct {
+	MinSize     uint `json:"min_size"`
+	MaxSize     uint `json:"max_size"`
+	AverageBits int  `json:"average_bits"`
+}
+
+// DefaultChunkerParams are the parameters of the chunker library, which are
+// used for repositories without chunker parameters in their config.
+var DefaultChunkerParams = ChunkerParams{
+	MinSize:     chunker.MinSize,
+	MaxSize:     chunker.MaxSize,
+	AverageBits: 20,
+}
+
+// ChunkerProfiles are the chunker parameters which can be selected by name
+// when a repository is created.
+var ChunkerProfiles = map[string]ChunkerParams{
+	// for many small changes to large files, e.g. VM images
+	"small":   {MinSize: 128 * 1024, MaxSize: 2 * 1024 * 1024, AverageBits: 18},
+	"default": DefaultChunkerParams,
+	// for large files which rarely change, e.g. media archives
+	"large": {MinSize: 2 * 1024 * 1024, MaxSize: 32 * 1024 * 1024, AverageBits: 23},
+}
+
+// limits for chunker parameters
+const (
+	minChunkSize   = 64 * 1024
+	maxChunkSize   = 64 * 1024 * 1024
+	minAverageBits = 16
+	maxAverageBits = 26
+)
+
+// Valid returns an error if the parameters are out of range.
+func (p ChunkerParams) Valid() error {
+	switch {
+	case p.MinSize < minChunkSize:
+		return errors.Errorf("minimal chunk size %d is smaller than %d", p.MinSize, minChunkSize)
+	case p.MaxSize > maxChunkSize:
+		return errors.Errorf("maximal chunk size %d is larger than %d", p.MaxSize, maxChunkSize)
+	case p.MinSize >= p.MaxSize:
+		return errors.Errorf("minimal chunk size %d is not smaller than maximal chunk size %d", p.MinSize, p.MaxSize)
+	case p.AverageBits < minAverageBits || p.AverageBits > maxAverageBits:
+		return errors.Errorf("average bits %d not between %d and %d", p.AverageBits, minAverageBits, maxAverageBits)
+	}
+	return nil
+}
+
+// NewChunker returns a chunker which splits the data read from rd according
+// to the parameters p and the polynomial pol.
+func (p ChunkerParams) NewChunker(rd io.Reader, pol chunker.Pol) *chunker.Chunker {
+	c := chunker.NewWithBoundaries(rd, pol, p.MinSize, p.MaxSize)
+	c.SetAverageBits(p.AverageBits)
+	return c
+}
+
+// ResetChunker reinitializes the chunker c to split the data read from rd
+// according to the parameters p and the polynomial pol.
+func (p ChunkerParams) ResetChunker(c *chunker.Chunker, rd io.Reader, pol chunker.Pol) {
+	c.ResetWithBoundaries(rd, pol, p.MinSize, p.MaxSize)
+	c.SetAverageBits(p.AverageBits)
+}
+
+// ChunkerParams returns the chunker parameters of the repository.
+func (cfg Config) ChunkerParams() ChunkerParams {
+	if cfg.ChunkSizes == nil {
+		return DefaultChunkerParams
+	}
+	return *cfg.ChunkSizes
 }
 
 // RepoVersion is the version that is written to the config when a repository
-// is newly created with Init().
+// is newly created with Init() and no version is requested explicitly.
 const RepoVersion = 1
 
+// MinRepoVersion and MaxRepoVersion are the lowest and highest repository
+// versions supported by this version of restic. Starting with version 2,
+// data and tree blobs may be stored compressed.
+const (
+	MinRepoVersion = 1
+	MaxRepoVersion = 2
+)
+
+// CompressedRepoVersion is the first repository version which supports
+// compressed blobs.
+const CompressedRepoVersion = 2
+
+// SupportsCompression returns true if blobs may be stored compressed in a
+// repository with this config.
+func (cfg Config) SupportsCompression() bool {
+	return cfg.Version >= CompressedRepoVersion
+}
+
+// ChunkSizesRepoVersion is the first repository version whose config may
+// contain chunk sizes and a pack size. Older versions of restic would ignore
+// them, but they refuse to open repositories with a newer version.
+const ChunkSizesRepoVersion = 2
+
+// CheckVersion returns an error if the config contains fields which are not
+// supported by its repository version.
+func (cfg Config) CheckVersion() error {
+	if cfg.Version < ChunkSizesRepoVersion && (cfg.ChunkSizes != nil || cfg.PackSize != 0) {
+		return errors.Errorf("chunk sizes and pack size require repository version %d or later", ChunkSizesRepoVersion)
+	}
+	return nil
+}
+
 // JSONUnpackedLoader loads unpacked JSON.
 type JSONUnpackedLoader interface {
 	LoadJSONUnpacked(context.Context, FileType, ID, interface{}) error
 }
 
 // CreateConfig creates a config file with a randomly selected polynomial and
-// ID.
-func CreateConfig() (Config, error) {
+// ID for a repository with the given version.
+func CreateConfig(version uint) (Config, error) {
 	var (
 		err error
 		cfg Config
 	)
 
+	if version < MinRepoVersion || version > MaxRepoVersion {
+		return Config{}, errors.Errorf("unsupported repository version %v", version)
+	}
+
 	cfg.ChunkerPolynomial, err = chunker.RandomPolynomial()
 	if err != nil {
 		return Config{}, errors.Wrap(err, "chunker.RandomPolynomial")
 	}
 
 	cfg.ID = NewRandomID().String()
-	cfg.Version = RepoVersion
+	cfg.Version = version
 
 	debug.Log("New config: %#v", cfg)
 	return cfg, nil
 }
 
 // TestCreateConfig creates a config for use within tests.
-func TestCreateConfig(t testing.TB, pol chunker.Pol) (cfg Config) {
+func TestCreateConfig(t testing.TB, pol chunker.Pol, version uint) (cfg Config) {
 	cfg.ChunkerPolynomial = pol
 
 	cfg.ID = NewRandomID().String()
-	cfg.Version = RepoVersion
+	cfg.Version = version
 
 	return cfg
 }
@@ -77,15 +228,31 @@ func LoadConfig(ctx context.Context, r JSONUnpackedLoader) (Config, error) {
 		return Config{}, err
 	}
 
-	if cfg.Version != RepoVersion {
+	if cfg.Version < MinRepoVersion || cfg.Version > MaxRepoVersion {
 		return Config{}, errors.New("unsupported repository version")
 	}
 
+	if err := cfg.CheckVersion(); err != nil {
+		return Config{}, err
+	}
+
 	if checkPolynomial {
 		if !cfg.ChunkerPolynomial.Irreducible() {
 			return Config{}, errors.New("invalid chunker polynomial")
 		}
 	}
 
+	if cfg.ChunkSizes != nil {
+		if err := cfg.ChunkSizes.Valid(); err != nil {
+			return Config{}, errors.Wrap(err, "invalid chunker parameters")
+		}
+	}
+
+	if cfg.PackSize != 0 {
+		if err := CheckPackSize(cfg.PackSize); err != nil {
+			return Config{}, errors.Wrap(err, "invalid pack size")
+		}
+	}
+
 	return cfg, nil
 }
@@ -1,9 +1,12 @@
 package restic_test
 
 import (
+	"bytes"
 	"context"
+	"io"
 	"testing"
 
+	"github.com/restic/chunker"
 	"github.com/restic/restic/internal/restic"
 	rtest "github.com/restic/restic/internal/test"
 )
@@ -32,7 +35,7 @@ func TestConfig(t *testing.T) {
 		return restic.ID{}, nil
 	}
 
-	cfg1, err := restic.CreateConfig()
+	cfg1, err := restic.CreateConfig(restic.RepoVersion)
 	rtest.OK(t, err)
 
 	_, err = saver(save).SaveJSONUnpacked(restic.ConfigFile, cfg1)
@@ -54,3 +57,70 @@ func TestConfig(t *testing.T) {
 	rtest.Assert(t, cfg1 == cfg2,
 		"configs aren't equal: %v != %v", cfg1, cfg2)
 }
+
+func TestChunkerParams(t *testing.T) {
+	data := rtest.Random(23, 40*1024*1024)
+
+	for name, params := range restic.ChunkerProfiles {
+		t.Run(name, func(t *testing.T) {
+			rtest.OK(t, params.Valid())
+
+			c := params.NewChunker(bytes.NewReader(data), chunker.Pol(0x3DA3358B4DC173))
+			var chunks, size uint
+			buf := make([]byte, params.MaxSize)
+			for {
+				chunk, err := c.Next(buf)
+				if err == io.EOF {
+					break
+				}
+				rtest.OK(t, err)
+
+				if size+chunk.Length < uint(len(data)) {
+					rtest.Assert(t, chunk.Length >= params.MinSize, "chunk %d smaller than %d bytes: %d", chunks, params.MinSize, chunk.Length)
+				}
+				rtest.Assert(t, chunk.Length <= params.MaxSize, "chunk %d larger than %d bytes: %d", chunks, params.MaxSize, chunk.Length)
+
+				chunks++
+				size += chunk.Length
+			}
+			rtest.Equals(t, uint(len(data)), size)
+
+			// the average size of the chunks is the minimal size plus about
+			// 2^AverageBits bytes
+			average := size / chunks
+			expected := params.MinSize + 1<<uint(params.AverageBits)
+			rtest.Assert(t, average > expected/2 && average < expected*2,
+				"average chunk size %d too far from %d", average, expected)
+		})
+	}
+}
+
+func TestChunkerParamsInvalid(t *testing.T) {
+	for _, params := range []restic.ChunkerParams{
+		{},
+		{MinSize: 1024, MaxSize: 8 * 1024 * 1024, AverageBits: 20},
+		{MinSize: 512 * 1024, MaxSize: 1024 * 1024 * 1024, AverageBits: 20},
+		{MinSize: 8 * 1024 * 1024, MaxSize: 512 * 1024, AverageBits: 20},
+		{MinSize: 512 * 1024, MaxSize: 8 * 1024 * 1024, AverageBits: 40},
+	} {
+		rtest.Assert(t, params.Valid() != nil, "expected error for %#v", params)
+	}
+}
+
+func TestConfigCheckVersion(t *testing.T) {
+	params := restic.ChunkerProfiles["large"]
+	var tests = []struct {
+		cfg   restic.Config
+		valid bool
+	}{
+		{restic.Config{Version: 1}, true},
+		{restic.Config{Version: 1, ChunkSizes: &params}, false},
+		{restic.Config{Version: 1, PackSize: 16 * 1024 * 1024}, false},
+		{restic.Config{Version: 2, ChunkSizes: &params, PackSize: 16 * 1024 * 1024}, true},
+	}
+
+	for i, test := range tests {
+		err := test.cfg.CheckVersion()
+		rtest.Assert(t, (err == nil) == test.valid, "test %d: unexpected result %v", i, err)
+	}
+}
@@ -17,6 +17,7 @@ const (
 	SnapshotFile FileType = "snapshot"
 	IndexFile    FileType = "index"
 	ConfigFile   FileType = "config"
+	PolicyFile   FileType = "policy"
 )
 
 // Handle is used to store and access data in a backend.
@@ -46,6 +47,7 @@ func (h Handle) Valid() error {
 	case SnapshotFile:
 	case IndexFile:
 	case ConfigFile:
+	case PolicyFile:
 	default:
 		return errors.Errorf("invalid Type %q", h.Type)
 	}
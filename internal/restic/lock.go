@@ -2,7 +2,10 @@ package restic
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"os"
 	"os/signal"
 	"os/user"
@@ -24,26 +27,52 @@ import (
 //
 // A lock must be refreshed regularly to not be considered stale, this must be
 // triggered by regularly calling Refresh.
+//
+// Locks in an append-only repository contain an expiry time, after which they
+// are ignored by other processes. This way, locks which the backend refuses to
+// remove don't block the repository forever.
 type Lock struct {
-	Time      time.Time `json:"time"`
-	Exclusive bool      `json:"exclusive"`
-	Hostname  string    `json:"hostname"`
-	Username  string    `json:"username"`
-	PID       int       `json:"pid"`
-	UID       uint32    `json:"uid,omitempty"`
-	GID       uint32    `json:"gid,omitempty"`
-
-	repo   Repository
-	lockID *ID
+	Time      time.Time  `json:"time"`
+	Expires   *time.Time `json:"expires,omitempty"`
+	Exclusive bool       `json:"exclusive"`
+	Hostname  string     `json:"hostname"`
+	Username  string     `json:"username"`
+	PID       int        `json:"pid"`
+	UID       uint32     `json:"uid,omitempty"`
+	GID       uint32     `json:"gid,omitempty"`
+
+	repo       Repository
+	lockID     *ID
+	appendOnly bool
+	// sealed is set for locks loaded with a write-only key, only the fields
+	// from the lockEnvelope are available.
+	sealed bool
+}
+
+// lockEnvelope is the format of lock files in repositories with version 2 or
+// later, which can be used with write-only keys. The fields needed to check
+// for conflicting locks are stored in plaintext, so that clients with a
+// write-only key can read them. The complete lock is encrypted in Data.
+type lockEnvelope struct {
+	Time      time.Time  `json:"time"`
+	Expires   *time.Time `json:"expires,omitempty"`
+	Exclusive bool       `json:"exclusive"`
+	Data      []byte     `json:"data"`
 }
 
 // ErrAlreadyLocked is returned when NewLock or NewExclusiveLock are unable to
 // acquire the desired lock.
 type ErrAlreadyLocked struct {
 	otherLock *Lock
+	// otherLockID is set instead of otherLock if the lock cannot be read
+	otherLockID *ID
 }
 
 func (e ErrAlreadyLocked) Error() string {
+	if e.otherLock == nil {
+		return fmt.Sprintf("repository is already locked by lock %v, which cannot be read with a write-only key", e.otherLockID.Str())
+	}
+
 	s := ""
 	if e.otherLock.Exclusive {
 		s = "exclusively "
@@ -82,12 +111,21 @@ func TestSetLockTimeout(t testing.TB, d time.Duration) {
 	waitBeforeLockCheck = d
 }
 
+// TestSetAppendOnlyLockExpiry can be used to change the expiry time of locks
+// in append-only repositories for tests.
+func TestSetAppendOnlyLockExpiry(t testing.TB, d time.Duration) {
+	t.Logf("setting lock expiry to %v", d)
+	appendOnlyLockExpiry = d
+}
+
 func newLock(ctx context.Context, repo Repository, excl bool) (*Lock, error) {
 	lock := &Lock{
 		Time:      time.Now(),
 		PID:       os.Getpid(),
 		Exclusive: excl,
 		repo:      repo,
+
+		appendOnly: repo.Config().AppendOnly,
 	}
 
 	hn, err := os.Hostname()
@@ -144,12 +182,27 @@ func (l *Lock) checkForOtherLocks(ctx context.Context) error {
 		}
 
 		lock, err := LoadLock(ctx, l.repo, id)
+		if err != nil && isWriteOnly(l.repo) {
+			// a write-only key can only read the envelope of a lock, if
+			// that is not possible it cannot tell whether the lock is
+			// exclusive or stale. Assume it is exclusive, otherwise e.g.
+			// prune could remove the data saved while it is running.
+			debug.Log("unable to read lock %v with write-only key: %v", id, err)
+			return ErrAlreadyLocked{otherLockID: &id}
+		}
 		if err != nil {
 			// ignore locks that cannot be loaded
 			debug.Log("ignore lock %v: %v", id, err)
 			return nil
 		}
 
+		// locks with an expiry time cannot be removed, so ignore them once
+		// they are stale
+		if lock.Expires != nil && lock.Stale() {
+			debug.Log("ignore expired lock %v", id)
+			return nil
+		}
+
 		if l.Exclusive {
 			return ErrAlreadyLocked{otherLock: lock}
 		}
@@ -162,9 +215,47 @@ func (l *Lock) checkForOtherLocks(ctx context.Context) error {
 	})
 }
 
+// isWriteOnly returns true if repo was opened with a key which cannot decrypt
+// data.
+func isWriteOnly(repo Repository) bool {
+	wo, ok := repo.(interface{ WriteOnly() bool })
+	return ok && wo.WriteOnly()
+}
+
 // createLock acquires the lock by creating a file in the repository.
 func (l *Lock) createLock(ctx context.Context) (ID, error) {
-	id, err := l.repo.SaveJSONUnpacked(ctx, LockFile, l)
+	if l.appendOnly {
+		expires := l.Time.Add(appendOnlyLockExpiry)
+		l.Expires = &expires
+	}
+
+	// write-only keys require at least this version
+	if l.repo.Config().Version < CompressedRepoVersion {
+		return l.repo.SaveJSONUnpacked(ctx, LockFile, l)
+	}
+
+	plaintext, err := json.Marshal(l)
+	if err != nil {
+		return ID{}, errors.Wrap(err, "json.Marshal")
+	}
+
+	ciphertext, err := l.repo.Encrypt(plaintext)
+	if err != nil {
+		return ID{}, err
+	}
+
+	buf, err := json.Marshal(lockEnvelope{
+		Time:      l.Time,
+		Expires:   l.Expires,
+		Exclusive: l.Exclusive,
+		Data:      ciphertext,
+	})
+	if err != nil {
+		return ID{}, errors.Wrap(err, "json.Marshal")
+	}
+
+	id := Hash(buf)
+	err = l.repo.Backend().Save(ctx, Handle{Type: LockFile, Name: id.String()}, NewByteReader(buf))
 	if err != nil {
 		return ID{}, err
 	}
@@ -172,22 +263,44 @@ func (l *Lock) createLock(ctx context.Context) (ID, error) {
 	return id, nil
 }
 
-// Unlock removes the lock from the repository.
+// Unlock removes the lock from the repository. In an append-only repository,
+// the lock is left in place and expires on its own if the backend refuses to
+// remove it.
 func (l *Lock) Unlock() error {
 	if l == nil || l.lockID == nil {
 		return nil
 	}
 
-	return l.repo.Backend().Remove(context.TODO(), Handle{Type: LockFile, Name: l.lockID.String()})
+	return l.remove(*l.lockID)
+}
+
+// remove deletes the lock file with the given ID. Errors are ignored in an
+// append-only repository, where the lock expires instead.
+func (l *Lock) remove(id ID) error {
+	err := l.repo.Backend().Remove(context.TODO(), Handle{Type: LockFile, Name: id.String()})
+	if err != nil && l.appendOnly {
+		debug.Log("unable to remove lock %v from append-only repository, it expires at %v: %v", id, l.Expires, err)
+		return nil
+	}
+	return err
 }
 
 var staleTimeout = 30 * time.Minute
 
+// appendOnlyLockExpiry is the duration after which a lock in an append-only
+// repository expires when it is not refreshed.
+var appendOnlyLockExpiry = 10 * time.Minute
+
 // Stale returns true if the lock is stale. A lock is stale if the timestamp is
-// older than 30 minutes or if it was created on the current machine and the
-// process isn't alive any more.
+// older than 30 minutes, if its expiry time has passed, or if it was created
+// on the current machine and the process isn't alive any more.
 func (l *Lock) Stale() bool {
 	debug.Log("testing if lock %v for process %d is stale", l, l.PID)
+	if l.Expires != nil && time.Now().After(*l.Expires) {
+		debug.Log("lock is stale, it expired at %v\n", *l.Expires)
+		return true
+	}
+
 	if time.Since(l.Time) > staleTimeout {
 		debug.Log("lock is stale, timestamp is too old: %v\n", l.Time)
 		return true
@@ -218,7 +331,8 @@ func (l *Lock) Stale() bool {
 }
 
 // Refresh refreshes the lock by creating a new file in the backend with a new
-// timestamp. Afterwards the old lock is removed.
+// timestamp. Afterwards the old lock is removed, in an append-only repository
+// it expires instead if it cannot be removed.
 func (l *Lock) Refresh(ctx context.Context) error {
 	debug.Log("refreshing lock %v", l.lockID)
 	l.Time = time.Now()
@@ -227,7 +341,7 @@ func (l *Lock) Refresh(ctx context.Context) error {
 		return err
 	}
 
-	err = l.repo.Backend().Remove(context.TODO(), Handle{Type: LockFile, Name: l.lockID.String()})
+	err = l.remove(*l.lockID)
 	if err != nil {
 		return err
 	}
@@ -239,6 +353,11 @@ func (l *Lock) Refresh(ctx context.Context) error {
 }
 
 func (l Lock) String() string {
+	if l.sealed {
+		return fmt.Sprintf("a client whose details cannot be read with a write-only key\nlock was created at %s (%s ago)\nstorage ID %v",
+			l.Time.Format("2006-01-02 15:04:05"), time.Since(l.Time), l.lockID.Str())
+	}
+
 	text := fmt.Sprintf("PID %d on %s by %s (UID %d, GID %d)\nlock was created at %s (%s ago)\nstorage ID %v",
 		l.PID, l.Hostname, l.Username, l.UID, l.GID,
 		l.Time.Format("2006-01-02 15:04:05"), time.Since(l.Time),
@@ -262,11 +381,45 @@ func init() {
 	})
 }
 
-// LoadLock loads and unserializes a lock from a repository.
+// LoadLock loads and unserializes a lock from a repository. If the repository
+// was opened with a write-only key, only the fields stored in plaintext in the
+// envelope of the lock are set.
 func LoadLock(ctx context.Context, repo Repository, id ID) (*Lock, error) {
+	h := Handle{Type: LockFile, Name: id.String()}
+	var buf []byte
+	err := repo.Backend().Load(ctx, h, 0, 0, func(rd io.Reader) (ierr error) {
+		buf, ierr = ioutil.ReadAll(rd)
+		return ierr
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if !Hash(buf).Equal(id) {
+		return nil, errors.Errorf("load %v: invalid data returned", h)
+	}
+
+	// locks saved in repositories with version 1 are encrypted completely
+	var envelope lockEnvelope
+	if json.Unmarshal(buf, &envelope) != nil || envelope.Data == nil {
+		envelope = lockEnvelope{Data: buf}
+	}
+
 	lock := &Lock{}
-	if err := repo.LoadJSONUnpacked(ctx, LockFile, id, lock); err != nil {
+	plaintext, err := repo.Decrypt(envelope.Data)
+	switch {
+	case err != nil && isWriteOnly(repo) && !envelope.Time.IsZero():
+		lock.Time = envelope.Time
+		lock.Expires = envelope.Expires
+		lock.Exclusive = envelope.Exclusive
+		lock.sealed = true
+	case err != nil:
 		return nil, err
+	default:
+		err = json.Unmarshal(plaintext, lock)
+		if err != nil {
+			return nil, errors.Wrap(err, "json.Unmarshal")
+		}
 	}
 	lock.lockID = &id
 
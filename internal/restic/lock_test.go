@@ -3,9 +3,11 @@ package restic_test
 import (
 	"context"
 	"os"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/restic/restic/internal/errors"
 	"github.com/restic/restic/internal/repository"
 	"github.com/restic/restic/internal/restic"
 	rtest "github.com/restic/restic/internal/test"
@@ -262,3 +264,130 @@ func TestLockRefresh(t *testing.T) {
 		"expected a later timestamp after lock refresh")
 	rtest.OK(t, lock.Unlock())
 }
+
+func countLocks(repo restic.Repository, t testing.TB) int {
+	n := 0
+	err := repo.List(context.TODO(), restic.LockFile, func(id restic.ID, size int64) error {
+		n++
+		return nil
+	})
+	rtest.OK(t, err)
+	return n
+}
+
+// appendOnlyBackend rejects removing files.
+type appendOnlyBackend struct {
+	restic.Backend
+}
+
+func (be appendOnlyBackend) Remove(ctx context.Context, h restic.Handle) error {
+	return errors.New("removing files is not allowed")
+}
+
+func TestLockAppendOnly(t *testing.T) {
+	be, beCleanup := repository.TestBackend(t)
+	defer beCleanup()
+	repo, cleanup := repository.TestAppendOnlyRepository(t, appendOnlyBackend{be})
+	defer cleanup()
+
+	lock, err := restic.NewLock(context.TODO(), repo)
+	rtest.OK(t, err)
+	rtest.Assert(t, lock.Expires != nil && lock.Expires.After(lock.Time),
+		"lock in append-only repository has no expiry time")
+
+	// refreshing and unlocking succeed although the old lock files cannot be
+	// removed
+	time.Sleep(time.Millisecond)
+	rtest.OK(t, lock.Refresh(context.TODO()))
+	rtest.Equals(t, 2, countLocks(repo, t))
+
+	rtest.OK(t, lock.Unlock())
+	rtest.Equals(t, 2, countLocks(repo, t))
+}
+
+func TestLockAppendOnlyRemove(t *testing.T) {
+	repo, cleanup := repository.TestAppendOnlyRepository(t, nil)
+	defer cleanup()
+
+	// locks are removed if the backend allows it
+	lock, err := restic.NewLock(context.TODO(), repo)
+	rtest.OK(t, err)
+	time.Sleep(time.Millisecond)
+	rtest.OK(t, lock.Refresh(context.TODO()))
+	rtest.Equals(t, 1, countLocks(repo, t))
+
+	rtest.OK(t, lock.Unlock())
+	rtest.Equals(t, 0, countLocks(repo, t))
+}
+
+func TestLockAppendOnlyExpired(t *testing.T) {
+	repo, cleanup := repository.TestAppendOnlyRepository(t, nil)
+	defer cleanup()
+
+	// an expired exclusive lock from another host is ignored
+	expires := time.Now().Add(-time.Minute)
+	other := &restic.Lock{
+		Time:      expires.Add(-10 * time.Minute),
+		Expires:   &expires,
+		Exclusive: true,
+		Hostname:  "other-host",
+		PID:       os.Getpid(),
+	}
+	_, err := repo.SaveJSONUnpacked(context.TODO(), restic.LockFile, other)
+	rtest.OK(t, err)
+
+	lock, err := restic.NewExclusiveLock(context.TODO(), repo)
+	rtest.OK(t, err)
+	rtest.OK(t, lock.Unlock())
+
+	// a lock which did not expire yet is respected
+	repo, cleanup = repository.TestAppendOnlyRepository(t, nil)
+	defer cleanup()
+
+	expires = time.Now().Add(time.Minute)
+	other.Time = time.Now()
+	_, err = repo.SaveJSONUnpacked(context.TODO(), restic.LockFile, other)
+	rtest.OK(t, err)
+
+	_, err = restic.NewLock(context.TODO(), repo)
+	rtest.Assert(t, restic.IsAlreadyLocked(err), "expected ErrAlreadyLocked, got %v", err)
+}
+
+func TestLockWriteOnly(t *testing.T) {
+	r, cleanup := repository.TestAppendOnlyRepositoryWithVersion(t, nil, restic.CompressedRepoVersion)
+	defer cleanup()
+	repo := r.(*repository.Repository)
+	wrepo := repository.TestWriteOnlyRepository(t, repo)
+
+	restic.TestSetAppendOnlyLockExpiry(t, time.Second)
+	defer restic.TestSetAppendOnlyLockExpiry(t, 10*time.Minute)
+
+	// an exclusive lock is respected by clients with a write-only key
+	elock, err := restic.NewExclusiveLock(context.TODO(), repo)
+	rtest.OK(t, err)
+	_, err = restic.NewLock(context.TODO(), wrepo)
+	rtest.Assert(t, restic.IsAlreadyLocked(err), "expected ErrAlreadyLocked, got %v", err)
+	rtest.Assert(t, strings.Contains(err.Error(), "exclusively"), "lock is not reported as exclusive: %v", err)
+
+	// after it has expired, locks of clients with a write-only key which are
+	// left behind don't conflict
+	time.Sleep(time.Until(*elock.Expires))
+	for i := 0; i < 3; i++ {
+		_, err := restic.NewLock(context.TODO(), wrepo)
+		rtest.OK(t, err)
+	}
+
+	// the write-only key can only read the envelope of the locks
+	rtest.OK(t, repo.List(context.TODO(), restic.LockFile, func(id restic.ID, size int64) error {
+		lock, err := restic.LoadLock(context.TODO(), wrepo, id)
+		rtest.OK(t, err)
+		rtest.Assert(t, lock.Expires != nil && !lock.Time.IsZero() && lock.PID == 0,
+			"unexpected lock loaded with write-only key: %v", lock)
+
+		lock, err = restic.LoadLock(context.TODO(), repo, id)
+		rtest.OK(t, err)
+		rtest.Equals(t, os.Getpid(), lock.PID)
+		return nil
+	}))
+	rtest.Equals(t, 4, countLocks(repo, t))
+}
@@ -39,6 +39,11 @@ type Repository interface {
 	SaveUnpacked(context.Context, FileType, []byte) (ID, error)
 	SaveJSONUnpacked(context.Context, FileType, interface{}) (ID, error)
 
+	// Encrypt encrypts data like SaveUnpacked does before saving it, the
+	// result can be decrypted with Decrypt.
+	Encrypt([]byte) ([]byte, error)
+	Decrypt([]byte) ([]byte, error)
+
 	LoadJSONUnpacked(ctx context.Context, t FileType, id ID, dest interface{}) error
 	// LoadAndDecrypt loads and decrypts the file with the given type and ID,
 	// using the supplied buffer (which must be empty). If the buffer is nil, a
@@ -0,0 +1,137 @@
+package restic
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/restic/restic/internal/errors"
+)
+
+// RetentionPolicy is stored in the repository and describes which snapshots
+// are kept by "forget --use-repo-policy". Each snapshot is matched against the
+// rules in order, the first rule which matches determines the ExpirePolicy for
+// the snapshot. Snapshots which are not matched by any rule are kept.
+type RetentionPolicy struct {
+	Time    time.Time       `json:"time"`
+	GroupBy string          `json:"group_by,omitempty"`
+	Rules   []RetentionRule `json:"rules"`
+
+	id *ID // ID of the policy file in the repository
+}
+
+// RetentionRule selects snapshots by host, tags and paths in the same way as
+// the filter options of the forget command, and configures which of those
+// snapshots are kept.
+type RetentionRule struct {
+	Hosts []string     `json:"hosts,omitempty"`
+	Tags  []TagList    `json:"tags,omitempty"`
+	Paths []string     `json:"paths,omitempty"`
+	Keep  ExpirePolicy `json:"keep"`
+}
+
+// Match returns true if the rule applies to the snapshot sn.
+func (r RetentionRule) Match(sn *Snapshot) bool {
+	return sn.HasHostname(r.Hosts) && sn.HasTagList(r.Tags) && sn.HasPaths(r.Paths)
+}
+
+func (r RetentionRule) String() string {
+	var filters []string
+	if len(r.Hosts) > 0 {
+		filters = append(filters, fmt.Sprintf("hosts %v", r.Hosts))
+	}
+	if len(r.Tags) > 0 {
+		filters = append(filters, fmt.Sprintf("tags %v", r.Tags))
+	}
+	if len(r.Paths) > 0 {
+		filters = append(filters, fmt.Sprintf("paths %v", r.Paths))
+	}
+
+	if len(filters) == 0 {
+		return "all snapshots"
+	}
+	return "snapshots with " + strings.Join(filters, ", ")
+}
+
+// ID returns the policy's ID.
+func (p RetentionPolicy) ID() *ID {
+	return p.id
+}
+
+// Valid returns an error if the policy cannot be applied.
+func (p RetentionPolicy) Valid() error {
+	if len(p.Rules) == 0 {
+		return errors.New("policy contains no rules")
+	}
+
+	for i, rule := range p.Rules {
+		if rule.Keep.Empty() {
+			return errors.Errorf("rule %d does not keep any snapshots", i+1)
+		}
+	}
+
+	return nil
+}
+
+// Partition returns the snapshots matched by each rule, the list at index i
+// belongs to rule i. Snapshots which are not matched by any rule are returned
+// separately.
+func (p RetentionPolicy) Partition(snapshots Snapshots) (matched []Snapshots, unmatched Snapshots) {
+	matched = make([]Snapshots, len(p.Rules))
+
+next:
+	for _, sn := range snapshots {
+		for i, rule := range p.Rules {
+			if rule.Match(sn) {
+				matched[i] = append(matched[i], sn)
+				continue next
+			}
+		}
+		unmatched = append(unmatched, sn)
+	}
+
+	return matched, unmatched
+}
+
+// ErrNoRetentionPolicy is returned by LoadRetentionPolicy when the repository
+// does not contain a policy.
+var ErrNoRetentionPolicy = errors.New("repository does not contain a retention policy")
+
+// LoadRetentionPolicy returns the newest policy stored in the repository.
+func LoadRetentionPolicy(ctx context.Context, repo Repository) (*RetentionPolicy, error) {
+	var newest *RetentionPolicy
+	err := repo.List(ctx, PolicyFile, func(id ID, size int64) error {
+		p := &RetentionPolicy{id: &id}
+		err := repo.LoadJSONUnpacked(ctx, PolicyFile, id, p)
+		if err != nil {
+			return err
+		}
+
+		if newest == nil || p.Time.After(newest.Time) {
+			newest = p
+		}
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	if newest == nil {
+		return nil, ErrNoRetentionPolicy
+	}
+
+	return newest, nil
+}
+
+// SaveRetentionPolicy stores the policy p in the repository and returns its ID.
+func SaveRetentionPolicy(ctx context.Context, repo Repository, p *RetentionPolicy) (ID, error) {
+	id, err := repo.SaveJSONUnpacked(ctx, PolicyFile, p)
+	if err != nil {
+		return ID{}, err
+	}
+
+	p.id = &id
+	return id, nil
+}
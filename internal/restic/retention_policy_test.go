@@ -0,0 +1,100 @@
+package restic_test
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/restic/restic/internal/repository"
+	"github.com/restic/restic/internal/restic"
+	rtest "github.com/restic/restic/internal/test"
+)
+
+func TestExpirePolicyJSON(t *testing.T) {
+	p := restic.ExpirePolicy{
+		Daily:        7,
+		Within:       restic.Duration{Days: 3},
+		WithinWeekly: restic.Duration{Years: 1, Months: 2},
+		Tags:         []restic.TagList{{"foo", "bar"}},
+	}
+
+	buf, err := json.Marshal(p)
+	rtest.OK(t, err)
+	rtest.Equals(t, `{"daily":7,"within":"3d","within_weekly":"1y2m","tags":[["foo","bar"]]}`, string(buf))
+
+	var p2 restic.ExpirePolicy
+	rtest.OK(t, json.Unmarshal(buf, &p2))
+	rtest.Equals(t, p, p2)
+
+	err = json.Unmarshal([]byte(`{"within":"3"}`), &p2)
+	rtest.Assert(t, err != nil, "expected error for invalid duration")
+}
+
+func TestRetentionPolicyValid(t *testing.T) {
+	var tests = []struct {
+		policy restic.RetentionPolicy
+		valid  bool
+	}{
+		{restic.RetentionPolicy{}, false},
+		{restic.RetentionPolicy{Rules: []restic.RetentionRule{{Hosts: []string{"foo"}}}}, false},
+		{restic.RetentionPolicy{Rules: []restic.RetentionRule{{Keep: restic.ExpirePolicy{Last: 1}}}}, true},
+	}
+
+	for i, test := range tests {
+		err := test.policy.Valid()
+		if test.valid && err != nil {
+			t.Errorf("test %d: unexpected error %v", i, err)
+		}
+		if !test.valid && err == nil {
+			t.Errorf("test %d: expected error for invalid policy", i)
+		}
+	}
+}
+
+func TestRetentionPolicyPartition(t *testing.T) {
+	snapshots := restic.Snapshots{
+		{Hostname: "db", Paths: []string{"/srv"}},
+		{Hostname: "web", Paths: []string{"/srv"}, Tags: []string{"important"}},
+		{Hostname: "web", Paths: []string{"/home"}},
+		{Hostname: "db", Paths: []string{"/home"}, Tags: []string{"important"}},
+	}
+
+	policy := restic.RetentionPolicy{
+		Rules: []restic.RetentionRule{
+			{Hosts: []string{"db"}, Keep: restic.ExpirePolicy{Daily: 7}},
+			{Tags: []restic.TagList{{"important"}}, Keep: restic.ExpirePolicy{Last: 10}},
+			{Paths: []string{"/srv"}, Keep: restic.ExpirePolicy{Last: 1}},
+		},
+	}
+
+	matched, unmatched := policy.Partition(snapshots)
+	rtest.Equals(t, 3, len(matched))
+	rtest.Equals(t, restic.Snapshots{snapshots[0], snapshots[3]}, matched[0])
+	rtest.Equals(t, restic.Snapshots{snapshots[1]}, matched[1])
+	rtest.Equals(t, 0, len(matched[2]))
+	rtest.Equals(t, restic.Snapshots{snapshots[2]}, unmatched)
+}
+
+func TestLoadRetentionPolicy(t *testing.T) {
+	repo, cleanup := repository.TestRepository(t)
+	defer cleanup()
+
+	_, err := restic.LoadRetentionPolicy(context.TODO(), repo)
+	rtest.Equals(t, restic.ErrNoRetentionPolicy, err)
+
+	now := time.Now()
+	for i, days := range []int{7, 14, 3} {
+		p := &restic.RetentionPolicy{
+			Time:  now.Add(time.Duration(i%2) * time.Hour),
+			Rules: []restic.RetentionRule{{Keep: restic.ExpirePolicy{Daily: days}}},
+		}
+		_, err = restic.SaveRetentionPolicy(context.TODO(), repo, p)
+		rtest.OK(t, err)
+	}
+
+	p, err := restic.LoadRetentionPolicy(context.TODO(), repo)
+	rtest.OK(t, err)
+	rtest.Equals(t, 14, p.Rules[0].Keep.Daily)
+	rtest.Assert(t, p.ID() != nil, "loaded policy has no ID")
+}
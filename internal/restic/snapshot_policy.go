@@ -1,6 +1,7 @@
 package restic
 
 import (
+	"encoding/json"
 	"fmt"
 	"reflect"
 	"sort"
@@ -8,18 +9,25 @@ import (
 	"time"
 
 	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/errors"
 )
 
 // ExpirePolicy configures which snapshots should be automatically removed.
+// All durations are relative to the newest snapshot.
 type ExpirePolicy struct {
-	Last    int       // keep the last n snapshots
-	Hourly  int       // keep the last n hourly snapshots
-	Daily   int       // keep the last n daily snapshots
-	Weekly  int       // keep the last n weekly snapshots
-	Monthly int       // keep the last n monthly snapshots
-	Yearly  int       // keep the last n yearly snapshots
-	Within  Duration  // keep snapshots made within this duration
-	Tags    []TagList // keep all snapshots that include at least one of the tag lists.
+	Last          int       // keep the last n snapshots
+	Hourly        int       // keep the last n hourly snapshots
+	Daily         int       // keep the last n daily snapshots
+	Weekly        int       // keep the last n weekly snapshots
+	Monthly       int       // keep the last n monthly snapshots
+	Yearly        int       // keep the last n yearly snapshots
+	Within        Duration  // keep snapshots made within this duration
+	WithinHourly  Duration  // keep hourly snapshots made within this duration
+	WithinDaily   Duration  // keep daily snapshots made within this duration
+	WithinWeekly  Duration  // keep weekly snapshots made within this duration
+	WithinMonthly Duration  // keep monthly snapshots made within this duration
+	WithinYearly  Duration  // keep yearly snapshots made within this duration
+	Tags          []TagList // keep all snapshots that include at least one of the tag lists.
 }
 
 func (e ExpirePolicy) String() (s string) {
@@ -61,11 +69,110 @@ func (e ExpirePolicy) String() (s string) {
 		s += fmt.Sprintf("all snapshots within %s of the newest", e.Within)
 	}
 
+	var within []string
+	for _, w := range []struct {
+		d    Duration
+		name string
+	}{
+		{e.WithinHourly, "hourly"},
+		{e.WithinDaily, "daily"},
+		{e.WithinWeekly, "weekly"},
+		{e.WithinMonthly, "monthly"},
+		{e.WithinYearly, "yearly"},
+	} {
+		if !w.d.Zero() {
+			within = append(within, fmt.Sprintf("%s snapshots within %s", w.name, w.d))
+		}
+	}
+
+	if len(within) > 0 {
+		if s != "" {
+			s += " and "
+		}
+		s += fmt.Sprintf("all %s of the newest", strings.Join(within, ", "))
+	}
+
 	return s
 }
 
-// Sum returns the maximum number of snapshots to be kept according to this
-// policy.
+// expirePolicyJSON is the JSON representation of an ExpirePolicy, durations
+// are encoded as strings like "14d" and unset values are omitted.
+type expirePolicyJSON struct {
+	Last          int       `json:"last,omitempty"`
+	Hourly        int       `json:"hourly,omitempty"`
+	Daily         int       `json:"daily,omitempty"`
+	Weekly        int       `json:"weekly,omitempty"`
+	Monthly       int       `json:"monthly,omitempty"`
+	Yearly        int       `json:"yearly,omitempty"`
+	Within        string    `json:"within,omitempty"`
+	WithinHourly  string    `json:"within_hourly,omitempty"`
+	WithinDaily   string    `json:"within_daily,omitempty"`
+	WithinWeekly  string    `json:"within_weekly,omitempty"`
+	WithinMonthly string    `json:"within_monthly,omitempty"`
+	WithinYearly  string    `json:"within_yearly,omitempty"`
+	Tags          []TagList `json:"tags,omitempty"`
+}
+
+// MarshalJSON converts the policy to JSON.
+func (e ExpirePolicy) MarshalJSON() ([]byte, error) {
+	return json.Marshal(expirePolicyJSON{
+		Last:          e.Last,
+		Hourly:        e.Hourly,
+		Daily:         e.Daily,
+		Weekly:        e.Weekly,
+		Monthly:       e.Monthly,
+		Yearly:        e.Yearly,
+		Within:        e.Within.String(),
+		WithinHourly:  e.WithinHourly.String(),
+		WithinDaily:   e.WithinDaily.String(),
+		WithinWeekly:  e.WithinWeekly.String(),
+		WithinMonthly: e.WithinMonthly.String(),
+		WithinYearly:  e.WithinYearly.String(),
+		Tags:          e.Tags,
+	})
+}
+
+// UnmarshalJSON fills the policy e with data from the JSON representation.
+func (e *ExpirePolicy) UnmarshalJSON(data []byte) error {
+	var j expirePolicyJSON
+	err := json.Unmarshal(data, &j)
+	if err != nil {
+		return err
+	}
+
+	p := ExpirePolicy{
+		Last:    j.Last,
+		Hourly:  j.Hourly,
+		Daily:   j.Daily,
+		Weekly:  j.Weekly,
+		Monthly: j.Monthly,
+		Yearly:  j.Yearly,
+		Tags:    j.Tags,
+	}
+
+	for _, d := range []struct {
+		s   string
+		dst *Duration
+	}{
+		{j.Within, &p.Within},
+		{j.WithinHourly, &p.WithinHourly},
+		{j.WithinDaily, &p.WithinDaily},
+		{j.WithinWeekly, &p.WithinWeekly},
+		{j.WithinMonthly, &p.WithinMonthly},
+		{j.WithinYearly, &p.WithinYearly},
+	} {
+		*d.dst, err = ParseDuration(d.s)
+		if err != nil {
+			return errors.Wrapf(err, "invalid duration %q", d.s)
+		}
+	}
+
+	*e = p
+	return nil
+}
+
+// Sum returns the maximum number of snapshots to be kept according to the
+// counts of this policy. The durations are not taken into account.
 func (e ExpirePolicy) Sum() int {
 	return e.Last + e.Hourly + e.Daily + e.Weekly + e.Monthly + e.Yearly
 }
@@ -111,6 +218,11 @@ func always(d time.Time, nr int) int {
 	return nr
 }
 
+// subtractDuration returns the time d before t.
+func subtractDuration(t time.Time, d Duration) time.Time {
+	return t.AddDate(-d.Years, -d.Months, -d.Days).Add(time.Hour * time.Duration(-d.Hours))
+}
+
 // findLatestTimestamp returns the time stamp for the newest snapshot.
 func findLatestTimestamp(list Snapshots) time.Time {
 	if len(list) == 0 {
@@ -180,6 +292,19 @@ func ApplyPolicy(list Snapshots, p ExpirePolicy) (keep, remove Snapshots, reason
 		{p.Yearly, y, -1, "yearly snapshot"},
 	}
 
+	var bucketsWithin = [5]struct {
+		Within Duration
+		bucker func(d time.Time, nr int) int
+		Last   int
+		reason string
+	}{
+		{p.WithinHourly, ymdh, -1, "hourly within"},
+		{p.WithinDaily, ymd, -1, "daily within"},
+		{p.WithinWeekly, yw, -1, "weekly within"},
+		{p.WithinMonthly, ym, -1, "monthly within"},
+		{p.WithinYearly, y, -1, "yearly within"},
+	}
+
 	latest := findLatestTimestamp(list)
 
 	for nr, cur := range list {
@@ -196,13 +321,28 @@ func ApplyPolicy(list Snapshots, p ExpirePolicy) (keep, remove Snapshots, reason
 
 		// If the timestamp of the snapshot is within the range, then keep it.
 		if !p.Within.Zero() {
-			t := latest.AddDate(-p.Within.Years, -p.Within.Months, -p.Within.Days).Add(time.Hour * time.Duration(-p.Within.Hours))
-			if cur.Time.After(t) {
+			if cur.Time.After(subtractDuration(latest, p.Within)) {
 				keepSnap = true
 				keepSnapReasons = append(keepSnapReasons, fmt.Sprintf("within %v", p.Within))
 			}
 		}
 
+		// Keep the newest snapshot of each period which is within the range,
+		// these buckets are not limited by a count.
+		for i, b := range bucketsWithin {
+			if b.Within.Zero() || !cur.Time.After(subtractDuration(latest, b.Within)) {
+				continue
+			}
+
+			val := b.bucker(cur.Time, nr)
+			if val != b.Last {
+				debug.Log("keep %v %v, bucker within %v, val %v\n", cur.Time, cur.id.Str(), i, val)
+				keepSnap = true
+				bucketsWithin[i].Last = val
+				keepSnapReasons = append(keepSnapReasons, fmt.Sprintf("%v %v", b.reason, b.Within))
+			}
+		}
+
 		// Now update the other buckets and see if they have some counts left.
 		for i, b := range buckets {
 			if b.Count > 0 {
@@ -40,6 +40,7 @@ func TestExpireSnapshotOps(t *testing.T) {
 		{true, 0, &restic.ExpirePolicy{}},
 		{true, 0, &restic.ExpirePolicy{Tags: []restic.TagList{}}},
 		{false, 22, &restic.ExpirePolicy{Daily: 7, Weekly: 2, Monthly: 3, Yearly: 10}},
+		{false, 0, &restic.ExpirePolicy{WithinDaily: parseDuration("7d")}},
 	}
 	for i, d := range data {
 		isEmpty := d.p.Empty()
@@ -228,6 +229,17 @@ func TestApplyPolicy(t *testing.T) {
 		{Within: parseDuration("13d23h")},
 		{Within: parseDuration("2m2h")},
 		{Within: parseDuration("1y2m3d3h")},
+		{WithinHourly: parseDuration("1d")},
+		{WithinHourly: parseDuration("7d")},
+		{WithinDaily: parseDuration("3d")},
+		{WithinDaily: parseDuration("14d")},
+		{WithinWeekly: parseDuration("1m")},
+		{WithinWeekly: parseDuration("1y")},
+		{WithinMonthly: parseDuration("1y")},
+		{WithinMonthly: parseDuration("2y")},
+		{WithinYearly: parseDuration("10y")},
+		{WithinDaily: parseDuration("14d"), WithinWeekly: parseDuration("3m"), WithinMonthly: parseDuration("2y")},
+		{Within: parseDuration("2d"), WithinDaily: parseDuration("7d"), Tags: []restic.TagList{{"foo"}}},
 	}
 
 	for i, p := range tests {
@@ -269,3 +281,174 @@ func TestApplyPolicy(t *testing.T) {
 		})
 	}
 }
+
+func TestApplyPolicyWithinBuckets(t *testing.T) {
+	var tests = []struct {
+		name      string
+		policy    restic.ExpirePolicy
+		snapshots []string
+		keep      []string
+	}{
+		{
+			name:   "hourly",
+			policy: restic.ExpirePolicy{WithinHourly: parseDuration("1d")},
+			snapshots: []string{
+				"2016-01-10 12:30:00",
+				"2016-01-10 12:10:00",
+				"2016-01-10 11:59:00",
+				"2016-01-09 12:31:00",
+				"2016-01-09 12:29:00",
+				"2016-01-09 11:00:00",
+			},
+			keep: []string{
+				"2016-01-10 12:30:00",
+				"2016-01-10 11:59:00",
+				"2016-01-09 12:31:00",
+			},
+		},
+		{
+			name:   "daily-exact-boundary",
+			policy: restic.ExpirePolicy{WithinDaily: parseDuration("3d")},
+			snapshots: []string{
+				"2016-01-10 00:00:00",
+				"2016-01-09 23:00:00",
+				"2016-01-09 01:00:00",
+				"2016-01-08 12:00:00",
+				"2016-01-07 00:00:00",
+				"2016-01-06 23:59:59",
+			},
+			keep: []string{
+				"2016-01-10 00:00:00",
+				"2016-01-09 23:00:00",
+				"2016-01-08 12:00:00",
+			},
+		},
+		{
+			name:   "daily-after-boundary",
+			policy: restic.ExpirePolicy{WithinDaily: parseDuration("3d")},
+			snapshots: []string{
+				"2016-01-10 00:00:00",
+				"2016-01-07 00:00:01",
+				"2016-01-07 00:00:00",
+			},
+			keep: []string{
+				"2016-01-10 00:00:00",
+				"2016-01-07 00:00:01",
+			},
+		},
+		{
+			name:   "weekly",
+			policy: restic.ExpirePolicy{WithinWeekly: parseDuration("14d")},
+			snapshots: []string{
+				"2016-01-31 12:00:00", // Sunday, week 4
+				"2016-01-25 12:00:00", // Monday, week 4
+				"2016-01-24 12:00:00", // Sunday, week 3
+				"2016-01-18 12:00:00", // Monday, week 3
+				"2016-01-17 13:00:00", // Sunday, week 2
+				"2016-01-17 11:00:00", // Sunday, week 2, before the boundary
+				"2016-01-11 12:00:00", // Monday, week 2
+			},
+			keep: []string{
+				"2016-01-31 12:00:00",
+				"2016-01-24 12:00:00",
+				"2016-01-17 13:00:00",
+			},
+		},
+		{
+			name:   "weekly-new-year",
+			policy: restic.ExpirePolicy{WithinWeekly: parseDuration("1m")},
+			snapshots: []string{
+				"2016-01-04 12:00:00", // week 1 of 2016
+				"2016-01-03 12:00:00", // week 53 of 2015
+				"2015-12-28 12:00:00", // week 53 of 2015
+				"2015-12-27 12:00:00", // week 52 of 2015
+			},
+			keep: []string{
+				"2016-01-04 12:00:00",
+				"2016-01-03 12:00:00",
+				"2015-12-27 12:00:00",
+			},
+		},
+		{
+			name:   "monthly",
+			policy: restic.ExpirePolicy{WithinMonthly: parseDuration("2m")},
+			snapshots: []string{
+				"2016-02-15 12:00:00",
+				"2016-02-01 12:00:00",
+				"2016-01-31 12:00:00",
+				"2016-01-01 12:00:00",
+				"2015-12-15 11:00:00",
+				"2015-11-30 12:00:00",
+			},
+			keep: []string{
+				"2016-02-15 12:00:00",
+				"2016-01-31 12:00:00",
+			},
+		},
+		{
+			name:   "yearly",
+			policy: restic.ExpirePolicy{WithinYearly: parseDuration("2y")},
+			snapshots: []string{
+				"2016-06-01 12:00:00",
+				"2016-01-01 12:00:00",
+				"2015-12-31 12:00:00",
+				"2015-01-01 12:00:00",
+				"2014-06-02 12:00:00",
+				"2014-01-01 12:00:00",
+				"2013-12-31 12:00:00",
+			},
+			keep: []string{
+				"2016-06-01 12:00:00",
+				"2015-12-31 12:00:00",
+				"2014-06-02 12:00:00",
+			},
+		},
+		{
+			name: "daily-and-weekly",
+			policy: restic.ExpirePolicy{
+				WithinDaily:  parseDuration("2d"),
+				WithinWeekly: parseDuration("14d"),
+			},
+			snapshots: []string{
+				"2016-01-31 12:00:00", // Sunday, week 4
+				"2016-01-30 12:00:00",
+				"2016-01-29 13:00:00",
+				"2016-01-29 11:00:00",
+				"2016-01-24 12:00:00", // Sunday, week 3
+				"2016-01-23 12:00:00",
+				"2016-01-17 11:00:00", // Sunday, week 2, before the boundary
+			},
+			keep: []string{
+				"2016-01-31 12:00:00",
+				"2016-01-30 12:00:00",
+				"2016-01-29 13:00:00",
+				"2016-01-24 12:00:00",
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var list restic.Snapshots
+			for _, ts := range test.snapshots {
+				list = append(list, &restic.Snapshot{Time: parseTimeUTC(ts)})
+			}
+
+			keep, remove, _ := restic.ApplyPolicy(list, test.policy)
+
+			var kept []string
+			for _, sn := range keep {
+				kept = append(kept, sn.Time.Format("2006-01-02 15:04:05"))
+			}
+
+			if !cmp.Equal(test.keep, kept) {
+				t.Error(cmp.Diff(test.keep, kept))
+			}
+
+			if len(keep)+len(remove) != len(list) {
+				t.Errorf("got %d snapshots to keep and %d to remove for %d snapshots",
+					len(keep), len(remove), len(list))
+			}
+		})
+	}
+}
@@ -31,14 +31,15 @@ type fakeFileSystem struct {
 // saveFile reads from rd and saves the blobs in the repository. The list of
 // IDs is returned.
 func (fs *fakeFileSystem) saveFile(ctx context.Context, rd io.Reader) (blobs IDs) {
+	cfg := fs.repo.Config()
 	if fs.buf == nil {
-		fs.buf = make([]byte, chunker.MaxSize)
+		fs.buf = make([]byte, cfg.ChunkerParams().MaxSize)
 	}
 
 	if fs.chunker == nil {
-		fs.chunker = chunker.New(rd, fs.repo.Config().ChunkerPolynomial)
+		fs.chunker = cfg.ChunkerParams().NewChunker(rd, cfg.ChunkerPolynomial)
 	} else {
-		fs.chunker.Reset(rd, fs.repo.Config().ChunkerPolynomial)
+		cfg.ChunkerParams().ResetChunker(fs.chunker, rd, cfg.ChunkerPolynomial)
 	}
 
 	blobs = IDs{}
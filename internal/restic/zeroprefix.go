@@ -0,0 +1,43 @@
+package restic
+
+import (
+	"bytes"
+	"sync"
+)
+
+// ZeroPrefixLen returns the length of the longest all-zero prefix of p.
+func ZeroPrefixLen(p []byte) (n int) {
+	// first skip 1kB-sized blocks, for speed
+	var zeros [1024]byte
+	for len(p) >= len(zeros) && bytes.Equal(p[:len(zeros)], zeros[:]) {
+		p = p[len(zeros):]
+		n += len(zeros)
+	}
+
+	for len(p) > 0 && p[0] == 0 {
+		p = p[1:]
+		n++
+	}
+
+	return n
+}
+
+var (
+	zeroChunkMutex sync.Mutex
+	zeroChunkIDs   = make(map[uint]ID)
+)
+
+// ZeroChunk returns the ID of a chunk of size zero bytes. The chunker splits
+// long runs of zeros, such as the holes in sparse files, into chunks of
+// exactly its minimal size.
+func ZeroChunk(size uint) ID {
+	zeroChunkMutex.Lock()
+	defer zeroChunkMutex.Unlock()
+
+	id, ok := zeroChunkIDs[size]
+	if !ok {
+		id = Hash(make([]byte, size))
+		zeroChunkIDs[size] = id
+	}
+	return id
+}
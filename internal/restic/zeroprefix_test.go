@@ -0,0 +1,39 @@
+package restic_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/restic/chunker"
+	"github.com/restic/restic/internal/restic"
+	rtest "github.com/restic/restic/internal/test"
+)
+
+func TestZeroPrefixLen(t *testing.T) {
+	var buf [2048]byte
+
+	// test all possible positions for the first non-zero byte
+	for i := 0; i < len(buf); i++ {
+		buf[i] = 42
+		rtest.Equals(t, i, restic.ZeroPrefixLen(buf[:]))
+		buf[i] = 0
+	}
+	rtest.Equals(t, len(buf), restic.ZeroPrefixLen(buf[:]))
+	rtest.Equals(t, 0, restic.ZeroPrefixLen(nil))
+}
+
+func TestZeroChunk(t *testing.T) {
+	rtest.Equals(t, restic.Hash(make([]byte, chunker.MinSize)), restic.ZeroChunk(chunker.MinSize))
+
+	for name, params := range restic.ChunkerProfiles {
+		t.Run(name, func(t *testing.T) {
+			zeros := make([]byte, 3*params.MinSize)
+			c := params.NewChunker(bytes.NewReader(zeros), chunker.Pol(0x3DA3358B4DC173))
+
+			chunk, err := c.Next(nil)
+			rtest.OK(t, err)
+			rtest.Equals(t, params.MinSize, chunk.Length)
+			rtest.Equals(t, restic.ZeroChunk(params.MinSize), restic.Hash(chunk.Data))
+		})
+	}
+}
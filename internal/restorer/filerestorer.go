@@ -11,6 +11,7 @@ import (
 	"github.com/restic/restic/internal/crypto"
 	"github.com/restic/restic/internal/debug"
 	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/repository"
 	"github.com/restic/restic/internal/restic"
 )
 
@@ -57,6 +58,11 @@ type fileRestorer struct {
 
 	filesWriter *filesWriter
 
+	// sparse enables recreating the holes of sparse files, the chunks which
+	// only contain zeros are not downloaded and not written
+	sparse    bool
+	zeroChunk restic.ID
+
 	dst   string
 	files []*fileInfo
 }
@@ -83,6 +89,11 @@ func (r *fileRestorer) targetPath(location string) string {
 	return filepath.Join(r.dst, location)
 }
 
+// isHole returns true if the blob is not written when restoring sparse files.
+func (r *fileRestorer) isHole(blobID restic.ID) bool {
+	return r.sparse && blobID.Equal(r.zeroChunk)
+}
+
 func (r *fileRestorer) forEachBlob(blobIDs []restic.ID, fn func(packID restic.ID, packBlob restic.Blob)) error {
 	if len(blobIDs) == 0 {
 		return nil
@@ -116,10 +127,16 @@ func (r *fileRestorer) restoreFiles(ctx context.Context) error {
 			packsMap = make(map[restic.ID][]fileBlobInfo)
 		}
 		fileOffset := int64(0)
+		hasData := false
 		err := r.forEachBlob(fileBlobs, func(packID restic.ID, blob restic.Blob) {
+			if r.isHole(blob.ID) {
+				fileOffset += int64(blob.DataLength())
+				return
+			}
+			hasData = true
 			if largeFile {
 				packsMap[packID] = append(packsMap[packID], fileBlobInfo{id: blob.ID, offset: fileOffset})
-				fileOffset += int64(blob.Length) - crypto.Extension
+				fileOffset += int64(blob.DataLength())
 			}
 			pack, ok := packs[packID]
 			if !ok {
@@ -139,6 +156,15 @@ func (r *fileRestorer) restoreFiles(ctx context.Context) error {
 		if largeFile {
 			file.blobs = packsMap
 		}
+		if !hasData {
+			// the file only consists of holes, no pack needs to be downloaded
+			file.flags |= fileProgress
+			err := r.filesWriter.writeToFile(r.targetPath(file.location), nil, 0, file.size, true)
+			if err != nil {
+				debug.Log("unable to create sparse file %v: %v", file.location, err)
+				file.flags |= fileError
+			}
+		}
 	}
 
 	var wg sync.WaitGroup
@@ -184,9 +210,10 @@ func (r *fileRestorer) downloadPack(ctx context.Context, pack *packInfo) {
 	// calculate pack byte range and blob->[]files->[]offsets mappings
 	start, end := int64(math.MaxInt64), int64(0)
 	blobs := make(map[restic.ID]struct {
-		offset int64                 // offset of the blob in the pack
-		length int                   // length of the blob
-		files  map[*fileInfo][]int64 // file -> offsets (plural!) of the blob in the file
+		offset             int64                 // offset of the blob in the pack
+		length             int                   // length of the blob
+		uncompressedLength uint                  // length of the compressed blob's content, zero if uncompressed
+		files              map[*fileInfo][]int64 // file -> offsets (plural!) of the blob in the file
 	})
 	for file := range pack.files {
 		addBlob := func(blob restic.Blob, fileOffset int64) {
@@ -200,6 +227,7 @@ func (r *fileRestorer) downloadPack(ctx context.Context, pack *packInfo) {
 			if !ok {
 				blobInfo.offset = int64(blob.Offset)
 				blobInfo.length = int(blob.Length)
+				blobInfo.uncompressedLength = blob.UncompressedLength
 				blobInfo.files = make(map[*fileInfo][]int64)
 				blobs[blob.ID] = blobInfo
 			}
@@ -208,10 +236,10 @@ func (r *fileRestorer) downloadPack(ctx context.Context, pack *packInfo) {
 		if fileBlobs, ok := file.blobs.(restic.IDs); ok {
 			fileOffset := int64(0)
 			r.forEachBlob(fileBlobs, func(packID restic.ID, blob restic.Blob) {
-				if packID.Equal(pack.id) {
+				if packID.Equal(pack.id) && !r.isHole(blob.ID) {
 					addBlob(blob, fileOffset)
 				}
-				fileOffset += int64(blob.Length) - crypto.Extension
+				fileOffset += int64(blob.DataLength())
 			})
 		} else if packsMap, ok := file.blobs.(map[restic.ID][]fileBlobInfo); ok {
 			for _, blob := range packsMap[pack.id] {
@@ -258,7 +286,7 @@ func (r *fileRestorer) downloadPack(ctx context.Context, pack *packInfo) {
 	rd := bytes.NewReader(packData)
 
 	for blobID, blob := range blobs {
-		blobData, err := r.loadBlob(rd, blobID, blob.offset-start, blob.length)
+		blobData, err := r.loadBlob(rd, blobID, blob.offset-start, blob.length, blob.uncompressedLength)
 		if err != nil {
 			for file := range blob.files {
 				markFileError(file, err)
@@ -284,7 +312,7 @@ func (r *fileRestorer) downloadPack(ctx context.Context, pack *packInfo) {
 					} else {
 						file.lock.Unlock()
 					}
-					return r.filesWriter.writeToFile(r.targetPath(file.location), blobData, offset, createSize)
+					return r.filesWriter.writeToFile(r.targetPath(file.location), blobData, offset, createSize, r.sparse)
 				}
 				err := writeToFile()
 				if err != nil {
@@ -296,7 +324,7 @@ func (r *fileRestorer) downloadPack(ctx context.Context, pack *packInfo) {
 	}
 }
 
-func (r *fileRestorer) loadBlob(rd io.ReaderAt, blobID restic.ID, offset int64, length int) ([]byte, error) {
+func (r *fileRestorer) loadBlob(rd io.ReaderAt, blobID restic.ID, offset int64, length int, uncompressedLength uint) ([]byte, error) {
 	// TODO reconcile with Repository#loadBlob implementation
 
 	buf := make([]byte, length)
@@ -317,6 +345,13 @@ func (r *fileRestorer) loadBlob(rd io.ReaderAt, blobID restic.ID, offset int64,
 		return nil, errors.Errorf("decrypting blob %v failed: %v", blobID, err)
 	}
 
+	if uncompressedLength != 0 {
+		plaintext, err = repository.DecompressBlob(nil, plaintext, uncompressedLength)
+		if err != nil {
+			return nil, errors.Errorf("decompressing blob %v failed: %v", blobID, err)
+		}
+	}
+
 	// check hash
 	if !restic.Hash(plaintext).Equal(blobID) {
 		return nil, errors.Errorf("blob %v returned invalid hash", blobID)
@@ -6,6 +6,7 @@ import (
 
 	"github.com/cespare/xxhash/v2"
 	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/restic"
 )
 
 // writes blobs to target files.
@@ -34,7 +35,10 @@ func newFilesWriter(count int) *filesWriter {
 	}
 }
 
-func (w *filesWriter) writeToFile(path string, blob []byte, offset int64, createSize int64) error {
+// writeToFile writes blob at offset to the file at path. If createSize is not
+// negative the file is created with that size. If sparse is set, blobs which
+// only contain zeros are not written, so they are left as holes in the file.
+func (w *filesWriter) writeToFile(path string, blob []byte, offset int64, createSize int64, sparse bool) error {
 	bucket := &w.buckets[uint(xxhash.Sum64String(path))%uint(len(w.buckets))]
 
 	acquireWriter := func() (*os.File, error) {
@@ -58,10 +62,20 @@ func (w *filesWriter) writeToFile(path string, blob []byte, offset int64, create
 			return nil, err
 		}
 
+		if createSize >= 0 && sparse {
+			// extend the file to its final size, the parts which are not
+			// written remain holes
+			err := wr.Truncate(createSize)
+			if err != nil {
+				_ = wr.Close()
+				return nil, err
+			}
+		}
+
 		bucket.files[path] = wr
 		bucket.users[path] = 1
 
-		if createSize >= 0 {
+		if createSize >= 0 && !sparse {
 			err := preallocateFile(wr, createSize)
 			if err != nil {
 				// Just log the preallocate error but don't let it cause the restore process to fail.
@@ -94,6 +108,10 @@ func (w *filesWriter) writeToFile(path string, blob []byte, offset int64, create
 		return err
 	}
 
+	if sparse && restic.ZeroPrefixLen(blob) == len(blob) {
+		return releaseWriter(wr)
+	}
+
 	_, err = wr.WriteAt(blob, offset)
 
 	if err != nil {
@@ -0,0 +1,316 @@
+package restorer
+
+import (
+	"bytes"
+	"context"
+	"path/filepath"
+	"sort"
+
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/restic"
+)
+
+// Conflict describes a path which is contained in several of the snapshots
+// merged by a restorer with differing content.
+type Conflict struct {
+	Location string
+	// Snapshot is the ID of the snapshot the restored node is taken from.
+	Snapshot restic.ID
+	// Others contains the IDs of the snapshots with a different node.
+	Others restic.IDs
+}
+
+// treeSource is a tree contained in the snapshot with the index sn.
+type treeSource struct {
+	id restic.ID
+	sn int
+}
+
+// mergedTree describes a directory which is contained in several snapshots.
+type mergedTree struct {
+	location string
+	sources  []treeSource
+}
+
+// treeMerger presents the union of several snapshots as a single tree. Merged
+// directories are identified by synthetic IDs, which are resolved by
+// LoadTree. For each path, the node with the newest modification time is
+// used, ties are resolved in favor of the newer snapshot.
+type treeMerger struct {
+	repo      restic.Repository
+	snapshots restic.Snapshots // sorted by time, oldest first
+	trees     map[restic.ID]mergedTree
+	reported  restic.IDSet
+
+	// conflict is called once for each conflicting path.
+	conflict func(Conflict)
+}
+
+func newTreeMerger(repo restic.Repository, snapshots restic.Snapshots) *treeMerger {
+	sort.SliceStable(snapshots, func(i, j int) bool {
+		return snapshots[i].Time.Before(snapshots[j].Time)
+	})
+
+	return &treeMerger{
+		repo:      repo,
+		snapshots: snapshots,
+		trees:     make(map[restic.ID]mergedTree),
+		reported:  restic.NewIDSet(),
+		conflict:  func(Conflict) {},
+	}
+}
+
+// add registers the directory at location which is merged from sources and
+// returns the ID which is resolved by LoadTree.
+func (m *treeMerger) add(location string, sources []treeSource) restic.ID {
+	// keep only the newest snapshot for identical trees
+	var uniq []treeSource
+	seen := make(map[restic.ID]int)
+	for _, src := range sources {
+		if i, ok := seen[src.id]; ok {
+			uniq[i] = src
+			continue
+		}
+		seen[src.id] = len(uniq)
+		uniq = append(uniq, src)
+	}
+
+	if len(uniq) == 1 {
+		return uniq[0].id
+	}
+
+	buf := bytes.NewBuffer(nil)
+	for _, src := range uniq {
+		buf.Write(src.id[:])
+	}
+	id := restic.Hash(buf.Bytes())
+
+	m.trees[id] = mergedTree{location: location, sources: uniq}
+	return id
+}
+
+// root returns the ID of the merged root tree of all snapshots.
+func (m *treeMerger) root() restic.ID {
+	sources := make([]treeSource, 0, len(m.snapshots))
+	for i, sn := range m.snapshots {
+		sources = append(sources, treeSource{id: *sn.Tree, sn: i})
+	}
+	return m.add(string(filepath.Separator), sources)
+}
+
+// snapshot returns a snapshot which describes the merged snapshots.
+func (m *treeMerger) snapshot() *restic.Snapshot {
+	newest := m.snapshots[len(m.snapshots)-1]
+	sn := &restic.Snapshot{
+		Time:     newest.Time,
+		Hostname: newest.Hostname,
+		Username: newest.Username,
+	}
+
+	paths := make(map[string]struct{})
+	for _, s := range m.snapshots {
+		for _, p := range s.Paths {
+			if _, ok := paths[p]; !ok {
+				paths[p] = struct{}{}
+				sn.Paths = append(sn.Paths, p)
+			}
+		}
+	}
+
+	root := m.root()
+	sn.Tree = &root
+	return sn
+}
+
+// candidate is a node contained in the snapshot with the index sn.
+type candidate struct {
+	node *restic.Node
+	sn   int
+}
+
+// newer returns true if c replaces other in the merged tree.
+func (c candidate) newer(other candidate) bool {
+	if c.node.ModTime.Equal(other.node.ModTime) {
+		return c.sn > other.sn
+	}
+	return c.node.ModTime.After(other.node.ModTime)
+}
+
+// sameData returns true if both nodes restore the same data.
+func sameData(a, b *restic.Node) bool {
+	if a.Type != b.Type {
+		return false
+	}
+
+	switch a.Type {
+	case "file":
+		if a.Size != b.Size || len(a.Content) != len(b.Content) {
+			return false
+		}
+		for i := range a.Content {
+			if !a.Content[i].Equal(b.Content[i]) {
+				return false
+			}
+		}
+	case "symlink":
+		return a.LinkTarget == b.LinkTarget
+	}
+	return true
+}
+
+// LoadTree returns the tree with the ID id, which is merged from several
+// snapshots if it was returned by add. Hard links are not restored across
+// snapshots, so all nodes are returned as if they had a single link.
+func (m *treeMerger) LoadTree(ctx context.Context, id restic.ID) (*restic.Tree, error) {
+	mt, ok := m.trees[id]
+	if !ok {
+		tree, err := m.repo.LoadTree(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		for i, node := range tree.Nodes {
+			tree.Nodes[i] = withoutHardlinks(node)
+		}
+		return tree, nil
+	}
+
+	debug.Log("merging %d trees for %v", len(mt.sources), mt.location)
+
+	candidates := make(map[string][]candidate)
+	var names []string
+	for _, src := range mt.sources {
+		tree, err := m.repo.LoadTree(ctx, src.id)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, node := range tree.Nodes {
+			if _, ok := candidates[node.Name]; !ok {
+				names = append(names, node.Name)
+			}
+			candidates[node.Name] = append(candidates[node.Name], candidate{node: node, sn: src.sn})
+		}
+	}
+
+	report := !m.reported.Has(id)
+	m.reported.Insert(id)
+
+	tree := restic.NewTree()
+	for _, name := range names {
+		location := filepath.Join(mt.location, name)
+		node, err := m.merge(location, candidates[name], report)
+		if err != nil {
+			return nil, err
+		}
+
+		err = tree.Insert(node)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return tree, nil
+}
+
+// merge returns the node which is restored at location.
+func (m *treeMerger) merge(location string, candidates []candidate, report bool) (*restic.Node, error) {
+	best := candidates[0]
+	for _, c := range candidates[1:] {
+		if c.newer(best) {
+			best = c
+		}
+	}
+
+	node := withoutHardlinks(best.node)
+
+	if report {
+		var others restic.IDs
+		for _, c := range candidates {
+			if c.node.Type == "dir" && best.node.Type == "dir" {
+				continue
+			}
+			if !sameData(c.node, best.node) {
+				others = append(others, *m.snapshots[c.sn].ID())
+			}
+		}
+
+		if len(others) > 0 {
+			m.conflict(Conflict{
+				Location: location,
+				Snapshot: *m.snapshots[best.sn].ID(),
+				Others:   others.Uniq(),
+			})
+		}
+	}
+
+	if node.Type != "dir" {
+		return node, nil
+	}
+
+	var sources []treeSource
+	for _, c := range candidates {
+		if c.node.Type != "dir" {
+			continue
+		}
+		if c.node.Subtree == nil {
+			return nil, errors.Errorf("dir %v without subtree", location)
+		}
+		sources = append(sources, treeSource{id: *c.node.Subtree, sn: c.sn})
+	}
+
+	if node == best.node {
+		copied := *node
+		node = &copied
+	}
+	subtree := m.add(location, sources)
+	node.Subtree = &subtree
+	return node, nil
+}
+
+// withoutHardlinks returns node, or a copy of node with a single link if it
+// has several. Inode numbers are not unique across snapshots, so hard links
+// cannot be restored in merged trees.
+func withoutHardlinks(node *restic.Node) *restic.Node {
+	if node.Links < 2 {
+		return node
+	}
+
+	copied := *node
+	copied.Links = 1
+	return &copied
+}
+
+// NewMergingRestorer creates a restorer which restores the union of the
+// snapshots ids as a single tree. For each path, the node with the newest
+// modification time is restored, ties are resolved in favor of the newer
+// snapshot. Paths which differ between the snapshots are reported to the
+// function Conflict of the restorer. Hard links are restored as separate
+// files.
+func NewMergingRestorer(ctx context.Context, repo restic.Repository, ids restic.IDs) (*Restorer, error) {
+	if len(ids) == 0 {
+		return nil, errors.New("no snapshots to merge")
+	}
+
+	snapshots := make(restic.Snapshots, 0, len(ids))
+	for _, id := range ids.Uniq() {
+		sn, err := restic.LoadSnapshot(ctx, repo, id)
+		if err != nil {
+			return nil, err
+		}
+		snapshots = append(snapshots, sn)
+	}
+
+	r := &Restorer{
+		repo:         repo,
+		Error:        restorerAbortOnAllErrors,
+		SelectFilter: func(string, string, *restic.Node) (bool, bool) { return true, true },
+		Conflict:     func(Conflict) {},
+	}
+
+	r.merger = newTreeMerger(repo, snapshots)
+	r.merger.conflict = func(c Conflict) { r.Conflict(c) }
+	r.sn = r.merger.snapshot()
+
+	return r, nil
+}
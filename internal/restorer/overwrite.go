@@ -0,0 +1,51 @@
+package restorer
+
+import (
+	"github.com/restic/restic/internal/errors"
+)
+
+// OverwriteBehavior controls which files already existing in the target
+// directory are replaced during restore.
+type OverwriteBehavior int
+
+const (
+	// OverwriteAlways replaces all existing files.
+	OverwriteAlways OverwriteBehavior = iota
+	// OverwriteIfChanged replaces existing files only if their content
+	// differs from the snapshot, the metadata is restored in any case.
+	OverwriteIfChanged
+	// OverwriteIfNewer replaces existing files only if the file in the
+	// snapshot has a newer modification time.
+	OverwriteIfNewer
+	// OverwriteNever keeps all existing files.
+	OverwriteNever
+)
+
+var overwriteNames = map[OverwriteBehavior]string{
+	OverwriteAlways:    "always",
+	OverwriteIfChanged: "if-changed",
+	OverwriteIfNewer:   "if-newer",
+	OverwriteNever:     "never",
+}
+
+// String returns the name of the behavior.
+func (b OverwriteBehavior) String() string {
+	return overwriteNames[b]
+}
+
+// Set parses the name of a behavior.
+func (b *OverwriteBehavior) Set(s string) error {
+	for behavior, name := range overwriteNames {
+		if name == s {
+			*b = behavior
+			return nil
+		}
+	}
+
+	return errors.Errorf("invalid overwrite behavior %q, must be one of always, if-changed, if-newer or never", s)
+}
+
+// Type returns a description of the type.
+func (OverwriteBehavior) Type() string {
+	return "behavior"
+}
@@ -19,6 +19,22 @@ type Restorer struct {
 
 	Error        func(location string, err error) error
 	SelectFilter func(item string, dstpath string, node *restic.Node) (selectedForRestore bool, childMayBeSelected bool)
+
+	// Overwrite controls which files already existing in the target are
+	// replaced.
+	Overwrite OverwriteBehavior
+	// Delete enables removing files from the target which are not contained
+	// in the snapshot.
+	Delete bool
+	// Sparse enables restoring files as sparse files, the holes recorded in
+	// the snapshot are not written.
+	Sparse bool
+
+	// Conflict is called for each path which differs between the snapshots
+	// merged by a restorer created with NewMergingRestorer.
+	Conflict func(Conflict)
+
+	merger *treeMerger
 }
 
 var restorerAbortOnAllErrors = func(location string, err error) error { return err }
@@ -29,6 +45,7 @@ func NewRestorer(ctx context.Context, repo restic.Repository, id restic.ID) (*Re
 		repo:         repo,
 		Error:        restorerAbortOnAllErrors,
 		SelectFilter: func(string, string, *restic.Node) (bool, bool) { return true, true },
+		Conflict:     func(Conflict) {},
 	}
 
 	var err error
@@ -41,6 +58,15 @@ func NewRestorer(ctx context.Context, repo restic.Repository, id restic.ID) (*Re
 	return r, nil
 }
 
+// loadTree loads the tree with the ID id, which may be a tree merged from
+// several snapshots.
+func (res *Restorer) loadTree(ctx context.Context, id restic.ID) (*restic.Tree, error) {
+	if res.merger != nil {
+		return res.merger.LoadTree(ctx, id)
+	}
+	return res.repo.LoadTree(ctx, id)
+}
+
 type treeVisitor struct {
 	enterDir  func(node *restic.Node, target, location string) error
 	visitNode func(node *restic.Node, target, location string) error
@@ -51,7 +77,7 @@ type treeVisitor struct {
 // target is the path in the file system, location within the snapshot.
 func (res *Restorer) traverseTree(ctx context.Context, target, location string, treeID restic.ID, visitor treeVisitor) (hasRestored bool, err error) {
 	debug.Log("%v %v %v", target, location, treeID)
-	tree, err := res.repo.LoadTree(ctx, treeID)
+	tree, err := res.loadTree(ctx, treeID)
 	if err != nil {
 		debug.Log("error loading tree %v: %v", treeID, err)
 		return hasRestored, res.Error(location, err)
@@ -157,6 +183,13 @@ func (res *Restorer) traverseTree(ctx context.Context, target, location string,
 func (res *Restorer) restoreNodeTo(ctx context.Context, node *restic.Node, target, location string) error {
 	debug.Log("restoreNode %v %v %v", node.Name, target, location)
 
+	// special files and symlinks cannot be created if the target exists
+	if _, err := fs.Lstat(target); err == nil {
+		if err := fs.Remove(target); err != nil {
+			return errors.Wrap(err, "RemoveNode")
+		}
+	}
+
 	err := node.CreateAt(ctx, target, res.repo)
 	if err != nil {
 		debug.Log("node.CreateAt(%s) error %v", target, err)
@@ -215,7 +248,14 @@ func (res *Restorer) RestoreTo(ctx context.Context, dst string) error {
 
 	idx := restic.NewHardlinkIndex()
 
+	// skipped contains the locations which are not restored because of the
+	// overwrite behavior, the value is true if the metadata should be
+	// restored nevertheless.
+	skipped := make(map[string]bool)
+
 	filerestorer := newFileRestorer(dst, res.repo.Backend().Load, res.repo.Key(), res.repo.Index().Lookup)
+	filerestorer.sparse = res.Sparse
+	filerestorer.zeroChunk = restic.ZeroChunk(res.repo.Config().ChunkerParams().MinSize)
 
 	debug.Log("first pass for %q", dst)
 
@@ -237,10 +277,26 @@ func (res *Restorer) RestoreTo(ctx context.Context, dst string) error {
 				return err
 			}
 
+			skip, restoreMetadata, err := res.keepExisting(node, target)
+			if err != nil {
+				return err
+			}
+			if skip {
+				debug.Log("first pass, visitNode: keep existing %q", location)
+				skipped[location] = restoreMetadata
+			}
+
 			if node.Type != "file" {
 				return nil
 			}
 
+			// never write through an existing symlink or to a special file
+			if fi, err := fs.Lstat(target); !skip && err == nil && !fi.Mode().IsRegular() && !fi.IsDir() {
+				if err := fs.Remove(target); err != nil {
+					return errors.Wrap(err, "RemoveNode")
+				}
+			}
+
 			if node.Size == 0 {
 				return nil // deal with empty files later
 			}
@@ -252,6 +308,10 @@ func (res *Restorer) RestoreTo(ctx context.Context, dst string) error {
 				idx.Add(node.Inode, node.DeviceID, location)
 			}
 
+			if skip {
+				return nil
+			}
+
 			filerestorer.addFile(location, node.Content, int64(node.Size))
 
 			return nil
@@ -278,6 +338,13 @@ func (res *Restorer) RestoreTo(ctx context.Context, dst string) error {
 		},
 		visitNode: func(node *restic.Node, target, location string) error {
 			debug.Log("second pass, visitNode: restore node %q", location)
+			if restoreMetadata, ok := skipped[location]; ok {
+				if restoreMetadata {
+					return res.restoreNodeMetadataTo(node, target, location)
+				}
+				return nil
+			}
+
 			if node.Type != "file" {
 				return res.restoreNodeTo(ctx, node, target, location)
 			}
@@ -301,7 +368,191 @@ func (res *Restorer) RestoreTo(ctx context.Context, dst string) error {
 			return res.restoreNodeMetadataTo(node, target, location)
 		},
 	})
-	return err
+	if err != nil || !res.Delete {
+		return err
+	}
+
+	debug.Log("removing files not contained in the snapshot from %q", dst)
+	return res.removeUnexpectedFiles(ctx, dst, string(filepath.Separator), *res.sn.Tree)
+}
+
+// keepExisting reports whether an existing file at target is kept instead of
+// restoring node, depending on res.Overwrite. If restoreMetadata is true, the
+// content of the file matches the node and only the metadata is restored.
+func (res *Restorer) keepExisting(node *restic.Node, target string) (keep bool, restoreMetadata bool, err error) {
+	if res.Overwrite == OverwriteAlways {
+		return false, false, nil
+	}
+
+	fi, err := fs.Lstat(target)
+	if os.IsNotExist(err) {
+		return false, false, nil
+	}
+	if err != nil {
+		return false, false, errors.Wrap(err, "Lstat")
+	}
+
+	switch res.Overwrite {
+	case OverwriteNever:
+		return true, false, nil
+	case OverwriteIfNewer:
+		return !node.ModTime.After(fi.ModTime()), false, nil
+	case OverwriteIfChanged:
+		switch node.Type {
+		case "file":
+			if !fi.Mode().IsRegular() || fi.Size() != int64(node.Size) {
+				return false, false, nil
+			}
+			if fi.ModTime().Equal(node.ModTime) {
+				return true, true, nil
+			}
+			// same size, but different modification time: compare the content
+			return res.verifyFile(target, node) == nil, true, nil
+		case "symlink":
+			if fi.Mode()&os.ModeSymlink == 0 {
+				return false, false, nil
+			}
+			linkTarget, err := fs.Readlink(target)
+			return err == nil && linkTarget == node.LinkTarget, true, nil
+		}
+	}
+
+	return false, false, nil
+}
+
+// removeUnexpectedFiles removes all files below target which are not contained
+// in the tree, but would have been selected for restore otherwise. Only
+// directories which are contained in the tree are visited.
+func (res *Restorer) removeUnexpectedFiles(ctx context.Context, target, location string, treeID restic.ID) error {
+	tree, err := res.loadTree(ctx, treeID)
+	if err != nil {
+		return res.Error(location, err)
+	}
+
+	entries, err := readdirnames(target)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return res.Error(location, err)
+	}
+
+	nodes := make(map[string]*restic.Node, len(tree.Nodes))
+	for _, node := range tree.Nodes {
+		nodes[node.Name] = node
+	}
+
+	for _, name := range entries {
+		entryTarget := filepath.Join(target, name)
+		entryLocation := filepath.Join(location, name)
+
+		fi, err := fs.Lstat(entryTarget)
+		if err != nil {
+			if err := res.Error(entryLocation, err); err != nil {
+				return err
+			}
+			continue
+		}
+
+		node, ok := nodes[name]
+		if !ok {
+			_, err := res.removeExtraneous(entryTarget, entryLocation, fi)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		// never follow symlinks
+		if node.Type != "dir" || !fi.IsDir() || node.Subtree == nil {
+			continue
+		}
+
+		_, childMayBeSelected := res.SelectFilter(entryLocation, entryTarget, node)
+		if !childMayBeSelected {
+			continue
+		}
+
+		err = res.removeUnexpectedFiles(ctx, entryTarget, entryLocation, *node.Subtree)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// removeExtraneous removes the file at target, which is not contained in the
+// snapshot, if the filter would have selected it for restore, e.g. excluded
+// files are kept. A directory is only removed if all entries below it were
+// removed. It returns whether target was removed.
+func (res *Restorer) removeExtraneous(target, location string, fi os.FileInfo) (removed bool, err error) {
+	node, err := restic.NodeFromFileInfo(target, fi)
+	if err != nil {
+		return false, res.Error(location, err)
+	}
+
+	selected, _ := res.SelectFilter(location, target, node)
+
+	// never follow symlinks, fi is the result of Lstat
+	if fi.IsDir() {
+		entries, err := readdirnames(target)
+		if err != nil {
+			return false, res.Error(location, err)
+		}
+
+		complete := true
+		for _, name := range entries {
+			entryTarget := filepath.Join(target, name)
+			entryLocation := filepath.Join(location, name)
+
+			entryFi, err := fs.Lstat(entryTarget)
+			if err != nil {
+				complete = false
+				if err := res.Error(entryLocation, err); err != nil {
+					return false, err
+				}
+				continue
+			}
+
+			entryRemoved, err := res.removeExtraneous(entryTarget, entryLocation, entryFi)
+			if err != nil {
+				return false, err
+			}
+			if !entryRemoved {
+				complete = false
+			}
+		}
+
+		if !complete {
+			return false, nil
+		}
+	}
+
+	if !selected {
+		return false, nil
+	}
+
+	debug.Log("removing %q, it is not contained in the snapshot", target)
+	if err := fs.Remove(target); err != nil {
+		return false, res.Error(location, err)
+	}
+	return true, nil
+}
+
+func readdirnames(dir string) ([]string, error) {
+	f, err := fs.Open(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	entries, err := f.Readdirnames(-1)
+	if err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+
+	return entries, f.Close()
 }
 
 // Snapshot returns the snapshot this restorer is configured to use.
@@ -309,6 +560,15 @@ func (res *Restorer) Snapshot() *restic.Snapshot {
 	return res.sn
 }
 
+// Snapshots returns the snapshots which are merged by the restorer, sorted by
+// time, or the restored snapshot if the restorer does not merge snapshots.
+func (res *Restorer) Snapshots() restic.Snapshots {
+	if res.merger == nil {
+		return restic.Snapshots{res.sn}
+	}
+	return res.merger.snapshots
+}
+
 // VerifyFiles reads all snapshot files and verifies their contents
 func (res *Restorer) VerifyFiles(ctx context.Context, dst string) (int, error) {
 	// TODO multithreaded?
@@ -322,44 +582,44 @@ func (res *Restorer) VerifyFiles(ctx context.Context, dst string) (int, error) {
 			}
 
 			count++
-			stat, err := os.Stat(target)
-			if err != nil {
-				return err
-			}
-			if int64(node.Size) != stat.Size() {
-				return errors.Errorf("Invalid file size: expected %d got %d", node.Size, stat.Size())
-			}
-
-			file, err := os.Open(target)
-			if err != nil {
-				return err
-			}
-
-			offset := int64(0)
-			for _, blobID := range node.Content {
-				length, _ := res.repo.LookupBlobSize(blobID, restic.DataBlob)
-				buf := make([]byte, length) // TODO do I want to reuse the buffer somehow?
-				_, err = file.ReadAt(buf, offset)
-				if err != nil {
-					_ = file.Close()
-					return err
-				}
-				if !blobID.Equal(restic.Hash(buf)) {
-					_ = file.Close()
-					return errors.Errorf("Unexpected contents starting at offset %d", offset)
-				}
-				offset += int64(length)
-			}
-
-			err = file.Close()
-			if err != nil {
-				return err
-			}
-
-			return nil
+			return res.verifyFile(target, node)
 		},
 		leaveDir: func(node *restic.Node, target, location string) error { return nil },
 	})
 
 	return count, err
 }
+
+// verifyFile checks that the file at target has the content of node.
+func (res *Restorer) verifyFile(target string, node *restic.Node) error {
+	stat, err := os.Stat(target)
+	if err != nil {
+		return err
+	}
+	if int64(node.Size) != stat.Size() {
+		return errors.Errorf("Invalid file size: expected %d got %d", node.Size, stat.Size())
+	}
+
+	file, err := os.Open(target)
+	if err != nil {
+		return err
+	}
+
+	offset := int64(0)
+	for _, blobID := range node.Content {
+		length, _ := res.repo.LookupBlobSize(blobID, restic.DataBlob)
+		buf := make([]byte, length) // TODO do I want to reuse the buffer somehow?
+		_, err = file.ReadAt(buf, offset)
+		if err != nil {
+			_ = file.Close()
+			return err
+		}
+		if !blobID.Equal(restic.Hash(buf)) {
+			_ = file.Close()
+			return errors.Errorf("Unexpected contents starting at offset %d", offset)
+		}
+		offset += int64(length)
+	}
+
+	return file.Close()
+}
@@ -804,3 +804,246 @@ func TestRestorerConsistentTimestampsAndPermissions(t *testing.T) {
 		checkConsistentInfo(t, test.path, f, test.modtime, test.mode)
 	}
 }
+
+func TestRestorerOverwrite(t *testing.T) {
+	baseTime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	snapshot := Snapshot{
+		Nodes: map[string]Node{
+			"older-local": File{Data: "content: new\n", ModTime: baseTime},
+			"same":        File{Data: "same\n", ModTime: baseTime},
+			"newer-local": File{Data: "snapshot\n", ModTime: baseTime},
+			"missing":     File{Data: "missing\n", ModTime: baseTime},
+		},
+	}
+
+	existing := map[string]struct {
+		data    string
+		modTime time.Time
+	}{
+		"older-local": {"content: old\n", baseTime.Add(-time.Hour)},
+		"same":        {"same\n", baseTime.Add(time.Hour)},
+		"newer-local": {"local\n", baseTime.Add(time.Hour)},
+	}
+
+	var tests = []struct {
+		overwrite OverwriteBehavior
+		files     map[string]string
+	}{
+		{
+			overwrite: OverwriteAlways,
+			files: map[string]string{
+				"older-local": "content: new\n",
+				"same":        "same\n",
+				"newer-local": "snapshot\n",
+				"missing":     "missing\n",
+			},
+		},
+		{
+			overwrite: OverwriteIfChanged,
+			files: map[string]string{
+				"older-local": "content: new\n",
+				"same":        "same\n",
+				"newer-local": "snapshot\n",
+				"missing":     "missing\n",
+			},
+		},
+		{
+			overwrite: OverwriteIfNewer,
+			files: map[string]string{
+				"older-local": "content: new\n",
+				"same":        "same\n",
+				"newer-local": "local\n",
+				"missing":     "missing\n",
+			},
+		},
+		{
+			overwrite: OverwriteNever,
+			files: map[string]string{
+				"older-local": "content: old\n",
+				"same":        "same\n",
+				"newer-local": "local\n",
+				"missing":     "missing\n",
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.overwrite.String(), func(t *testing.T) {
+			repo, cleanup := repository.TestRepository(t)
+			defer cleanup()
+
+			_, id := saveSnapshot(t, repo, snapshot)
+
+			res, err := NewRestorer(context.TODO(), repo, id)
+			rtest.OK(t, err)
+			res.Overwrite = test.overwrite
+
+			tempdir, cleanup := rtest.TempDir(t)
+			defer cleanup()
+
+			for name, file := range existing {
+				filename := filepath.Join(tempdir, name)
+				rtest.OK(t, ioutil.WriteFile(filename, []byte(file.data), 0644))
+				rtest.OK(t, os.Chtimes(filename, file.modTime, file.modTime))
+			}
+
+			rtest.OK(t, res.RestoreTo(context.TODO(), tempdir))
+
+			for name, content := range test.files {
+				data, err := ioutil.ReadFile(filepath.Join(tempdir, name))
+				rtest.OK(t, err)
+				rtest.Equals(t, content, string(data))
+			}
+
+			// the metadata of unchanged files is restored
+			if test.overwrite == OverwriteIfChanged {
+				fi, err := os.Stat(filepath.Join(tempdir, "same"))
+				rtest.OK(t, err)
+				rtest.Assert(t, fi.ModTime().Equal(baseTime), "wrong modification time %v for unchanged file", fi.ModTime())
+			}
+		})
+	}
+}
+
+func TestOverwriteBehaviorSet(t *testing.T) {
+	for _, behavior := range []OverwriteBehavior{OverwriteAlways, OverwriteIfChanged, OverwriteIfNewer, OverwriteNever} {
+		var b OverwriteBehavior
+		rtest.OK(t, b.Set(behavior.String()))
+		rtest.Equals(t, behavior, b)
+	}
+
+	var b OverwriteBehavior
+	rtest.Assert(t, b.Set("sometimes") != nil, "invalid behavior was accepted")
+}
+
+func TestRestorerDelete(t *testing.T) {
+	repo, cleanup := repository.TestRepository(t)
+	defer cleanup()
+
+	_, id := saveSnapshot(t, repo, Snapshot{
+		Nodes: map[string]Node{
+			"foo": File{Data: "content: foo\n"},
+			"dirtest": Dir{
+				Nodes: map[string]Node{
+					"file": File{Data: "content: file\n"},
+				},
+			},
+		},
+	})
+
+	res, err := NewRestorer(context.TODO(), repo, id)
+	rtest.OK(t, err)
+	res.Delete = true
+	// files matching *.txt are excluded
+	res.SelectFilter = func(item, dstpath string, node *restic.Node) (bool, bool) {
+		selected := filepath.Ext(item) != ".txt"
+		return selected, selected && node.Type == "dir"
+	}
+
+	tempdir, cleanup := rtest.TempDir(t)
+	defer cleanup()
+
+	for _, dir := range []string{"dirtest/subdir", "otherdir", "partialdir/subdir"} {
+		rtest.OK(t, os.MkdirAll(filepath.Join(tempdir, filepath.FromSlash(dir)), 0755))
+	}
+	for _, name := range []string{"extra", "dirtest/extra", "dirtest/subdir/file", "otherdir/file", "excluded.txt", "dirtest/excluded.txt",
+		"partialdir/file", "partialdir/subdir/excluded.txt"} {
+		rtest.OK(t, ioutil.WriteFile(filepath.Join(tempdir, filepath.FromSlash(name)), []byte("extra"), 0644))
+	}
+
+	rtest.OK(t, res.RestoreTo(context.TODO(), tempdir))
+
+	var files []string
+	rtest.OK(t, filepath.Walk(tempdir, func(p string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if p == tempdir {
+			return nil
+		}
+		rel, err := filepath.Rel(tempdir, p)
+		files = append(files, filepath.ToSlash(rel))
+		return err
+	}))
+
+	// directories containing excluded files are kept
+	rtest.Equals(t, []string{"dirtest", "dirtest/excluded.txt", "dirtest/file", "excluded.txt", "foo",
+		"partialdir", "partialdir/subdir", "partialdir/subdir/excluded.txt"}, files)
+}
+
+func TestRestorerMerge(t *testing.T) {
+	repo, cleanup := repository.TestRepository(t)
+	defer cleanup()
+
+	t0 := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	t1 := t0.Add(time.Hour)
+	t2 := t1.Add(time.Hour)
+
+	_, id1 := saveSnapshot(t, repo, Snapshot{
+		Nodes: map[string]Node{
+			"dir": Dir{
+				Nodes: map[string]Node{
+					"a": File{Data: "a1", ModTime: t1},
+					"b": File{Data: "b1", ModTime: t1},
+				},
+			},
+			"only1": File{Data: "x", ModTime: t1},
+		},
+	})
+	_, id2 := saveSnapshot(t, repo, Snapshot{
+		Nodes: map[string]Node{
+			"dir": Dir{
+				Nodes: map[string]Node{
+					"a": File{Data: "a2", ModTime: t2},
+					"c": File{Data: "c2", ModTime: t2},
+				},
+			},
+			"only1": File{Data: "x", ModTime: t1},
+		},
+	})
+	_, id3 := saveSnapshot(t, repo, Snapshot{
+		Nodes: map[string]Node{
+			"dir": Dir{
+				Nodes: map[string]Node{
+					"b": File{Data: "b0", ModTime: t0},
+				},
+			},
+		},
+	})
+
+	res, err := NewMergingRestorer(context.TODO(), repo, restic.IDs{id3, id1, id2})
+	rtest.OK(t, err)
+	rtest.Equals(t, 3, len(res.Snapshots()))
+
+	conflicts := make(map[string]Conflict)
+	res.Conflict = func(c Conflict) {
+		_, ok := conflicts[c.Location]
+		rtest.Assert(t, !ok, "conflict for %v reported twice", c.Location)
+		conflicts[c.Location] = c
+	}
+
+	tempdir, cleanup := rtest.TempDir(t)
+	defer cleanup()
+
+	rtest.OK(t, res.RestoreTo(context.TODO(), tempdir))
+
+	for filename, data := range map[string]string{
+		"dir/a": "a2",
+		"dir/b": "b1",
+		"dir/c": "c2",
+		"only1": "x",
+	} {
+		buf, err := ioutil.ReadFile(filepath.Join(tempdir, filepath.FromSlash(filename)))
+		rtest.OK(t, err)
+		rtest.Equals(t, data, string(buf))
+	}
+
+	rtest.Equals(t, map[string]Conflict{
+		filepath.FromSlash("/dir/a"): {Location: filepath.FromSlash("/dir/a"), Snapshot: id2, Others: restic.IDs{id1}},
+		filepath.FromSlash("/dir/b"): {Location: filepath.FromSlash("/dir/b"), Snapshot: id1, Others: restic.IDs{id3}},
+	}, conflicts)
+
+	_, err = NewMergingRestorer(context.TODO(), repo, nil)
+	rtest.Assert(t, err != nil, "expected error when merging no snapshots")
+}
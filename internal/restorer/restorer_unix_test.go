@@ -3,12 +3,17 @@
 package restorer
 
 import (
+	"bytes"
 	"context"
+	"io/ioutil"
 	"os"
 	"path/filepath"
 	"syscall"
 	"testing"
+	"time"
 
+	"github.com/restic/restic/internal/archiver"
+	"github.com/restic/restic/internal/fs"
 	"github.com/restic/restic/internal/repository"
 	"github.com/restic/restic/internal/restic"
 	rtest "github.com/restic/restic/internal/test"
@@ -59,3 +64,69 @@ func TestRestorerRestoreEmptyHardlinkedFileds(t *testing.T) {
 		rtest.Equals(t, s1.Ino, s2.Ino)
 	}
 }
+
+// allocatedSize returns the number of bytes allocated on disk for filename.
+func allocatedSize(t testing.TB, filename string) int64 {
+	fi, err := os.Stat(filename)
+	rtest.OK(t, err)
+	return fi.Sys().(*syscall.Stat_t).Blocks * 512
+}
+
+func TestRestorerSparseFiles(t *testing.T) {
+	repo, cleanup := repository.TestRepository(t)
+	defer cleanup()
+
+	srcdir, cleanup := rtest.TempDir(t)
+	defer cleanup()
+
+	// a file with some data at the beginning and at the end and a large hole
+	// in between
+	const size = 16 * 1024 * 1024
+	data := bytes.Repeat([]byte("data"), 1024)
+	f, err := os.Create(filepath.Join(srcdir, "file"))
+	rtest.OK(t, err)
+	_, err = f.Write(data)
+	rtest.OK(t, err)
+	_, err = f.WriteAt(data, size-int64(len(data)))
+	rtest.OK(t, err)
+	rtest.OK(t, f.Close())
+
+	if allocatedSize(t, filepath.Join(srcdir, "file")) >= size/2 {
+		t.Skip("file system does not support sparse files")
+	}
+
+	cleanupChdir := rtest.Chdir(t, srcdir)
+	arch := archiver.New(repo, fs.Local{}, archiver.Options{})
+	_, id, err := arch.Snapshot(context.TODO(), []string{"file"}, archiver.SnapshotOptions{Time: time.Now()})
+	rtest.OK(t, err)
+	cleanupChdir()
+
+	for _, sparse := range []bool{false, true} {
+		res, err := NewRestorer(context.TODO(), repo, id)
+		rtest.OK(t, err)
+		res.Sparse = sparse
+
+		tempdir, cleanup := rtest.TempDir(t)
+		defer cleanup()
+
+		rtest.OK(t, res.RestoreTo(context.TODO(), tempdir))
+
+		filename := filepath.Join(tempdir, "file")
+		buf, err := ioutil.ReadFile(filename)
+		rtest.OK(t, err)
+		rtest.Equals(t, size, len(buf))
+		rtest.Assert(t, bytes.Equal(data, buf[:len(data)]), "wrong data at the beginning of the file")
+		rtest.Assert(t, bytes.Equal(data, buf[size-len(data):]), "wrong data at the end of the file")
+		rtest.Equals(t, size-2*len(data), bytes.Count(buf[len(data):size-len(data)], []byte{0}))
+
+		allocated := allocatedSize(t, filename)
+		t.Logf("sparse %v: %d of %d bytes allocated", sparse, allocated, size)
+		if sparse {
+			rtest.Assert(t, allocated < size/2,
+				"sparse file uses %d bytes on disk, want less than %d", allocated, size/2)
+		} else {
+			rtest.Assert(t, allocated >= size,
+				"file uses %d bytes on disk, want at least %d", allocated, size)
+		}
+	}
+}
@@ -0,0 +1,428 @@
+// Package searchindex implements a local index of the trees in a repository,
+// which allows searching for files in many snapshots without loading the
+// trees from the repository again and again.
+//
+// The index is stored as a set of encrypted segment files in a directory,
+// usually in the cache directory of the repository. Trees are keyed by their
+// ID, so a tree which is contained in several snapshots is only stored once.
+// New snapshots are added to a new segment, which only contains the trees not
+// yet known. When there are too many segments or too many trees which are no
+// longer referenced by any snapshot, all segments are combined into one.
+package searchindex
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/restic/restic/internal/crypto"
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/fs"
+	"github.com/restic/restic/internal/restic"
+)
+
+const (
+	dirMode = 0700
+
+	segmentVersion = 1
+
+	// maxSegments is the number of segments above which all segments are
+	// combined into one.
+	maxSegments = 20
+	// maxUnused is the fraction of unreferenced trees above which all
+	// segments are rewritten.
+	maxUnused = 0.2
+)
+
+// segment is the content of a segment file.
+type segment struct {
+	Version   int             `json:"version"`
+	Snapshots []snapshotEntry `json:"snapshots"`
+	Trees     []treeEntry     `json:"trees"`
+}
+
+type snapshotEntry struct {
+	ID   restic.ID `json:"id"`
+	Tree restic.ID `json:"tree"`
+}
+
+type treeEntry struct {
+	ID    restic.ID      `json:"id"`
+	Nodes []*restic.Node `json:"nodes"`
+}
+
+// Index is a local index of the trees of a set of snapshots. The nodes in
+// the index contain all metadata except for the content of files. Index
+// implements restic.TreeLoader, so it can be used to walk the indexed
+// snapshots.
+type Index struct {
+	dir string
+	key *crypto.Key
+
+	trees     map[restic.ID]*restic.Tree
+	snapshots map[restic.ID]restic.ID
+	segments  restic.IDSet
+	// loaded is the number of trees stored in the segments
+	loaded int
+
+	// new trees and snapshots which are not saved yet
+	newTrees     restic.IDSet
+	newSnapshots restic.IDSet
+}
+
+// Open loads the index stored in dir, which is created if it does not exist.
+// Segments which cannot be read or decrypted with key are removed.
+func Open(dir string, key *crypto.Key) (*Index, error) {
+	idx := &Index{
+		dir:          dir,
+		key:          key,
+		trees:        make(map[restic.ID]*restic.Tree),
+		snapshots:    make(map[restic.ID]restic.ID),
+		segments:     restic.NewIDSet(),
+		newTrees:     restic.NewIDSet(),
+		newSnapshots: restic.NewIDSet(),
+	}
+
+	err := fs.MkdirAll(dir, dirMode)
+	if err != nil {
+		return nil, errors.Wrap(err, "MkdirAll")
+	}
+
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, errors.Wrap(err, "ReadDir")
+	}
+
+	for _, fi := range entries {
+		id, err := restic.ParseID(fi.Name())
+		if err != nil || !fi.Mode().IsRegular() {
+			continue
+		}
+
+		err = idx.loadSegment(id)
+		if err != nil {
+			debug.Log("removing invalid segment %v: %v", id.Str(), err)
+			_ = fs.Remove(filepath.Join(dir, fi.Name()))
+			continue
+		}
+		idx.segments.Insert(id)
+	}
+
+	idx.removeIncomplete()
+	debug.Log("loaded %d snapshots and %d trees from %d segments", len(idx.snapshots), len(idx.trees), len(idx.segments))
+
+	return idx, nil
+}
+
+func (idx *Index) loadSegment(id restic.ID) error {
+	buf, err := ioutil.ReadFile(filepath.Join(idx.dir, id.String()))
+	if err != nil {
+		return err
+	}
+
+	if !restic.Hash(buf).Equal(id) {
+		return errors.New("checksum mismatch")
+	}
+	if len(buf) < idx.key.NonceSize() {
+		return errors.New("segment too short")
+	}
+
+	nonce, ciphertext := buf[:idx.key.NonceSize()], buf[idx.key.NonceSize():]
+	plaintext, err := idx.key.Open(ciphertext[:0], nonce, ciphertext, nil)
+	if err != nil {
+		return err
+	}
+
+	var seg segment
+	err = json.Unmarshal(plaintext, &seg)
+	if err != nil {
+		return err
+	}
+	if seg.Version != segmentVersion {
+		return errors.Errorf("unsupported version %d", seg.Version)
+	}
+
+	idx.loaded += len(seg.Trees)
+	for _, t := range seg.Trees {
+		idx.trees[t.ID] = &restic.Tree{Nodes: t.Nodes}
+	}
+	for _, sn := range seg.Snapshots {
+		idx.snapshots[sn.ID] = sn.Tree
+	}
+	return nil
+}
+
+// removeIncomplete removes all trees for which a subtree is missing, e.g.
+// because a segment was damaged, and the snapshots referencing them. Such
+// snapshots are indexed again when they are added the next time.
+func (idx *Index) removeIncomplete() {
+	complete := make(map[restic.ID]bool, len(idx.trees))
+
+	var check func(id restic.ID) bool
+	check = func(id restic.ID) bool {
+		if ok, seen := complete[id]; seen {
+			return ok
+		}
+
+		tree, ok := idx.trees[id]
+		if ok {
+			for _, node := range tree.Nodes {
+				if node.Type == "dir" && node.Subtree != nil && !check(*node.Subtree) {
+					ok = false
+				}
+			}
+		}
+		complete[id] = ok
+		return ok
+	}
+
+	for id := range idx.trees {
+		if !check(id) {
+			delete(idx.trees, id)
+		}
+	}
+
+	for sn, tree := range idx.snapshots {
+		if !complete[tree] {
+			debug.Log("snapshot %v is incomplete", sn.Str())
+			delete(idx.snapshots, sn)
+		}
+	}
+}
+
+// Has returns true if the snapshot with the given ID is contained in the
+// index.
+func (idx *Index) Has(id restic.ID) bool {
+	_, ok := idx.snapshots[id]
+	return ok
+}
+
+// Len returns the number of snapshots in the index.
+func (idx *Index) Len() int {
+	return len(idx.snapshots)
+}
+
+// Add adds the snapshot sn to the index. All trees which are not yet in the
+// index are loaded from repo.
+func (idx *Index) Add(ctx context.Context, repo restic.TreeLoader, sn *restic.Snapshot) error {
+	if sn.Tree == nil {
+		return errors.Errorf("snapshot %v has no tree", sn.ID().Str())
+	}
+	if idx.Has(*sn.ID()) {
+		return nil
+	}
+
+	err := idx.addTree(ctx, repo, *sn.Tree)
+	if err != nil {
+		return err
+	}
+
+	idx.snapshots[*sn.ID()] = *sn.Tree
+	idx.newSnapshots.Insert(*sn.ID())
+	return nil
+}
+
+// addTree loads the tree id and all its subtrees which are not yet in the
+// index from repo. A tree is only added after all its subtrees, so all trees
+// in the index are always complete.
+func (idx *Index) addTree(ctx context.Context, repo restic.TreeLoader, id restic.ID) error {
+	if _, ok := idx.trees[id]; ok {
+		return nil
+	}
+
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	tree, err := repo.LoadTree(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	nodes := make([]*restic.Node, 0, len(tree.Nodes))
+	for _, node := range tree.Nodes {
+		if node.Type == "dir" && node.Subtree != nil {
+			err = idx.addTree(ctx, repo, *node.Subtree)
+			if err != nil {
+				return err
+			}
+		}
+
+		// the content is not needed to search for files and would make the
+		// index much larger
+		n := *node
+		n.Content = nil
+		nodes = append(nodes, &n)
+	}
+
+	idx.trees[id] = &restic.Tree{Nodes: nodes}
+	idx.newTrees.Insert(id)
+	return nil
+}
+
+// LoadTree returns the tree with the given ID from the index.
+func (idx *Index) LoadTree(ctx context.Context, id restic.ID) (*restic.Tree, error) {
+	tree, ok := idx.trees[id]
+	if !ok {
+		return nil, errors.Errorf("tree %v not found in search index", id.Str())
+	}
+	return tree, nil
+}
+
+// referencedTrees returns the IDs of all trees referenced by the snapshots in
+// the index.
+func (idx *Index) referencedTrees() restic.IDSet {
+	referenced := restic.NewIDSet()
+
+	var walk func(id restic.ID)
+	walk = func(id restic.ID) {
+		if referenced.Has(id) {
+			return
+		}
+		referenced.Insert(id)
+
+		for _, node := range idx.trees[id].Nodes {
+			if node.Type == "dir" && node.Subtree != nil {
+				walk(*node.Subtree)
+			}
+		}
+	}
+
+	for _, tree := range idx.snapshots {
+		walk(tree)
+	}
+	return referenced
+}
+
+// Save writes the snapshots added since the index was opened to a new
+// segment. Snapshots which are not contained in current are removed from the
+// index. If there are too many segments or unreferenced trees, all segments
+// are combined into one.
+func (idx *Index) Save(current restic.IDSet) error {
+	for sn := range idx.snapshots {
+		if !current.Has(sn) {
+			delete(idx.snapshots, sn)
+			idx.newSnapshots.Delete(sn)
+		}
+	}
+
+	referenced := idx.referencedTrees()
+	for id := range idx.trees {
+		if !referenced.Has(id) {
+			delete(idx.trees, id)
+			idx.newTrees.Delete(id)
+		}
+	}
+
+	// idx.loaded also counts trees stored in several segments, which are
+	// wasted space as well
+	unused := idx.loaded - (len(idx.trees) - len(idx.newTrees))
+
+	if len(idx.segments) >= maxSegments || float64(unused) > maxUnused*float64(idx.loaded) {
+		return idx.compact()
+	}
+
+	if len(idx.newSnapshots) == 0 {
+		return nil
+	}
+
+	seg := segment{Version: segmentVersion}
+	for sn := range idx.newSnapshots {
+		seg.Snapshots = append(seg.Snapshots, snapshotEntry{ID: sn, Tree: idx.snapshots[sn]})
+	}
+	for id := range idx.newTrees {
+		seg.Trees = append(seg.Trees, treeEntry{ID: id, Nodes: idx.trees[id].Nodes})
+	}
+
+	id, err := idx.writeSegment(&seg)
+	if err != nil {
+		return err
+	}
+
+	idx.segments.Insert(id)
+	idx.newSnapshots = restic.NewIDSet()
+	idx.newTrees = restic.NewIDSet()
+	return nil
+}
+
+// compact writes all snapshots and trees to a single new segment and removes
+// all other segments.
+func (idx *Index) compact() error {
+	debug.Log("combining %d segments", len(idx.segments))
+
+	seg := segment{Version: segmentVersion}
+	for sn, tree := range idx.snapshots {
+		seg.Snapshots = append(seg.Snapshots, snapshotEntry{ID: sn, Tree: tree})
+	}
+	for id, tree := range idx.trees {
+		seg.Trees = append(seg.Trees, treeEntry{ID: id, Nodes: tree.Nodes})
+	}
+
+	id, err := idx.writeSegment(&seg)
+	if err != nil {
+		return err
+	}
+
+	for old := range idx.segments {
+		if old.Equal(id) {
+			continue
+		}
+		err = fs.Remove(filepath.Join(idx.dir, old.String()))
+		if err != nil && !os.IsNotExist(errors.Cause(err)) {
+			return err
+		}
+	}
+
+	idx.segments = restic.NewIDSet(id)
+	idx.loaded = len(idx.trees)
+	idx.newSnapshots = restic.NewIDSet()
+	idx.newTrees = restic.NewIDSet()
+	return nil
+}
+
+// writeSegment encrypts seg and saves it in a new file, which is named after
+// the hash of its content.
+func (idx *Index) writeSegment(seg *segment) (restic.ID, error) {
+	plaintext, err := json.Marshal(seg)
+	if err != nil {
+		return restic.ID{}, errors.Wrap(err, "Marshal")
+	}
+
+	nonce := crypto.NewRandomNonce()
+	ciphertext := make([]byte, 0, len(nonce)+len(plaintext)+idx.key.Overhead())
+	ciphertext = append(ciphertext, nonce...)
+	ciphertext = idx.key.Seal(ciphertext, nonce, plaintext, nil)
+
+	id := restic.Hash(ciphertext)
+
+	// write to a temporary file first so that readers never see a partial
+	// segment
+	f, err := ioutil.TempFile(idx.dir, "tmp-")
+	if err != nil {
+		return restic.ID{}, errors.Wrap(err, "TempFile")
+	}
+
+	_, err = f.Write(ciphertext)
+	if err != nil {
+		_ = f.Close()
+		_ = fs.Remove(f.Name())
+		return restic.ID{}, errors.Wrap(err, "Write")
+	}
+
+	if err = f.Close(); err != nil {
+		_ = fs.Remove(f.Name())
+		return restic.ID{}, errors.Wrap(err, "Close")
+	}
+
+	err = os.Rename(f.Name(), filepath.Join(idx.dir, id.String()))
+	if err != nil {
+		_ = fs.Remove(f.Name())
+		return restic.ID{}, errors.Wrap(err, "Rename")
+	}
+
+	debug.Log("saved segment %v with %d snapshots and %d trees", id.Str(), len(seg.Snapshots), len(seg.Trees))
+	return id, nil
+}
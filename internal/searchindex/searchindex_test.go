@@ -0,0 +1,162 @@
+package searchindex
+
+import (
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/restic/restic/internal/crypto"
+	"github.com/restic/restic/internal/repository"
+	"github.com/restic/restic/internal/restic"
+	rtest "github.com/restic/restic/internal/test"
+)
+
+// countingLoader counts the trees loaded from the repository.
+type countingLoader struct {
+	restic.TreeLoader
+	loaded int
+}
+
+func (l *countingLoader) LoadTree(ctx context.Context, id restic.ID) (*restic.Tree, error) {
+	l.loaded++
+	return l.TreeLoader.LoadTree(ctx, id)
+}
+
+func countTrees(t testing.TB, loader restic.TreeLoader, id restic.ID) int {
+	tree, err := loader.LoadTree(context.TODO(), id)
+	rtest.OK(t, err)
+
+	n := 1
+	for _, node := range tree.Nodes {
+		if node.Type == "dir" {
+			n += countTrees(t, loader, *node.Subtree)
+		}
+	}
+	return n
+}
+
+func segments(t testing.TB, dir string) []string {
+	names, err := filepath.Glob(filepath.Join(dir, "*"))
+	rtest.OK(t, err)
+	return names
+}
+
+func TestIndex(t *testing.T) {
+	repo, cleanup := repository.TestRepository(t)
+	defer cleanup()
+
+	dir, cleanup := rtest.TempDir(t)
+	defer cleanup()
+
+	ctx := context.TODO()
+	key := crypto.NewRandomKey()
+	sn1 := restic.TestCreateSnapshot(t, repo, time.Unix(1500000000, 0), 3, 0)
+	sn2 := restic.TestCreateSnapshot(t, repo, time.Unix(1600000000, 0), 3, 0)
+
+	idx, err := Open(dir, key)
+	rtest.OK(t, err)
+	rtest.Equals(t, 0, idx.Len())
+
+	loader := &countingLoader{TreeLoader: repo}
+	rtest.OK(t, idx.Add(ctx, loader, sn1))
+	rtest.Equals(t, countTrees(t, repo, *sn1.Tree), loader.loaded)
+
+	// adding a snapshot twice must not load any trees
+	loader.loaded = 0
+	rtest.OK(t, idx.Add(ctx, loader, sn1))
+	rtest.Equals(t, 0, loader.loaded)
+
+	rtest.OK(t, idx.Save(restic.NewIDSet(*sn1.ID(), *sn2.ID())))
+	rtest.Equals(t, 1, len(segments(t, dir)))
+
+	// reopen the index and add the second snapshot
+	idx, err = Open(dir, key)
+	rtest.OK(t, err)
+	rtest.Assert(t, idx.Has(*sn1.ID()), "snapshot %v missing in index", sn1.ID().Str())
+	rtest.Assert(t, !idx.Has(*sn2.ID()), "snapshot %v unexpectedly in index", sn2.ID().Str())
+
+	rtest.OK(t, idx.Add(ctx, loader, sn1))
+	rtest.Equals(t, 0, loader.loaded)
+	rtest.OK(t, idx.Add(ctx, loader, sn2))
+	rtest.Equals(t, countTrees(t, repo, *sn2.Tree), loader.loaded)
+
+	rtest.OK(t, idx.Save(restic.NewIDSet(*sn1.ID(), *sn2.ID())))
+	rtest.Equals(t, 2, len(segments(t, dir)))
+
+	idx, err = Open(dir, key)
+	rtest.OK(t, err)
+	rtest.Equals(t, 2, idx.Len())
+
+	// the trees in the index match the trees in the repository except for
+	// the content of files
+	for _, sn := range []*restic.Snapshot{sn1, sn2} {
+		rtest.Equals(t, countTrees(t, repo, *sn.Tree), countTrees(t, idx, *sn.Tree))
+
+		want, err := repo.LoadTree(ctx, *sn.Tree)
+		rtest.OK(t, err)
+		tree, err := idx.LoadTree(ctx, *sn.Tree)
+		rtest.OK(t, err)
+
+		rtest.Equals(t, len(want.Nodes), len(tree.Nodes))
+		for i, node := range tree.Nodes {
+			rtest.Assert(t, node.Content == nil, "node %v has content", node.Name)
+			node.Content = want.Nodes[i].Content
+			rtest.Assert(t, node.Equals(*want.Nodes[i]), "node %v differs", node.Name)
+		}
+	}
+
+	// removing a snapshot combines the segments
+	rtest.OK(t, idx.Save(restic.NewIDSet(*sn2.ID())))
+	rtest.Equals(t, 1, len(segments(t, dir)))
+
+	idx, err = Open(dir, key)
+	rtest.OK(t, err)
+	rtest.Equals(t, 1, idx.Len())
+	rtest.Assert(t, idx.Has(*sn2.ID()), "snapshot %v missing in index", sn2.ID().Str())
+
+	_, err = idx.LoadTree(ctx, *sn1.Tree)
+	rtest.Assert(t, err != nil, "tree of removed snapshot still in index")
+}
+
+func TestIndexInvalidSegment(t *testing.T) {
+	repo, cleanup := repository.TestRepository(t)
+	defer cleanup()
+
+	dir, cleanup := rtest.TempDir(t)
+	defer cleanup()
+
+	ctx := context.TODO()
+	sn := restic.TestCreateSnapshot(t, repo, time.Unix(1500000000, 0), 2, 0)
+
+	idx, err := Open(dir, crypto.NewRandomKey())
+	rtest.OK(t, err)
+	rtest.OK(t, idx.Add(ctx, repo, sn))
+	rtest.OK(t, idx.Save(restic.NewIDSet(*sn.ID())))
+
+	// segments which cannot be decrypted are removed
+	idx, err = Open(dir, crypto.NewRandomKey())
+	rtest.OK(t, err)
+	rtest.Equals(t, 0, idx.Len())
+	rtest.Equals(t, 0, len(segments(t, dir)))
+
+	// as are damaged segments
+	key := crypto.NewRandomKey()
+	idx, err = Open(dir, key)
+	rtest.OK(t, err)
+	rtest.OK(t, idx.Add(ctx, repo, sn))
+	rtest.OK(t, idx.Save(restic.NewIDSet(*sn.ID())))
+
+	names := segments(t, dir)
+	rtest.Equals(t, 1, len(names))
+	buf, err := ioutil.ReadFile(names[0])
+	rtest.OK(t, err)
+	buf[len(buf)/2] ^= 0xff
+	rtest.OK(t, ioutil.WriteFile(names[0], buf, 0600))
+
+	idx, err = Open(dir, key)
+	rtest.OK(t, err)
+	rtest.Equals(t, 0, idx.Len())
+	rtest.Equals(t, 0, len(segments(t, dir)))
+}
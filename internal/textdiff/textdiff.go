@@ -0,0 +1,316 @@
+// Package textdiff computes line-based differences between two texts and
+// formats them as unified diffs.
+package textdiff
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// NoNewline is appended as a separate line to a hunk after a line which is
+// not terminated by a newline character.
+const NoNewline = `\ No newline at end of file`
+
+// Hunk is a group of changed lines together with the unchanged lines around
+// them. Each line starts with ' ' (unchanged), '-' (removed) or '+' (added),
+// or is NoNewline. Line numbers start at one.
+type Hunk struct {
+	OldStart, OldLines int
+	NewStart, NewLines int
+	Lines              []string
+}
+
+// Header returns the line which introduces the hunk in a unified diff.
+func (h Hunk) Header() string {
+	return fmt.Sprintf("@@ -%s +%s @@", formatRange(h.OldStart, h.OldLines), formatRange(h.NewStart, h.NewLines))
+}
+
+func formatRange(start, lines int) string {
+	switch lines {
+	case 0:
+		// an empty range refers to the line before it
+		return fmt.Sprintf("%d,0", start-1)
+	case 1:
+		return fmt.Sprintf("%d", start)
+	}
+	return fmt.Sprintf("%d,%d", start, lines)
+}
+
+// String returns the hunk in unified diff format.
+func (h Hunk) String() string {
+	var buf strings.Builder
+	buf.WriteString(h.Header())
+	buf.WriteByte('\n')
+	for _, line := range h.Lines {
+		buf.WriteString(line)
+		buf.WriteByte('\n')
+	}
+	return buf.String()
+}
+
+// splitLines returns the lines of data, each including the trailing newline
+// character if there is one.
+func splitLines(data []byte) []string {
+	var lines []string
+	for len(data) > 0 {
+		i := bytes.IndexByte(data, '\n')
+		if i < 0 {
+			lines = append(lines, string(data))
+			break
+		}
+		lines = append(lines, string(data[:i+1]))
+		data = data[i+1:]
+	}
+	return lines
+}
+
+// Unified returns the hunks needed to change old into new. Each hunk contains
+// up to context unchanged lines before and after the changes, hunks which
+// would overlap are combined.
+func Unified(old, new []byte, context int) []Hunk {
+	a, b := splitLines(old), splitLines(new)
+
+	// compare integers instead of strings
+	ids := make(map[string]int)
+	toIDs := func(lines []string) []int {
+		res := make([]int, len(lines))
+		for i, line := range lines {
+			id, ok := ids[line]
+			if !ok {
+				id = len(ids)
+				ids[line] = id
+			}
+			res[i] = id
+		}
+		return res
+	}
+
+	d := &differ{
+		a:       toIDs(a),
+		b:       toIDs(b),
+		removed: make([]bool, len(a)),
+		added:   make([]bool, len(b)),
+	}
+	d.compare(0, len(a), 0, len(b))
+
+	// build the list of operations, removed lines come before added lines
+	type op struct {
+		kind byte
+		i, j int // index of the line in a and b
+	}
+	var ops []op
+	for i, j := 0, 0; i < len(a) || j < len(b); {
+		switch {
+		case i < len(a) && d.removed[i]:
+			ops = append(ops, op{'-', i, j})
+			i++
+		case j < len(b) && d.added[j]:
+			ops = append(ops, op{'+', i, j})
+			j++
+		default:
+			ops = append(ops, op{' ', i, j})
+			i++
+			j++
+		}
+	}
+
+	line := func(o op) []string {
+		var s string
+		if o.kind == '+' {
+			s = b[o.j]
+		} else {
+			s = a[o.i]
+		}
+		if strings.HasSuffix(s, "\n") {
+			return []string{string(o.kind) + s[:len(s)-1]}
+		}
+		return []string{string(o.kind) + s, NoNewline}
+	}
+
+	var hunks []Hunk
+	for start := 0; start < len(ops); {
+		// find the next change
+		for start < len(ops) && ops[start].kind == ' ' {
+			start++
+		}
+		if start == len(ops) {
+			break
+		}
+
+		// extend the hunk until more than 2*context unchanged lines follow
+		end := start
+		for end < len(ops) {
+			if ops[end].kind != ' ' {
+				end++
+				continue
+			}
+			n := end
+			for n < len(ops) && ops[n].kind == ' ' {
+				n++
+			}
+			if n == len(ops) || n-end > 2*context {
+				break
+			}
+			end = n
+		}
+
+		first := start - context
+		if first < 0 {
+			first = 0
+		}
+		last := end + context
+		if last > len(ops) {
+			last = len(ops)
+		}
+
+		h := Hunk{
+			OldStart: ops[first].i + 1,
+			NewStart: ops[first].j + 1,
+		}
+		for _, o := range ops[first:last] {
+			if o.kind != '+' {
+				h.OldLines++
+			}
+			if o.kind != '-' {
+				h.NewLines++
+			}
+			h.Lines = append(h.Lines, line(o)...)
+		}
+		hunks = append(hunks, h)
+
+		start = last
+	}
+
+	return hunks
+}
+
+// differ implements the linear space variant of the algorithm described in
+// "An O(ND) Difference Algorithm and Its Variations" by Eugene W. Myers.
+type differ struct {
+	a, b           []int
+	removed, added []bool
+}
+
+// compare marks the lines in a[aLo:aHi] and b[bLo:bHi] which are not part of
+// the longest common subsequence.
+func (d *differ) compare(aLo, aHi, bLo, bHi int) {
+	for aLo < aHi && bLo < bHi && d.a[aLo] == d.b[bLo] {
+		aLo++
+		bLo++
+	}
+	for aLo < aHi && bLo < bHi && d.a[aHi-1] == d.b[bHi-1] {
+		aHi--
+		bHi--
+	}
+
+	switch {
+	case aLo == aHi:
+		for j := bLo; j < bHi; j++ {
+			d.added[j] = true
+		}
+	case bLo == bHi:
+		for i := aLo; i < aHi; i++ {
+			d.removed[i] = true
+		}
+	default:
+		x, y, ok := d.split(aLo, aHi, bLo, bHi)
+		if !ok {
+			for i := aLo; i < aHi; i++ {
+				d.removed[i] = true
+			}
+			for j := bLo; j < bHi; j++ {
+				d.added[j] = true
+			}
+			return
+		}
+		d.compare(aLo, x, bLo, y)
+		d.compare(x, aHi, y, bHi)
+	}
+}
+
+// split finds the middle snake of an optimal path through the edit graph of
+// a[aLo:aHi] and b[bLo:bHi] by searching from both ends at the same time,
+// and returns the point at which the problem is divided.
+func (d *differ) split(aLo, aHi, bLo, bHi int) (x, y int, ok bool) {
+	n, m := aHi-aLo, bHi-bLo
+	maxD := (n + m + 1) / 2
+	offset := maxD
+	vf := make([]int, 2*maxD+2)
+	vb := make([]int, 2*maxD+2)
+	for i := range vf {
+		vf[i] = -1
+		vb[i] = -1
+	}
+	vf[offset+1] = 0
+	vb[offset+1] = 0
+
+	delta := n - m
+	// if delta is odd, the paths overlap during the forward search
+	front := delta%2 != 0
+
+	var kfStart, kfEnd, kbStart, kbEnd int
+	for k := 0; k < maxD; k++ {
+		// walk the forward path one step
+		for kf := -k + kfStart; kf <= k-kfEnd; kf += 2 {
+			var x1 int
+			if kf == -k || (kf != k && vf[offset+kf-1] < vf[offset+kf+1]) {
+				x1 = vf[offset+kf+1]
+			} else {
+				x1 = vf[offset+kf-1] + 1
+			}
+			y1 := x1 - kf
+			for x1 < n && y1 < m && d.a[aLo+x1] == d.b[bLo+y1] {
+				x1++
+				y1++
+			}
+			vf[offset+kf] = x1
+
+			switch {
+			case x1 > n:
+				kfEnd += 2
+			case y1 > m:
+				kfStart += 2
+			case front:
+				kb := offset + delta - kf
+				if kb >= 0 && kb < len(vb) && vb[kb] != -1 && x1 >= n-vb[kb] {
+					return aLo + x1, bLo + y1, true
+				}
+			}
+		}
+
+		// walk the backward path one step
+		for kb := -k + kbStart; kb <= k-kbEnd; kb += 2 {
+			var x2 int
+			if kb == -k || (kb != k && vb[offset+kb-1] < vb[offset+kb+1]) {
+				x2 = vb[offset+kb+1]
+			} else {
+				x2 = vb[offset+kb-1] + 1
+			}
+			y2 := x2 - kb
+			for x2 < n && y2 < m && d.a[aHi-x2-1] == d.b[bHi-y2-1] {
+				x2++
+				y2++
+			}
+			vb[offset+kb] = x2
+
+			switch {
+			case x2 > n:
+				kbEnd += 2
+			case y2 > m:
+				kbStart += 2
+			case !front:
+				kf := offset + delta - kb
+				if kf >= 0 && kf < len(vf) && vf[kf] != -1 {
+					x1 := vf[kf]
+					y1 := offset + x1 - kf
+					if x1 >= n-x2 {
+						return aLo + x1, bLo + y1, true
+					}
+				}
+			}
+		}
+	}
+
+	return 0, 0, false
+}
@@ -0,0 +1,148 @@
+package textdiff_test
+
+import (
+	"math/rand"
+	"strings"
+	"testing"
+
+	rtest "github.com/restic/restic/internal/test"
+	"github.com/restic/restic/internal/textdiff"
+)
+
+func format(hunks []textdiff.Hunk) string {
+	var buf strings.Builder
+	for _, h := range hunks {
+		buf.WriteString(h.String())
+	}
+	return buf.String()
+}
+
+func TestUnified(t *testing.T) {
+	var tests = []struct {
+		old, new string
+		context  int
+		patch    string
+	}{
+		{"", "", 3, ""},
+		{"a\nb\n", "a\nb\n", 3, ""},
+		{"", "a\nb\n", 3, "@@ -0,0 +1,2 @@\n+a\n+b\n"},
+		{"a\nb\n", "", 3, "@@ -1,2 +0,0 @@\n-a\n-b\n"},
+		{"a\nb\nc\n", "a\nx\nc\n", 3, "@@ -1,3 +1,3 @@\n a\n-b\n+x\n c\n"},
+		{"a\nb\nc\n", "a\nx\nc\n", 0, "@@ -2 +2 @@\n-b\n+x\n"},
+		{"a\nb\nc\n", "a\nc\n", 0, "@@ -2 +1,0 @@\n-b\n"},
+		{"a\nc\n", "a\nb\nc\n", 0, "@@ -1,0 +2 @@\n+b\n"},
+		{"a\nb", "a\nc", 3, "@@ -1,2 +1,2 @@\n a\n-b\n\\ No newline at end of file\n+c\n\\ No newline at end of file\n"},
+		{"a\nb", "a\nb\n", 3, "@@ -1,2 +1,2 @@\n a\n-b\n\\ No newline at end of file\n+b\n"},
+		{
+			"1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n",
+			"1\nx\n3\n4\n5\n6\n7\n8\ny\n10\n",
+			1,
+			"@@ -1,3 +1,3 @@\n 1\n-2\n+x\n 3\n@@ -8,3 +8,3 @@\n 8\n-9\n+y\n 10\n",
+		},
+		{
+			"1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n",
+			"1\nx\n3\n4\n5\n6\n7\n8\ny\n10\n",
+			3,
+			"@@ -1,10 +1,10 @@\n 1\n-2\n+x\n 3\n 4\n 5\n 6\n 7\n 8\n-9\n+y\n 10\n",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run("", func(t *testing.T) {
+			hunks := textdiff.Unified([]byte(test.old), []byte(test.new), test.context)
+			rtest.Equals(t, test.patch, format(hunks))
+		})
+	}
+}
+
+// apply applies hunks created with unlimited context to old.
+func apply(t testing.TB, hunks []textdiff.Hunk) string {
+	var lines []string
+	removed := false
+	for _, h := range hunks {
+		for _, line := range h.Lines {
+			switch {
+			case line == textdiff.NoNewline:
+				if !removed {
+					last := len(lines) - 1
+					lines[last] = strings.TrimSuffix(lines[last], "\n")
+				}
+			case line[0] == ' ', line[0] == '+':
+				lines = append(lines, line[1:]+"\n")
+				removed = false
+			case line[0] == '-':
+				removed = true
+			default:
+				t.Fatalf("invalid line %q", line)
+			}
+		}
+	}
+	return strings.Join(lines, "")
+}
+
+// lcs returns the length of the longest common subsequence of a and b.
+func lcs(a, b []string) int {
+	prev := make([]int, len(b)+1)
+	for i := range a {
+		cur := make([]int, len(b)+1)
+		for j := range b {
+			switch {
+			case a[i] == b[j]:
+				cur[j+1] = prev[j] + 1
+			case prev[j+1] > cur[j]:
+				cur[j+1] = prev[j+1]
+			default:
+				cur[j+1] = cur[j]
+			}
+		}
+		prev = cur
+	}
+	return prev[len(b)]
+}
+
+func randomLines(rnd *rand.Rand, n int) []string {
+	lines := make([]string, n)
+	for i := range lines {
+		lines[i] = string(rune('a' + rnd.Intn(4)))
+	}
+	return lines
+}
+
+func TestUnifiedRandom(t *testing.T) {
+	rnd := rand.New(rand.NewSource(42))
+
+	for i := 0; i < 500; i++ {
+		a := randomLines(rnd, rnd.Intn(30))
+		b := randomLines(rnd, rnd.Intn(30))
+		old := strings.Join(a, "\n")
+		new := strings.Join(b, "\n")
+
+		hunks := textdiff.Unified([]byte(old), []byte(new), len(a)+len(b))
+		if old == new {
+			rtest.Equals(t, 0, len(hunks))
+			continue
+		}
+		rtest.Equals(t, 1, len(hunks))
+
+		// the patch must transform old into new
+		rtest.Equals(t, new, apply(t, hunks))
+
+		// the number of changed lines must be minimal
+		changes := 0
+		for _, line := range hunks[0].Lines {
+			if line[0] == '-' || line[0] == '+' {
+				changes++
+			}
+		}
+		// compare the lines including the newline
+		oldLines := strings.SplitAfter(old, "\n")
+		newLines := strings.SplitAfter(new, "\n")
+		if old == "" {
+			oldLines = nil
+		}
+		if new == "" {
+			newLines = nil
+		}
+		rtest.Equals(t, len(oldLines)+len(newLines)-2*lcs(oldLines, newLines), changes)
+	}
+}
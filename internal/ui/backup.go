@@ -33,6 +33,7 @@ type Backup struct {
 	term  *termstatus.Terminal
 	v     uint
 	start time.Time
+	dry   bool
 
 	totalBytes uint64
 
@@ -40,6 +41,7 @@ type Backup struct {
 	processedCh chan counter
 	errCh       chan struct{}
 	workerCh    chan fileWorkerMessage
+	uploadedCh  chan uint64
 	finished    chan struct{}
 	closed      chan struct{}
 
@@ -71,6 +73,7 @@ func NewBackup(term *termstatus.Terminal, verbosity uint) *Backup {
 		processedCh: make(chan counter),
 		errCh:       make(chan struct{}),
 		workerCh:    make(chan fileWorkerMessage),
+		uploadedCh:  make(chan uint64),
 		finished:    make(chan struct{}),
 		closed:      make(chan struct{}),
 	}
@@ -83,6 +86,7 @@ func (b *Backup) Run(ctx context.Context) error {
 		lastUpdate       time.Time
 		total, processed counter
 		errors           uint
+		uploaded         uint64
 		started          bool
 		currentFiles     = make(map[string]struct{})
 		secondsRemaining uint64
@@ -118,6 +122,8 @@ func (b *Backup) Run(ctx context.Context) error {
 		case <-b.errCh:
 			errors++
 			started = true
+		case n := <-b.uploadedCh:
+			uploaded += n
 		case m := <-b.workerCh:
 			if m.done {
 				delete(currentFiles, m.filename)
@@ -142,18 +148,26 @@ func (b *Backup) Run(ctx context.Context) error {
 		}
 		lastUpdate = time.Now()
 
-		b.update(total, processed, errors, currentFiles, secondsRemaining)
+		b.update(total, processed, errors, uploaded, currentFiles, secondsRemaining)
 	}
 }
 
 // update updates the status lines.
-func (b *Backup) update(total, processed counter, errors uint, currentFiles map[string]struct{}, secs uint64) {
+func (b *Backup) update(total, processed counter, errors uint, uploaded uint64, currentFiles map[string]struct{}, secs uint64) {
+	elapsed := time.Since(b.start)
+
+	var upload string
+	if uploaded > 0 && elapsed >= time.Second {
+		upload = fmt.Sprintf(", uploaded %s at %s/s", formatBytes(uploaded),
+			formatBytes(uint64(float64(uploaded)/elapsed.Seconds())))
+	}
+
 	var status string
 	if total.Files == 0 && total.Dirs == 0 {
 		// no total count available yet
-		status = fmt.Sprintf("[%s] %v files, %s, %d errors",
-			formatDuration(time.Since(b.start)),
-			processed.Files, formatBytes(processed.Bytes), errors,
+		status = fmt.Sprintf("[%s] %v files, %s, %d errors%s",
+			formatDuration(elapsed),
+			processed.Files, formatBytes(processed.Bytes), errors, upload,
 		)
 	} else {
 		var eta, percent string
@@ -165,14 +179,15 @@ func (b *Backup) update(total, processed counter, errors uint, currentFiles map[
 		}
 
 		// include totals
-		status = fmt.Sprintf("[%s] %s%v files %s, total %v files %v, %d errors%s",
-			formatDuration(time.Since(b.start)),
+		status = fmt.Sprintf("[%s] %s%v files %s, total %v files %v, %d errors%s%s",
+			formatDuration(elapsed),
 			percent,
 			processed.Files,
 			formatBytes(processed.Bytes),
 			total.Files,
 			formatBytes(total.Bytes),
 			errors,
+			upload,
 			eta,
 		)
 	}
@@ -212,6 +227,14 @@ func (b *Backup) StartFile(filename string) {
 	}
 }
 
+// CompletePack is called for each pack file uploaded to the repository.
+func (b *Backup) CompletePack(bytes uint64) {
+	select {
+	case b.uploadedCh <- bytes:
+	case <-b.closed:
+	}
+}
+
 // CompleteBlob is called for all saved blobs for files.
 func (b *Backup) CompleteBlob(filename string, bytes uint64) {
 	select {
@@ -334,7 +357,11 @@ func (b *Backup) CompleteItem(item string, previous, current *restic.Node, s arc
 		}
 
 		if previous == nil {
-			b.VV("new       %v, saved in %.3fs (%v added)", item, d.Seconds(), formatBytes(s.DataSize))
+			if b.dry {
+				b.P("new       %v, %v would be added", item, formatBytes(s.DataSize))
+			} else {
+				b.VV("new       %v, saved in %.3fs (%v added)", item, d.Seconds(), formatBytes(s.DataSize))
+			}
 			b.summary.Lock()
 			b.summary.Files.New++
 			b.summary.Unlock()
@@ -347,7 +374,11 @@ func (b *Backup) CompleteItem(item string, previous, current *restic.Node, s arc
 			b.summary.Files.Unchanged++
 			b.summary.Unlock()
 		} else {
-			b.VV("modified  %v, saved in %.3fs (%v added)", item, d.Seconds(), formatBytes(s.DataSize))
+			if b.dry {
+				b.P("modified  %v, %v would be added", item, formatBytes(s.DataSize))
+			} else {
+				b.VV("modified  %v, saved in %.3fs (%v added)", item, d.Seconds(), formatBytes(s.DataSize))
+			}
 			b.summary.Lock()
 			b.summary.Files.Changed++
 			b.summary.Unlock()
@@ -384,7 +415,11 @@ func (b *Backup) Finish(snapshotID restic.ID) {
 	b.P("Dirs:        %5d new, %5d changed, %5d unmodified\n", b.summary.Dirs.New, b.summary.Dirs.Changed, b.summary.Dirs.Unchanged)
 	b.V("Data Blobs:  %5d new\n", b.summary.ItemStats.DataBlobs)
 	b.V("Tree Blobs:  %5d new\n", b.summary.ItemStats.TreeBlobs)
-	b.P("Added to the repo: %-5s\n", formatBytes(b.summary.ItemStats.DataSize+b.summary.ItemStats.TreeSize))
+	if b.dry {
+		b.P("Would add to the repo: %-5s\n", formatBytes(b.summary.ItemStats.DataSize+b.summary.ItemStats.TreeSize))
+	} else {
+		b.P("Added to the repo: %-5s\n", formatBytes(b.summary.ItemStats.DataSize+b.summary.ItemStats.TreeSize))
+	}
 	b.P("\n")
 	b.P("processed %v files, %v in %s",
 		b.summary.Files.New+b.summary.Files.Changed+b.summary.Files.Unchanged,
@@ -398,3 +433,10 @@ func (b *Backup) Finish(snapshotID restic.ID) {
 func (b *Backup) SetMinUpdatePause(d time.Duration) {
 	b.MinUpdatePause = d
 }
+
+// SetDryRun marks the backup as a dry run, new and modified files are printed
+// and the summary reports the data which would be added. It satisfies the
+// ArchiveProgressReporter interface.
+func (b *Backup) SetDryRun() {
+	b.dry = true
+}
@@ -1,9 +1,7 @@
 package json
 
 import (
-	"bytes"
 	"context"
-	"encoding/json"
 	"os"
 	"sort"
 	"sync"
@@ -34,6 +32,7 @@ type Backup struct {
 	term  *termstatus.Terminal
 	v     uint
 	start time.Time
+	dry   bool
 
 	totalBytes uint64
 
@@ -41,6 +40,7 @@ type Backup struct {
 	processedCh chan counter
 	errCh       chan struct{}
 	workerCh    chan fileWorkerMessage
+	uploadedCh  chan uint64
 	finished    chan struct{}
 	closed      chan struct{}
 
@@ -72,23 +72,23 @@ func NewBackup(term *termstatus.Terminal, verbosity uint) *Backup {
 		processedCh: make(chan counter),
 		errCh:       make(chan struct{}),
 		workerCh:    make(chan fileWorkerMessage),
+		uploadedCh:  make(chan uint64),
 		finished:    make(chan struct{}),
 		closed:      make(chan struct{}),
 	}
 }
 
-func toJSONString(status interface{}) string {
-	buf := new(bytes.Buffer)
-	json.NewEncoder(buf).Encode(status)
-	return buf.String()
+func toJSONString(messageType string, status Message) string {
+	buf, _ := marshal(messageType, status)
+	return string(buf)
 }
 
-func (b *Backup) print(status interface{}) {
-	b.term.Print(toJSONString(status))
+func (b *Backup) print(messageType string, status Message) {
+	b.term.Print(toJSONString(messageType, status))
 }
 
-func (b *Backup) error(status interface{}) {
-	b.term.Error(toJSONString(status))
+func (b *Backup) error(messageType string, status Message) {
+	b.term.Error(toJSONString(messageType, status))
 }
 
 // Run regularly updates the status lines. It should be called in a separate
@@ -98,6 +98,7 @@ func (b *Backup) Run(ctx context.Context) error {
 		lastUpdate       time.Time
 		total, processed counter
 		errors           uint
+		uploaded         uint64
 		started          bool
 		currentFiles     = make(map[string]struct{})
 		secondsRemaining uint64
@@ -130,6 +131,8 @@ func (b *Backup) Run(ctx context.Context) error {
 		case <-b.errCh:
 			errors++
 			started = true
+		case n := <-b.uploadedCh:
+			uploaded += n
 		case m := <-b.workerCh:
 			if m.done {
 				delete(currentFiles, m.filename)
@@ -154,14 +157,13 @@ func (b *Backup) Run(ctx context.Context) error {
 		}
 		lastUpdate = time.Now()
 
-		b.update(total, processed, errors, currentFiles, secondsRemaining)
+		b.update(total, processed, errors, uploaded, currentFiles, secondsRemaining)
 	}
 }
 
 // update updates the status lines.
-func (b *Backup) update(total, processed counter, errors uint, currentFiles map[string]struct{}, secs uint64) {
-	status := statusUpdate{
-		MessageType:      "status",
+func (b *Backup) update(total, processed counter, errors uint, uploaded uint64, currentFiles map[string]struct{}, secs uint64) {
+	status := &statusUpdate{
 		SecondsElapsed:   uint64(time.Since(b.start) / time.Second),
 		SecondsRemaining: secs,
 		TotalFiles:       total.Files,
@@ -169,6 +171,7 @@ func (b *Backup) update(total, processed counter, errors uint, currentFiles map[
 		TotalBytes:       total.Bytes,
 		BytesDone:        processed.Bytes,
 		ErrorCount:       errors,
+		BytesUploaded:    uploaded,
 	}
 
 	if total.Bytes > 0 {
@@ -180,28 +183,26 @@ func (b *Backup) update(total, processed counter, errors uint, currentFiles map[
 	}
 	sort.Strings(status.CurrentFiles)
 
-	b.print(status)
+	b.print(TypeStatus, status)
 }
 
 // ScannerError is the error callback function for the scanner, it prints the
 // error in verbose mode and returns nil.
 func (b *Backup) ScannerError(item string, fi os.FileInfo, err error) error {
-	b.error(errorUpdate{
-		MessageType: "error",
-		Error:       err,
-		During:      "scan",
-		Item:        item,
+	b.error(TypeError, &errorUpdate{
+		Error:  err.Error(),
+		During: "scan",
+		Item:   item,
 	})
 	return nil
 }
 
 // Error is the error callback function for the archiver, it prints the error and returns nil.
 func (b *Backup) Error(item string, fi os.FileInfo, err error) error {
-	b.error(errorUpdate{
-		MessageType: "error",
-		Error:       err,
-		During:      "archival",
-		Item:        item,
+	b.error(TypeError, &errorUpdate{
+		Error:  err.Error(),
+		During: "archival",
+		Item:   item,
 	})
 	select {
 	case b.errCh <- struct{}{}:
@@ -218,6 +219,14 @@ func (b *Backup) StartFile(filename string) {
 	}
 }
 
+// CompletePack is called for each pack file uploaded to the repository.
+func (b *Backup) CompletePack(bytes uint64) {
+	select {
+	case b.uploadedCh <- bytes:
+	case <-b.closed:
+	}
+}
+
 // CompleteBlob is called for all saved blobs for files.
 func (b *Backup) CompleteBlob(filename string, bytes uint64) {
 	select {
@@ -264,8 +273,7 @@ func (b *Backup) CompleteItem(item string, previous, current *restic.Node, s arc
 	if current.Type == "dir" {
 		if previous == nil {
 			if b.v >= 3 {
-				b.print(verboseUpdate{
-					MessageType:  "verbose_status",
+				b.print(TypeVerboseStatus, &verboseUpdate{
 					Action:       "new",
 					Item:         item,
 					Duration:     d.Seconds(),
@@ -281,10 +289,9 @@ func (b *Backup) CompleteItem(item string, previous, current *restic.Node, s arc
 
 		if previous.Equals(*current) {
 			if b.v >= 3 {
-				b.print(verboseUpdate{
-					MessageType: "verbose_status",
-					Action:      "unchanged",
-					Item:        item,
+				b.print(TypeVerboseStatus, &verboseUpdate{
+					Action: "unchanged",
+					Item:   item,
 				})
 			}
 			b.summary.Lock()
@@ -292,8 +299,7 @@ func (b *Backup) CompleteItem(item string, previous, current *restic.Node, s arc
 			b.summary.Unlock()
 		} else {
 			if b.v >= 3 {
-				b.print(verboseUpdate{
-					MessageType:  "verbose_status",
+				b.print(TypeVerboseStatus, &verboseUpdate{
 					Action:       "modified",
 					Item:         item,
 					Duration:     d.Seconds(),
@@ -313,13 +319,12 @@ func (b *Backup) CompleteItem(item string, previous, current *restic.Node, s arc
 		}
 
 		if previous == nil {
-			if b.v >= 3 {
-				b.print(verboseUpdate{
-					MessageType: "verbose_status",
-					Action:      "new",
-					Item:        item,
-					Duration:    d.Seconds(),
-					DataSize:    s.DataSize,
+			if b.v >= 3 || b.dry {
+				b.print(TypeVerboseStatus, &verboseUpdate{
+					Action:   "new",
+					Item:     item,
+					Duration: d.Seconds(),
+					DataSize: s.DataSize,
 				})
 			}
 			b.summary.Lock()
@@ -330,23 +335,21 @@ func (b *Backup) CompleteItem(item string, previous, current *restic.Node, s arc
 
 		if previous.Equals(*current) {
 			if b.v >= 3 {
-				b.print(verboseUpdate{
-					MessageType: "verbose_status",
-					Action:      "unchanged",
-					Item:        item,
+				b.print(TypeVerboseStatus, &verboseUpdate{
+					Action: "unchanged",
+					Item:   item,
 				})
 			}
 			b.summary.Lock()
 			b.summary.Files.Unchanged++
 			b.summary.Unlock()
 		} else {
-			if b.v >= 3 {
-				b.print(verboseUpdate{
-					MessageType: "verbose_status",
-					Action:      "modified",
-					Item:        item,
-					Duration:    d.Seconds(),
-					DataSize:    s.DataSize,
+			if b.v >= 3 || b.dry {
+				b.print(TypeVerboseStatus, &verboseUpdate{
+					Action:   "modified",
+					Item:     item,
+					Duration: d.Seconds(),
+					DataSize: s.DataSize,
 				})
 			}
 			b.summary.Lock()
@@ -365,12 +368,11 @@ func (b *Backup) ReportTotal(item string, s archiver.ScanStats) {
 
 	if item == "" {
 		if b.v >= 2 {
-			b.print(verboseUpdate{
-				MessageType: "status",
-				Action:      "scan_finished",
-				Duration:    time.Since(b.start).Seconds(),
-				DataSize:    s.Bytes,
-				TotalFiles:  s.Files,
+			b.print(TypeStatus, &verboseUpdate{
+				Action:     "scan_finished",
+				Duration:   time.Since(b.start).Seconds(),
+				DataSize:   s.Bytes,
+				TotalFiles: s.Files,
 			})
 		}
 		close(b.totalCh)
@@ -385,8 +387,7 @@ func (b *Backup) Finish(snapshotID restic.ID) {
 	case <-b.closed:
 	}
 
-	b.print(summaryOutput{
-		MessageType:         "summary",
+	summary := &summaryOutput{
 		FilesNew:            b.summary.Files.New,
 		FilesChanged:        b.summary.Files.Changed,
 		FilesUnmodified:     b.summary.Files.Unchanged,
@@ -400,7 +401,13 @@ func (b *Backup) Finish(snapshotID restic.ID) {
 		TotalBytesProcessed: b.summary.ProcessedBytes,
 		TotalDuration:       time.Since(b.start).Seconds(),
 		SnapshotID:          snapshotID.Str(),
-	})
+		DryRun:              b.dry,
+	}
+	if b.dry {
+		summary.SnapshotID = ""
+	}
+
+	b.print(TypeSummary, summary)
 }
 
 // SetMinUpdatePause sets b.MinUpdatePause. It satisfies the
@@ -409,8 +416,14 @@ func (b *Backup) SetMinUpdatePause(d time.Duration) {
 	b.MinUpdatePause = d
 }
 
+// SetDryRun marks the backup as a dry run, new and modified files are always
+// reported. It satisfies the ArchiveProgressReporter interface.
+func (b *Backup) SetDryRun() {
+	b.dry = true
+}
+
 type statusUpdate struct {
-	MessageType      string   `json:"message_type"` // "status"
+	Header
 	SecondsElapsed   uint64   `json:"seconds_elapsed,omitempty"`
 	SecondsRemaining uint64   `json:"seconds_remaining,omitempty"`
 	PercentDone      float64  `json:"percent_done"`
@@ -419,18 +432,19 @@ type statusUpdate struct {
 	TotalBytes       uint64   `json:"total_bytes,omitempty"`
 	BytesDone        uint64   `json:"bytes_done,omitempty"`
 	ErrorCount       uint     `json:"error_count,omitempty"`
+	BytesUploaded    uint64   `json:"bytes_uploaded,omitempty"`
 	CurrentFiles     []string `json:"current_files,omitempty"`
 }
 
 type errorUpdate struct {
-	MessageType string `json:"message_type"` // "error"
-	Error       error  `json:"error"`
-	During      string `json:"during"`
-	Item        string `json:"item"`
+	Header
+	Error  string `json:"error"`
+	During string `json:"during"`
+	Item   string `json:"item"`
 }
 
 type verboseUpdate struct {
-	MessageType  string  `json:"message_type"` // "verbose_status"
+	Header
 	Action       string  `json:"action"`
 	Item         string  `json:"item"`
 	Duration     float64 `json:"duration"` // in seconds
@@ -440,7 +454,7 @@ type verboseUpdate struct {
 }
 
 type summaryOutput struct {
-	MessageType         string  `json:"message_type"` // "summary"
+	Header
 	FilesNew            uint    `json:"files_new"`
 	FilesChanged        uint    `json:"files_changed"`
 	FilesUnmodified     uint    `json:"files_unmodified"`
@@ -453,5 +467,6 @@ type summaryOutput struct {
 	TotalFilesProcessed uint    `json:"total_files_processed"`
 	TotalBytesProcessed uint64  `json:"total_bytes_processed"`
 	TotalDuration       float64 `json:"total_duration"` // in seconds
-	SnapshotID          string  `json:"snapshot_id"`
+	SnapshotID          string  `json:"snapshot_id,omitempty"`
+	DryRun              bool    `json:"dry_run,omitempty"`
 }
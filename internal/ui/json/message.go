@@ -0,0 +1,277 @@
+package json
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"time"
+)
+
+// SchemaVersion is the version of the JSON messages printed by restic. It is
+// increased whenever a message is changed in an incompatible way, e.g. when a
+// field is removed or its meaning changes. New fields and new message types
+// may be added without increasing the version.
+const SchemaVersion = 1
+
+// Message types used by all commands.
+const (
+	TypeStatus        = "status"
+	TypeVerboseStatus = "verbose_status"
+	TypeError         = "error"
+	TypeSummary       = "summary"
+)
+
+// TypeChange is the message type of the changes printed by the diff command.
+const TypeChange = "change"
+
+// TypeConflict is the message type of the conflicts printed by the restore
+// command when merging snapshots.
+const TypeConflict = "conflict"
+
+// Header contains the fields common to all messages.
+type Header struct {
+	MessageType   string `json:"message_type"`
+	SchemaVersion int    `json:"schema_version"`
+}
+
+func (h *Header) header() *Header {
+	return h
+}
+
+// Message is implemented by all messages which embed a Header.
+type Message interface {
+	header() *Header
+}
+
+// marshal sets the header of msg and returns the encoded message, terminated
+// by a newline.
+func marshal(messageType string, msg Message) ([]byte, error) {
+	h := msg.header()
+	h.MessageType = messageType
+	h.SchemaVersion = SchemaVersion
+
+	buf := new(bytes.Buffer)
+	err := json.NewEncoder(buf).Encode(msg)
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Print writes msg with the given message type as a single line to w.
+func Print(w io.Writer, messageType string, msg Message) error {
+	buf, err := marshal(messageType, msg)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(buf)
+	return err
+}
+
+// StatusMessage reports the progress of an operation which processes a known
+// number of items.
+type StatusMessage struct {
+	Header
+	Action         string  `json:"action"`
+	SecondsElapsed uint64  `json:"seconds_elapsed"`
+	PercentDone    float64 `json:"percent_done"`
+	Total          uint64  `json:"total"`
+	Done           uint64  `json:"done"`
+}
+
+// ErrorMessage reports an error. During describes the operation which failed
+// and Item the file, snapshot or blob the error is about, if any.
+type ErrorMessage struct {
+	Header
+	Error  string `json:"error"`
+	During string `json:"during,omitempty"`
+	Item   string `json:"item,omitempty"`
+}
+
+// InitSummary is printed by the init command.
+type InitSummary struct {
+	Header
+	RepositoryID string `json:"repository_id"`
+	Repository   string `json:"repository"`
+}
+
+// CheckSummary is printed by the check command. Hints describe problems which
+// are not critical.
+type CheckSummary struct {
+	Header
+	NumErrors     int      `json:"num_errors"`
+	Hints         []string `json:"hints,omitempty"`
+	OrphanedPacks int      `json:"orphaned_packs"`
+	ReadPacks     uint64   `json:"read_packs,omitempty"`
+}
+
+// PruneBlobStats contains the number and size of a set of blobs.
+type PruneBlobStats struct {
+	Blobs uint   `json:"blobs"`
+	Bytes uint64 `json:"bytes"`
+}
+
+// PrunePackStats contains the number of packs which are kept, rewritten and
+// removed.
+type PrunePackStats struct {
+	Used         uint `json:"used"`
+	PartlyUsed   uint `json:"partly_used"`
+	Unused       uint `json:"unused"`
+	Keep         uint `json:"keep"`
+	Repack       int  `json:"repack"`
+	Remove       int  `json:"remove"`
+	Unreferenced int  `json:"unreferenced"`
+}
+
+// PruneSummary is printed by the prune command.
+type PruneSummary struct {
+	Header
+	DryRun            bool           `json:"dry_run,omitempty"`
+	Used              PruneBlobStats `json:"used"`
+	Duplicate         PruneBlobStats `json:"duplicate"`
+	Unused            PruneBlobStats `json:"unused"`
+	UnreferencedBytes uint64         `json:"unreferenced_bytes"`
+	Repack            PruneBlobStats `json:"repack"`
+	RepackRemove      PruneBlobStats `json:"repack_remove"`
+	Remove            PruneBlobStats `json:"remove"`
+	Remaining         PruneBlobStats `json:"remaining"`
+	Packs             PrunePackStats `json:"packs"`
+}
+
+// RestoreSummary is printed by the restore command. When several snapshots
+// are merged, their IDs are listed in SnapshotIDs instead of SnapshotID.
+type RestoreSummary struct {
+	Header
+	SnapshotID    string   `json:"snapshot_id,omitempty"`
+	SnapshotIDs   []string `json:"snapshot_ids,omitempty"`
+	Target        string   `json:"target"`
+	TotalErrors   int      `json:"total_errors"`
+	FilesVerified int      `json:"files_verified,omitempty"`
+	Conflicts     int      `json:"conflicts,omitempty"`
+}
+
+// RestoreConflict reports a path which differs between the snapshots merged
+// by the restore command. The path is restored from SnapshotID, Others lists
+// the snapshots which contain a different version.
+type RestoreConflict struct {
+	Header
+	Path       string   `json:"path"`
+	SnapshotID string   `json:"snapshot_id"`
+	Others     []string `json:"others"`
+}
+
+// DiffChange reports a single difference between two snapshots. The modifier
+// is one of "+" (added), "-" (removed), "M" (content modified), "T" (type
+// changed) or "U" (metadata changed).
+type DiffChange struct {
+	Header
+	Path     string     `json:"path"`
+	Modifier string     `json:"modifier"`
+	Patch    *DiffPatch `json:"patch,omitempty"`
+}
+
+// DiffPatch describes the changed content of a modified file. Hunks are only
+// set for text files which are not larger than the size limit of the diff
+// command.
+type DiffPatch struct {
+	Binary   bool       `json:"binary,omitempty"`
+	TooLarge bool       `json:"too_large,omitempty"`
+	OldSize  uint64     `json:"old_size"`
+	NewSize  uint64     `json:"new_size"`
+	Hunks    []DiffHunk `json:"hunks,omitempty"`
+}
+
+// DiffHunk is a group of changed lines in a text file. Each line starts with
+// " " (unchanged), "-" (removed) or "+" (added). Line numbers start at one.
+type DiffHunk struct {
+	OldStart int      `json:"old_start"`
+	OldLines int      `json:"old_lines"`
+	NewStart int      `json:"new_start"`
+	NewLines int      `json:"new_lines"`
+	Lines    []string `json:"lines"`
+}
+
+// DiffStat contains the number of items added or removed.
+type DiffStat struct {
+	Files     int    `json:"files"`
+	Dirs      int    `json:"dirs"`
+	Others    int    `json:"others"`
+	DataBlobs int    `json:"data_blobs"`
+	TreeBlobs int    `json:"tree_blobs"`
+	Bytes     uint64 `json:"bytes"`
+}
+
+// DiffSummary is printed by the diff command. When comparing a snapshot to
+// local files, TargetPath is set instead of TargetSnapshot and the blob
+// statistics are not collected.
+type DiffSummary struct {
+	Header
+	SourceSnapshot string   `json:"source_snapshot"`
+	TargetSnapshot string   `json:"target_snapshot,omitempty"`
+	TargetPath     string   `json:"target_path,omitempty"`
+	ChangedFiles   int      `json:"changed_files"`
+	Added          DiffStat `json:"added"`
+	Removed        DiffStat `json:"removed"`
+}
+
+// SnapshotAction reports that a snapshot has been processed. Depending on the
+// command, the action is "copied", "skipped" or "modified".
+type SnapshotAction struct {
+	Header
+	Action     string `json:"action"`
+	SnapshotID string `json:"snapshot_id"`
+	NewID      string `json:"new_id,omitempty"`
+}
+
+// CopySummary is printed by the copy command.
+type CopySummary struct {
+	Header
+	Copied  int `json:"copied"`
+	Skipped int `json:"skipped"`
+}
+
+// TagSummary is printed by the tag command.
+type TagSummary struct {
+	Header
+	ChangedSnapshots int `json:"changed_snapshots"`
+}
+
+// RebuildIndexSummary is printed by the rebuild-index command.
+type RebuildIndexSummary struct {
+	Header
+	SavedIndexes   []string `json:"saved_indexes"`
+	RemovedIndexes int      `json:"removed_indexes"`
+	SkippedPacks   []string `json:"skipped_packs,omitempty"`
+}
+
+// IndexCompactSummary is printed by the index compact command.
+type IndexCompactSummary struct {
+	Header
+	SavedIndexes   []string `json:"saved_indexes"`
+	RemovedIndexes int      `json:"removed_indexes"`
+}
+
+// CacheDir describes a cache directory.
+type CacheDir struct {
+	RepositoryID string    `json:"repository_id"`
+	LastUsed     time.Time `json:"last_used"`
+	Old          bool      `json:"old"`
+	Size         *int64    `json:"size,omitempty"`
+}
+
+// CacheSummary is printed by the cache command. For a cleanup, the removed
+// directories are listed, otherwise all cache directories.
+type CacheSummary struct {
+	Header
+	BaseDir string     `json:"base_dir"`
+	Dirs    []CacheDir `json:"dirs,omitempty"`
+	Removed []string   `json:"removed,omitempty"`
+}
+
+// PolicySummary is printed by the policy commands. Policy contains the
+// retention policy as it is stored in the repository.
+type PolicySummary struct {
+	Header
+	ID     string          `json:"id"`
+	Policy json.RawMessage `json:"policy"`
+}
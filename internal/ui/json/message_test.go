@@ -0,0 +1,174 @@
+package json
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+// The tests in this file make sure the messages printed by restic do not
+// change accidentally. If a test fails because a message was changed in an
+// incompatible way, SchemaVersion must be increased.
+
+func TestPrint(t *testing.T) {
+	size := int64(1234)
+
+	var tests = []struct {
+		messageType string
+		msg         Message
+		want        string
+	}{
+		{
+			TypeStatus,
+			&StatusMessage{Action: "packs", SecondsElapsed: 3, PercentDone: 0.5, Total: 10, Done: 5},
+			`{"message_type":"status","schema_version":1,"action":"packs","seconds_elapsed":3,"percent_done":0.5,"total":10,"done":5}`,
+		},
+		{
+			TypeError,
+			&ErrorMessage{Error: "pack 1234 is damaged", During: "read data"},
+			`{"message_type":"error","schema_version":1,"error":"pack 1234 is damaged","during":"read data"}`,
+		},
+		{
+			TypeSummary,
+			&InitSummary{RepositoryID: "abcdef", Repository: "/srv/repo"},
+			`{"message_type":"summary","schema_version":1,"repository_id":"abcdef","repository":"/srv/repo"}`,
+		},
+		{
+			TypeSummary,
+			&CheckSummary{NumErrors: 2, Hints: []string{"duplicate packs"}, OrphanedPacks: 1},
+			`{"message_type":"summary","schema_version":1,"num_errors":2,"hints":["duplicate packs"],"orphaned_packs":1}`,
+		},
+		{
+			TypeSummary,
+			&PruneSummary{
+				Used:      PruneBlobStats{Blobs: 10, Bytes: 1000},
+				Remove:    PruneBlobStats{Blobs: 2, Bytes: 200},
+				Remaining: PruneBlobStats{Blobs: 10, Bytes: 1000},
+				Packs:     PrunePackStats{Used: 1, Unused: 1, Keep: 1, Remove: 1},
+			},
+			`{"message_type":"summary","schema_version":1,"used":{"blobs":10,"bytes":1000},` +
+				`"duplicate":{"blobs":0,"bytes":0},"unused":{"blobs":0,"bytes":0},"unreferenced_bytes":0,` +
+				`"repack":{"blobs":0,"bytes":0},"repack_remove":{"blobs":0,"bytes":0},"remove":{"blobs":2,"bytes":200},` +
+				`"remaining":{"blobs":10,"bytes":1000},"packs":{"used":1,"partly_used":0,"unused":1,"keep":1,` +
+				`"repack":0,"remove":1,"unreferenced":0}}`,
+		},
+		{
+			TypeSummary,
+			&RestoreSummary{SnapshotID: "abcdef", Target: "/tmp/restore", FilesVerified: 3},
+			`{"message_type":"summary","schema_version":1,"snapshot_id":"abcdef","target":"/tmp/restore","total_errors":0,"files_verified":3}`,
+		},
+		{
+			TypeSummary,
+			&RestoreSummary{SnapshotIDs: []string{"abc", "def"}, Target: "/tmp/restore", Conflicts: 1},
+			`{"message_type":"summary","schema_version":1,"snapshot_ids":["abc","def"],"target":"/tmp/restore","total_errors":0,"conflicts":1}`,
+		},
+		{
+			TypeConflict,
+			&RestoreConflict{Path: "/foo/bar", SnapshotID: "def", Others: []string{"abc"}},
+			`{"message_type":"conflict","schema_version":1,"path":"/foo/bar","snapshot_id":"def","others":["abc"]}`,
+		},
+		{
+			TypeChange,
+			&DiffChange{Path: "/foo/bar", Modifier: "M"},
+			`{"message_type":"change","schema_version":1,"path":"/foo/bar","modifier":"M"}`,
+		},
+		{
+			TypeChange,
+			&DiffChange{Path: "/foo/bar", Modifier: "M", Patch: &DiffPatch{OldSize: 2, NewSize: 2,
+				Hunks: []DiffHunk{{OldStart: 1, OldLines: 1, NewStart: 1, NewLines: 1, Lines: []string{"-a", "+b"}}}}},
+			`{"message_type":"change","schema_version":1,"path":"/foo/bar","modifier":"M","patch":{"old_size":2,"new_size":2,` +
+				`"hunks":[{"old_start":1,"old_lines":1,"new_start":1,"new_lines":1,"lines":["-a","+b"]}]}}`,
+		},
+		{
+			TypeChange,
+			&DiffChange{Path: "/foo/bar", Modifier: "M", Patch: &DiffPatch{Binary: true, OldSize: 10, NewSize: 20}},
+			`{"message_type":"change","schema_version":1,"path":"/foo/bar","modifier":"M","patch":{"binary":true,"old_size":10,"new_size":20}}`,
+		},
+		{
+			TypeSummary,
+			&DiffSummary{SourceSnapshot: "abc", TargetSnapshot: "def", ChangedFiles: 1, Added: DiffStat{Files: 2, Bytes: 100}},
+			`{"message_type":"summary","schema_version":1,"source_snapshot":"abc","target_snapshot":"def","changed_files":1,` +
+				`"added":{"files":2,"dirs":0,"others":0,"data_blobs":0,"tree_blobs":0,"bytes":100},` +
+				`"removed":{"files":0,"dirs":0,"others":0,"data_blobs":0,"tree_blobs":0,"bytes":0}}`,
+		},
+		{
+			TypeVerboseStatus,
+			&SnapshotAction{Action: "copied", SnapshotID: "abc", NewID: "def"},
+			`{"message_type":"verbose_status","schema_version":1,"action":"copied","snapshot_id":"abc","new_id":"def"}`,
+		},
+		{
+			TypeSummary,
+			&CopySummary{Copied: 2, Skipped: 1},
+			`{"message_type":"summary","schema_version":1,"copied":2,"skipped":1}`,
+		},
+		{
+			TypeSummary,
+			&TagSummary{ChangedSnapshots: 3},
+			`{"message_type":"summary","schema_version":1,"changed_snapshots":3}`,
+		},
+		{
+			TypeSummary,
+			&RebuildIndexSummary{SavedIndexes: []string{"abc"}, RemovedIndexes: 2},
+			`{"message_type":"summary","schema_version":1,"saved_indexes":["abc"],"removed_indexes":2}`,
+		},
+		{
+			TypeSummary,
+			&IndexCompactSummary{SavedIndexes: []string{"abc"}, RemovedIndexes: 20},
+			`{"message_type":"summary","schema_version":1,"saved_indexes":["abc"],"removed_indexes":20}`,
+		},
+		{
+			TypeSummary,
+			&CacheSummary{
+				BaseDir: "/home/user/.cache/restic",
+				Dirs: []CacheDir{
+					{RepositoryID: "abc", LastUsed: time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC), Old: true, Size: &size},
+				},
+			},
+			`{"message_type":"summary","schema_version":1,"base_dir":"/home/user/.cache/restic",` +
+				`"dirs":[{"repository_id":"abc","last_used":"2020-01-02T03:04:05Z","old":true,"size":1234}]}`,
+		},
+		{
+			TypeSummary,
+			&PolicySummary{ID: "abc", Policy: []byte(`{"rules":[{"keep":{"daily":7}}]}`)},
+			`{"message_type":"summary","schema_version":1,"id":"abc","policy":{"rules":[{"keep":{"daily":7}}]}}`,
+		},
+		{
+			TypeStatus,
+			&statusUpdate{SecondsElapsed: 2, PercentDone: 0.25, TotalFiles: 4, FilesDone: 1},
+			`{"message_type":"status","schema_version":1,"seconds_elapsed":2,"percent_done":0.25,"total_files":4,"files_done":1}`,
+		},
+		{
+			TypeError,
+			&errorUpdate{Error: "permission denied", During: "archival", Item: "/foo"},
+			`{"message_type":"error","schema_version":1,"error":"permission denied","during":"archival","item":"/foo"}`,
+		},
+		{
+			TypeVerboseStatus,
+			&verboseUpdate{Action: "new", Item: "/foo", Duration: 0.5, DataSize: 10},
+			`{"message_type":"verbose_status","schema_version":1,"action":"new","item":"/foo","duration":0.5,"data_size":10,"metadata_size":0,"total_files":0}`,
+		},
+		{
+			TypeSummary,
+			&summaryOutput{FilesNew: 1, DataBlobs: 1, SnapshotID: "abc"},
+			`{"message_type":"summary","schema_version":1,"files_new":1,"files_changed":0,"files_unmodified":0,` +
+				`"dirs_new":0,"dirs_changed":0,"dirs_unmodified":0,"data_blobs":1,"tree_blobs":0,"data_added":0,` +
+				`"total_files_processed":0,"total_bytes_processed":0,"total_duration":0,"snapshot_id":"abc"}`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run("", func(t *testing.T) {
+			buf := new(bytes.Buffer)
+			err := Print(buf, test.messageType, test.msg)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !cmp.Equal(test.want+"\n", buf.String()) {
+				t.Error(cmp.Diff(test.want+"\n", buf.String()))
+			}
+		})
+	}
+}
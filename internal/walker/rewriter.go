@@ -0,0 +1,140 @@
+package walker
+
+import (
+	"context"
+	"path"
+
+	"github.com/pkg/errors"
+
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/restic"
+)
+
+// TreeLoadSaver loads and saves trees.
+type TreeLoadSaver interface {
+	restic.TreeLoader
+	SaveTree(context.Context, *restic.Tree) (restic.ID, error)
+}
+
+// RewriteFunc is called by TreeRewriter for each node. Path is the
+// slash-separated path from the root node. The node returned replaces the
+// original node in the new tree, if nil is returned the node is removed. The
+// result must only depend on node and path.
+type RewriteFunc func(node *restic.Node, path string) *restic.Node
+
+// FailedTreeRewriteFunc is called by TreeRewriter when the tree with the ID
+// nodeID at path cannot be loaded. It returns the ID of the tree to use
+// instead, or an error to abort the rewrite.
+type FailedTreeRewriteFunc func(nodeID restic.ID, path string, err error) (restic.ID, error)
+
+// RewriteOpts configures a TreeRewriter.
+type RewriteOpts struct {
+	// RewriteNode is called for each node, it must not be nil.
+	RewriteNode RewriteFunc
+
+	// RewriteFailedTree is called for trees which cannot be loaded. If it is
+	// nil, the error is returned instead.
+	RewriteFailedTree FailedTreeRewriteFunc
+
+	// AllowUnstableSerialization skips the check that trees are encoded the
+	// same way again when saved, this is only useful for repairing trees.
+	AllowUnstableSerialization bool
+}
+
+type rewriteKey struct {
+	path string
+	id   restic.ID
+}
+
+// TreeRewriter creates modified copies of trees.
+type TreeRewriter struct {
+	opts RewriteOpts
+
+	// replaces caches the IDs of rewritten trees, the result of
+	// rewriteNode may depend on the path so it's part of the key
+	replaces map[rewriteKey]restic.ID
+}
+
+// NewTreeRewriter returns a TreeRewriter configured by opts.
+func NewTreeRewriter(opts RewriteOpts) *TreeRewriter {
+	return &TreeRewriter{
+		opts:     opts,
+		replaces: make(map[rewriteKey]restic.ID),
+	}
+}
+
+// RewriteTree recursively rewrites the tree with the ID nodeID, which is
+// located at nodepath, and saves all modified trees to repo. The ID of the
+// new tree is returned, it's the same as nodeID if nothing has been changed.
+func (t *TreeRewriter) RewriteTree(ctx context.Context, repo TreeLoadSaver, nodepath string, nodeID restic.ID) (newNodeID restic.ID, err error) {
+	key := rewriteKey{path: nodepath, id: nodeID}
+	if id, ok := t.replaces[key]; ok {
+		return id, nil
+	}
+
+	curTree, err := repo.LoadTree(ctx, nodeID)
+	if err != nil {
+		if t.opts.RewriteFailedTree == nil {
+			return restic.ID{}, err
+		}
+		debug.Log("loading tree %v at %v failed: %v", nodeID.Str(), nodepath, err)
+		newNodeID, err = t.opts.RewriteFailedTree(nodeID, nodepath, err)
+		if err != nil {
+			return restic.ID{}, err
+		}
+		t.replaces[key] = newNodeID
+		return newNodeID, nil
+	}
+
+	if !t.opts.AllowUnstableSerialization {
+		// make sure the tree is encoded the same way again, otherwise
+		// unchanged trees would get a different ID or information could get
+		// lost
+		testID, err := repo.SaveTree(ctx, curTree)
+		if err != nil {
+			return restic.ID{}, err
+		}
+		if !testID.Equal(nodeID) {
+			return restic.ID{}, errors.Errorf("cannot encode tree at %q without losing information", nodepath)
+		}
+	}
+
+	debug.Log("rewriting tree %v at %v", nodeID.Str(), nodepath)
+
+	tree := restic.NewTree()
+	for _, node := range curTree.Nodes {
+		p := path.Join(nodepath, node.Name)
+		node = t.opts.RewriteNode(node, p)
+		if node == nil {
+			continue
+		}
+
+		if node.Type == "dir" {
+			if node.Subtree == nil {
+				return restic.ID{}, errors.Errorf("subtree for node %v in tree %v is nil", node.Name, p)
+			}
+
+			newID, err := t.RewriteTree(ctx, repo, p, *node.Subtree)
+			if err != nil {
+				return restic.ID{}, err
+			}
+			// don't modify the node of the original tree
+			newNode := *node
+			newNode.Subtree = &newID
+			node = &newNode
+		}
+
+		err = tree.Insert(node)
+		if err != nil {
+			return restic.ID{}, err
+		}
+	}
+
+	newNodeID, err = repo.SaveTree(ctx, tree)
+	if err != nil {
+		return restic.ID{}, err
+	}
+
+	t.replaces[key] = newNodeID
+	return newNodeID, nil
+}
@@ -0,0 +1,208 @@
+package walker
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/restic/restic/internal/restic"
+)
+
+// WritableTreeMap also supports saving trees.
+type WritableTreeMap struct {
+	TreeMap
+}
+
+func (t WritableTreeMap) SaveTree(ctx context.Context, tree *restic.Tree) (restic.ID, error) {
+	// encode the tree the same way as buildTreeMap
+	buf, err := json.Marshal(tree)
+	if err != nil {
+		return restic.ID{}, err
+	}
+
+	id := restic.Hash(buf)
+	if _, ok := t.TreeMap[id]; !ok {
+		t.TreeMap[id] = tree
+	}
+
+	return id, nil
+}
+
+func listPaths(t testing.TB, repo restic.TreeLoader, root restic.ID) []string {
+	var paths []string
+	err := Walk(context.TODO(), repo, root, nil, func(_ restic.ID, path string, node *restic.Node, err error) (bool, error) {
+		if err != nil {
+			return false, err
+		}
+		if node != nil {
+			paths = append(paths, path)
+		}
+		return false, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return paths
+}
+
+func TestRewriter(t *testing.T) {
+	tree := TestTree{
+		"foo": TestFile{},
+		"subdir": TestTree{
+			"secret": TestFile{},
+			"other":  TestFile{},
+			"subsubdir": TestTree{
+				"secret": TestFile{},
+			},
+		},
+		"secret": TestTree{
+			"file": TestFile{},
+		},
+	}
+
+	var tests = []struct {
+		name    string
+		rewrite RewriteFunc
+		want    []string
+	}{
+		{
+			name: "unchanged",
+			rewrite: func(node *restic.Node, path string) *restic.Node {
+				return node
+			},
+			want: []string{
+				"/foo",
+				"/secret",
+				"/secret/file",
+				"/subdir",
+				"/subdir/other",
+				"/subdir/secret",
+				"/subdir/subsubdir",
+				"/subdir/subsubdir/secret",
+			},
+		},
+		{
+			name: "remove-path",
+			rewrite: func(node *restic.Node, path string) *restic.Node {
+				if path == "/subdir/secret" {
+					return nil
+				}
+				return node
+			},
+			want: []string{
+				"/foo",
+				"/secret",
+				"/secret/file",
+				"/subdir",
+				"/subdir/other",
+				"/subdir/subsubdir",
+				"/subdir/subsubdir/secret",
+			},
+		},
+		{
+			name: "remove-name",
+			rewrite: func(node *restic.Node, path string) *restic.Node {
+				if node.Name == "secret" {
+					return nil
+				}
+				return node
+			},
+			want: []string{
+				"/foo",
+				"/subdir",
+				"/subdir/other",
+				"/subdir/subsubdir",
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			m, root := BuildTreeMap(tree)
+			repo := WritableTreeMap{m}
+
+			rewriter := NewTreeRewriter(RewriteOpts{RewriteNode: test.rewrite})
+			newRoot, err := rewriter.RewriteTree(context.TODO(), repo, "/", root)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if test.name == "unchanged" && !newRoot.Equal(root) {
+				t.Errorf("tree ID changed although no node was modified")
+			}
+
+			paths := listPaths(t, repo, newRoot)
+			if len(paths) != len(test.want) {
+				t.Fatalf("wrong paths returned, want %v, got %v", test.want, paths)
+			}
+			for i := range paths {
+				if paths[i] != test.want[i] {
+					t.Errorf("wrong path %d, want %q, got %q", i, test.want[i], paths[i])
+				}
+			}
+		})
+	}
+}
+
+func TestRewriterFailOnUnknownTree(t *testing.T) {
+	m, _ := BuildTreeMap(TestTree{"foo": TestFile{}})
+	repo := WritableTreeMap{m}
+
+	rewriter := NewTreeRewriter(RewriteOpts{
+		RewriteNode: func(node *restic.Node, path string) *restic.Node {
+			return node
+		},
+	})
+	_, err := rewriter.RewriteTree(context.TODO(), repo, "/", restic.NewRandomID())
+	if err == nil {
+		t.Fatal("missing error for unknown tree")
+	}
+}
+
+func TestRewriterFailedTree(t *testing.T) {
+	m, root := BuildTreeMap(TestTree{
+		"foo": TestFile{},
+		"subdir": TestTree{
+			"file": TestFile{},
+		},
+	})
+	repo := WritableTreeMap{m}
+
+	// remove the subtree from the repo
+	tree, err := repo.LoadTree(context.TODO(), root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	delete(m, *tree.Find("subdir").Subtree)
+
+	emptyID, err := repo.SaveTree(context.TODO(), restic.NewTree())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var failedPaths []string
+	rewriter := NewTreeRewriter(RewriteOpts{
+		RewriteNode: func(node *restic.Node, path string) *restic.Node {
+			return node
+		},
+		RewriteFailedTree: func(nodeID restic.ID, path string, err error) (restic.ID, error) {
+			failedPaths = append(failedPaths, path)
+			return emptyID, nil
+		},
+	})
+
+	newRoot, err := rewriter.RewriteTree(context.TODO(), repo, "/", root)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(failedPaths) != 1 || failedPaths[0] != "/subdir" {
+		t.Errorf("wrong failed paths, want [/subdir], got %v", failedPaths)
+	}
+
+	paths := listPaths(t, repo, newRoot)
+	want := []string{"/foo", "/subdir"}
+	if len(paths) != len(want) || paths[0] != want[0] || paths[1] != want[1] {
+		t.Errorf("wrong paths returned, want %v, got %v", want, paths)
+	}
+}